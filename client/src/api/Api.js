@@ -31,7 +31,10 @@ class Api {
                     return false;
                 }
 
-                throw new Error(`${errorPath} | ${error.response.data} | ${error.response.status}`);
+                // the filtering requests return the message with its code
+                const { data } = error.response;
+                const message = data && data.message ? data.message : data;
+                throw new Error(`${errorPath} | ${message} | ${error.response.status}`);
             }
             throw new Error(`${errorPath} | ${error.message || error}`);
         }
@@ -6,6 +6,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"regexp"
 	"runtime"
 	"runtime/debug"
 	"strings"
@@ -38,6 +39,11 @@ type RequestFilteringSettings struct {
 	ClientIP   string
 	ClientTags []string
 
+	// The group of the client (see Config.ClientGroups):
+	//  only the filters that aren't bound to a group and the filters of this group are applied.
+	// All filters are applied if it's empty.
+	ClientGroup string
+
 	ServicesRules []ServiceEntry
 }
 
@@ -67,6 +73,10 @@ type Config struct {
 
 	// Register an HTTP handler
 	HTTPRegister func(string, string, func(http.ResponseWriter, *http.Request)) `yaml:"-"`
+
+	// The groups of clients the filters may be bound to (see Filter.ClientGroup).
+	// A separate filtering engine is built for each group that has its own set of filters.
+	ClientGroups []string `yaml:"-"`
 }
 
 // LookupStats store stats collected during safebrowsing or parental checks
@@ -90,13 +100,23 @@ type filtersInitializerParams struct {
 	blockFilters []Filter
 }
 
-// Dnsfilter holds added rules and performs hostname matches against the rules
-type Dnsfilter struct {
+// The filtering engines built from a set of filters
+type filteringEngines struct {
 	rulesStorage         *filterlist.RuleStorage
 	filteringEngine      *urlfilter.DNSEngine
 	rulesStorageWhite    *filterlist.RuleStorage
 	filteringEngineWhite *urlfilter.DNSEngine
-	engineLock           sync.RWMutex
+
+	// the strict blocklists
+	rulesStorageStrict    *filterlist.RuleStorage
+	filteringEngineStrict *urlfilter.DNSEngine
+}
+
+// Dnsfilter holds added rules and performs hostname matches against the rules
+type Dnsfilter struct {
+	filteringEngines                              // the engines for all filters
+	groupEngines     map[string]*filteringEngines // the engines for the groups of clients that have their own set of filters
+	engineLock       sync.RWMutex
 
 	parentalServer       string // access via methods
 	safeBrowsingServer   string // access via methods
@@ -116,6 +136,14 @@ type Filter struct {
 	ID       int64  // auto-assigned when filter is added (see nextFilterID)
 	Data     []byte `yaml:"-"` // List of rules divided by '\n'
 	FilePath string `yaml:"-"` // Path to a filtering rules file
+
+	// Blocklists only: the rules of a strict filter are matched before the allowlists
+	//  and the exception rules of the other filters are considered, so they can't be overridden.
+	Strict bool `yaml:"-"`
+
+	// The group of clients the filter is applied to (see Config.ClientGroups).
+	// The filter is applied to all clients if it's empty.
+	ClientGroup string `yaml:"-"`
 }
 
 // Reason holds an enum detailing why it was filtered or not filtered
@@ -252,11 +280,22 @@ func (d *Dnsfilter) Close() {
 }
 
 func (d *Dnsfilter) reset() {
-	if d.rulesStorage != nil {
-		_ = d.rulesStorage.Close()
+	d.filteringEngines.close()
+	for _, e := range d.groupEngines {
+		e.close()
 	}
-	if d.rulesStorageWhite != nil {
-		d.rulesStorageWhite.Close()
+	d.groupEngines = nil
+}
+
+func (e *filteringEngines) close() {
+	if e.rulesStorage != nil {
+		_ = e.rulesStorage.Close()
+	}
+	if e.rulesStorageWhite != nil {
+		e.rulesStorageWhite.Close()
+	}
+	if e.rulesStorageStrict != nil {
+		_ = e.rulesStorageStrict.Close()
 	}
 }
 
@@ -481,6 +520,31 @@ func matchBlockedServicesRules(host string, svcs []ServiceEntry) Result {
 // Adding rule and matching against the rules
 //
 
+// ValidateRule checks the syntax of a filtering rule
+// Empty lines and comments are valid
+func ValidateRule(line string) error {
+	line = strings.TrimSpace(line)
+	if len(line) == 0 || line[0] == '!' || line[0] == '#' {
+		return nil
+	}
+
+	// regular expression rule: /regex/$modifiers
+	pattern := strings.TrimPrefix(line, "@@")
+	if strings.HasPrefix(pattern, "/") {
+		end := strings.LastIndexByte(pattern, '/')
+		if end == 0 {
+			return fmt.Errorf("unbalanced regular expression delimiters")
+		}
+		_, err := regexp.Compile(pattern[1:end])
+		if err != nil {
+			return fmt.Errorf("invalid regular expression: %s", err)
+		}
+	}
+
+	_, err := rules.NewRule(line, 0)
+	return err
+}
+
 // Return TRUE if file exists
 func fileExists(fn string) bool {
 	_, err := os.Stat(fn)
@@ -490,14 +554,38 @@ func fileExists(fn string) bool {
 	return true
 }
 
+// MatchRule checks whether the single rule matches the host name.
+// The rule is compiled into a transient filtering engine, the configured filters aren't used.
+func MatchRule(rule, host string, qtype uint16) (Result, error) {
+	rule = strings.TrimSpace(rule)
+	if len(rule) == 0 || rule[0] == '!' || rule[0] == '#' {
+		return Result{}, fmt.Errorf("not a filtering rule")
+	}
+	err := ValidateRule(rule)
+	if err != nil {
+		return Result{}, err
+	}
+
+	rulesStorage, filteringEngine, err := createFilteringEngine([]Filter{{ID: 0, Data: []byte(rule)}})
+	if err != nil {
+		return Result{}, err
+	}
+	defer rulesStorage.Close()
+
+	d := &Dnsfilter{}
+	d.filteringEngine = filteringEngine
+	return d.matchHost(strings.ToLower(host), qtype, RequestFilteringSettings{})
+}
+
 func createFilteringEngine(filters []Filter) (*filterlist.RuleStorage, *urlfilter.DNSEngine, error) {
 	listArray := []filterlist.RuleList{}
 	for _, f := range filters {
 		var list filterlist.RuleList
 
-		if f.ID == 0 {
+		if f.ID <= 0 {
+			// user rules
 			list = &filterlist.StringRuleList{
-				ID:             0,
+				ID:             int(f.ID),
 				RulesText:      string(f.Data),
 				IgnoreCosmetic: true,
 			}
@@ -539,23 +627,81 @@ func createFilteringEngine(filters []Filter) (*filterlist.RuleStorage, *urlfilte
 	return rulesStorage, filteringEngine, nil
 }
 
+// Create the filtering engines for the set of filters
+func createFilteringEngines(allowFilters, blockFilters []Filter) (*filteringEngines, error) {
+	var normalFilters, strictFilters []Filter
+	for _, f := range blockFilters {
+		if f.Strict {
+			strictFilters = append(strictFilters, f)
+		} else {
+			normalFilters = append(normalFilters, f)
+		}
+	}
+
+	e := &filteringEngines{}
+	var err error
+	e.rulesStorage, e.filteringEngine, err = createFilteringEngine(normalFilters)
+	if err != nil {
+		return nil, err
+	}
+	e.rulesStorageWhite, e.filteringEngineWhite, err = createFilteringEngine(allowFilters)
+	if err != nil {
+		e.close()
+		return nil, err
+	}
+	if len(strictFilters) != 0 {
+		e.rulesStorageStrict, e.filteringEngineStrict, err = createFilteringEngine(strictFilters)
+		if err != nil {
+			e.close()
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// Get the filters applied to the group of clients.
+// Return FALSE if some filters aren't applied to the group.
+func groupFilters(filters []Filter, group string) ([]Filter, bool) {
+	var res []Filter
+	for _, f := range filters {
+		if len(f.ClientGroup) == 0 || f.ClientGroup == group {
+			res = append(res, f)
+		}
+	}
+	return res, len(res) == len(filters)
+}
+
 // Initialize urlfilter objects
 func (d *Dnsfilter) initFiltering(allowFilters, blockFilters []Filter) error {
-	rulesStorage, filteringEngine, err := createFilteringEngine(blockFilters)
+	all, err := createFilteringEngines(allowFilters, blockFilters)
 	if err != nil {
 		return err
 	}
-	rulesStorageWhite, filteringEngineWhite, err := createFilteringEngine(allowFilters)
-	if err != nil {
-		return err
+
+	// the rules of the filters bound to the other groups must not be matched at all:
+	//  the engine returns only one network rule for a request and it may hide the applied rules
+	groups := map[string]*filteringEngines{}
+	for _, g := range d.ClientGroups {
+		allow, allowAll := groupFilters(allowFilters, g)
+		block, blockAll := groupFilters(blockFilters, g)
+		if allowAll && blockAll {
+			continue // the engines for all filters are used
+		}
+		e, err := createFilteringEngines(allow, block)
+		if err != nil {
+			all.close()
+			for _, e := range groups {
+				e.close()
+			}
+			return err
+		}
+		groups[g] = e
 	}
 
 	d.engineLock.Lock()
 	d.reset()
-	d.rulesStorage = rulesStorage
-	d.filteringEngine = filteringEngine
-	d.rulesStorageWhite = rulesStorageWhite
-	d.filteringEngineWhite = filteringEngineWhite
+	d.filteringEngines = *all
+	d.groupEngines = groups
 	d.engineLock.Unlock()
 
 	// Make sure that the OS reclaims memory as soon as possible
@@ -565,6 +711,45 @@ func (d *Dnsfilter) initFiltering(allowFilters, blockFilters []Filter) error {
 	return nil
 }
 
+// EngineStatus is the state of the filtering engine
+type EngineStatus struct {
+	RulesCount     int   // the number of rules loaded to the engine (blocklists and allowlists)
+	MemoryEstimate int64 // approximate memory used by the loaded rules (in bytes)
+}
+
+// Approximate memory used by the engine per loaded rule: the rule object and its lookup table entries (in bytes)
+const ruleMemoryEstimate = 200
+
+// GetEngineStatus returns the state of the filtering engine
+func (d *Dnsfilter) GetEngineStatus() EngineStatus {
+	d.engineLock.RLock()
+	defer d.engineLock.RUnlock()
+
+	st := EngineStatus{}
+	st.RulesCount = d.filteringEngines.rulesCount()
+	n := st.RulesCount
+	for _, e := range d.groupEngines {
+		n += e.rulesCount() // the rules are loaded again for every group
+	}
+	st.MemoryEstimate = int64(n) * ruleMemoryEstimate
+	return st
+}
+
+// Get the number of rules loaded to the engines
+func (e *filteringEngines) rulesCount() int {
+	n := 0
+	if e.filteringEngine != nil {
+		n += e.filteringEngine.RulesCount
+	}
+	if e.filteringEngineWhite != nil {
+		n += e.filteringEngineWhite.RulesCount
+	}
+	if e.filteringEngineStrict != nil {
+		n += e.filteringEngineStrict.RulesCount
+	}
+	return n
+}
+
 // matchHost is a low-level way to check only if hostname is filtered by rules, skipping expensive safebrowsing and parental lookups
 func (d *Dnsfilter) matchHost(host string, qtype uint16, setts RequestFilteringSettings) (Result, error) {
 	d.engineLock.RLock()
@@ -578,8 +763,22 @@ func (d *Dnsfilter) matchHost(host string, qtype uint16, setts RequestFilteringS
 	ureq.ClientName = setts.ClientName
 	ureq.SortedClientTags = setts.ClientTags
 
-	if d.filteringEngineWhite != nil {
-		rr, ok := d.filteringEngineWhite.MatchRequest(ureq)
+	e := &d.filteringEngines
+	if g, ok := d.groupEngines[setts.ClientGroup]; ok {
+		e = g
+	}
+
+	if e.filteringEngineStrict != nil {
+		// the strict blocklists are matched before the allowlists are considered,
+		//  only the exception rules of the strict blocklists themselves are applied
+		res := matchEngine(e.filteringEngineStrict, ureq, qtype)
+		if res.IsFiltered {
+			return res, nil
+		}
+	}
+
+	if e.filteringEngineWhite != nil {
+		rr, ok := e.filteringEngineWhite.MatchRequest(ureq)
 		if ok {
 			var rule rules.Rule
 			if rr.NetworkRule != nil {
@@ -597,13 +796,19 @@ func (d *Dnsfilter) matchHost(host string, qtype uint16, setts RequestFilteringS
 		}
 	}
 
-	if d.filteringEngine == nil {
+	if e.filteringEngine == nil {
 		return Result{}, nil
 	}
 
-	rr, ok := d.filteringEngine.MatchRequest(ureq)
+	return matchEngine(e.filteringEngine, ureq, qtype), nil
+}
+
+// Match the request against the blocklists of the filtering engine
+func matchEngine(engine *urlfilter.DNSEngine, ureq urlfilter.DNSRequest, qtype uint16) Result {
+	host := ureq.Hostname
+	rr, ok := engine.MatchRequest(ureq)
 	if !ok {
-		return Result{}, nil
+		return Result{}
 	}
 
 	if rr.NetworkRule != nil {
@@ -614,7 +819,7 @@ func (d *Dnsfilter) matchHost(host string, qtype uint16, setts RequestFilteringS
 			reason = NotFilteredWhiteList
 		}
 		res := makeResult(rr.NetworkRule, reason)
-		return res, nil
+		return res
 	}
 
 	if qtype == dns.TypeA && rr.HostRulesV4 != nil {
@@ -623,7 +828,7 @@ func (d *Dnsfilter) matchHost(host string, qtype uint16, setts RequestFilteringS
 			host, rule.Text(), rule.GetFilterListID())
 		res := makeResult(rule, FilteredBlackList)
 		res.IP = rule.IP.To4()
-		return res, nil
+		return res
 	}
 
 	if qtype == dns.TypeAAAA && rr.HostRulesV6 != nil {
@@ -632,7 +837,7 @@ func (d *Dnsfilter) matchHost(host string, qtype uint16, setts RequestFilteringS
 			host, rule.Text(), rule.GetFilterListID())
 		res := makeResult(rule, FilteredBlackList)
 		res.IP = rule.IP
-		return res, nil
+		return res
 	}
 
 	if rr.HostRulesV4 != nil || rr.HostRulesV6 != nil {
@@ -648,10 +853,10 @@ func (d *Dnsfilter) matchHost(host string, qtype uint16, setts RequestFilteringS
 			host, rule.Text(), rule.GetFilterListID())
 		res := makeResult(rule, FilteredBlackList)
 		res.IP = net.IP{}
-		return res, nil
+		return res
 	}
 
-	return Result{}, nil
+	return Result{}
 }
 
 // Construct Result object
@@ -458,6 +458,37 @@ func TestWhitelist(t *testing.T) {
 
 }
 
+// The rules of a strict filter can't be overridden by the allowlists and the exception rules of the other filters
+func TestStrictFilters(t *testing.T) {
+	filters := []Filter{
+		Filter{ID: 0, Data: []byte("@@||strict.org^\n@@||normal.org^\n")},
+		Filter{ID: -1, Data: []byte("||normal.org^\n")},
+		Filter{ID: -2, Data: []byte("||strict.org^\n@@||ok.strict.org^\n"), Strict: true},
+	}
+	whiteFilters := []Filter{Filter{
+		ID: -3, Data: []byte("||strict.org^\n"),
+	}}
+	d := NewForTest(nil, filters)
+	d.SetFilters(filters, whiteFilters, false)
+	defer d.Close()
+
+	r, err := d.CheckHost("strict.org", dns.TypeA, &setts)
+	assert.Nil(t, err)
+	assert.True(t, r.IsFiltered)
+	assert.Equal(t, int64(-2), r.FilterID)
+
+	r, err = d.CheckHost("normal.org", dns.TypeA, &setts)
+	assert.Nil(t, err)
+	assert.False(t, r.IsFiltered)
+
+	// the exception rules of the strict filter itself are applied
+	r, err = d.CheckHost("ok.strict.org", dns.TypeA, &setts)
+	assert.Nil(t, err)
+	assert.False(t, r.IsFiltered)
+
+	assert.Equal(t, 6, d.GetEngineStatus().RulesCount)
+}
+
 // CLIENT SETTINGS
 
 func applyClientSettings(setts *RequestFilteringSettings) {
@@ -474,6 +505,119 @@ func applyClientSettings(setts *RequestFilteringSettings) {
 
 // Check behaviour without any per-client settings,
 //  then apply per-client settings and check behaviour once again
+func TestValidateRule(t *testing.T) {
+	for _, line := range []string{
+		"",
+		"! comment",
+		"# comment",
+		"||example.org^",
+		"@@||example.org^$important",
+		"0.0.0.0 example.org",
+		"/example\\.(org|com)/",
+	} {
+		assert.Nil(t, ValidateRule(line), line)
+	}
+
+	for _, line := range []string{
+		"/example.org",
+		"/example(.org/",
+		"||example.org^$unknown_modifier",
+	} {
+		assert.NotNil(t, ValidateRule(line), line)
+	}
+}
+
+// Rules from several in-memory lists (user rule groups) are attributed to their lists
+func TestUserRuleLists(t *testing.T) {
+	filters := []Filter{
+		Filter{ID: 0, Data: []byte("||example.org^\n")},
+		Filter{ID: -1, Data: []byte("||example.com^\n")},
+	}
+	d := NewForTest(nil, filters)
+	defer d.Close()
+
+	r, err := d.CheckHost("example.org", dns.TypeA, &setts)
+	assert.Nil(t, err)
+	assert.True(t, r.IsFiltered)
+	assert.Equal(t, int64(0), r.FilterID)
+
+	r, err = d.CheckHost("example.com", dns.TypeA, &setts)
+	assert.Nil(t, err)
+	assert.True(t, r.IsFiltered)
+	assert.Equal(t, int64(-1), r.FilterID)
+}
+
+// Only the filters of the client's group and the filters that aren't bound to a group are applied
+func TestClientGroups(t *testing.T) {
+	filters := []Filter{
+		Filter{ID: 1, Data: []byte("||example.org^\n0.0.0.0 host.example\n")},
+		Filter{ID: 2, Data: []byte("@@||example.org^\n||example.com^\n0.0.0.1 host.example\n"), ClientGroup: "a"},
+	}
+	d := NewForTest(&Config{ClientGroups: []string{"a", "b"}}, filters)
+	defer d.Close()
+
+	// the exception rule of the other group doesn't hide the blocking rule for the same host
+	s := setts
+	s.ClientGroup = "b"
+	r, err := d.CheckHost("example.org", dns.TypeA, &s)
+	assert.Nil(t, err)
+	assert.True(t, r.IsFiltered)
+	assert.Equal(t, int64(1), r.FilterID)
+
+	r, err = d.CheckHost("example.com", dns.TypeA, &s)
+	assert.Nil(t, err)
+	assert.False(t, r.IsFiltered)
+
+	r, err = d.CheckHost("host.example", dns.TypeA, &s)
+	assert.Nil(t, err)
+	assert.True(t, r.IsFiltered)
+	assert.Equal(t, "0.0.0.0", r.IP.String())
+
+	s.ClientGroup = "a"
+	r, err = d.CheckHost("example.org", dns.TypeA, &s)
+	assert.Nil(t, err)
+	assert.False(t, r.IsFiltered)
+	assert.Equal(t, NotFilteredWhiteList, r.Reason)
+	assert.Equal(t, int64(2), r.FilterID)
+
+	r, err = d.CheckHost("example.com", dns.TypeA, &s)
+	assert.Nil(t, err)
+	assert.True(t, r.IsFiltered)
+	assert.Equal(t, int64(2), r.FilterID)
+
+	// all filters are applied
+	r, err = d.CheckHost("example.com", dns.TypeA, &setts)
+	assert.Nil(t, err)
+	assert.True(t, r.IsFiltered)
+
+	// all filters are applied to the group "a": only the group "b" has its own engines
+	assert.Equal(t, 1, len(d.groupEngines))
+	assert.NotNil(t, d.groupEngines["b"])
+	st := d.GetEngineStatus()
+	assert.Equal(t, 5, st.RulesCount)
+	assert.Equal(t, int64(5+2)*ruleMemoryEstimate, st.MemoryEstimate)
+}
+
+func TestMatchRule(t *testing.T) {
+	r, err := MatchRule("||example.org^", "Sub.Example.org", dns.TypeA)
+	assert.Nil(t, err)
+	assert.True(t, r.IsFiltered)
+	assert.Equal(t, "||example.org^", r.Rule)
+
+	r, err = MatchRule("||example.org^", "example.com", dns.TypeA)
+	assert.Nil(t, err)
+	assert.Equal(t, NotFilteredNotFound, r.Reason)
+
+	r, err = MatchRule("0.0.0.0 example.org", "example.org", dns.TypeAAAA)
+	assert.Nil(t, err)
+	assert.True(t, r.IsFiltered)
+
+	_, err = MatchRule("# comment", "example.org", dns.TypeA)
+	assert.NotNil(t, err)
+	_, err = MatchRule("/example(.org/", "example.org", dns.TypeA)
+	assert.NotNil(t, err)
+}
+
 func TestClientSettings(t *testing.T) {
 	var r Result
 	filters := []Filter{Filter{
@@ -278,6 +278,26 @@ func (clients *clientsContainer) Find(ip string) (Client, bool) {
 	return c, true
 }
 
+// FindByNameOrID searches for a persistent client by its name or by one of its IDs (IP, CIDR or MAC)
+func (clients *clientsContainer) FindByNameOrID(s string) (Client, bool) {
+	clients.lock.Lock()
+	defer clients.lock.Unlock()
+
+	c, ok := clients.list[s]
+	if !ok {
+		c, ok = clients.idIndex[s]
+	}
+	if !ok {
+		return Client{}, false
+	}
+	cl := *c
+	cl.IDs = stringArrayDup(cl.IDs)
+	cl.Tags = stringArrayDup(cl.Tags)
+	cl.BlockedServices = stringArrayDup(cl.BlockedServices)
+	cl.Upstreams = stringArrayDup(cl.Upstreams)
+	return cl, true
+}
+
 // FindUpstreams looks for upstreams configured for the client
 // If no client found for this IP, or if no custom upstreams are configured,
 // this method returns nil
@@ -99,6 +99,7 @@ func registerControlHandlers() {
 	httpRegister(http.MethodPost, "/control/update", handleUpdate)
 
 	httpRegister("GET", "/control/profile", handleGetProfile)
+	httpRegister(http.MethodGet, "/metrics", Context.filters.HandleMetrics)
 	RegisterAuthHandlers()
 }
 
@@ -1,97 +1,357 @@
 package home
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
 	"github.com/AdguardTeam/AdGuardHome/util"
-	"github.com/AdguardTeam/golibs/log"
 	"github.com/miekg/dns"
 )
 
-// isValidURL - return TRUE if URL or file path is valid
-func isValidURL(rawurl string) bool {
-	if filepath.IsAbs(rawurl) {
-		// this is a file path
-		return util.FileExists(rawurl)
-	}
-
-	url, err := url.ParseRequestURI(rawurl)
-	if err != nil {
-		return false //Couldn't even parse the rawurl
-	}
-	if len(url.Scheme) == 0 {
-		return false //No Scheme found
-	}
-	return true
-}
-
 type filterAddJSON struct {
 	Name      string `json:"name"`
 	URL       string `json:"url"`
 	Whitelist bool   `json:"whitelist"`
+	Enabled   *bool  `json:"enabled,omitempty"` // TRUE if not set
+	Notes     string `json:"notes,omitempty"`
+
+	StrictKind bool `json:"strict_kind,omitempty"` // refuse to add a blocklist as an allowlist and vice versa
 }
 
-func (f *Filtering) handleFilteringAddURL(w http.ResponseWriter, r *http.Request) {
-	fj := filterAddJSON{}
-	err := json.NewDecoder(r.Body).Decode(&fj)
-	if err != nil {
-		httpError(w, http.StatusBadRequest, "Failed to parse request body json: %s", err)
-		return
-	}
+func (fj *filterAddJSON) enabled() bool {
+	return fj.Enabled == nil || *fj.Enabled
+}
 
-	if !isValidURL(fj.URL) {
-		http.Error(w, "Invalid URL or file path", http.StatusBadRequest)
-		return
+// Validate the request data and download the filter contents.
+// A disabled filter isn't downloaded: its data is downloaded when it's enabled.
+func (f *Filtering) downloadNewFilter(fj filterAddJSON) (filter, error) {
+	if f.isReadOnly() {
+		return filter{}, newMsgError(msgFilterDirReadOnly, filepath.Join(Context.getDataDir(), filterDir))
+	}
+	if !isValidFilterURL(fj.URL) {
+		return filter{}, newMsgError(msgInvalidURL)
 	}
 
 	// Check for duplicates
 	if filterExists(fj.URL) {
-		httpError(w, http.StatusBadRequest, "Filter URL already added -- %s", fj.URL)
-		return
+		return filter{}, newMsgError(msgFilterExists, fj.URL)
+	}
+
+	notes, err := sanitizeFilterNotes(fj.Notes)
+	if err != nil {
+		return filter{}, err
 	}
 
 	// Set necessary properties
 	filt := filter{
-		Enabled: true,
+		Enabled: fj.enabled(),
 		URL:     fj.URL,
 		Name:    fj.Name,
+		Notes:   notes,
 		white:   fj.Whitelist,
 	}
 	filt.ID = assignUniqueFilterID()
+	if !filt.Enabled {
+		return filt, nil
+	}
 
 	// Download the filter contents
-	ok, err := f.update(&filt)
+	ok, err := f.update(f.context(), &filt)
 	if err != nil {
-		httpError(w, http.StatusBadRequest, "Couldn't fetch filter from url %s: %s", filt.URL, err)
-		return
+		return filter{}, newMsgError(msgFilterFetchFailed, filt.URL, err)
 	}
 	if !ok {
-		httpError(w, http.StatusBadRequest, "Filter at the url %s is invalid (maybe it points to blank page?)", filt.URL)
+		return filter{}, newMsgError(msgFilterInvalid, filt.URL)
+	}
+
+	if warning, mismatch := filt.kindMismatch(fj.Whitelist); mismatch {
+		if fj.StrictKind {
+			return filter{}, newMsgError(msgFilterKindMismatch, filt.URL, filt.kind(), listKind(fj.Whitelist))
+		}
+		filt.warnings = append(filt.warnings, warning)
+	}
+	return filt, nil
+}
+
+func (f *Filtering) handleFilteringAddURL(w http.ResponseWriter, r *http.Request) {
+	fj := filterAddJSON{}
+	err := json.NewDecoder(r.Body).Decode(&fj)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgBadJSON, err)
+		return
+	}
+
+	filt, err := f.downloadNewFilter(fj)
+	if err != nil {
+		f.httpErrorErr(w, r, filterErrorStatus(err), err)
 		return
 	}
 
 	// URL is deemed valid, append it to filters, update config, write new filter file and tell dns to reload it
 	if !filterAdd(filt) {
-		httpError(w, http.StatusBadRequest, "Filter URL already added -- %s", filt.URL)
+		f.httpErrorMsg(w, r, http.StatusConflict, msgFilterExists, filt.URL)
 		return
 	}
 
 	onConfigModified()
-	enableFilters(true)
+	if filt.Enabled {
+		enableFilters(true)
+	}
 
-	_, err = fmt.Fprintf(w, "OK %d rules\n", filt.RulesCount)
+	msg := fmt.Sprintf("OK %d rules\n", filt.RulesCount)
+	if warning, mismatch := filt.kindMismatch(fj.Whitelist); mismatch {
+		msg += "Warning: " + warning + "\n"
+	}
+	_, err = fmt.Fprint(w, msg)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgWriteFailed, err)
+	}
+}
+
+type filterAddResultJSON struct {
+	URL        string `json:"url"`
+	RulesCount int    `json:"rules_count"`
+	Error      string `json:"error,omitempty"`
+	Warning    string `json:"warning,omitempty"` // the filter kind doesn't match the list it's added to
+}
+
+// Download and add several filters at once.
+// A failure to add one filter doesn't prevent the others from being added.
+// Return the results for each filter, the number of added filters
+//  and TRUE if some of the added filters are enabled (i.e. the filtering engine must be rebuilt).
+func (f *Filtering) addFilters(req []filterAddJSON) ([]filterAddResultJSON, int, bool) {
+	results := make([]filterAddResultJSON, len(req))
+	var filters []filter
+	var indexes []int // indexes of the downloaded filters in results
+	for i, fj := range req {
+		results[i].URL = fj.URL
+		filt, err := f.downloadNewFilter(fj)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		filters = append(filters, filt)
+		indexes = append(indexes, i)
+	}
+
+	nAdded := 0
+	enabled := false
+	for i, ok := range filterAddMultiple(filters) {
+		res := &results[indexes[i]]
+		if !ok {
+			res.Error = newMsgError(msgFilterExists, res.URL).Error()
+			continue
+		}
+		res.RulesCount = filters[i].RulesCount
+		res.Warning, _ = filters[i].kindMismatch(filters[i].white)
+		nAdded++
+		enabled = enabled || filters[i].Enabled
+	}
+	return results, nAdded, enabled
+}
+
+// Add several filters at once.
+func (f *Filtering) handleFilteringAddURLs(w http.ResponseWriter, r *http.Request) {
+	var req []filterAddJSON
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgBadJSON, err)
+		return
+	}
+
+	results, nAdded, enabled := f.addFilters(req)
+	if nAdded != 0 {
+		onConfigModified()
+	}
+	if enabled {
+		enableFilters(true)
+	}
+
+	js, err := json.Marshal(results)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
+
+// The maximum size of the data downloaded to check a filter URL
+const checkURLMaxSize = 4 * 1024 * 1024
+
+// The result of checking a filter URL
+type checkURLResp struct {
+	StatusCode  int    `json:"status_code,omitempty"` // HTTP status code (not set for local files)
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size"`      // -1 if unknown
+	IsFilter    bool   `json:"is_filter"` // TRUE if the data looks like a filter list
+	Error       string `json:"error,omitempty"`
+
+	Valid      bool   `json:"valid"`               // TRUE if the filter can be added
+	RulesCount int    `json:"rules_count"`         // the number of rules in the checked data
+	Title      string `json:"title,omitempty"`     // "! Title:" of the filter
+	Format     string `json:"format,omitempty"`    // "adblock", "hosts" or "domains"
+	Kind       string `json:"kind,omitempty"`      // "blocklist" or "allowlist", not set if the kind is unknown
+	Truncated  bool   `json:"truncated,omitempty"` // the data is larger than checkURLMaxSize: only the beginning is checked
+}
+
+// Download the filter data into memory and check it as if the filter was being added.
+// Nothing is written to disk.
+func (f *Filtering) checkFilterURL(rawurl string) checkURLResp {
+	resp := checkURLResp{Size: -1}
+
+	var reader io.Reader
+	if isLocalFilterURL(rawurl) {
+		data, err := readLocalFilter(rawurl)
+		if err != nil {
+			resp.Error = err.Error()
+			return resp
+		}
+		resp.Size = int64(len(data))
+		reader = bytes.NewReader(data)
+	} else if isDataURL(rawurl) {
+		data, err := decodeDataURL(rawurl)
+		if err != nil {
+			resp.Error = err.Error()
+			return resp
+		}
+		resp.Size = int64(len(data))
+		reader = bytes.NewReader(data)
+	} else {
+		req, err := http.NewRequestWithContext(f.context(), "GET", rawurl, nil)
+		if err != nil {
+			resp.Error = err.Error()
+			return resp
+		}
+		hresp, err := filterDo(req, false)
+		if hresp != nil && hresp.Body != nil {
+			defer hresp.Body.Close()
+		}
+		if err != nil {
+			resp.Error = err.Error()
+			return resp
+		}
+
+		resp.StatusCode = hresp.StatusCode
+		resp.ContentType = hresp.Header.Get("Content-Type")
+		if hresp.StatusCode != http.StatusOK {
+			resp.Error = fmt.Sprintf("got status code != 200: %d", hresp.StatusCode)
+			return resp
+		}
+		resp.Size = hresp.ContentLength
+		reader = hresp.Body
+	}
+
+	// one more byte tells whether the data is larger than the limit
+	data, err := ioutil.ReadAll(io.LimitReader(reader, checkURLMaxSize+1))
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	if len(data) > checkURLMaxSize {
+		resp.Truncated = true
+		data = data[:checkURLMaxSize]
+		if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+			data = data[:i+1] // the last line may be incomplete
+		}
+	} else if resp.Size < 0 {
+		resp.Size = int64(len(data))
+	}
+
+	check := newFilterDataCheck()
+	err = check.add(data, true)
+	if err == nil {
+		err = check.finish()
+	}
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	resp.IsFilter = true
+
+	var st ruleStats
+	resp.RulesCount, _, resp.Title, st = f.parseFilterContents(bytes.NewReader(data))
+	resp.Kind = (&filter{ruleStats: st}).kind()
+	resp.Format = filterFormat(data)
+	if resp.RulesCount == 0 {
+		resp.Error = "the filter contains no rules"
+		return resp
+	}
+	resp.Valid = true
+	return resp
+}
+
+// Get the format of the filter list by the majority of its rules:
+//  "hosts" ("0.0.0.0 example.org"), "domains" ("example.org") or "adblock" (the other rules)
+// Return "" if there are no rules
+func filterFormat(data []byte) string {
+	hosts := 0
+	domains := 0
+	adblock := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || line[0] == '!' || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && net.ParseIP(fields[0]) != nil {
+			hosts++
+		} else if len(fields) == 1 && !strings.ContainsAny(line, "|^$*/@") && strings.IndexByte(line, '.') > 0 {
+			domains++
+		} else {
+			adblock++
+		}
+	}
+
+	switch {
+	case hosts+domains+adblock == 0:
+		return ""
+	case hosts >= domains && hosts >= adblock:
+		return "hosts"
+	case domains >= adblock:
+		return "domains"
+	}
+	return "adblock"
+}
+
+// Check that the filter URL is valid without adding the filter
+func (f *Filtering) handleFilteringCheckURL(w http.ResponseWriter, r *http.Request) {
+	type Req struct {
+		URL string `json:"url"`
+	}
+	req := Req{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgBadJSON, err)
+		return
+	}
+
+	if !isValidFilterURL(req.URL) {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgInvalidURL)
+		return
+	}
+
+	js, err := json.Marshal(f.checkFilterURL(req.URL))
 	if err != nil {
-		httpError(w, http.StatusInternalServerError, "Couldn't write body: %s", err)
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
 }
 
 func (f *Filtering) handleFilteringRemoveURL(w http.ResponseWriter, r *http.Request) {
@@ -103,42 +363,109 @@ func (f *Filtering) handleFilteringRemoveURL(w http.ResponseWriter, r *http.Requ
 	req := request{}
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		httpError(w, http.StatusBadRequest, "Failed to parse request body json: %s", err)
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgBadJSON, err)
 		return
 	}
 
-	// go through each element and delete if url matches
-	config.Lock()
-	newFilters := []filter{}
-	filters := &config.Filters
-	if req.Whitelist {
-		filters = &config.WhitelistFilters
-	}
-	for _, filter := range *filters {
-		if filter.URL != req.URL {
-			newFilters = append(newFilters, filter)
-		} else {
-			err := os.Rename(filter.Path(), filter.Path()+".old")
-			if err != nil {
-				log.Error("os.Rename: %s: %s", filter.Path(), err)
-			}
-		}
+	if len(req.URL) == 0 {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgURLRequired)
+		return
+	}
+	if !filterRemove(req.URL, req.Whitelist) {
+		f.httpErrorMsg(w, r, http.StatusNotFound, msgFilterURLNotFound)
+		return
 	}
-	// Update the configuration after removing filter files
-	*filters = newFilters
-	config.Unlock()
 
 	onConfigModified()
 	enableFilters(true)
 
-	// Note: the old files "filter.txt.old" aren't deleted - it's not really necessary,
-	//  but will require the additional code to run after enableFilters() is finished: i.e. complicated
+	// Note: the filter file is moved to the trash rather than deleted,
+	//  so the filtering engine may continue using it until the new filters are ready
+}
+
+// Parse the type of the filter list: "blocklist" (default) or "allowlist".
+// Return TRUE for the allowlists, and FALSE as the second value if the type is unknown.
+func parseFilterListType(s string) (bool, bool) {
+	switch s {
+	case "", "blocklist":
+		return false, true
+	case "allowlist":
+		return true, true
+	}
+	return false, false
+}
+
+// Remove all filters of the list.
+// The configuration is written and the filtering engine is rebuilt once.
+func (f *Filtering) handleFilteringRemoveAll(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Type string `json:"type"` // "blocklist" (default) or "allowlist"
+	}
+	req := request{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgBadJSON, err)
+		return
+	}
+	whitelist, ok := parseFilterListType(req.Type)
+	if !ok {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgUnknownType, req.Type)
+		return
+	}
+
+	removed := filterRemoveAll(whitelist)
+	if len(removed) != 0 {
+		onConfigModified()
+		enableFilters(true)
+	}
+
+	js, err := json.Marshal(map[string]int{"removed": len(removed)})
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
+
+// Restore a removed filter from the trash
+func (f *Filtering) handleFilteringRestoreURL(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		URL       string `json:"url"`
+		Whitelist bool   `json:"whitelist"`
+	}
+	req := request{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgBadJSON, err)
+		return
+	}
+
+	filt, err := f.filterRestore(req.URL, req.Whitelist)
+	if err != nil {
+		f.httpErrorErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	onConfigModified()
+	if filt.Enabled {
+		enableFilters(true)
+	}
+
+	_, err = fmt.Fprintf(w, "OK %d rules\n", filt.RulesCount)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgWriteFailed, err)
+	}
 }
 
 type filterURLJSON struct {
 	Name    string `json:"name"`
 	URL     string `json:"url"`
 	Enabled bool   `json:"enabled"`
+	ApplyTo string `json:"apply_to,omitempty"` // not changed if not set
+
+	AllowExceptions *bool   `json:"allow_exceptions,omitempty"` // blocklists only; not changed if not set
+	Notes           *string `json:"notes,omitempty"`            // not changed if not set
 }
 
 type filterURLReq struct {
@@ -151,27 +478,67 @@ func (f *Filtering) handleFilteringSetURL(w http.ResponseWriter, r *http.Request
 	fj := filterURLReq{}
 	err := json.NewDecoder(r.Body).Decode(&fj)
 	if err != nil {
-		httpError(w, http.StatusBadRequest, "json decode: %s", err)
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgBadJSON, err)
 		return
 	}
 
-	if !isValidURL(fj.Data.URL) {
-		http.Error(w, "invalid URL or file path", http.StatusBadRequest)
+	if !isValidFilterURL(fj.Data.URL) {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgInvalidURL)
+		return
+	}
+	if !checkApplyTo(fj.Data.ApplyTo) {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgInvalidApplyTo, fj.Data.ApplyTo)
 		return
 	}
 
 	filt := filter{
-		Enabled: fj.Data.Enabled,
-		Name:    fj.Data.Name,
-		URL:     fj.Data.URL,
+		Enabled:         fj.Data.Enabled,
+		Name:            fj.Data.Name,
+		URL:             fj.Data.URL,
+		ApplyTo:         fj.Data.ApplyTo,
+		AllowExceptions: fj.Data.AllowExceptions,
+	}
+	if fj.Data.Notes != nil {
+		filt.Notes, err = sanitizeFilterNotes(*fj.Data.Notes)
+		if err != nil {
+			f.httpErrorErr(w, r, http.StatusBadRequest, err)
+			return
+		}
+	} else if old, ok := filterFind(fj.URL, fj.Whitelist); ok {
+		filt.Notes = old.Notes
+	}
+	if fj.Data.URL != fj.URL && fj.Data.Enabled {
+		// the filter is changed only if the data has been downloaded from the new URL
+		ctx, cancel := f.withContext(r.Context())
+		err = f.filterChangeURL(ctx, fj.URL, filt, fj.Whitelist)
+		cancel()
+		if err != nil {
+			f.httpErrorErr(w, r, filterErrorStatus(err), err)
+			return
+		}
+		onConfigModified()
+		enableFilters(true)
+		return
+	}
+
+	var warnings []memoryWarning
+	if fj.Data.Enabled {
+		mw, strict := f.checkMemoryBudget([]string{fj.URL}, fj.Whitelist)
+		if mw != nil && strict {
+			f.httpErrorMsg(w, r, http.StatusBadRequest, msgMemoryBudget, mw.estimateMB(), mw.budgetMB())
+			return
+		} else if mw != nil {
+			warnings = append(warnings, *mw)
+		}
 	}
+
 	status := f.filterSetProperties(fj.URL, filt, fj.Whitelist)
 	if (status & statusFound) == 0 {
-		http.Error(w, "URL doesn't exist", http.StatusBadRequest)
+		f.httpErrorMsg(w, r, http.StatusNotFound, msgFilterURLNotFound)
 		return
 	}
 	if (status & statusURLExists) != 0 {
-		http.Error(w, "URL already exists", http.StatusBadRequest)
+		f.httpErrorMsg(w, r, http.StatusConflict, msgFilterURLExists)
 		return
 	}
 
@@ -181,13 +548,21 @@ func (f *Filtering) handleFilteringSetURL(w http.ResponseWriter, r *http.Request
 		// we must add or remove filter rules
 		restart = true
 	}
+	if (status & statusStrictChanged) != 0 {
+		// the filter must be moved to the other group of the filtering engine
+		restart = true
+	}
+	if (status & statusScopeChanged) != 0 {
+		// the engines for the groups of clients must be rebuilt
+		restart = true
+	}
 	if (status&statusUpdateRequired) != 0 && fj.Data.Enabled {
 		// download new filter and apply its rules
 		flags := FilterRefreshBlocklists
 		if fj.Whitelist {
 			flags = FilterRefreshAllowlists
 		}
-		nUpdated, _ := f.refreshFilters(flags, true)
+		nUpdated, _ := f.refreshFilters(flags, true, updateTriggerURLChange)
 		// if at least 1 filter has been updated, refreshFilters() restarts the filtering automatically
 		// if not - we restart the filtering ourselves
 		restart = false
@@ -198,144 +573,1155 @@ func (f *Filtering) handleFilteringSetURL(w http.ResponseWriter, r *http.Request
 	if restart {
 		enableFilters(true)
 	}
-}
 
-func (f *Filtering) handleFilteringSetRules(w http.ResponseWriter, r *http.Request) {
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		httpError(w, http.StatusBadRequest, "Failed to read request body: %s", err)
-		return
+	if len(warnings) != 0 {
+		js, err := json.Marshal(map[string]interface{}{"warnings": warnings})
+		if err != nil {
+			f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(js)
 	}
-
-	config.UserRules = strings.Split(string(body), "\n")
-	onConfigModified()
-	enableFilters(true)
 }
 
-func (f *Filtering) handleFilteringRefresh(w http.ResponseWriter, r *http.Request) {
+// Enable or disable several filters at once
+func (f *Filtering) handleFilteringSetEnabled(w http.ResponseWriter, r *http.Request) {
 	type Req struct {
-		White bool `json:"whitelist"`
+		Type    string   `json:"type"`
+		URLs    []string `json:"urls"`
+		Enabled bool     `json:"enabled"`
 	}
 	type Resp struct {
-		Updated int `json:"updated"`
+		Changed  int             `json:"changed"`
+		Warnings []memoryWarning `json:"warnings,omitempty"`
 	}
-	resp := Resp{}
-	var err error
 
 	req := Req{}
-	err = json.NewDecoder(r.Body).Decode(&req)
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		httpError(w, http.StatusBadRequest, "json decode: %s", err)
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgBadJSON, err)
 		return
 	}
 
-	Context.controlLock.Unlock()
-	flags := FilterRefreshBlocklists
-	if req.White {
-		flags = FilterRefreshAllowlists
-	}
-	resp.Updated, err = f.refreshFilters(flags|FilterRefreshForce, false)
-	Context.controlLock.Lock()
-	if err != nil {
-		httpError(w, http.StatusInternalServerError, "%s", err)
+	whitelist := false
+	switch req.Type {
+	case "blocklist":
+		//
+	case "allowlist":
+		whitelist = true
+	default:
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgUnknownType, req.Type)
 		return
 	}
 
+	resp := Resp{}
+	if req.Enabled {
+		mw, strict := f.checkMemoryBudget(req.URLs, whitelist)
+		if mw != nil && strict {
+			f.httpErrorMsg(w, r, http.StatusBadRequest, msgMemoryBudget, mw.estimateMB(), mw.budgetMB())
+			return
+		} else if mw != nil {
+			resp.Warnings = append(resp.Warnings, *mw)
+		}
+	}
+
+	updateRequired := false
+	resp.Changed, updateRequired = f.filtersSetEnabled(req.URLs, req.Enabled, whitelist)
+	if resp.Changed != 0 {
+		onConfigModified()
+		nUpdated := 0
+		if updateRequired {
+			flags := FilterRefreshBlocklists
+			if whitelist {
+				flags = FilterRefreshAllowlists
+			}
+			// if at least 1 filter has been updated, refreshFilters() restarts the filtering automatically
+			nUpdated, _ = f.refreshFilters(flags, true, updateTriggerManual)
+		}
+		if nUpdated == 0 {
+			enableFilters(true)
+		}
+	}
+
 	js, err := json.Marshal(resp)
 	if err != nil {
-		httpError(w, http.StatusInternalServerError, "json encode: %s", err)
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	_, _ = w.Write(js)
 }
 
-type filterJSON struct {
-	ID          int64  `json:"id"`
-	Enabled     bool   `json:"enabled"`
-	URL         string `json:"url"`
-	Name        string `json:"name"`
-	RulesCount  uint32 `json:"rules_count"`
-	LastUpdated string `json:"last_updated"`
-}
-
-type filteringConfig struct {
-	Enabled          bool         `json:"enabled"`
-	Interval         uint32       `json:"interval"` // in hours
-	Filters          []filterJSON `json:"filters"`
-	WhitelistFilters []filterJSON `json:"whitelist_filters"`
-	UserRules        []string     `json:"user_rules"`
-}
+// Set the order of filters.
+// Either "urls" (the new order of all filters of the list) or "url" and "index" (move one filter) are set.
+func (f *Filtering) handleFilteringSetOrder(w http.ResponseWriter, r *http.Request) {
+	type Req struct {
+		Type  string   `json:"type"`
+		URLs  []string `json:"urls"`
+		URL   string   `json:"url"`
+		Index int      `json:"index"`
+	}
 
-func filterToJSON(f filter) filterJSON {
-	fj := filterJSON{
-		ID:         f.ID,
-		Enabled:    f.Enabled,
-		URL:        f.URL,
-		Name:       f.Name,
-		RulesCount: uint32(f.RulesCount),
+	req := Req{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgBadJSON, err)
+		return
 	}
 
-	if !f.LastUpdated.IsZero() {
-		fj.LastUpdated = f.LastUpdated.Format(time.RFC3339)
+	whitelist := false
+	switch req.Type {
+	case "blocklist":
+		//
+	case "allowlist":
+		whitelist = true
+	default:
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgUnknownType, req.Type)
+		return
 	}
 
-	return fj
-}
+	var changed bool
+	if len(req.URL) != 0 {
+		changed, err = filterMove(req.URL, req.Index, whitelist)
+	} else {
+		changed, err = filtersSetOrder(req.URLs, whitelist)
+	}
+	if err != nil {
+		f.httpErrorErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	if changed {
+		onConfigModified()
+		enableFilters(true)
+	}
+}
+
+// An invalid user rule
+type ruleErrorJSON struct {
+	Line  int    `json:"line"` // 1-based line number
+	Rule  string `json:"rule"`
+	Error string `json:"error"`
+}
+
+// Check the syntax of user rules
+func validateUserRules(lines []string) []ruleErrorJSON {
+	errs := []ruleErrorJSON{}
+	for i, line := range lines {
+		err := dnsfilter.ValidateRule(line)
+		if err != nil {
+			errs = append(errs, ruleErrorJSON{Line: i + 1, Rule: line, Error: err.Error()})
+		}
+	}
+	return errs
+}
+
+// Set user rules.
+// The rules aren't saved if there are invalid lines.
+// "check_only=true" query parameter: validate the rules without saving them.
+func (f *Filtering) handleFilteringSetRules(w http.ResponseWriter, r *http.Request) {
+	type Resp struct {
+		Errors []ruleErrorJSON `json:"errors"`
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgReadFailed, err)
+		return
+	}
+
+	lines := setRulesRequestLines(r, body)
+	resp := Resp{
+		Errors: validateUserRules(lines),
+	}
+	js, err := json.Marshal(resp)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+		return
+	}
+
+	checkOnly := r.URL.Query().Get("check_only") == "true"
+	if len(resp.Errors) == 0 && !checkOnly {
+		config.Lock()
+		setUserRules(lines)
+		config.Unlock()
+		onConfigModified()
+		enableFilters(true)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if len(resp.Errors) != 0 && !checkOnly {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	_, _ = w.Write(js)
+}
+
+// Check user rule groups: names must be unique and not empty, rules must be valid
+func validateUserRuleGroups(groups []userRuleGroup) error {
+	names := map[string]bool{}
+	for i, g := range groups {
+		if len(g.Name) == 0 {
+			return newMsgError(msgGroupNameRequired, i+1)
+		}
+		if names[g.Name] {
+			return newMsgError(msgGroupDuplicate, g.Name)
+		}
+		names[g.Name] = true
+
+		errs := validateUserRules(g.Rules)
+		if len(errs) != 0 {
+			return newMsgError(msgGroupInvalidRule, g.Name, errs[0].Line, errs[0].Rule, errs[0].Error)
+		}
+	}
+	return nil
+}
+
+func userRuleGroupsEqual(a, b []userRuleGroup) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Enabled != b[i].Enabled ||
+			strings.Join(a[i].Rules, "\n") != strings.Join(b[i].Rules, "\n") {
+			return false
+		}
+	}
+	return true
+}
+
+type userGroupJSON struct {
+	userRuleGroup
+	ID int64 `json:"id"` // filter ID that is used for the group's rules
+}
+
+// Get user rule groups
+func (f *Filtering) handleUserGroups(w http.ResponseWriter, r *http.Request) {
+	resp := []userGroupJSON{}
+	config.RLock()
+	for i, g := range config.UserRuleGroups {
+		resp = append(resp, userGroupJSON{userRuleGroup: g, ID: userRuleGroupID(i)})
+	}
+	config.RUnlock()
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
+
+// Replace all user rule groups
+func (f *Filtering) handleSetUserGroups(w http.ResponseWriter, r *http.Request) {
+	groups := []userRuleGroup{}
+	err := json.NewDecoder(r.Body).Decode(&groups)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgBadJSON, err)
+		return
+	}
+
+	err = validateUserRuleGroups(groups)
+	if err != nil {
+		f.httpErrorErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	config.Lock()
+	config.UserRuleGroups = groups
+	config.Unlock()
+	onConfigModified()
+	enableFilters(true)
+}
+
+// Import Pi-hole configuration files from a multipart form: "adlists", "regex" and "custom".
+// Filter lists from adlists.list are added as blocklists,
+// the rules converted from regex.list and custom.list are appended to the user rules.
+func (f *Filtering) handleFilteringImportPihole(w http.ResponseWriter, r *http.Request) {
+	type Resp struct {
+		Items        []piholeItem `json:"items"`
+		FiltersAdded int          `json:"filters_added"`
+		RulesAdded   int          `json:"rules_added"`
+	}
+
+	err := r.ParseMultipartForm(8 * 1024 * 1024)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgMultipartForm, err)
+		return
+	}
+
+	resp := Resp{
+		Items: []piholeItem{},
+	}
+	var urls []string
+	var rules []string
+	for _, name := range []string{piholeAdlists, piholeRegex, piholeCustom} {
+		file, _, err := r.FormFile(name)
+		if err == http.ErrMissingFile {
+			continue
+		} else if err != nil {
+			f.httpErrorMsg(w, r, http.StatusBadRequest, msgImportFile, name, err)
+			return
+		}
+
+		var res []string
+		var items []piholeItem
+		switch name {
+		case piholeAdlists:
+			urls, items, err = convertPiholeAdlists(file)
+		case piholeRegex:
+			res, items, err = convertPiholeRegex(file)
+		case piholeCustom:
+			res, items, err = convertPiholeCustom(file)
+		}
+		_ = file.Close()
+		if err != nil {
+			f.httpErrorMsg(w, r, http.StatusBadRequest, msgImportFile, name, err)
+			return
+		}
+		rules = append(rules, res...)
+		resp.Items = append(resp.Items, items...)
+	}
+
+	// add the filters via the same path as "add_urls", then mark the failed ones as skipped
+	var req []filterAddJSON
+	for _, u := range urls {
+		req = append(req, filterAddJSON{Name: u, URL: u})
+	}
+	results, nAdded, _ := f.addFilters(req)
+	resp.FiltersAdded = nAdded
+	failed := map[string]string{}
+	for _, res := range results {
+		if len(res.Error) != 0 {
+			failed[res.URL] = res.Error
+		}
+	}
+	for i := range resp.Items {
+		it := &resp.Items[i]
+		if it.File == piholeAdlists && it.Status == "converted" && len(failed[it.Result]) != 0 {
+			it.skip("%s", failed[it.Result])
+			it.Result = ""
+		}
+	}
+
+	config.Lock()
+	userRulesNew := append([]string{}, userRules()...)
+	existing := map[string]bool{}
+	for _, rule := range userRulesNew {
+		existing[rule] = true
+	}
+	for _, rule := range rules {
+		if existing[rule] {
+			continue
+		}
+		existing[rule] = true
+		userRulesNew = append(userRulesNew, rule)
+		resp.RulesAdded++
+	}
+	if resp.RulesAdded != 0 {
+		setUserRules(userRulesNew)
+	}
+	config.Unlock()
+
+	if nAdded != 0 || resp.RulesAdded != 0 {
+		onConfigModified()
+		enableFilters(true)
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
+
+// filterDetailsJSON is the filter object with the extended information
+type filterDetailsJSON struct {
+	filterJSON
+	FileSize     int64  `json:"file_size"` // the size of the stored filter file, 0 if the filter isn't downloaded
+	Checksum     uint32 `json:"checksum"`
+	EffectiveURL string `json:"effective_url"`         // the URL the data was received from on the last download (after redirects)
+	NextUpdate   string `json:"next_update,omitempty"` // when the filter is going to be updated
+}
+
+// Get the time of the next update of the filter
+// Note: config must be locked
+func (filt *filter) nextUpdateTime() time.Time {
+	interval := filtersUpdateInterval()
+	if !filt.Enabled || interval == 0 {
+		return time.Time{}
+	}
+	if !filt.nextUpdate.IsZero() {
+		return filt.nextUpdate
+	}
+	return filt.LastUpdated.Add(interval)
+}
+
+// Get a single filter by ID or URL
+// "type" query parameter: "blocklist" (default) or "allowlist"
+func (f *Filtering) handleFilteringGetFilter(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	whitelist, ok := parseFilterListType(q.Get("type"))
+	if !ok {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgUnknownType, q.Get("type"))
+		return
+	}
+
+	var filt filter
+	var found bool
+	if len(q.Get("id")) != 0 {
+		id, err := strconv.ParseInt(q.Get("id"), 10, 64)
+		if err != nil {
+			f.httpErrorMsg(w, r, http.StatusBadRequest, msgInvalidID, err)
+			return
+		}
+		filt, found = filterFindByID(id, whitelist)
+	} else if len(q.Get("url")) != 0 {
+		filt, found = filterFind(q.Get("url"), whitelist)
+	} else {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgIDOrURLRequired)
+		return
+	}
+	if !found {
+		f.httpErrorMsg(w, r, http.StatusNotFound, msgFilterNotFound)
+		return
+	}
+
+	resp := filterDetailsJSON{
+		filterJSON:   filterToJSON(filt),
+		Checksum:     filt.checksum,
+		EffectiveURL: filt.effectiveURL,
+	}
+	resp.ResponseHeaders = filt.lastResponse
+	resp.Timing = filt.timing
+	if len(resp.EffectiveURL) == 0 {
+		resp.EffectiveURL = filt.URL
+	}
+	st, err := os.Stat(filt.Path())
+	if err == nil {
+		resp.FileSize = st.Size()
+	}
+	config.RLock()
+	next := filt.nextUpdateTime()
+	config.RUnlock()
+	if !next.IsZero() {
+		resp.NextUpdate = next.Format(time.RFC3339)
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
+
+// Refresh filters
+// If "url" or "id" is set, only this filter is downloaded and its last error is returned.
+func (f *Filtering) handleFilteringRefresh(w http.ResponseWriter, r *http.Request) {
+	type Req struct {
+		White bool   `json:"whitelist"`
+		URL   string `json:"url"`
+		ID    int64  `json:"id"`
+		Force bool   `json:"force"` // apply the data of the filter even if the number of rules has dropped suspiciously
+
+		// download the data of the filter bypassing the HTTP caches and rewrite its file even if the data hasn't changed
+		Full bool `json:"force_full"`
+	}
+	type Resp struct {
+		Updated int `json:"updated"`
+	}
+	resp := Resp{}
+	var err error
+
+	req := Req{}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgBadJSON, err)
+		return
+	}
+
+	var filt filter
+	single := len(req.URL) != 0 || req.ID != 0
+	if single {
+		found := false
+		if req.ID != 0 {
+			filt, found = filterFindByID(req.ID, req.White)
+		} else {
+			filt, found = filterFind(req.URL, req.White)
+		}
+		if !found {
+			f.httpErrorMsg(w, r, http.StatusNotFound, msgFilterNotFound)
+			return
+		}
+		if !filt.Enabled {
+			f.httpErrorMsg(w, r, http.StatusBadRequest, msgFilterDisabled)
+			return
+		}
+	} else if req.Full {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgInvalidParameter, "force_full", "only a single filter may be re-downloaded")
+		return
+	}
+
+	// the downloads are aborted if the client goes away
+	ctx, cancel := f.withContext(r.Context())
+	Context.controlLock.Unlock()
+	if single {
+		flags := 0
+		if req.Force {
+			flags |= FilterRefreshAcceptRuleDrop
+		}
+		if req.Full {
+			flags |= FilterRefreshFull
+		}
+		resp.Updated, err = f.refreshSingleFilter(ctx, filt.ID, req.White, flags)
+	} else {
+		flags := FilterRefreshBlocklists
+		if req.White {
+			flags = FilterRefreshAllowlists
+		}
+		resp.Updated, err = f.refreshFiltersOnly(ctx, flags|FilterRefreshForce, false, updateTriggerManual, 0)
+	}
+	Context.controlLock.Lock()
+	cancel()
+	if _, ok := err.(*filterDownloadError); ok {
+		f.httpErrorErr(w, r, http.StatusBadGateway, err)
+		return
+	} else if err != nil {
+		f.httpErrorErr(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
+
+type filterJSON struct {
+	ID          int64  `json:"id"`
+	Enabled     bool   `json:"enabled"`
+	URL         string `json:"url"`
+	Name        string `json:"name"`
+	RulesCount  uint32 `json:"rules_count"`
+	LastUpdated string `json:"last_updated"`
+	ApplyTo     string `json:"apply_to"` // "all", "dhcp_clients" or "non_dhcp_clients"
+	Notes       string `json:"notes,omitempty"`
+	SizeBytes   int64  `json:"size_bytes"` // the size of the filter file on disk
+
+	AllowExceptions bool `json:"allow_exceptions"` // FALSE if the allowlists and the exception rules can't override the rules of the blocklist
+
+	EstMemory *int64     `json:"est_memory_bytes,omitempty"` // only in verbose status
+	RuleStats *ruleStats `json:"rule_stats,omitempty"`       // only in verbose status
+
+	PunycodeRules int      `json:"punycode_rules,omitempty"` // the number of rules converted to punycode
+	Warnings      []string `json:"warnings,omitempty"`       // problems found in the filter data
+	LastError     string   `json:"last_error,omitempty"`     // the error of the last update attempt
+	Kind          string   `json:"kind,omitempty"`           // "blocklist" or "allowlist" by the header or the rules, not set if the kind is unknown
+
+	DownloadSize     int64 `json:"download_size,omitempty"`        // the number of bytes received during the last download
+	DownloadDuration int64 `json:"download_duration_ms,omitempty"` // how long the last download took (in milliseconds)
+
+	ResponseHeaders string          `json:"response_headers,omitempty"` // only in verbose status and filter details: the response of the last download attempt
+	Timing          *downloadTiming `json:"timing,omitempty"`           // only in verbose status and filter details: the timing of the last traced download attempt
+}
+
+type filteringConfig struct {
+	Enabled          bool         `json:"enabled"`
+	Interval         uint32       `json:"interval"`                   // in hours
+	IntervalMinutes  *uint32      `json:"interval_minutes,omitempty"` // in minutes, takes precedence over Interval if not 0; not changed if not set
+	Filters          []filterJSON `json:"filters"`
+	WhitelistFilters []filterJSON `json:"whitelist_filters"`
+	UserRules        []string     `json:"user_rules"`
+
+	ProxyURL *string `json:"proxy_url,omitempty"` // the proxy for the filters downloads (the password is hidden in response), "": the global proxy is used; not changed if not set
+
+	FiltersTotal          *int `json:"filters_total,omitempty"`           // only in response
+	WhitelistFiltersTotal *int `json:"whitelist_filters_total,omitempty"` // only in response
+
+	LastUpdateCycles []updateCycle    `json:"last_update_cycles,omitempty"` // only in response
+	ReloadPending    bool             `json:"reload_pending"`               // only in response
+	ReloadETA        string           `json:"reload_eta,omitempty"`         // only in response
+	LowDisk          bool             `json:"low_disk"`                     // only in response
+	ReadOnly         bool             `json:"read_only"`                    // only in response: the filters directory isn't writable, the filters aren't updated
+	PostUpdateHook   *hookStatus      `json:"post_update_hook,omitempty"`   // only in response: the state of the post-update hook if it's configured
+	UpdatesPaused    bool             `json:"updates_paused"`               // only in response
+	Loading          bool             `json:"loading"`                      // only in response: the filters are being loaded in background on startup
+	InactiveFilters  []inactiveFilter `json:"inactive_filters"`             // only in response
+	CompactionSaved  int64            `json:"compaction_saved_bytes"`       // only in response
+
+	TotalRulesEnabled    int    `json:"total_rules_enabled"`              // only in response
+	TotalFiltersEnabled  int    `json:"total_filters_enabled"`            // only in response
+	EngineRulesCount     *int   `json:"engine_rules_count,omitempty"`     // only in response
+	EngineMemoryEstimate *int64 `json:"engine_memory_estimate,omitempty"` // only in response
+
+	EstMemory map[string]int64 `json:"est_memory_bytes,omitempty"` // only in verbose status: "blocklist" and "whitelist" estimates
+}
+
+// Get the number of enabled filter lists (blocklists and allowlists) and the total number of rules in them
+// Note: config must be locked
+func enabledFiltersTotals() (filters int, rules int) {
+	for _, list := range [][]filter{config.Filters, config.WhitelistFilters} {
+		for i := range list {
+			if !list[i].Enabled {
+				continue
+			}
+			filters++
+			rules += list[i].RulesCount
+		}
+	}
+	return filters, rules
+}
+
+func filterToJSON(f filter) filterJSON {
+	fj := filterJSON{
+		ID:         f.ID,
+		Enabled:    f.Enabled,
+		URL:        f.URL,
+		Name:       f.Name,
+		RulesCount: uint32(f.RulesCount),
+		ApplyTo:    f.ApplyTo,
+		Notes:      f.Notes,
+		SizeBytes:  f.sizeBytes(),
+		LastError:  f.lastError,
+
+		AllowExceptions: f.allowExceptions(),
+
+		PunycodeRules: f.punycodeRules,
+		Warnings:      f.warnings,
+		Kind:          f.kind(),
+
+		DownloadSize:     f.downloadSize,
+		DownloadDuration: f.downloadDuration.Milliseconds(),
+	}
+
+	if !f.LastUpdated.IsZero() {
+		fj.LastUpdated = f.LastUpdated.Format(time.RFC3339)
+	}
+	if len(fj.ApplyTo) == 0 {
+		fj.ApplyTo = applyToAll
+	}
+
+	return fj
+}
+
+// statusQuery is the set of status request parameters that limit the returned filters
+type statusQuery struct {
+	storage     string // "": all lists; "blocklist" or "whitelist"
+	offset      int
+	limit       int // 0: no limit
+	enabledOnly bool
+	verbose     bool   // add the memory estimates
+	search      string // in lower case: return only the filters whose name, URL or notes contain it
+}
+
+// Parse status request parameters
+// Return FALSE if there are no parameters: the full response must be returned
+func parseStatusQuery(q url.Values) (statusQuery, bool, error) {
+	sq := statusQuery{}
+	set := false
+
+	switch t := q.Get("type"); t {
+	case "":
+		//
+	case "blocklist", "whitelist":
+		sq.storage = t
+		set = true
+	case "allowlist":
+		sq.storage = "whitelist"
+		set = true
+	default:
+		return sq, false, newMsgError(msgUnknownType, t)
+	}
+
+	for _, p := range []struct {
+		name string
+		val  *int
+	}{{"offset", &sq.offset}, {"limit", &sq.limit}} {
+		s := q.Get(p.name)
+		if len(s) == 0 {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return sq, false, newMsgError(msgInvalidParameter, p.name, s)
+		}
+		*p.val = n
+		set = true
+	}
+
+	if q.Get("enabled_only") == "true" {
+		sq.enabledOnly = true
+		set = true
+	}
+	if s := q.Get("q"); len(s) != 0 {
+		sq.search = strings.ToLower(s)
+		set = true
+	}
+	sq.verbose = q.Get("verbose") == "true"
+	return sq, set, nil
+}
+
+// Convert the filters to JSON objects applying the status request parameters
+// Return the objects and the total number of the filters matching the parameters
+func filtersToJSON(filters []filter, sq statusQuery) ([]filterJSON, int) {
+	var res []filterJSON
+	total := 0
+	for _, f := range filters {
+		if sq.enabledOnly && !f.Enabled {
+			continue
+		}
+		if !filterMatchesSearch(f, sq.search) {
+			continue
+		}
+		total++
+		if total <= sq.offset || (sq.limit != 0 && len(res) == sq.limit) {
+			continue
+		}
+		fj := filterToJSON(f)
+		if sq.verbose {
+			mem := f.EstimateMemory()
+			st := f.ruleStats
+			fj.EstMemory = &mem
+			fj.RuleStats = &st
+			fj.ResponseHeaders = f.lastResponse
+			fj.Timing = f.timing
+		}
+		res = append(res, fj)
+	}
+	return res, total
+}
+
+// Get filtering configuration
+// Optional query parameters:
+//  type=blocklist|whitelist: return only this list (without user rules)
+//  offset, limit: return only a part of the lists
+//  enabled_only=true: return only the enabled filters
+//  q: return only the filters whose name, URL or notes contain this string (case-insensitive)
+//  verbose=true: add the memory estimates for each filter and list
+// If a parameter is set, the total number of filters is returned for each list.
+func (f *Filtering) handleFilteringStatus(w http.ResponseWriter, r *http.Request) {
+	sq, paged, err := parseStatusQuery(r.URL.Query())
+	if err != nil {
+		f.httpErrorErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	resp := filteringConfig{}
+	config.RLock()
+	resp.Enabled = config.DNS.FilteringEnabled
+	resp.Interval = config.DNS.FiltersUpdateIntervalHours
+	resp.UpdatesPaused = config.DNS.FiltersUpdatesPaused
+	resp.Loading = f.isLoading()
+	minutes := config.DNS.FiltersUpdateMinutes
+	resp.IntervalMinutes = &minutes
+	proxy := redactProxyURL(config.DNS.FiltersProxyURL)
+	resp.ProxyURL = &proxy
+	if sq.storage == "" || sq.storage == "blocklist" {
+		var total int
+		resp.Filters, total = filtersToJSON(config.Filters, sq)
+		if paged {
+			resp.FiltersTotal = &total
+		}
+	}
+	if sq.storage == "" || sq.storage == "whitelist" {
+		var total int
+		resp.WhitelistFilters, total = filtersToJSON(config.WhitelistFilters, sq)
+		if paged {
+			resp.WhitelistFiltersTotal = &total
+		}
+	}
+	if sq.storage == "" {
+		resp.UserRules = userRules()
+	}
+	resp.TotalFiltersEnabled, resp.TotalRulesEnabled = enabledFiltersTotals()
+	if sq.verbose {
+		resp.EstMemory = map[string]int64{
+			"blocklist": estimateStorageMemory(config.Filters),
+			"whitelist": estimateStorageMemory(config.WhitelistFilters),
+		}
+	}
+	config.RUnlock()
+	if st, ok := f.engineStatus(); ok {
+		resp.EngineRulesCount = &st.RulesCount
+		resp.EngineMemoryEstimate = &st.MemoryEstimate
+	}
+	resp.LastUpdateCycles = f.updateCycles()
+	resp.LowDisk = f.isLowDisk()
+	resp.ReadOnly = f.isReadOnly()
+	resp.PostUpdateHook = f.postUpdateHookStatus()
+	resp.InactiveFilters = f.inactiveFilters()
+	resp.CompactionSaved = f.compactionSaved()
+	var eta time.Time
+	resp.ReloadPending, eta = f.reloadStatus()
+	if resp.ReloadPending {
+		resp.ReloadETA = eta.Format(time.RFC3339)
+	}
+
+	jsonVal, err := json.Marshal(resp)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(jsonVal)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgWriteFailed, err)
+	}
+}
+
+// Set filtering configuration
+func (f *Filtering) handleFilteringConfig(w http.ResponseWriter, r *http.Request) {
+	req := filteringConfig{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgBadJSON, err)
+		return
+	}
+
+	if !checkFiltersUpdateIntervalHours(req.Interval) ||
+		(req.IntervalMinutes != nil && !checkFiltersUpdateIntervalMinutes(*req.IntervalMinutes)) {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgUnsupportedInterval)
+		return
+	}
+
+	proxy := config.DNS.FiltersProxyURL
+	if req.ProxyURL != nil && *req.ProxyURL != redactProxyURL(proxy) {
+		// the client sends back the hidden password if the proxy isn't changed
+		proxy = *req.ProxyURL
+		err = checkFiltersProxyURL(proxy)
+		if err != nil {
+			f.httpErrorMsg(w, r, http.StatusBadRequest, msgInvalidProxyURL, err)
+			return
+		}
+	}
+
+	config.DNS.FilteringEnabled = req.Enabled
+	config.DNS.FiltersProxyURL = proxy
+	config.DNS.FiltersUpdateIntervalHours = req.Interval
+	if req.IntervalMinutes != nil {
+		config.DNS.FiltersUpdateMinutes = *req.IntervalMinutes
+	}
+	onConfigModified()
+	enableFilters(true)
+}
+
+type filterExportJSON struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Enabled bool   `json:"enabled"`
+	Notes   string `json:"notes,omitempty"`
+}
+
+// filteringExportJSON is a portable filtering configuration
+type filteringExportJSON struct {
+	Filters          []filterExportJSON `json:"filters"`
+	WhitelistFilters []filterExportJSON `json:"whitelist_filters"`
+	UserRules        []string           `json:"user_rules"`
+	UserRuleGroups   []userRuleGroup    `json:"user_rule_groups,omitempty"`
+	Interval         uint32             `json:"interval"` // in hours
+}
+
+type updatesPauseReq struct {
+	Paused bool `json:"paused"`
+}
+
+// Pause or resume the automatic filters updates
+func (f *Filtering) handleUpdatesPause(w http.ResponseWriter, r *http.Request) {
+	req := updatesPauseReq{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgBadJSON, err)
+		return
+	}
+
+	if req.Paused {
+		f.PauseUpdates()
+	} else {
+		f.ResumeUpdates()
+	}
+}
+
+// Export filtering configuration
+func (f *Filtering) handleFilteringExport(w http.ResponseWriter, r *http.Request) {
+	exp := filteringExportJSON{
+		Filters:          []filterExportJSON{},
+		WhitelistFilters: []filterExportJSON{},
+	}
+	config.RLock()
+	for _, filt := range config.Filters {
+		exp.Filters = append(exp.Filters, filterExportJSON{Name: filt.Name, URL: filt.URL, Enabled: filt.Enabled, Notes: filt.Notes})
+	}
+	for _, filt := range config.WhitelistFilters {
+		exp.WhitelistFilters = append(exp.WhitelistFilters, filterExportJSON{Name: filt.Name, URL: filt.URL, Enabled: filt.Enabled, Notes: filt.Notes})
+	}
+	exp.UserRules = append([]string{}, userRules()...)
+	exp.UserRuleGroups = append([]userRuleGroup{}, config.UserRuleGroups...)
+	exp.Interval = config.DNS.FiltersUpdateIntervalHours
+	config.RUnlock()
+
+	js, err := json.Marshal(exp)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
+
+// The result of importing a single item
+type importResultJSON struct {
+	URL       string `json:"url,omitempty"`
+	Whitelist bool   `json:"whitelist"`
+	Status    string `json:"status"` // "added", "updated", "unchanged", "removed", "conflict", "error"
+	Error     string `json:"error,omitempty"`
+}
+
+// Check the imported configuration
+func validateFilteringImport(req filteringExportJSON) error {
+	if !checkFiltersUpdateIntervalHours(req.Interval) {
+		return newMsgError(msgUnsupportedInterval)
+	}
+
+	urls := map[string]bool{}
+	for _, arr := range [][]filterExportJSON{req.Filters, req.WhitelistFilters} {
+		for _, fj := range arr {
+			if !isValidFilterURL(fj.URL) {
+				return newMsgError(msgInvalidURLValue, fj.URL)
+			}
+			if urls[fj.URL] {
+				return newMsgError(msgDuplicateURL, fj.URL)
+			}
+			urls[fj.URL] = true
+			if _, err := sanitizeFilterNotes(fj.Notes); err != nil {
+				return err
+			}
+		}
+	}
+	return validateUserRuleGroups(req.UserRuleGroups)
+}
+
+// Find a filter by URL
+// Return the copy of filter object and TRUE if found
+func filterFind(url string, whitelist bool) (filter, bool) {
+	config.RLock()
+	defer config.RUnlock()
+	filters := config.Filters
+	if whitelist {
+		filters = config.WhitelistFilters
+	}
+	for _, filt := range filters {
+		if filt.URL == url {
+			return filt, true
+		}
+	}
+	return filter{}, false
+}
 
-// Get filtering configuration
-func (f *Filtering) handleFilteringStatus(w http.ResponseWriter, r *http.Request) {
-	resp := filteringConfig{}
+// Find a filter by ID
+// Return the copy of filter object and TRUE if found
+func filterFindByID(id int64, whitelist bool) (filter, bool) {
 	config.RLock()
-	resp.Enabled = config.DNS.FilteringEnabled
-	resp.Interval = config.DNS.FiltersUpdateIntervalHours
-	for _, f := range config.Filters {
-		fj := filterToJSON(f)
-		resp.Filters = append(resp.Filters, fj)
+	defer config.RUnlock()
+	filters := config.Filters
+	if whitelist {
+		filters = config.WhitelistFilters
 	}
-	for _, f := range config.WhitelistFilters {
-		fj := filterToJSON(f)
-		resp.WhitelistFilters = append(resp.WhitelistFilters, fj)
+	for _, filt := range filters {
+		if filt.ID == id {
+			return filt, true
+		}
 	}
-	resp.UserRules = config.UserRules
-	config.RUnlock()
+	return filter{}, false
+}
 
-	jsonVal, err := json.Marshal(resp)
-	if err != nil {
-		httpError(w, http.StatusInternalServerError, "json encode: %s", err)
-		return
+// Import a list of filters
+// Return TRUE if the configuration has been changed and TRUE if the filters must be updated
+func (f *Filtering) importFilters(items []filterExportJSON, whitelist bool, replace bool, results *[]importResultJSON) (bool, bool) {
+	modified := false
+	updateRequired := false
+
+	urls := map[string]bool{}
+	for _, fj := range items {
+		urls[fj.URL] = true
+		res := importResultJSON{URL: fj.URL, Whitelist: whitelist}
+
+		// the length has been checked by validateFilteringImport()
+		fj.Notes, _ = sanitizeFilterNotes(fj.Notes)
+
+		existing, ok := filterFind(fj.URL, whitelist)
+		if ok {
+			res.Status = "unchanged"
+			if existing.Name != fj.Name || existing.Enabled != fj.Enabled || existing.Notes != fj.Notes {
+				newf := filter{Name: fj.Name, URL: fj.URL, Enabled: fj.Enabled, Notes: fj.Notes}
+				status := f.filterSetProperties(fj.URL, newf, whitelist)
+				if (status & statusUpdateRequired) != 0 {
+					updateRequired = true
+				}
+				res.Status = "updated"
+				modified = true
+			}
+			*results = append(*results, res)
+			continue
+		}
+
+		if _, ok = filterFind(fj.URL, !whitelist); ok {
+			// a filter can be in one list only: the user must resolve the conflict
+			res.Status = "conflict"
+			res.Error = "the filter is in the blocklists"
+			if !whitelist {
+				res.Error = "the filter is in the allowlists"
+			}
+			*results = append(*results, res)
+			continue
+		}
+
+		enabled := fj.Enabled
+		filt, err := f.downloadNewFilter(filterAddJSON{Name: fj.Name, URL: fj.URL, Whitelist: whitelist, Enabled: &enabled, Notes: fj.Notes})
+		if err == nil && !filterAdd(filt) {
+			err = newMsgError(msgFilterExists, fj.URL)
+		}
+		if err != nil {
+			res.Status = "error"
+			res.Error = err.Error()
+		} else {
+			res.Status = "added"
+			modified = true
+		}
+		*results = append(*results, res)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	_, err = w.Write(jsonVal)
-	if err != nil {
-		httpError(w, http.StatusInternalServerError, "http write: %s", err)
+
+	if !replace {
+		return modified, updateRequired
+	}
+
+	var remove []string
+	config.RLock()
+	filters := config.Filters
+	if whitelist {
+		filters = config.WhitelistFilters
+	}
+	for _, filt := range filters {
+		if !urls[filt.URL] {
+			remove = append(remove, filt.URL)
+		}
+	}
+	config.RUnlock()
+
+	for _, u := range remove {
+		if filterRemove(u, whitelist) {
+			*results = append(*results, importResultJSON{URL: u, Whitelist: whitelist, Status: "removed"})
+			modified = true
+		}
 	}
+	return modified, updateRequired
 }
 
-// Set filtering configuration
-func (f *Filtering) handleFilteringConfig(w http.ResponseWriter, r *http.Request) {
-	req := filteringConfig{}
+// Import filtering configuration.
+// Missing filters are added, filters not present in the request are removed if "replace" is set.
+// Importing the same configuration twice changes nothing.
+// "user_rule_groups" (if present) take precedence over "user_rules".
+func (f *Filtering) handleFilteringImport(w http.ResponseWriter, r *http.Request) {
+	type Req struct {
+		filteringExportJSON
+		Replace bool `json:"replace"`
+	}
+	type Resp struct {
+		Results []importResultJSON `json:"results"`
+	}
+
+	req := Req{}
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		httpError(w, http.StatusBadRequest, "json decode: %s", err)
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgBadJSON, err)
 		return
 	}
 
-	if !checkFiltersUpdateIntervalHours(req.Interval) {
-		httpError(w, http.StatusBadRequest, "Unsupported interval")
+	err = validateFilteringImport(req.filteringExportJSON)
+	if err != nil {
+		f.httpErrorErr(w, r, http.StatusBadRequest, err)
 		return
 	}
+	if req.UserRuleGroups == nil && req.UserRules != nil {
+		// the same response as for the invalid rules in "set_rules"
+		errs := validateUserRules(req.UserRules)
+		if len(errs) != 0 {
+			js, err := json.Marshal(map[string][]ruleErrorJSON{"errors": errs})
+			if err != nil {
+				f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write(js)
+			return
+		}
+	}
 
-	config.DNS.FilteringEnabled = req.Enabled
-	config.DNS.FiltersUpdateIntervalHours = req.Interval
-	onConfigModified()
-	enableFilters(true)
+	resp := Resp{
+		Results: []importResultJSON{},
+	}
+	modified, updateRequired := f.importFilters(req.Filters, false, req.Replace, &resp.Results)
+	modifiedW, updateRequiredW := f.importFilters(req.WhitelistFilters, true, req.Replace, &resp.Results)
+	modified = modified || modifiedW
+
+	config.Lock()
+	if req.UserRuleGroups != nil {
+		if !userRuleGroupsEqual(req.UserRuleGroups, config.UserRuleGroups) {
+			config.UserRuleGroups = req.UserRuleGroups
+			modified = true
+		}
+	} else if req.UserRules != nil && strings.Join(req.UserRules, "\n") != strings.Join(userRules(), "\n") {
+		setUserRules(req.UserRules)
+		modified = true
+	}
+	if config.DNS.FiltersUpdateIntervalHours != req.Interval {
+		config.DNS.FiltersUpdateIntervalHours = req.Interval
+		modified = true
+	}
+	config.Unlock()
+
+	if modified {
+		onConfigModified()
+		nUpdated := 0
+		if updateRequired || updateRequiredW {
+			flags := 0
+			if updateRequired {
+				flags |= FilterRefreshBlocklists
+			}
+			if updateRequiredW {
+				flags |= FilterRefreshAllowlists
+			}
+			// if at least 1 filter has been updated, refreshFilters() restarts the filtering automatically
+			nUpdated, _ = f.refreshFilters(flags, true, updateTriggerManual)
+		}
+		if nUpdated == 0 {
+			enableFilters(true)
+		}
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
 }
 
 type checkHostResp struct {
-	Reason   string `json:"reason"`
-	FilterID int64  `json:"filter_id"`
-	Rule     string `json:"rule"`
+	Reason     string `json:"reason"`
+	FilterID   int64  `json:"filter_id"`
+	FilterName string `json:"filter_name,omitempty"` // "(removed)" if the filter doesn't exist anymore
+	FilterURL  string `json:"filter_url,omitempty"`
+	Rule       string `json:"rule"`
 
 	// for FilteredBlockedService:
 	SvcName string `json:"service_name"`
@@ -343,31 +1729,380 @@ type checkHostResp struct {
 	// for ReasonRewrite:
 	CanonName string   `json:"cname"`    // CNAME value
 	IPList    []net.IP `json:"ip_addrs"` // list of IP addresses
+
+	QType  string `json:"qtype"`            // the query type the host was checked with
+	Client string `json:"client,omitempty"` // the client the host was checked for
+
+	Strict bool `json:"strict,omitempty"` // TRUE if the host is blocked by a strict blocklist: the allowlists haven't been considered
+
+	Results []checkHostResp `json:"results,omitempty"` // the results for each query type, if several types are requested
 }
 
-func (f *Filtering) handleCheckHost(w http.ResponseWriter, r *http.Request) {
-	q := r.URL.Query()
-	host := q.Get("name")
+// Get the name and URL of the filter (or the name of the user rule group) by ID
+// Return "(removed)" name if there's no such filter
+func filterNameByID(id int64) (string, string) {
+	config.RLock()
+	defer config.RUnlock()
 
-	setts := Context.dnsFilter.GetConfig()
-	setts.FilteringEnabled = true
-	Context.dnsFilter.ApplyBlockedServices(&setts, nil, true)
-	result, err := Context.dnsFilter.CheckHost(host, dns.TypeA, &setts)
+	if id <= 0 {
+		i := int(-id)
+		if i < len(config.UserRuleGroups) {
+			return config.UserRuleGroups[i].Name, ""
+		}
+		if i == 0 {
+			return defaultUserRuleGroup, ""
+		}
+		return "(removed)", ""
+	}
+
+	for _, filters := range [][]filter{config.Filters, config.WhitelistFilters} {
+		for _, filt := range filters {
+			if filt.ID == id {
+				return filt.Name, filt.URL
+			}
+		}
+	}
+	return "(removed)", ""
+}
+
+// dns package doesn't know about HTTPS type yet
+const typeHTTPS = 65
+
+// Parse DNS query type: "A", "AAAA", "HTTPS", etc. or a number
+// Empty string means "A"
+func parseQType(s string) (uint16, error) {
+	if len(s) == 0 {
+		return dns.TypeA, nil
+	}
+	n, err := strconv.ParseUint(s, 10, 16)
+	if err == nil {
+		return uint16(n), nil
+	}
+	s = strings.ToUpper(s)
+	if s == "HTTPS" {
+		return typeHTTPS, nil
+	}
+	qtype, ok := dns.StringToType[s]
+	if !ok {
+		return 0, newMsgError(msgUnknownQType, s)
+	}
+	return qtype, nil
+}
+
+func qtypeToString(qtype uint16) string {
+	if qtype == typeHTTPS {
+		return "HTTPS"
+	}
+	return dns.Type(qtype).String()
+}
+
+// Parse the comma-separated list of DNS query types
+func parseQTypes(s string) ([]uint16, error) {
+	if len(s) == 0 {
+		return []uint16{dns.TypeA}, nil
+	}
+	var qtypes []uint16
+	for _, t := range strings.Split(s, ",") {
+		qtype, err := parseQType(strings.TrimSpace(t))
+		if err != nil {
+			return nil, err
+		}
+		qtypes = append(qtypes, qtype)
+	}
+	return qtypes, nil
+}
+
+// Check how the host name is filtered for the query type
+func checkHost(host string, qtype uint16, setts *dnsfilter.RequestFilteringSettings) (checkHostResp, error) {
+	result, err := Context.dnsFilter.CheckHost(host, qtype, setts)
 	if err != nil {
-		httpError(w, http.StatusInternalServerError, "couldn't apply filtering: %s: %s", host, err)
-		return
+		return checkHostResp{}, err
 	}
 
 	resp := checkHostResp{}
 	resp.Reason = result.Reason.String()
 	resp.FilterID = result.FilterID
 	resp.Rule = result.Rule
+	if result.Reason == dnsfilter.FilteredBlackList || result.Reason == dnsfilter.NotFilteredWhiteList {
+		resp.FilterName, resp.FilterURL = filterNameByID(result.FilterID)
+	}
 	resp.SvcName = result.ServiceName
 	resp.CanonName = result.CanonName
 	resp.IPList = result.IPList
+	resp.QType = qtypeToString(qtype)
+	resp.Strict = result.Reason == dnsfilter.FilteredBlackList && isStrictFilter(result.FilterID)
+	return resp, nil
+}
+
+// Apply the filtering settings of the client whose requests are checked.
+// "client" is an IP address, or the name or an ID (IP, CIDR or MAC) of a persistent client.
+// Return FALSE if there's no such client.
+func applyCheckClient(client string, setts *dnsfilter.RequestFilteringSettings) bool {
+	if len(client) == 0 || net.ParseIP(client) != nil {
+		applyAdditionalFiltering(client, setts)
+		return true
+	}
+
+	c, ok := Context.clients.FindByNameOrID(client)
+	if !ok {
+		return false
+	}
+
+	// filter lists may be applied to the client by its IP address
+	for _, id := range c.IDs {
+		if net.ParseIP(id) != nil {
+			applyAdditionalFiltering(id, setts)
+			return true
+		}
+	}
+
+	applyAdditionalFiltering("", setts)
+	applyClientSettings(c, setts)
+	return true
+}
+
+// Check how the host name is filtered.
+// "qtype" (or "type") query parameter: DNS query type (A by default) or a comma-separated list of types.
+// "client" query parameter: IP address, or the name or an ID of a persistent client
+//  whose filtering settings are used.
+// If several query types are specified, the results for each type are returned in "results",
+// and the top-level fields contain the result for the first type.
+func (f *Filtering) handleCheckHost(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	host := q.Get("name")
+
+	types := q.Get("qtype")
+	if len(types) == 0 {
+		types = q.Get("type")
+	}
+	qtypes, err := parseQTypes(types)
+	if err != nil {
+		f.httpErrorErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	client := q.Get("client")
+
+	// use the same settings as DNS server does for the client's requests
+	setts := Context.dnsFilter.GetConfig()
+	setts.FilteringEnabled = true
+	if !applyCheckClient(client, &setts) {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgClientNotFound, client)
+		return
+	}
+
+	var results []checkHostResp
+	for _, qtype := range qtypes {
+		res, err := checkHost(host, qtype, &setts)
+		if err != nil {
+			f.httpErrorMsg(w, r, http.StatusInternalServerError, msgFilteringFailed, host, err)
+			return
+		}
+		res.Client = client
+		results = append(results, res)
+	}
+
+	resp := results[0]
+	if len(results) > 1 {
+		resp.Results = results
+	}
+	js, err := json.Marshal(resp)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
+
+type testRuleReq struct {
+	Rule  string `json:"rule"`
+	Host  string `json:"host"`
+	QType string `json:"qtype"` // A by default
+}
+
+type testRuleResp struct {
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason"`       // "FilteredBlackList", "NotFilteredWhiteList" (an exception rule) or "NotFilteredNotFound"
+	IP      string `json:"ip,omitempty"` // the address from a hosts-style rule
+	QType   string `json:"qtype"`
+}
+
+// Check whether a single rule matches the host name.
+// The rule isn't added to the configuration, the configured filters aren't used.
+func (f *Filtering) handleTestRule(w http.ResponseWriter, r *http.Request) {
+	req := testRuleReq{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgBadJSON, err)
+		return
+	}
+	if len(req.Host) == 0 {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgHostRequired)
+		return
+	}
+	qtype, err := parseQType(req.QType)
+	if err != nil {
+		f.httpErrorErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	res, err := dnsfilter.MatchRule(req.Rule, req.Host, qtype)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgInvalidRule, err)
+		return
+	}
+
+	resp := testRuleResp{
+		Matched: res.Reason == dnsfilter.FilteredBlackList || res.Reason == dnsfilter.NotFilteredWhiteList,
+		Reason:  res.Reason.String(),
+		QType:   qtypeToString(qtype),
+	}
+	if len(res.IP) != 0 {
+		resp.IP = res.IP.String()
+	}
+	js, err := json.Marshal(resp)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
+
+// The maximum number of host names in a check_hosts request
+const checkHostsMax = 256
+
+// Check how several host names are filtered.
+// The results are returned in the same order as the names in the request.
+func (f *Filtering) handleCheckHosts(w http.ResponseWriter, r *http.Request) {
+	type Req struct {
+		Names  []string `json:"names"`
+		QType  string   `json:"qtype"`  // A by default
+		Client string   `json:"client"` // IP address, or the name or an ID of a persistent client
+	}
+
+	req := Req{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgBadJSON, err)
+		return
+	}
+	if len(req.Names) > checkHostsMax {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgTooManyNames, len(req.Names), checkHostsMax)
+		return
+	}
+	qtype, err := parseQType(req.QType)
+	if err != nil {
+		f.httpErrorErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	setts := Context.dnsFilter.GetConfig()
+	setts.FilteringEnabled = true
+	if !applyCheckClient(req.Client, &setts) {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgClientNotFound, req.Client)
+		return
+	}
+
+	resp := []checkHostResp{}
+	for _, host := range req.Names {
+		res, err := checkHost(host, qtype, &setts)
+		if err != nil {
+			f.httpErrorMsg(w, r, http.StatusInternalServerError, msgFilteringFailed, host, err)
+			return
+		}
+		res.Client = req.Client
+		resp = append(resp, res)
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
+
+const searchRulesLimitMax = 1000
+
+type searchRulesResp struct {
+	Matches   []ruleMatch `json:"matches"`
+	Truncated bool        `json:"truncated"` // TRUE if there are more matches than returned
+}
+
+// Search for the filter lines containing a substring or matching a regular expression
+func (f *Filtering) handleSearchRules(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := q.Get("query")
+	if len(query) == 0 {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgQueryRequired)
+		return
+	}
+
+	whitelist, ok := parseFilterListType(q.Get("type"))
+	if !ok {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgUnknownType, q.Get("type"))
+		return
+	}
+
+	limit := searchRulesLimitMax
+	if s := q.Get("limit"); len(s) != 0 {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			f.httpErrorMsg(w, r, http.StatusBadRequest, msgInvalidLimit, s)
+			return
+		}
+		limit = util.MinInt(n, searchRulesLimitMax)
+	}
+
+	match := func(line string) bool {
+		return strings.Contains(line, query)
+	}
+	if q.Get("regex") == "true" {
+		re, err := regexp.Compile(query)
+		if err != nil {
+			f.httpErrorMsg(w, r, http.StatusBadRequest, msgInvalidRegexp, err)
+			return
+		}
+		match = re.MatchString
+	}
+
+	resp := searchRulesResp{}
+	resp.Matches, resp.Truncated = f.searchRules(match, whitelist, limit)
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
+
+// Get the history of filter update cycles
+func (f *Filtering) handleUpdateCycles(w http.ResponseWriter, r *http.Request) {
+	type Resp struct {
+		Cycles []updateCycle `json:"update_cycles"`
+	}
+	resp := Resp{
+		Cycles: f.updateCycles(),
+	}
+
 	js, err := json.Marshal(resp)
 	if err != nil {
-		httpError(w, http.StatusInternalServerError, "json encode: %s", err)
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
+
+// Get the state of the filters update procedure
+func (f *Filtering) handleUpdateStatus(w http.ResponseWriter, r *http.Request) {
+	js, err := json.Marshal(f.updateStatus())
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
@@ -376,16 +2111,57 @@ func (f *Filtering) handleCheckHost(w http.ResponseWriter, r *http.Request) {
 
 // RegisterFilteringHandlers - register handlers
 func (f *Filtering) RegisterFilteringHandlers() {
-	httpRegister("GET", "/control/filtering/status", f.handleFilteringStatus)
-	httpRegister("POST", "/control/filtering/config", f.handleFilteringConfig)
-	httpRegister("POST", "/control/filtering/add_url", f.handleFilteringAddURL)
-	httpRegister("POST", "/control/filtering/remove_url", f.handleFilteringRemoveURL)
-	httpRegister("POST", "/control/filtering/set_url", f.handleFilteringSetURL)
-	httpRegister("POST", "/control/filtering/refresh", f.handleFilteringRefresh)
-	httpRegister("POST", "/control/filtering/set_rules", f.handleFilteringSetRules)
-	httpRegister("GET", "/control/filtering/check_host", f.handleCheckHost)
+	f.registerHandlers(httpRegister)
+}
+
+// Register the handlers with the specified function (the web server's mux or a test mux)
+func (f *Filtering) registerHandlers(register func(method, url string, handler func(http.ResponseWriter, *http.Request))) {
+	register("GET", "/control/filtering/status", f.handleFilteringStatus)
+	register("GET", "/control/filtering/filter", f.handleFilteringGetFilter)
+	register("POST", "/control/filtering/config", f.handleFilteringConfig)
+	register("POST", "/control/filtering/add_url", f.handleFilteringAddURL)
+	register("POST", "/control/filtering/add_urls", f.handleFilteringAddURLs)
+	register("POST", "/control/filtering/check_url", f.handleFilteringCheckURL)
+	register("POST", "/control/filtering/remove_url", f.handleFilteringRemoveURL)
+	register("POST", "/control/filtering/remove_all", f.handleFilteringRemoveAll)
+	register("GET", "/control/filtering/selftest", f.handleSelfTest)
+	register("GET", "/control/filtering/metrics", f.handleActivityMetrics)
+	register("POST", "/control/filtering/restore_url", f.handleFilteringRestoreURL)
+	register("POST", "/control/filtering/set_url", f.handleFilteringSetURL)
+	register("POST", "/control/filtering/set_enabled", f.handleFilteringSetEnabled)
+	register("POST", "/control/filtering/set_order", f.handleFilteringSetOrder)
+	register("POST", "/control/filtering/refresh", f.handleFilteringRefresh)
+	register("POST", "/control/filtering/set_rules", f.handleFilteringSetRules)
+	register("GET", "/control/filtering/user_groups", f.handleUserGroups)
+	register("POST", "/control/filtering/user_groups", f.handleSetUserGroups)
+	register("GET", "/control/filtering/export", f.handleFilteringExport)
+	register("POST", "/control/filtering/import", f.handleFilteringImport)
+	register("POST", "/control/filtering/import_pihole", f.handleFilteringImportPihole)
+	register("POST", "/control/filtering/import_etc_hosts", f.handleImportEtcHosts)
+	register("GET", "/control/filtering/check_host", f.handleCheckHost)
+	register("POST", "/control/filtering/check_hosts", f.handleCheckHosts)
+	register("POST", "/control/filtering/test_rule", f.handleTestRule)
+	register("GET", "/control/filtering/search_rules", f.handleSearchRules)
+	register("GET", "/control/filtering/update_cycles", f.handleUpdateCycles)
+	register("GET", "/control/filtering/update_status", f.handleUpdateStatus)
+	register("GET", "/control/filtering/history", f.handleFilterHistory)
+	register("GET", "/control/filtering/disk_usage", f.handleDiskUsage)
+	register("POST", "/control/filtering/updates_pause", f.handleUpdatesPause)
+	register("GET", "/control/filtering/serve/"+serveBlocklist, f.handleServeBlocklist)
+	register("GET", "/control/filtering/serve/"+serveAllowlist, f.handleServeAllowlist)
+	register("GET", "/control/filtering/serve/"+serveBlocklist+".sha256", f.handleServeBlocklistDigest)
+	register("GET", "/control/filtering/serve/"+serveAllowlist+".sha256", f.handleServeAllowlistDigest)
 }
 
+// The maximum filters update interval (in hours)
+const filtersUpdateIntervalMax = 30 * 24
+
+// Check the filters update interval (in hours): 0 (the filters aren't updated automatically) or 1..filtersUpdateIntervalMax
 func checkFiltersUpdateIntervalHours(i uint32) bool {
-	return i == 0 || i == 1 || i == 12 || i == 1*24 || i == 3*24 || i == 7*24
+	return i <= filtersUpdateIntervalMax
+}
+
+// Check the filters update interval (in minutes): 0 (the interval in hours is used) or 1..filtersUpdateIntervalMax*60
+func checkFiltersUpdateIntervalMinutes(i uint32) bool {
+	return i <= filtersUpdateIntervalMax*60
 }
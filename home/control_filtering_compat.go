@@ -0,0 +1,39 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Compatibility with the request formats of the stock AdGuard Home filtering API,
+//  which are used by the third-party tools (e.g. adguardhome-sync).
+// add_url, remove_url, set_url, refresh and status requests have the same shape in both APIs.
+// The only difference is set_rules:
+//  stock: {"rules":["rule", ...]} (application/json)
+//  here: plain text, one rule per line
+
+// setRulesStockJSON is the stock format of set_rules request
+type setRulesStockJSON struct {
+	Rules *[]string `json:"rules"`
+}
+
+// Get user rules from set_rules request body.
+// The stock-format JSON request is accepted if the compatibility mode is enabled,
+//  it's detected by Content-Type and the presence of "rules" field.
+// Otherwise the body is a plain text.
+func setRulesRequestLines(r *http.Request, body []byte) []string {
+	config.RLock()
+	stockAPI := config.DNS.FiltersStockAPI
+	config.RUnlock()
+
+	if stockAPI && strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		req := setRulesStockJSON{}
+		err := json.Unmarshal(body, &req)
+		if err == nil && req.Rules != nil {
+			return *req.Rules
+		}
+	}
+
+	return strings.Split(string(body), "\n")
+}
@@ -0,0 +1,122 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+// Replay the requests sent by the tools that use the stock AdGuard Home API
+func TestFilteringStockAPI(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("@@||example.org^\n"))
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.configFilename = "AdGuardHome.yaml"
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	Context.filters.Init()
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	Context.dnsFilter.Start() // the handlers rebuild the filtering engine asynchronously
+	defer Context.dnsFilter.Close()
+	defer func() {
+		config.Filters = nil
+		config.WhitelistFilters = nil
+		config.UserRuleGroups = nil
+	}()
+
+	post := func(path, contentType, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		switch path {
+		case "/control/filtering/add_url":
+			Context.filters.handleFilteringAddURL(w, r)
+		case "/control/filtering/set_url":
+			Context.filters.handleFilteringSetURL(w, r)
+		case "/control/filtering/set_rules":
+			Context.filters.handleFilteringSetRules(w, r)
+		}
+		return w
+	}
+
+	// add an allowlist
+	u := srv.URL + "/allow.txt"
+	w := post("/control/filtering/add_url", "application/json",
+		`{"name":"Allowlist","url":"`+u+`","whitelist":true}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "OK 1 rules\n", w.Body.String())
+	assert.Equal(t, 0, len(config.Filters))
+	assert.Equal(t, 1, len(config.WhitelistFilters))
+
+	// disable it
+	w = post("/control/filtering/set_url", "application/json",
+		`{"url":"`+u+`","whitelist":true,"data":{"name":"Allowlist 2","url":"`+u+`","enabled":false}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "Allowlist 2", config.WhitelistFilters[0].Name)
+	assert.False(t, config.WhitelistFilters[0].Enabled)
+
+	// set user rules
+	w = post("/control/filtering/set_rules", "application/json",
+		`{"rules":["||example.com^","@@||example.net^"]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"||example.com^", "@@||example.net^"}, userRules())
+
+	// the plain text request is still supported
+	w = post("/control/filtering/set_rules", "text/plain", "||example.org^\n||example.com^")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"||example.org^", "||example.com^"}, userRules())
+
+	// status
+	rec := httptest.NewRecorder()
+	Context.filters.handleFilteringStatus(rec, httptest.NewRequest("GET", "/control/filtering/status", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var stock struct {
+		Enabled          bool     `json:"enabled"`
+		Interval         uint32   `json:"interval"`
+		UserRules        []string `json:"user_rules"`
+		WhitelistFilters []struct {
+			ID         int64  `json:"id"`
+			Enabled    bool   `json:"enabled"`
+			URL        string `json:"url"`
+			Name       string `json:"name"`
+			RulesCount uint32 `json:"rules_count"`
+		} `json:"whitelist_filters"`
+	}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &stock))
+	assert.Equal(t, []string{"||example.org^", "||example.com^"}, stock.UserRules)
+	assert.Equal(t, 1, len(stock.WhitelistFilters))
+	assert.Equal(t, u, stock.WhitelistFilters[0].URL)
+	assert.Equal(t, "Allowlist 2", stock.WhitelistFilters[0].Name)
+	assert.False(t, stock.WhitelistFilters[0].Enabled)
+}
+
+func TestSetRulesRequestLines(t *testing.T) {
+	body := []byte(`{"rules":["||example.org^"]}`)
+	r := httptest.NewRequest("POST", "/control/filtering/set_rules", nil)
+	r.Header.Set("Content-Type", "application/json")
+
+	config.DNS.FiltersStockAPI = true
+	assert.Equal(t, []string{"||example.org^"}, setRulesRequestLines(r, body))
+
+	// not a stock request
+	assert.Equal(t, []string{`{"foo":1}`}, setRulesRequestLines(r, []byte(`{"foo":1}`)))
+
+	// the compatibility mode is disabled
+	config.DNS.FiltersStockAPI = false
+	defer func() { config.DNS.FiltersStockAPI = true }()
+	assert.Equal(t, []string{string(body)}, setRulesRequestLines(r, body))
+}
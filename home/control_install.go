@@ -24,6 +24,10 @@ type firstRunData struct {
 	WebPort    int                    `json:"web_port"`
 	DNSPort    int                    `json:"dns_port"`
 	Interfaces map[string]interface{} `json:"interfaces"`
+
+	// the number of the blocking entries in the system hosts file:
+	//  the setup wizard offers to import them via /control/filtering/import_etc_hosts
+	EtcHostsEntries int `json:"etc_hosts_entries"`
 }
 
 type netInterfaceJSON struct {
@@ -39,6 +43,7 @@ func (web *Web) handleInstallGetAddresses(w http.ResponseWriter, r *http.Request
 	data := firstRunData{}
 	data.WebPort = 80
 	data.DNSPort = 53
+	data.EtcHostsEntries = etcHostsBlockingEntries()
 
 	ifaces, err := util.GetValidNetInterfacesForWeb()
 	if err != nil {
@@ -18,6 +18,9 @@ import (
 // Called by other modules when configuration is changed
 func onConfigModified() {
 	_ = config.write()
+	if Context.configModifiedHook != nil { // for tests
+		Context.configModifiedHook()
+	}
 }
 
 // initDNSServer creates an instance of the dnsforward.Server
@@ -59,7 +62,10 @@ func initDNSServer() error {
 	filterConf.AutoHosts = &Context.autoHosts
 	filterConf.ConfigModified = onConfigModified
 	filterConf.HTTPRegister = httpRegister
+	filterConf.ClientGroups = []string{applyToDHCPClients, applyToNonDHCPClients}
 	Context.dnsFilter = dnsfilter.New(&filterConf, nil)
+	Context.filters.SetEngineStatusFn(Context.dnsFilter.GetEngineStatus)
+	Context.filters.SetUpdateMetrics(&Context.filters.activity)
 
 	p := dnsforward.DNSCreateParams{
 		DNSFilter: Context.dnsFilter,
@@ -80,7 +86,11 @@ func initDNSServer() error {
 	Context.rdns = InitRDNS(Context.dnsServer, &Context.clients)
 	Context.whois = initWhois(&Context.clients)
 
-	Context.filters.Init()
+	err = Context.filters.Init()
+	if err != nil {
+		closeDNSServer()
+		return err
+	}
 	return nil
 }
 
@@ -252,13 +262,19 @@ func applyAdditionalFiltering(clientAddr string, setts *dnsfilter.RequestFilteri
 	}
 	setts.ClientIP = clientAddr
 
+	setts.ClientGroup = Context.filters.clientGroup(net.ParseIP(clientAddr))
+
 	c, ok := Context.clients.Find(clientAddr)
 	if !ok {
 		return
 	}
 
 	log.Debug("Using settings for client %s with IP %s", c.Name, clientAddr)
+	applyClientSettings(c, setts)
+}
 
+// Apply the settings of the persistent client
+func applyClientSettings(c Client, setts *dnsfilter.RequestFilteringSettings) {
 	if c.UseOwnBlockedServices {
 		Context.dnsFilter.ApplyBlockedServices(setts, c.BlockedServices, false)
 	}
@@ -342,6 +358,7 @@ func closeDNSServer() {
 	}
 
 	if Context.dnsFilter != nil {
+		Context.filters.SetEngineStatusFn(nil)
 		Context.dnsFilter.Close()
 		Context.dnsFilter = nil
 	}
@@ -2,10 +2,14 @@ package home
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -28,33 +32,258 @@ var (
 type Filtering struct {
 	// conf FilteringConf
 	refreshStatus     uint32 // 0:none; 1:in progress
+	loading           uint32 // 1: the filters are being loaded in background (filters_lazy_load)
+	lowDisk           uint32 // 1: filters update is suspended because there's not enough free disk space
+	readOnly          uint32 // 1: the filters directory isn't writable: the filter files are loaded, but the filters aren't updated
 	refreshLock       sync.Mutex
 	filterTitleRegexp *regexp.Regexp
+
+	stateLock sync.Mutex
+	state     filtersState // runtime state, it's stored in filtersStateFile
+
+	progressLock sync.Mutex
+	progress     updateProgress // the state of the current update procedure
+
+	reloadLock    sync.Mutex
+	reloadGate    ReloadGate
+	reloadPending bool        // TRUE if the reload has been deferred
+	reloadFirst   time.Time   // when the first of the pending reloads was requested
+	reloadETA     time.Time   // when the pending reload will be performed
+	reloadTimer   *time.Timer // timer for the pending reload
+	reloadFunc    func()      // the function that reloads the filtering engine (for tests)
+
+	inactiveLock sync.Mutex
+	inactive     []inactiveFilter // enabled filters that weren't passed to the filtering engine on the last rebuild
+
+	engineStatusLock sync.Mutex
+	engineStatusFn   EngineStatusFn
+
+	metricsLock sync.Mutex
+	metrics     map[int64]*filterMetrics // the update counters of the filters (by ID)
+
+	updateMetricsLock sync.Mutex
+	updateMetricsHook UpdateMetrics   // receives the update events, see SetUpdateMetrics
+	activity          activityMetrics // the counters of the update events served by /control/filtering/metrics
+
+	historyLock sync.Mutex
+	history     map[string][]filterHistoryEntry // the latest update attempts of the filters (by URL)
+
+	translateLock sync.Mutex
+	translateFn   TranslateFn // translates the user-facing messages
+
+	scoped uint32 // 1 if the filtering engine has scoped filters ("apply_to"), it's accessed atomically
+
+	serveLock      sync.Mutex
+	serveSnapshots map[string]*serveSnapshot // the last merged lists (by name)
+
+	watchLock sync.Mutex
+	watch     *filterWatcher // watches the local filter files; nil if it's disabled
+
+	loadDoneLock sync.Mutex
+	loadDoneFn   InitialLoadDoneFn // called when the background initial load has been completed
+
+	hookLock sync.Mutex
+	hook     hookStatus          // the state of the post-update hook
+	hookDone func(st hookStatus) // called when the post-update hook run has finished (for tests)
+
+	ctxLock sync.Mutex
+	ctx     context.Context    // the downloads are cancelled with this context
+	cancel  context.CancelFunc // cancels the downloads when the module is closed
+
+	now           func() time.Time                               // current time (for tests)
+	engineRebuilt func(filters, whiteFilters []dnsfilter.Filter) // called on every rebuild of the filtering engine (for tests)
+}
+
+// Get the current time
+func (f *Filtering) timeNow() time.Time {
+	if f.now != nil { // for tests
+		return f.now()
+	}
+	return time.Now()
+}
+
+// EngineStatusFn returns the state of the filtering engine
+type EngineStatusFn func() dnsfilter.EngineStatus
+
+// SetEngineStatusFn sets the function that reports the state of the filtering engine
+func (f *Filtering) SetEngineStatusFn(fn EngineStatusFn) {
+	f.engineStatusLock.Lock()
+	f.engineStatusFn = fn
+	f.engineStatusLock.Unlock()
+}
+
+// Get the state of the filtering engine
+// Return FALSE if the engine isn't available
+func (f *Filtering) engineStatus() (dnsfilter.EngineStatus, bool) {
+	f.engineStatusLock.Lock()
+	fn := f.engineStatusFn
+	f.engineStatusLock.Unlock()
+	if fn == nil {
+		return dnsfilter.EngineStatus{}, false
+	}
+	return fn(), true
+}
+
+// updateProgress is the state of the filters update procedure
+type updateProgress struct {
+	Running bool   `json:"running"`
+	Storage string `json:"storage,omitempty"` // "blocklist" or "allowlist"
+	Done    int    `json:"done"`              // the number of filters processed
+	Total   int    `json:"total"`             // the number of filters to download
+	URL     string `json:"url,omitempty"`     // URL of the filter being downloaded now
+}
+
+// Get the state of the filters update procedure
+func (f *Filtering) updateStatus() updateProgress {
+	f.progressLock.Lock()
+	defer f.progressLock.Unlock()
+	return f.progress
+}
+
+func (f *Filtering) setProgress(p updateProgress) {
+	f.progressLock.Lock()
+	f.progress = p
+	f.progressLock.Unlock()
 }
 
 // Init - initialize the module
-func (f *Filtering) Init() {
+// Context.workDir must be set, the filter files are stored in its data directory.
+// Context.client is optional, the default HTTP client is used for the downloads if it isn't set.
+func (f *Filtering) Init() error {
+	if len(Context.workDir) == 0 {
+		return fmt.Errorf("filter: work directory isn't set")
+	}
+	err := os.MkdirAll(filepath.Join(Context.getDataDir(), filterDir), 0755)
+	if err != nil {
+		return fmt.Errorf("filter: %s", err)
+	}
+
 	f.filterTitleRegexp = regexp.MustCompile(`^! Title: +(.*)$`)
-	_ = os.MkdirAll(filepath.Join(Context.getDataDir(), filterDir), 0755)
-	f.loadFilters(config.Filters)
-	f.loadFilters(config.WhitelistFilters)
+	f.ctxLock.Lock()
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	f.ctxLock.Unlock()
+	f.loadState()
+	f.loadHistory()
+	writable := f.checkFilterDirWritable()
+
+	// the handlers may already be serving the requests
+	config.Lock()
 	deduplicateFilters()
+	assignMissingFilterIDs()
+	if writable {
+		f.compactFilesIfNeeded()
+	}
+	lazy := config.DNS.FiltersLazyLoad
+	f.loadFilters(config.Filters, lazy)
+	f.loadFilters(config.WhitelistFilters, lazy)
 	updateUniqueFilterID(config.Filters)
 	updateUniqueFilterID(config.WhitelistFilters)
+	for _, d := range config.DeletedFilters {
+		updateUniqueFilterID([]filter{d.filter})
+	}
+	config.Unlock()
+
+	if writable {
+		removeOrphanedFiles()
+	}
+	if lazy {
+		atomic.StoreUint32(&f.loading, 1)
+		go f.loadFiltersLazy()
+	}
+	return nil
+}
+
+// Remove the files in the filters directory that don't belong to any filter:
+//  "<id>.txt" files of the filters that don't exist anymore
+//  and the temporary files left by the interrupted downloads.
+// The files renamed to "<id>.txt.old" on filter removal are kept.
+func removeOrphanedFiles() {
+	dir := filepath.Join(Context.getDataDir(), filterDir)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Error("filter: %s", err)
+		return
+	}
+
+	ids := map[int64]bool{}
+	config.RLock()
+	for _, filters := range [][]filter{config.Filters, config.WhitelistFilters} {
+		for _, filt := range filters {
+			ids[filt.ID] = true
+		}
+	}
+	config.RUnlock()
+
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		name := fi.Name()
+		base := strings.TrimSuffix(name, ".txt")
+		id, err := strconv.ParseInt(base, 10, 64)
+		if err != nil {
+			continue // not a filter file, e.g. "<id>.txt.old"
+		}
+		if base != name && ids[id] {
+			continue // the file of an existing filter
+		}
+
+		err = os.Remove(filepath.Join(dir, name))
+		if err != nil {
+			log.Error("filter: %s", err)
+			continue
+		}
+		log.Info("filter: removed orphaned file %s (%d bytes)", name, fi.Size())
+	}
 }
 
 // Start - start the module
 func (f *Filtering) Start() {
 	f.RegisterFilteringHandlers()
+	f.purgeTrash()
 
 	// Here we should start updating filters,
 	//  but currently we can't wake up the periodic task to do so.
 	// So for now we just start this periodic task from here.
 	go f.periodicallyRefreshFilters()
+	f.startWatcher()
 }
 
 // Close - close the module
+// The filters update that is in progress is cancelled.
 func (f *Filtering) Close() {
+	f.stopWatcher()
+	f.ctxLock.Lock()
+	if f.cancel != nil {
+		f.cancel()
+	}
+	f.ctxLock.Unlock()
+}
+
+// Get the context that is cancelled when the module is closed
+func (f *Filtering) context() context.Context {
+	f.ctxLock.Lock()
+	defer f.ctxLock.Unlock()
+	if f.ctx == nil {
+		return context.Background()
+	}
+	return f.ctx
+}
+
+// Get the context that is cancelled either with the parent context (e.g. when the HTTP client goes away)
+//  or when the module is closed.
+// The caller must call the returned function when the work is done.
+func (f *Filtering) withContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	moduleCtx := f.context()
+	go func() {
+		select {
+		case <-moduleCtx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
 }
 
 func defaultFilters() []filter {
@@ -70,22 +299,99 @@ type filter struct {
 	Enabled     bool
 	URL         string    // URL or a file path
 	Name        string    `yaml:"name"`
+	Trusted     bool      `yaml:"trusted,omitempty"`  // set by the administrator in the configuration file: the filter may be downloaded from a private address
+	ApplyTo     string    `yaml:"apply_to,omitempty"` // the clients the rules are applied to: "" (all), "dhcp_clients" or "non_dhcp_clients"
 	RulesCount  int       `yaml:"-"`
 	LastUpdated time.Time `yaml:"-"`
 	checksum    uint32    // checksum of the file data
 	white       bool
 
+	AllowExceptions *bool   `yaml:"allow_exceptions,omitempty"` // blocklists only: FALSE if the allowlists and the exception rules can't override the rules of the filter; TRUE if not set
+	Notes           string  `yaml:"notes,omitempty"`            // free-form notes of the administrator
+	MaxRetries      *uint32 `yaml:"max_retries,omitempty"`      // the number of the download retries after a network error or a server error; filters_download_retries if not set
+
+	downloadSize     int64         // the number of bytes received during the last download
+	downloadDuration time.Duration // how long the last download took
+	downloadRetries  int           // the number of retries made during the last download
+
+	fileSize      int64 // the size of the filter file on disk
+	fileSizeKnown bool  // fileSize has been set: the file isn't checked again when the filters are listed
+
+	ruleStats ruleStats // the number of rules of each type, it's used to estimate the memory usage
+
+	punycodeRules int      // the number of rules converted to punycode during the last download
+	warnings      []string // problems found in the filter data during the last download
+
+	retries    int       // the number of failed download attempts in a row
+	nextUpdate time.Time // don't try to download a failed filter before this time
+
+	lastError    string // the error of the last download attempt
+	effectiveURL string // the URL the data was received from on the last download (after redirects)
+	lastResponse string // the response status line and headers of the last download attempt
+
+	sharedFrom string // the file with the data of the same list downloaded during this update, see sharedDownloads
+
+	traceTiming bool            // trace the timing of the download: the update is recorded in the update history
+	timing      *downloadTiming // the timing of the last traced download attempt
+
+	acceptRuleDrop bool // apply the downloaded data even if the number of rules has dropped suspiciously
+	full           bool // download the data bypassing the HTTP caches and rewrite the file even if the data hasn't changed
+
+	// TRUE if this is a copy of the configured filter that is being updated:
+	// the data isn't stored if the filter has been removed or its URL has been changed during the download
+	existing bool
+
 	dnsfilter.Filter `yaml:",inline"`
 }
 
-// Creates a helper object for working with the user rules
-func userFilter() filter {
-	f := filter{
-		// User filter always has constant ID=0
-		Enabled: true,
+// The name of the user rule group that is created for the rules set via "set_rules"
+const defaultUserRuleGroup = "Custom rules"
+
+// userRuleGroup is a named set of user rules that can be turned on and off as a whole
+type userRuleGroup struct {
+	Name    string   `yaml:"name" json:"name"`
+	Enabled bool     `yaml:"enabled" json:"enabled"`
+	Rules   []string `yaml:"rules" json:"rules"`
+}
+
+// Get filter ID for the user rule group with the specified index.
+// User rule groups have non-positive IDs: the first group has ID=0, the second one has ID=-1, etc.
+func userRuleGroupID(i int) int64 {
+	return -int64(i)
+}
+
+// Get the rules of the default (the first) user rule group
+// Note: config must be locked
+func userRules() []string {
+	if len(config.UserRuleGroups) == 0 {
+		return nil
+	}
+	return config.UserRuleGroups[0].Rules
+}
+
+// Set the rules of the default (the first) user rule group, the group is created if necessary
+// Note: config must be locked
+func setUserRules(rules []string) {
+	if len(config.UserRuleGroups) == 0 {
+		config.UserRuleGroups = []userRuleGroup{{Name: defaultUserRuleGroup, Enabled: true}}
+	}
+	config.UserRuleGroups[0].Rules = rules
+}
+
+// Get filters for the enabled user rule groups
+func userFilters() []dnsfilter.Filter {
+	var filters []dnsfilter.Filter
+	for i, g := range config.UserRuleGroups {
+		if !g.Enabled {
+			continue
+		}
+		f := dnsfilter.Filter{
+			ID:   userRuleGroupID(i),
+			Data: []byte(strings.Join(g.Rules, "\n")),
+		}
+		filters = append(filters, f)
 	}
-	f.Filter.Data = []byte(strings.Join(config.UserRules, "\n"))
-	return f
+	return filters
 }
 
 const (
@@ -94,6 +400,8 @@ const (
 	statusURLChanged     = 4
 	statusURLExists      = 8
 	statusUpdateRequired = 0x10
+	statusStrictChanged  = 0x20
+	statusScopeChanged   = 0x40
 )
 
 // Update properties for a filter specified by its URL
@@ -117,6 +425,15 @@ func (f *Filtering) filterSetProperties(url string, newf filter, whitelist bool)
 		log.Debug("filter: set properties: %s: {%s %s %v}",
 			filt.URL, newf.Name, newf.URL, newf.Enabled)
 		filt.Name = newf.Name
+		filt.Notes = newf.Notes
+		if len(newf.ApplyTo) != 0 && normalizeApplyTo(newf.ApplyTo) != filt.ApplyTo {
+			filt.ApplyTo = normalizeApplyTo(newf.ApplyTo)
+			r |= statusScopeChanged
+		}
+		if newf.AllowExceptions != nil && newf.allowExceptions() != filt.allowExceptions() {
+			filt.setAllowExceptions(newf.allowExceptions())
+			r |= statusStrictChanged
+		}
 
 		if filt.URL != newf.URL {
 			r |= statusURLChanged | statusUpdateRequired
@@ -124,10 +441,15 @@ func (f *Filtering) filterSetProperties(url string, newf filter, whitelist bool)
 				return statusURLExists
 			}
 			filt.URL = newf.URL
+			// the file contains the data downloaded from the old URL
+			removeFilterFile(filt.Path())
 			filt.unload()
 			filt.LastUpdated = time.Time{}
 			filt.checksum = 0
 			filt.RulesCount = 0
+			filt.retries = 0
+			filt.nextUpdate = time.Time{}
+			filt.fileSize = 0
 		}
 
 		if filt.Enabled != newf.Enabled {
@@ -155,6 +477,130 @@ func (f *Filtering) filterSetProperties(url string, newf filter, whitelist bool)
 	return 0
 }
 
+// Change the URL of an enabled filter.
+// The data is downloaded from the new URL to a temporary filter with a new ID:
+//  on success the filter is replaced with it and the old file is deleted,
+//  on failure the filter isn't changed and the downloaded file is deleted.
+func (f *Filtering) filterChangeURL(ctx context.Context, url string, newf filter, whitelist bool) error {
+	old, ok := filterFind(url, whitelist)
+	if !ok {
+		return newMsgError(msgFilterURLNotFound)
+	}
+	if filterExists(newf.URL) {
+		return newMsgError(msgFilterURLExists)
+	}
+
+	tmp := filter{
+		Enabled: true,
+		URL:     newf.URL,
+		Name:    newf.Name,
+		ApplyTo: old.ApplyTo,
+		Notes:   newf.Notes,
+		white:   whitelist,
+
+		MaxRetries: old.MaxRetries,
+	}
+	if newf.MaxRetries != nil {
+		tmp.MaxRetries = newf.MaxRetries
+	}
+	if len(newf.ApplyTo) != 0 {
+		tmp.ApplyTo = normalizeApplyTo(newf.ApplyTo)
+	}
+	tmp.setAllowExceptions(old.allowExceptions())
+	if newf.AllowExceptions != nil {
+		tmp.setAllowExceptions(newf.allowExceptions())
+	}
+	tmp.ID = assignUniqueFilterID()
+	log.Debug("filter: changing URL: %s -> %s: downloading to %s", url, tmp.URL, tmp.Path())
+	updated, err := f.update(ctx, &tmp)
+	if err == nil && !updated {
+		err = newMsgError(msgFilterInvalid, tmp.URL)
+	}
+	if err != nil {
+		removeFilterFile(tmp.Path())
+		return newMsgError(msgFilterFetchFailed, tmp.URL, err)
+	}
+
+	config.Lock()
+	filters := config.Filters
+	if whitelist {
+		filters = config.WhitelistFilters
+	}
+	var filt *filter
+	for i := range filters {
+		if filters[i].ID == old.ID && filters[i].URL == url {
+			filt = &filters[i]
+			break
+		}
+	}
+	if filt == nil || filterExistsNoLock(tmp.URL) {
+		// the filter has been changed while we were downloading the data
+		config.Unlock()
+		removeFilterFile(tmp.Path())
+		return newMsgError(msgFilterModified)
+	}
+	oldPath := filt.Path()
+	*filt = tmp
+	config.Unlock()
+
+	removeFilterFile(oldPath)
+	return nil
+}
+
+// Delete the filter file.  It's not an error if the file doesn't exist.
+func removeFilterFile(path string) {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Error("filter: os.Remove: %s", err)
+	}
+}
+
+// Enable or disable several filters at once.
+// The filters being enabled are loaded from the files on disk.
+// Return the number of filters whose state has been changed
+//  and TRUE if some of them must be downloaded because the file couldn't be loaded.
+func (f *Filtering) filtersSetEnabled(urls []string, enabled bool, whitelist bool) (int, bool) {
+	set := map[string]bool{}
+	for _, u := range urls {
+		set[u] = true
+	}
+
+	config.Lock()
+	defer config.Unlock()
+
+	filters := config.Filters
+	if whitelist {
+		filters = config.WhitelistFilters
+	}
+
+	changed := 0
+	updateRequired := false
+	for i := range filters {
+		filt := &filters[i]
+		if !set[filt.URL] || filt.Enabled == enabled {
+			continue
+		}
+
+		log.Debug("filter: set enabled: %s: %v", filt.URL, enabled)
+		filt.Enabled = enabled
+		changed++
+		if !enabled {
+			filt.unload()
+			continue
+		}
+
+		err := f.load(filt)
+		if err != nil {
+			// the file may have been removed from disk
+			filt.LastUpdated = time.Time{}
+			filt.checksum = 0
+			filt.RulesCount = 0
+			updateRequired = true
+		}
+	}
+	return changed, updateRequired
+}
+
 // Return TRUE if a filter with this URL exists
 func filterExists(url string) bool {
 	config.RLock()
@@ -182,7 +628,22 @@ func filterExistsNoLock(url string) bool {
 func filterAdd(f filter) bool {
 	config.Lock()
 	defer config.Unlock()
+	return filterAddNoLock(f)
+}
+
+// Add several filters while holding the configuration lock only once
+// Return an array of the same length: FALSE if a filter with this URL exists
+func filterAddMultiple(filters []filter) []bool {
+	added := make([]bool, len(filters))
+	config.Lock()
+	defer config.Unlock()
+	for i, f := range filters {
+		added[i] = filterAddNoLock(f)
+	}
+	return added
+}
 
+func filterAddNoLock(f filter) bool {
 	// Check for duplicates
 	if filterExistsNoLock(f.URL) {
 		return false
@@ -196,17 +657,135 @@ func filterAdd(f filter) bool {
 	return true
 }
 
+// Remove a filter, it's moved to the trash and can be restored
+// Return FALSE if a filter with this URL doesn't exist
+func filterRemove(url string, whitelist bool) bool {
+	config.Lock()
+	defer config.Unlock()
+
+	// go through each element and delete if url matches
+	removed := false
+	newFilters := []filter{}
+	filters := &config.Filters
+	if whitelist {
+		filters = &config.WhitelistFilters
+	}
+	for _, filter := range *filters {
+		if filter.URL != url {
+			newFilters = append(newFilters, filter)
+		} else {
+			removed = true
+			trashFilterNoLock(filter, whitelist, Context.filters.timeNow())
+		}
+	}
+	// Update the configuration after removing filter files
+	*filters = newFilters
+	return removed
+}
+
+// Remove all filters of the list: the filter files are moved to the trash
+// Return the removed filters
+func filterRemoveAll(whitelist bool) []filter {
+	config.Lock()
+	defer config.Unlock()
+
+	filters := &config.Filters
+	if whitelist {
+		filters = &config.WhitelistFilters
+	}
+	removed := *filters
+	now := Context.filters.timeNow()
+	for _, filter := range removed {
+		trashFilterNoLock(filter, whitelist, now)
+	}
+	*filters = []filter{}
+	return removed
+}
+
+// Move the filter to the new position in the list.
+// The filters order defines the rules precedence in the filtering engine.
+// Return TRUE if the order has been changed.
+func filterMove(url string, newIndex int, whitelist bool) (bool, error) {
+	config.Lock()
+	defer config.Unlock()
+
+	filters := &config.Filters
+	if whitelist {
+		filters = &config.WhitelistFilters
+	}
+
+	i := -1
+	for n := range *filters {
+		if (*filters)[n].URL == url {
+			i = n
+			break
+		}
+	}
+	if i < 0 {
+		return false, newMsgError(msgFilterNotFoundURL, url)
+	}
+	if newIndex < 0 || newIndex >= len(*filters) {
+		return false, newMsgError(msgFilterInvalidIndex, newIndex)
+	}
+	if i == newIndex {
+		return false, nil
+	}
+
+	list := make([]filter, 0, len(*filters))
+	list = append(list, (*filters)[:i]...)
+	list = append(list, (*filters)[i+1:]...)
+	list = append(list[:newIndex], append([]filter{(*filters)[i]}, list[newIndex:]...)...)
+	*filters = list
+	return true, nil
+}
+
+// Set the order of filters in the list.
+// urls must contain all filters of the list, each one exactly once, otherwise nothing is changed.
+// Return TRUE if the order has been changed.
+func filtersSetOrder(urls []string, whitelist bool) (bool, error) {
+	config.Lock()
+	defer config.Unlock()
+
+	filters := &config.Filters
+	if whitelist {
+		filters = &config.WhitelistFilters
+	}
+	if len(urls) != len(*filters) {
+		return false, newMsgError(msgFilterOrderCount, len(*filters), len(urls))
+	}
+
+	index := map[string]int{}
+	for i, filt := range *filters {
+		index[filt.URL] = i
+	}
+	list := make([]filter, 0, len(urls))
+	changed := false
+	for i, u := range urls {
+		n, ok := index[u]
+		if !ok {
+			return false, newMsgError(msgFilterOrderURL, u)
+		}
+		delete(index, u)
+		list = append(list, (*filters)[n])
+		changed = changed || n != i
+	}
+
+	*filters = list
+	return changed, nil
+}
+
 // Load filters from the disk
 // And if any filter has zero ID, assign a new one
-func (f *Filtering) loadFilters(array []filter) {
+// lazy: the enabled filters are loaded later by loadFiltersLazy()
+func (f *Filtering) loadFilters(array []filter, lazy bool) {
 	for i := range array {
 		filter := &array[i] // otherwise we're operating on a copy
-		if filter.ID == 0 {
-			filter.ID = assignUniqueFilterID()
-		}
-
 		if !filter.Enabled {
 			// No need to load a filter that is not enabled
+			filter.statFile()
+			continue
+		}
+		if lazy {
 			continue
 		}
 
@@ -237,77 +816,297 @@ func deduplicateFilters() {
 // Set the next filter ID to max(filter.ID) + 1
 func updateUniqueFilterID(filters []filter) {
 	for _, filter := range filters {
-		if nextFilterID < filter.ID {
+		if nextFilterID <= filter.ID {
 			nextFilterID = filter.ID + 1
 		}
 	}
 }
 
-func assignUniqueFilterID() int64 {
-	value := nextFilterID
-	nextFilterID++
-	return value
+// Assign the IDs to the filters that have no ID or have the ID of another filter
+//  (e.g. the configuration file has been edited by hand).
+// The new IDs follow the largest configured ID in the order of the filters:
+//  the same configuration file gets the same IDs on every start even if it hasn't been written yet,
+//  so the IDs always match the names of the filter files.
+// Note: config must be locked
+func assignMissingFilterIDs() {
+	lists := [][]filter{config.Filters, config.WhitelistFilters}
+	var maxID int64
+	for _, list := range lists {
+		for _, filt := range list {
+			if maxID < filt.ID {
+				maxID = filt.ID
+			}
+		}
+	}
+	for _, d := range config.DeletedFilters {
+		if maxID < d.ID {
+			maxID = d.ID
+		}
+	}
+
+	used := map[int64]bool{}
+	for _, list := range lists {
+		for i := range list {
+			filt := &list[i]
+			if filt.ID == 0 || used[filt.ID] {
+				maxID++
+				log.Info("filter: %s: ID %d -> %d", filt.URL, filt.ID, maxID)
+				filt.ID = maxID
+			}
+			used[filt.ID] = true
+		}
+	}
+}
+
+func assignUniqueFilterID() int64 {
+	value := nextFilterID
+	nextFilterID++
+	return value
+}
+
+// Get the filters update interval: 0 if the filters aren't updated automatically.
+// The interval in minutes takes precedence if it's set.
+// Note: config must be locked
+func filtersUpdateInterval() time.Duration {
+	if config.DNS.FiltersUpdateMinutes != 0 {
+		return time.Duration(config.DNS.FiltersUpdateMinutes) * time.Minute
+	}
+	return time.Duration(config.DNS.FiltersUpdateIntervalHours) * time.Hour
+}
+
+// PauseUpdates - suspend the automatic filters updates.
+// The filters can still be updated manually.
+func (f *Filtering) PauseUpdates() {
+	f.setUpdatesPaused(true)
+}
+
+// ResumeUpdates - resume the automatic filters updates
+func (f *Filtering) ResumeUpdates() {
+	f.setUpdatesPaused(false)
+}
+
+func (f *Filtering) setUpdatesPaused(paused bool) {
+	config.Lock()
+	changed := config.DNS.FiltersUpdatesPaused != paused
+	config.DNS.FiltersUpdatesPaused = paused
+	config.Unlock()
+	if !changed {
+		return
+	}
+
+	if paused {
+		log.Info("filter: automatic updates are paused")
+	} else {
+		log.Info("filter: automatic updates are resumed")
+	}
+	onConfigModified()
+}
+
+// Update the expired filters and retry the failed ones, unless the automatic updates are disabled or paused
+func (f *Filtering) refreshByTimer() {
+	config.RLock()
+	enabled := filtersUpdateInterval() != 0 && !config.DNS.FiltersUpdatesPaused
+	config.RUnlock()
+	if enabled && atomic.CompareAndSwapUint32(&f.refreshStatus, 0, 1) {
+		f.refreshLock.Lock()
+		_, _ = f.refreshFiltersIfNecessary(f.context(), FilterRefreshBlocklists|FilterRefreshAllowlists, updateTriggerTimer, 0)
+		f.refreshLock.Unlock()
+		atomic.StoreUint32(&f.refreshStatus, 0)
+	}
+}
+
+// Sets up a timer that will be checking for filters updates periodically
+func (f *Filtering) periodicallyRefreshFilters() {
+	for {
+		f.refreshByTimer()
+
+		config.RLock()
+		interval := filtersUpdateInterval()
+		config.RUnlock()
+		enabled := interval != 0
+
+		// the filters are checked every hour, or more often if the update interval is shorter
+		maxInterval := time.Hour
+		if enabled && interval < maxInterval {
+			maxInterval = interval
+		}
+
+		// wake up earlier if there's a failed filter to retry
+		intval := maxInterval
+		retry := nextRetryTime()
+		if !retry.IsZero() {
+			intval = time.Until(retry)
+			if intval < time.Second {
+				intval = time.Second
+			} else if intval > maxInterval {
+				intval = maxInterval
+			}
+		}
+
+		time.Sleep(intval)
+	}
+}
+
+const (
+	retryDelayMin = 10 * time.Second
+	retryJitter   = 10 // maximum random addition to the retry delay, in percents
+)
+
+// Get the time to wait before the next download attempt after 'retries' failed attempts in a row:
+// 10s, 20s, 40s, ... but not more than the filters update interval.
+// A small random delay is added so that the filters that failed at the same time aren't retried in lockstep.
+func retryDelay(retries int) time.Duration {
+	max := filtersUpdateInterval()
+	if max < retryDelayMin {
+		max = retryDelayMin
+	}
+
+	d := retryDelayMin
+	for i := 1; i < retries && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	return d + time.Duration(rand.Int63n(int64(d)*retryJitter/100+1))
+}
+
+// Get the earliest time when a failed filter should be retried
+// Return zero time if there are no failed filters
+func nextRetryTime() time.Time {
+	var t time.Time
+	config.RLock()
+	defer config.RUnlock()
+	for _, arr := range [][]filter{config.Filters, config.WhitelistFilters} {
+		for _, f := range arr {
+			if !f.Enabled || f.nextUpdate.IsZero() {
+				continue
+			}
+			if t.IsZero() || f.nextUpdate.Before(t) {
+				t = f.nextUpdate
+			}
+		}
+	}
+	return t
+}
+
+// Refresh filters
+// flags: FilterRefresh*
+// important:
+//  TRUE: ignore the fact that we're currently updating the filters
+// trigger: updateTrigger*
+func (f *Filtering) refreshFilters(flags int, important bool, trigger string) (int, error) {
+	return f.refreshFiltersOnly(f.context(), flags, important, trigger, 0)
+}
+
+// errFilterNotFound is returned when there's no filter with the specified URL
+var errFilterNotFound = newMsgError(msgFilterNotFound)
+
+// filterDownloadError is the error of the filter download, as opposed to the errors of the update procedure
+type filterDownloadError struct {
+	msg string
+}
+
+func (e *filterDownloadError) Error() string {
+	return e.msg
+}
+
+// Download the filter and apply its data if it has changed.
+// The filter is downloaded even if it isn't expired or its retry time hasn't come yet.
+// Return the number of updated filters (0 or 1);
+//  the download error is returned as *filterDownloadError.
+// flags: FilterRefreshAcceptRuleDrop, FilterRefreshFull
+func (f *Filtering) refreshSingleFilter(ctx context.Context, id int64, whitelist bool, flags int) (int, error) {
+	flags &= FilterRefreshAcceptRuleDrop | FilterRefreshFull
+	if whitelist {
+		flags |= FilterRefreshAllowlists
+	} else {
+		flags |= FilterRefreshBlocklists
+	}
+	n, err := f.refreshFiltersOnly(ctx, flags|FilterRefreshForce, false, updateTriggerManual, id)
+	if err != nil {
+		return 0, err
+	}
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+
+	filt, ok := filterFindByID(id, whitelist)
+	if !ok {
+		return 0, errFilterRemoved
+	}
+	if len(filt.lastError) != 0 {
+		return 0, &filterDownloadError{msg: filt.lastError}
+	}
+	return n, nil
+}
+
+// RefreshFilter - download the filter (a blocklist or an allowlist) with this URL and apply its data if it has changed.
+// The filter is downloaded even if it isn't expired or its retry time hasn't come yet.
+func (f *Filtering) RefreshFilter(url string) error {
+	return f.refreshFilterURL(url, 0)
 }
 
-// Sets up a timer that will be checking for filters updates periodically
-func (f *Filtering) periodicallyRefreshFilters() {
-	const maxInterval = 1 * 60 * 60
-	intval := 5 // use a dynamically increasing time interval
-	for {
-		isNetworkErr := false
-		if config.DNS.FiltersUpdateIntervalHours != 0 && atomic.CompareAndSwapUint32(&f.refreshStatus, 0, 1) {
-			f.refreshLock.Lock()
-			_, isNetworkErr = f.refreshFiltersIfNecessary(FilterRefreshBlocklists | FilterRefreshAllowlists)
-			f.refreshLock.Unlock()
-			f.refreshStatus = 0
-			if !isNetworkErr {
-				intval = maxInterval
-			}
-		}
-
-		if isNetworkErr {
-			intval *= 2
-			if intval > maxInterval {
-				intval = maxInterval
-			}
-		}
+// RefreshFilterFull - download the filter with this URL bypassing the HTTP caches
+//  and rewrite its file even if the data hasn't changed
+func (f *Filtering) RefreshFilterFull(url string) error {
+	return f.refreshFilterURL(url, FilterRefreshFull)
+}
 
-		time.Sleep(time.Duration(intval) * time.Second)
+func (f *Filtering) refreshFilterURL(url string, flags int) error {
+	whitelist := false
+	filt, ok := filterFind(url, false)
+	if !ok {
+		whitelist = true
+		filt, ok = filterFind(url, true)
+	}
+	if !ok {
+		return errFilterNotFound
 	}
+
+	_, err := f.refreshSingleFilter(f.context(), filt.ID, whitelist, flags)
+	return err
 }
 
 // Refresh filters
-// flags: FilterRefresh*
-// important:
-//  TRUE: ignore the fact that we're currently updating the filters
-func (f *Filtering) refreshFilters(flags int, important bool) (int, error) {
+// only: refresh only the filter with this ID; 0: all filters
+// The downloads are aborted when the context is cancelled.
+func (f *Filtering) refreshFiltersOnly(ctx context.Context, flags int, important bool, trigger string, only int64) (int, error) {
 	set := atomic.CompareAndSwapUint32(&f.refreshStatus, 0, 1)
 	if !important && !set {
-		return 0, fmt.Errorf("filters update procedure is already running")
+		return 0, newMsgError(msgUpdateRunning)
 	}
 
 	f.refreshLock.Lock()
-	nUpdated, _ := f.refreshFiltersIfNecessary(flags)
+	nUpdated, _ := f.refreshFiltersIfNecessary(ctx, flags, trigger, only)
 	f.refreshLock.Unlock()
-	f.refreshStatus = 0
+	atomic.StoreUint32(&f.refreshStatus, 0)
 	return nUpdated, nil
 }
 
-func (f *Filtering) refreshFiltersArray(filters *[]filter, force bool) (int, []filter, []bool, bool) {
+// Download the filters that need to be updated and fill in the update cycle properties
+// only: download only the filter with this ID; 0: all filters
+// acceptRuleDrop: apply the data even if the number of rules has dropped suspiciously
+// full: download the data bypassing the caches and rewrite the files even if the data hasn't changed
+func (f *Filtering) refreshFiltersArray(ctx context.Context, filters *[]filter, force bool, acceptRuleDrop bool, full bool, only int64, cycle *updateCycle) (int, []filter, []bool, bool) {
 	var updateFilters []filter
 	var updateFlags []bool // 'true' if filter data has changed
 
-	now := time.Now()
+	now := f.timeNow()
+	cycle.Started = now
 	config.RLock()
+	interval := filtersUpdateInterval()
 	for i := range *filters {
 		f := &(*filters)[i] // otherwise we will be operating on a copy
 
-		if !f.Enabled {
+		if !f.Enabled || (only != 0 && f.ID != only) {
 			continue
 		}
 
-		expireTime := f.LastUpdated.Unix() + int64(config.DNS.FiltersUpdateIntervalHours)*60*60
-		if !force && expireTime > now.Unix() {
+		expireTime := f.LastUpdated.Add(interval)
+		if !force && (expireTime.After(now) || now.Before(f.nextUpdate)) &&
+			!(isLocalFilterURL(f.URL) && localFilterModTime(f.URL).After(f.LastUpdated)) {
 			continue
 		}
 
@@ -315,7 +1114,13 @@ func (f *Filtering) refreshFiltersArray(filters *[]filter, force bool) (int, []f
 		uf.ID = f.ID
 		uf.URL = f.URL
 		uf.Name = f.Name
+		uf.Trusted = f.Trusted
 		uf.checksum = f.checksum
+		uf.RulesCount = f.RulesCount
+		uf.acceptRuleDrop = acceptRuleDrop
+		uf.full = full
+		uf.existing = true
+		uf.traceTiming = true
 		updateFilters = append(updateFilters, uf)
 	}
 	config.RUnlock()
@@ -325,16 +1130,105 @@ func (f *Filtering) refreshFiltersArray(filters *[]filter, force bool) (int, []f
 	}
 
 	nfail := 0
+	failed := make([]bool, len(updateFilters))
+	errs := make([]string, len(updateFilters))
 	for i := range updateFilters {
+		if ctx.Err() != nil || !f.checkFreeDiskSpace() {
+			updateFilters = updateFilters[:i]
+			failed = failed[:i]
+			errs = errs[:i]
+			break
+		}
+
 		uf := &updateFilters[i]
-		updated, err := f.update(uf)
+		f.setProgress(updateProgress{
+			Running: true,
+			Storage: cycle.Storage,
+			Done:    i,
+			Total:   len(updateFilters),
+			URL:     uf.URL,
+		})
+		uf.sharedFrom, _ = cycle.shared.get(uf)
+		updated, err := f.update(ctx, uf)
+		if err != nil && ctx.Err() != nil {
+			// the update has been cancelled: the filter will be downloaded next time
+			log.Debug("filter: %s: update cancelled", uf.URL)
+			updateFilters = updateFilters[:i]
+			failed = failed[:i]
+			errs = errs[:i]
+			break
+		}
 		updateFlags = append(updateFlags, updated)
+		cycle.Bytes += uf.downloadSize
+		cycle.Retries += uf.downloadRetries
+		if err == errFilterRemoved {
+			// not an error: the filter isn't a part of this update anymore
+			log.Debug("filter: %s: %s", uf.URL, err)
+			continue
+		}
+		cycle.Checked++
+		f.countUpdate(uf.ID, err != nil, uf.downloadRetries)
 		if err != nil {
 			nfail++
+			failed[i] = true
+			errs[i] = err.Error()
 			log.Printf("Failed to update filter %s: %s\n", uf.URL, err)
 			continue
 		}
+		if len(uf.sharedFrom) == 0 {
+			cycle.shared.add(uf)
+		}
+		if updated {
+			cycle.Updated++
+		} else {
+			cycle.NotModified++
+		}
 	}
+	cycle.Failed = nfail
+	cycle.Finished = f.timeNow()
+	if len(updateFilters) == 0 {
+		return 0, nil, nil, false
+	}
+
+	var history []filterHistoryEntry
+	defer func() { f.addHistory(history) }()
+
+	config.Lock()
+	for i := range updateFilters {
+		uf := &updateFilters[i]
+		for k := range *filters {
+			f := &(*filters)[k]
+			if f.ID != uf.ID || f.URL != uf.URL {
+				continue
+			}
+			f.lastError = errs[i]
+			f.lastResponse = uf.lastResponse
+			if uf.timing != nil {
+				f.timing = uf.timing
+			}
+			if failed[i] {
+				history = append(history, filterHistoryEntry{
+					url:      f.URL,
+					Time:     cycle.Finished,
+					OldRules: f.RulesCount,
+					NewRules: f.RulesCount,
+					Bytes:    uf.downloadSize,
+					Result:   historyResultFailed,
+					Error:    errs[i],
+					Timing:   uf.timing,
+					Forced:   uf.full,
+					Retries:  uf.downloadRetries,
+				})
+				f.retries++
+				f.nextUpdate = Context.filters.timeNow().Add(retryDelay(f.retries))
+				log.Debug("filter: %s: retry #%d at %s", f.URL, f.retries, f.nextUpdate)
+			} else {
+				f.retries = 0
+				f.nextUpdate = time.Time{}
+			}
+		}
+	}
+	config.Unlock()
 
 	if nfail == len(updateFilters) {
 		return 0, nil, nil, true
@@ -344,6 +1238,10 @@ func (f *Filtering) refreshFiltersArray(filters *[]filter, force bool) (int, []f
 	for i := range updateFilters {
 		uf := &updateFilters[i]
 		updated := updateFlags[i]
+		if failed[i] {
+			// keep the old update time so that the filter is retried
+			continue
+		}
 
 		config.Lock()
 		for k := range *filters {
@@ -352,15 +1250,38 @@ func (f *Filtering) refreshFiltersArray(filters *[]filter, force bool) (int, []f
 				continue
 			}
 			f.LastUpdated = uf.LastUpdated
+			f.effectiveURL = uf.effectiveURL
+			f.downloadSize = uf.downloadSize
+			f.downloadDuration = uf.downloadDuration
+			e := filterHistoryEntry{
+				url:      f.URL,
+				Time:     cycle.Finished,
+				OldRules: f.RulesCount,
+				NewRules: f.RulesCount,
+				Bytes:    uf.downloadSize,
+				Result:   historyResultNotModified,
+				Timing:   uf.timing,
+				Forced:   uf.full,
+				Retries:  uf.downloadRetries,
+			}
 			if !updated {
+				history = append(history, e)
 				continue
 			}
+			e.NewRules = uf.RulesCount
+			e.Result = historyResultUpdated
+			history = append(history, e)
 
 			log.Info("Updated filter #%d.  Rules: %d -> %d",
 				f.ID, f.RulesCount, uf.RulesCount)
 			f.Name = uf.Name
 			f.RulesCount = uf.RulesCount
+			f.ruleStats = uf.ruleStats
 			f.checksum = uf.checksum
+			f.fileSize = uf.fileSize
+			f.fileSizeKnown = uf.fileSizeKnown
+			f.punycodeRules = uf.punycodeRules
+			f.warnings = uf.warnings
 			updateCount++
 		}
 		config.Unlock()
@@ -373,6 +1294,9 @@ const (
 	FilterRefreshForce      = 1 // ignore last file modification date
 	FilterRefreshAllowlists = 2 // update allow-lists
 	FilterRefreshBlocklists = 4 // update block-lists
+
+	FilterRefreshAcceptRuleDrop = 8  // apply the data even if the number of rules has dropped suspiciously
+	FilterRefreshFull           = 16 // download the data bypassing the HTTP caches and rewrite the files even if the data hasn't changed
 )
 
 // Checks filters updates if necessary
@@ -394,7 +1318,7 @@ const (
 //
 // Return the number of updated filters
 // Return TRUE - there was a network error and nothing could be updated
-func (f *Filtering) refreshFiltersIfNecessary(flags int) (int, bool) {
+func (f *Filtering) refreshFiltersIfNecessary(ctx context.Context, flags int, trigger string, only int64) (int, bool) {
 	log.Debug("Filters: updating...")
 
 	updateCount := 0
@@ -406,24 +1330,57 @@ func (f *Filtering) refreshFiltersIfNecessary(flags int) (int, bool) {
 	if (flags & FilterRefreshForce) != 0 {
 		force = true
 	}
+	acceptRuleDrop := (flags & FilterRefreshAcceptRuleDrop) != 0
+	full := (flags & FilterRefreshFull) != 0
+	if !f.checkFilterDirWritable() || !f.checkFreeDiskSpace() {
+		log.Debug("Filters: update skipped")
+		return 0, false
+	}
+
+	var shared *sharedDownloads
+	config.RLock()
+	if config.DNS.FiltersSharedDownloads {
+		shared = newSharedDownloads()
+	}
+	config.RUnlock()
+
+	f.setProgress(updateProgress{Running: true})
+	defer f.setProgress(updateProgress{})
+	start := time.Now()
+	nUpdated := 0
+	nFailed := 0
 	if (flags & FilterRefreshBlocklists) != 0 {
-		updateCount, updateFilters, updateFlags, netError = f.refreshFiltersArray(&config.Filters, force)
+		cycle := updateCycle{Storage: "blocklist", Trigger: trigger, shared: shared}
+		updateCount, updateFilters, updateFlags, netError = f.refreshFiltersArray(ctx, &config.Filters, force, acceptRuleDrop, full, only, &cycle)
+		if cycle.Checked != 0 {
+			f.addUpdateCycle(cycle)
+		}
+		nUpdated += cycle.Updated
+		nFailed += cycle.Failed
 	}
+	nBlocklists := len(updateFilters)
 	if (flags & FilterRefreshAllowlists) != 0 {
 		updateCountW := 0
 		var updateFiltersW []filter
 		var updateFlagsW []bool
-		updateCountW, updateFiltersW, updateFlagsW, netErrorW = f.refreshFiltersArray(&config.WhitelistFilters, force)
+		cycle := updateCycle{Storage: "allowlist", Trigger: trigger, shared: shared}
+		updateCountW, updateFiltersW, updateFlagsW, netErrorW = f.refreshFiltersArray(ctx, &config.WhitelistFilters, force, acceptRuleDrop, full, only, &cycle)
+		if cycle.Checked != 0 {
+			f.addUpdateCycle(cycle)
+		}
+		nUpdated += cycle.Updated
+		nFailed += cycle.Failed
 		updateCount += updateCountW
 		updateFilters = append(updateFilters, updateFiltersW...)
 		updateFlags = append(updateFlags, updateFlagsW...)
 	}
+	f.updateMetrics().UpdateCycleFinished(nUpdated, nFailed, time.Since(start))
 	if netError && netErrorW {
 		return 0, true
 	}
 
 	if updateCount != 0 {
-		enableFilters(false)
+		f.reloadFilters()
 
 		for i := range updateFilters {
 			uf := &updateFilters[i]
@@ -433,12 +1390,71 @@ func (f *Filtering) refreshFiltersIfNecessary(flags int) (int, bool) {
 			}
 			_ = os.Remove(uf.Path() + ".old")
 		}
+
+		var blocklists, allowlists []filter
+		for i := range updateFilters {
+			if !updateFlags[i] {
+				continue
+			}
+			if i < nBlocklists {
+				blocklists = append(blocklists, updateFilters[i])
+			} else {
+				allowlists = append(allowlists, updateFilters[i])
+			}
+		}
+		if len(blocklists)+len(allowlists) != 0 {
+			config.RLock()
+			s := newPostUpdateSummary(blocklists, allowlists)
+			config.RUnlock()
+			f.runPostUpdateHook(s)
+		}
 	}
 
 	log.Debug("Filters: update finished")
 	return updateCount, false
 }
 
+// Get free disk space (overridden in tests)
+var freeDiskSpace = util.FreeDiskSpace
+
+// Check that there's enough free disk space to download filters
+// Return FALSE if the free space is below the configured threshold
+func (f *Filtering) checkFreeDiskSpace() bool {
+	config.RLock()
+	minMB := config.DNS.FiltersMinFreeDiskMB
+	config.RUnlock()
+	min := uint64(minMB) * 1024 * 1024
+	if min == 0 {
+		atomic.StoreUint32(&f.lowDisk, 0)
+		return true
+	}
+
+	dir := filepath.Join(Context.getDataDir(), filterDir)
+	free, err := freeDiskSpace(dir)
+	if err != nil {
+		log.Debug("filter: can't get free disk space: %s: %s", dir, err)
+		return true
+	}
+
+	if free < min {
+		if atomic.SwapUint32(&f.lowDisk, 1) == 0 {
+			log.Error("filter: not enough free disk space: %d MB available, %d MB required.  Filters won't be updated",
+				free/(1024*1024), minMB)
+		}
+		return false
+	}
+
+	if atomic.SwapUint32(&f.lowDisk, 0) == 1 {
+		log.Info("filter: free disk space: %d MB.  Filters update is resumed", free/(1024*1024))
+	}
+	return true
+}
+
+// Return TRUE if filters update is suspended because there's not enough free disk space
+func (f *Filtering) isLowDisk() bool {
+	return atomic.LoadUint32(&f.lowDisk) == 1
+}
+
 // Allows printable UTF-8 text with CR, LF, TAB characters
 func isPrintableText(data []byte, len int) bool {
 	for i := 0; i < len; i++ {
@@ -451,9 +1467,92 @@ func isPrintableText(data []byte, len int) bool {
 	return true
 }
 
+// The filter data is checked for an HTML page
+const (
+	filterFirstChunkSize = 4 * 1024   // the start of the data: must be a plain text without HTML tags
+	filterSampleSize     = 256 * 1024 // the larger sample: must not be mostly HTML
+	htmlSampleMinLines   = 10         // the share of the HTML lines isn't checked for the shorter samples
+	htmlSampleMaxPercent = 30         // the maximum share of the lines that look like HTML tags
+)
+
+// Check that the first chunk of the filter data looks like a plain text filter list
+func checkFilterData(data []byte) error {
+	if !isPrintableText(data, len(data)) {
+		return fmt.Errorf("data contains non-printable characters")
+	}
+
+	s := strings.ToLower(string(data))
+	if strings.Index(s, "<html") >= 0 ||
+		strings.Index(s, "<!doctype") >= 0 {
+		return fmt.Errorf("data is HTML, not plain text")
+	}
+	return nil
+}
+
+// Check the larger sample of the filter data for an HTML page.
+// An error page may follow a short text preamble so a line that starts an HTML document is searched for,
+//  and the data is rejected if too many lines look like HTML tags.
+func checkHTMLSample(data []byte) error {
+	lines := 0
+	tags := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(line, "\uFEFF")))
+		if len(line) == 0 {
+			continue
+		}
+		if strings.HasPrefix(line, "<!doctype") || strings.HasPrefix(line, "<html") {
+			return fmt.Errorf("data is HTML, not plain text")
+		}
+
+		lines++
+		// the filter rules never start with '<'
+		if line[0] == '<' && strings.IndexByte(line, '>') > 0 {
+			tags++
+		}
+	}
+
+	if lines >= htmlSampleMinLines && tags*100 > lines*htmlSampleMaxPercent {
+		return fmt.Errorf("data is HTML, not plain text: %d of %d lines are HTML tags", tags, lines)
+	}
+	return nil
+}
+
+// filterDataCheck checks the filter data as it's received, whatever the data is written to
+type filterDataCheck struct {
+	sample            []byte // the beginning of the data
+	firstChunkChecked bool
+}
+
+func newFilterDataCheck() *filterDataCheck {
+	return &filterDataCheck{
+		sample: make([]byte, 0, filterSampleSize),
+	}
+}
+
+// Add the next portion of the data, eof: there's no more data
+// Return an error if the beginning of the data doesn't look like a filter list
+func (c *filterDataCheck) add(data []byte, eof bool) error {
+	if len(c.sample) < cap(c.sample) {
+		num := util.MinInt(len(data), cap(c.sample)-len(c.sample))
+		c.sample = append(c.sample, data[:num]...)
+	}
+	if !c.firstChunkChecked && (len(c.sample) >= filterFirstChunkSize || eof) {
+		// fail early if the data doesn't look like a filter list at all
+		c.firstChunkChecked = true
+		return checkFilterData(c.sample[:util.MinInt(len(c.sample), filterFirstChunkSize)])
+	}
+	return nil
+}
+
+// Check the data after all of it has been received
+func (c *filterDataCheck) finish() error {
+	return checkHTMLSample(c.sample)
+}
+
 // A helper function that parses filter contents and returns a number of rules and a filter name (if there's any)
-func (f *Filtering) parseFilterContents(file io.Reader) (int, uint32, string) {
+func (f *Filtering) parseFilterContents(file io.Reader) (int, uint32, string, ruleStats) {
 	rulesCount := 0
+	st := ruleStats{}
 	name := ""
 	seenTitle := false
 	r := bufio.NewReader(file)
@@ -473,12 +1572,16 @@ func (f *Filtering) parseFilterContents(file io.Reader) (int, uint32, string) {
 				name = m[0][1]
 				seenTitle = true
 			}
+			if kind := parseFilterKindDirective(line); len(kind) != 0 && len(st.declaredKind) == 0 {
+				st.declaredKind = kind
+			}
 
 		} else if line[0] == '#' {
 			//
 
 		} else {
 			rulesCount++
+			st.add(line)
 		}
 
 		if err != nil {
@@ -486,26 +1589,115 @@ func (f *Filtering) parseFilterContents(file io.Reader) (int, uint32, string) {
 		}
 	}
 
-	return rulesCount, checksum, name
+	return rulesCount, checksum, name, st
+}
+
+// ruleMatch is a filter line that matches a rules search query
+type ruleMatch struct {
+	FilterID   int64  `json:"filter_id"`
+	FilterName string `json:"filter_name"`
+	Line       int    `json:"line"` // 1-based line number within the filter file
+	Rule       string `json:"rule"`
+}
+
+// searchRules scans the stored files of the blocklists (or allowlists if whitelist is set)
+//  and returns at most 'limit' lines for which match() returns TRUE.
+// Files are read line by line so that large lists aren't loaded into memory.
+// Return TRUE if the search stopped because the limit was reached.
+func (f *Filtering) searchRules(match func(line string) bool, whitelist bool, limit int) ([]ruleMatch, bool) {
+	var filters []filter
+	config.RLock()
+	if whitelist {
+		filters = append(filters, config.WhitelistFilters...)
+	} else {
+		filters = append(filters, config.Filters...)
+	}
+	config.RUnlock()
+
+	matches := []ruleMatch{}
+	for _, filt := range filters {
+		file, err := os.Open(filt.Path())
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Debug("filter: search: %s", err)
+			}
+			continue
+		}
+
+		r := bufio.NewReader(file)
+		lineNum := 0
+		for {
+			line, err := r.ReadString('\n')
+			lineNum++
+			line = strings.TrimSpace(line)
+			if len(line) != 0 && match(line) {
+				if len(matches) == limit {
+					_ = file.Close()
+					return matches, true
+				}
+				matches = append(matches, ruleMatch{
+					FilterID:   filt.ID,
+					FilterName: filt.Name,
+					Line:       lineNum,
+					Rule:       line,
+				})
+			}
+
+			if err != nil {
+				break
+			}
+		}
+		_ = file.Close()
+	}
+
+	return matches, false
 }
 
 // Perform upgrade on a filter and update LastUpdated value
-func (f *Filtering) update(filter *filter) (bool, error) {
-	b, err := f.updateIntl(filter)
-	filter.LastUpdated = time.Now()
-	if !b {
+func (f *Filtering) update(ctx context.Context, filter *filter) (bool, error) {
+	filter.downloadSize = 0
+	filter.warnings = nil
+	filter.effectiveURL = ""
+	filter.lastResponse = ""
+	filter.timing = nil
+	filter.downloadRetries = 0
+	remote := !isLocalFilterURL(filter.URL) && !isDataURL(filter.URL) && len(filter.sharedFrom) == 0
+	m := f.updateMetrics()
+	if remote {
+		m.DownloadStarted(filter.URL)
+	}
+	start := time.Now()
+	b, err := f.updateIntl(ctx, filter)
+	filter.downloadDuration = time.Since(start)
+	if remote {
+		if err == errFilterRemoved {
+			m.DownloadFinished(filter.URL, filter.downloadSize, nil)
+		} else {
+			m.DownloadFinished(filter.URL, filter.downloadSize, err)
+		}
+	}
+	filter.LastUpdated = f.timeNow()
+	if !b && err != errFilterRemoved {
 		e := os.Chtimes(filter.Path(), filter.LastUpdated, filter.LastUpdated)
 		if e != nil {
 			log.Error("os.Chtimes(): %v", e)
 		}
 	}
+	if b {
+		filter.statFile()
+	}
 	return b, err
 }
 
 // nolint(gocyclo)
-func (f *Filtering) updateIntl(filter *filter) (bool, error) {
+func (f *Filtering) updateIntl(ctx context.Context, filter *filter) (bool, error) {
 	log.Tracef("Downloading update for filter %d from %s", filter.ID, filter.URL)
 
+	config.RLock()
+	punycode := config.DNS.FiltersPunycodeRules
+	compact := config.DNS.FiltersCompactFiles
+	config.RUnlock()
+
 	tmpFile, err := ioutil.TempFile(filepath.Join(Context.getDataDir(), filterDir), "")
 	if err != nil {
 		return false, err
@@ -517,19 +1709,52 @@ func (f *Filtering) updateIntl(filter *filter) (bool, error) {
 		}
 	}()
 
+	if filter.full {
+		ctx = withNoCache(ctx)
+	}
+
 	var reader io.Reader
-	if filepath.IsAbs(filter.URL) {
-		f, err := os.Open(filter.URL)
+	var tt *timingTrace
+	if isLocalFilterURL(filter.URL) {
+		data, err := readLocalFilter(filter.URL)
+		if err != nil {
+			return false, err
+		}
+		reader = bytes.NewReader(data)
+	} else if isDataURL(filter.URL) {
+		data, err := decodeDataURL(filter.URL)
+		if err != nil {
+			return false, err
+		}
+		reader = bytes.NewReader(data)
+	} else if len(filter.sharedFrom) != 0 {
+		log.Debug("filter: %s: using the data downloaded for filter file %s", filter.URL, filter.sharedFrom)
+		file, err := os.Open(filter.sharedFrom)
 		if err != nil {
-			return false, fmt.Errorf("open file: %s", err)
+			return false, err
 		}
-		defer f.Close()
-		reader = f
+		defer file.Close()
+		reader = file
 	} else {
-		resp, err := Context.client.Get(filter.URL)
+		gctx := ctx // the includes aren't traced
+		if filter.traceTiming {
+			gctx, tt = withTimingTrace(ctx)
+			defer func() {
+				if filter.timing == nil {
+					// the attempt has failed before the end of the transfer
+					filter.timing = tt.finish()
+				}
+			}()
+		}
+		retries := &downloadRetries{max: filter.maxRetries()}
+		resp, err := filterGet(withDownloadRetries(gctx, retries), filter.URL, filter.Trusted)
+		filter.downloadRetries = retries.count
 		if resp != nil && resp.Body != nil {
 			defer resp.Body.Close()
 		}
+		if resp != nil {
+			filter.lastResponse = captureResponseHeaders(resp)
+		}
 		if err != nil {
 			log.Printf("Couldn't request filter from URL %s, skipping: %s", filter.URL, err)
 			return false, err
@@ -540,40 +1765,25 @@ func (f *Filtering) updateIntl(filter *filter) (bool, error) {
 			return false, fmt.Errorf("got status code != 200: %d", resp.StatusCode)
 		}
 		reader = resp.Body
+		filter.effectiveURL = resp.Request.URL.String()
 	}
 
-	htmlTest := true
-	firstChunk := make([]byte, 4*1024)
-	firstChunkLen := 0
+	check := newFilterDataCheck()
 	buf := make([]byte, 64*1024)
 	total := 0
 	for {
 		n, err := reader.Read(buf)
 		total += n
+		if len(filter.sharedFrom) == 0 { // the shared data hasn't been received from network
+			filter.downloadSize = int64(total)
+		}
 
-		if htmlTest {
-			// gather full buffer firstChunk and perform its data tests
-			num := util.MinInt(n, len(firstChunk)-firstChunkLen)
-			copied := copy(firstChunk[firstChunkLen:], buf[:num])
-			firstChunkLen += copied
-
-			if firstChunkLen == len(firstChunk) || err == io.EOF {
-				if !isPrintableText(firstChunk, firstChunkLen) {
-					return false, fmt.Errorf("data contains non-printable characters")
-				}
-
-				s := strings.ToLower(string(firstChunk))
-				if strings.Index(s, "<html") >= 0 ||
-					strings.Index(s, "<!doctype") >= 0 {
-					return false, fmt.Errorf("data is HTML, not plain text")
-				}
-
-				htmlTest = false
-				firstChunk = nil
-			}
+		err2 := check.add(buf[:n], err == io.EOF)
+		if err2 != nil {
+			return false, err2
 		}
 
-		_, err2 := tmpFile.Write(buf[:n])
+		_, err2 = tmpFile.Write(buf[:n])
 		if err2 != nil {
 			return false, err2
 		}
@@ -586,15 +1796,66 @@ func (f *Filtering) updateIntl(filter *filter) (bool, error) {
 			return false, err
 		}
 	}
+	if tt != nil {
+		filter.timing = tt.finish()
+	}
+
+	err = check.finish()
+	if err != nil {
+		return false, err
+	}
+
+	newFile, warnings, err := inlineIncludes(ctx, tmpFile, filter.URL, filter.Trusted)
+	if err != nil {
+		return false, err
+	}
+	if newFile != nil {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+		tmpFile = newFile
+	}
+	filter.warnings = append(filter.warnings, warnings...)
+
+	if punycode {
+		newFile, n, warnings, err := convertFileToPunycode(tmpFile)
+		if err != nil {
+			return false, err
+		}
+		if newFile != nil {
+			_ = tmpFile.Close()
+			_ = os.Remove(tmpFile.Name())
+			tmpFile = newFile
+			log.Debug("filter: %s: converted %d rules to punycode", filter.URL, n)
+		}
+		filter.punycodeRules = n
+		filter.warnings = append(filter.warnings, warnings...)
+	}
+
+	if compact {
+		newFile, saved, err := compactFilterFile(tmpFile)
+		if err != nil {
+			return false, err
+		}
+		if newFile != nil {
+			_ = tmpFile.Close()
+			_ = os.Remove(tmpFile.Name())
+			tmpFile = newFile
+			log.Debug("filter: %s: compaction saved %d bytes", filter.URL, saved)
+		}
+	}
 
 	// Extract filter name and count number of rules
 	_, _ = tmpFile.Seek(0, io.SeekStart)
-	rulesCount, checksum, filterName := f.parseFilterContents(tmpFile)
+	rulesCount, checksum, filterName, st := f.parseFilterContents(tmpFile)
 	// Check if the filter has been really changed
-	if filter.checksum == checksum {
+	if filter.checksum == checksum && !filter.full {
 		log.Tracef("Filter #%d at URL %s hasn't changed, not updating it", filter.ID, filter.URL)
 		return false, nil
 	}
+	if !filter.acceptRuleDrop && isRuleCountDrop(filter.RulesCount, rulesCount) {
+		// most likely the server returns a maintenance page or a truncated file: keep the old data
+		return false, newMsgError(msgRuleCountDrop, filter.RulesCount, rulesCount)
+	}
 
 	log.Printf("Filter %d has been updated: %d bytes, %d rules",
 		filter.ID, total, rulesCount)
@@ -602,12 +1863,21 @@ func (f *Filtering) updateIntl(filter *filter) (bool, error) {
 		filter.Name = filterName
 	}
 	filter.RulesCount = rulesCount
+	filter.ruleStats = st
 	filter.checksum = checksum
 	filterFilePath := filter.Path()
 	log.Printf("Saving filter %d contents to: %s", filter.ID, filterFilePath)
 
 	// Closing the file before renaming it is necessary on Windows
 	_ = tmpFile.Close()
+	if filter.existing {
+		// the filter may have been removed or changed while we were downloading it
+		config.RLock()
+		defer config.RUnlock()
+		if !filterExistsWithIDNoLock(filter.ID, filter.URL) {
+			return false, errFilterRemoved
+		}
+	}
 	err = os.Rename(tmpFile.Name(), filterFilePath)
 	if err != nil {
 		return false, err
@@ -617,6 +1887,36 @@ func (f *Filtering) updateIntl(filter *filter) (bool, error) {
 	return true, nil
 }
 
+// Return TRUE if the number of rules has dropped below the configured share of the previous non-zero number
+func isRuleCountDrop(old, new int) bool {
+	config.RLock()
+	ratio := config.DNS.FiltersMinRuleRatio
+	config.RUnlock()
+	return old > 0 && float64(new) < ratio*float64(old)
+}
+
+// Check the minimum share of the rules that must be kept on update
+func checkFiltersMinRuleRatio(r float64) bool {
+	return r >= 0 && r <= 1
+}
+
+// errFilterRemoved is returned when the filter has been removed (or its URL has been changed) while it was being downloaded.
+// The downloaded data is discarded: the file of the removed filter must not appear again.
+var errFilterRemoved = errors.New("filter has been removed or changed during the update")
+
+// Return TRUE if there's a filter with this ID and URL
+// Note: config must be locked
+func filterExistsWithIDNoLock(id int64, url string) bool {
+	for _, filters := range [][]filter{config.Filters, config.WhitelistFilters} {
+		for _, f := range filters {
+			if f.ID == id && f.URL == url {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // loads filter contents from the file in dataDir
 func (f *Filtering) load(filter *filter) error {
 	filterFilePath := filter.Path()
@@ -636,10 +1936,13 @@ func (f *Filtering) load(filter *filter) error {
 
 	log.Tracef("File %s, id %d, length %d",
 		filterFilePath, filter.ID, st.Size())
-	rulesCount, checksum, _ := f.parseFilterContents(file)
+	rulesCount, checksum, _, stats := f.parseFilterContents(file)
 
 	filter.RulesCount = rulesCount
+	filter.ruleStats = stats
 	filter.checksum = checksum
+	filter.fileSize = st.Size()
+	filter.fileSizeKnown = true
 	filter.LastUpdated = filter.LastTimeUpdated()
 
 	return nil
@@ -648,6 +1951,7 @@ func (f *Filtering) load(filter *filter) error {
 // Clear filter rules
 func (filter *filter) unload() {
 	filter.RulesCount = 0
+	filter.ruleStats = ruleStats{}
 	filter.checksum = 0
 }
 
@@ -674,40 +1978,106 @@ func (filter *filter) LastTimeUpdated() time.Time {
 	return s.ModTime()
 }
 
-func enableFilters(async bool) {
-	var filters []dnsfilter.Filter
-	var whiteFilters []dnsfilter.Filter
-	if config.DNS.FilteringEnabled {
-		// convert array of filters
+// Reasons why an enabled filter isn't passed to the filtering engine
+const (
+	inactiveNoFile  = "no_file"  // the filter hasn't been downloaded yet
+	inactiveNoRules = "no_rules" // the filter contains no rules
+	inactiveInvalid = "invalid"  // the filter file can't be used
+)
 
-		userFilter := userFilter()
-		f := dnsfilter.Filter{
-			ID:   userFilter.ID,
-			Data: userFilter.Data,
-		}
-		filters = append(filters, f)
+// inactiveFilter is an enabled filter that doesn't contribute any rules
+type inactiveFilter struct {
+	ID        int64  `json:"id"`
+	URL       string `json:"url"`
+	Name      string `json:"name"`
+	Whitelist bool   `json:"whitelist"`
+	Reason    string `json:"reason"`          // inactive*
+	Error     string `json:"error,omitempty"` // for inactiveInvalid
+}
 
-		for _, filter := range config.Filters {
-			if !filter.Enabled {
-				continue
-			}
-			f := dnsfilter.Filter{
-				ID:       filter.ID,
-				FilePath: filter.Path(),
-			}
-			filters = append(filters, f)
+// Get the reason why the filter can't be used by the filtering engine
+// Return an empty string if the filter is OK
+func (filter *filter) inactiveReason() (string, error) {
+	st, err := os.Stat(filter.Path())
+	if os.IsNotExist(err) {
+		return inactiveNoFile, nil
+	} else if err != nil {
+		return inactiveInvalid, err
+	}
+	if !st.Mode().IsRegular() {
+		return inactiveInvalid, fmt.Errorf("%s is not a regular file", filter.Path())
+	}
+	if filter.RulesCount == 0 {
+		return inactiveNoRules, nil
+	}
+	return "", nil
+}
+
+// Append the enabled filters to the list for the filtering engine
+// The filters that can't be used are skipped and added to the inactive list
+func appendActiveFilters(dst []dnsfilter.Filter, filters []filter, whitelist bool, inactive *[]inactiveFilter) []dnsfilter.Filter {
+	for _, filter := range filters {
+		if !filter.Enabled {
+			continue
 		}
-		for _, filter := range config.WhitelistFilters {
-			if !filter.Enabled {
-				continue
+		reason, err := filter.inactiveReason()
+		if len(reason) != 0 {
+			inf := inactiveFilter{
+				ID:        filter.ID,
+				URL:       filter.URL,
+				Name:      filter.Name,
+				Whitelist: whitelist,
+				Reason:    reason,
 			}
-			f := dnsfilter.Filter{
-				ID:       filter.ID,
-				FilePath: filter.Path(),
+			if err != nil {
+				inf.Error = err.Error()
 			}
-			whiteFilters = append(whiteFilters, f)
+			*inactive = append(*inactive, inf)
+			continue
+		}
+		f := dnsfilter.Filter{
+			ID:          filter.ID,
+			FilePath:    filter.Path(),
+			Strict:      !whitelist && !filter.allowExceptions(),
+			ClientGroup: filter.ApplyTo,
 		}
+		dst = append(dst, f)
+	}
+	return dst
+}
+
+func enableFilters(async bool) {
+	var filters []dnsfilter.Filter
+	var whiteFilters []dnsfilter.Filter
+	inactive := []inactiveFilter{}
+	config.RLock()
+	if config.DNS.FilteringEnabled {
+		// convert array of filters
+
+		filters = append(filters, userFilters()...)
+		filters = appendActiveFilters(filters, config.Filters, false, &inactive)
+		whiteFilters = appendActiveFilters(whiteFilters, config.WhitelistFilters, true, &inactive)
 	}
+	config.RUnlock()
 
+	for _, inf := range inactive {
+		log.Debug("filter: %d (%s) is not used: %s", inf.ID, inf.URL, inf.Reason)
+	}
+	Context.filters.inactiveLock.Lock()
+	Context.filters.inactive = inactive
+	Context.filters.inactiveLock.Unlock()
+	Context.filters.setScoped(filters, whiteFilters)
+	Context.filters.updateWatchList()
+
+	if Context.filters.engineRebuilt != nil { // for tests
+		Context.filters.engineRebuilt(filters, whiteFilters)
+	}
 	_ = Context.dnsFilter.SetFilters(filters, whiteFilters, async)
 }
+
+// Get the list of enabled filters that weren't used on the last rebuild of the filtering engine
+func (f *Filtering) inactiveFilters() []inactiveFilter {
+	f.inactiveLock.Lock()
+	defer f.inactiveLock.Unlock()
+	return append([]inactiveFilter{}, f.inactive...)
+}
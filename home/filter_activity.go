@@ -0,0 +1,136 @@
+package home
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The metrics of the filter update activity.
+// The update procedure reports the downloads and the update cycles to UpdateMetrics,
+//  e.g. to count them in a monitoring system.
+// activityMetrics is the ready-made implementation: the counters are served in Prometheus text format.
+
+// UpdateMetrics receives the events of the filter updates.
+// The methods are called from the update procedure: they must not block.
+type UpdateMetrics interface {
+	// DownloadStarted is called before a filter list is downloaded
+	DownloadStarted(url string)
+
+	// DownloadFinished is called after the download: bytes is the number of bytes received,
+	//  err is the download error or nil
+	DownloadFinished(url string, bytes int64, err error)
+
+	// UpdateCycleFinished is called after the update procedure:
+	//  the number of the filters with the changed data, the number of the failed filters and the duration
+	UpdateCycleFinished(updated, failed int, dur time.Duration)
+}
+
+// noopUpdateMetrics drops the events
+type noopUpdateMetrics struct{}
+
+func (noopUpdateMetrics) DownloadStarted(string)                      {}
+func (noopUpdateMetrics) DownloadFinished(string, int64, error)       {}
+func (noopUpdateMetrics) UpdateCycleFinished(int, int, time.Duration) {}
+
+// SetUpdateMetrics sets the receiver of the update events, nil: the events are dropped
+func (f *Filtering) SetUpdateMetrics(m UpdateMetrics) {
+	f.updateMetricsLock.Lock()
+	f.updateMetricsHook = m
+	f.updateMetricsLock.Unlock()
+}
+
+// Get the receiver of the update events
+func (f *Filtering) updateMetrics() UpdateMetrics {
+	f.updateMetricsLock.Lock()
+	defer f.updateMetricsLock.Unlock()
+	if f.updateMetricsHook == nil {
+		return noopUpdateMetrics{}
+	}
+	return f.updateMetricsHook
+}
+
+// activityMetrics counts the update events since the start
+type activityMetrics struct {
+	lock             sync.Mutex
+	downloads        uint64  // the started downloads
+	downloadsDone    uint64  // the finished downloads
+	downloadFailures uint64  // the failed downloads
+	downloadBytes    uint64  // the number of bytes received
+	cycles           uint64  // the update procedures
+	cycleSeconds     float64 // the total duration of the update procedures
+	cycleUpdated     uint64  // the number of the filters with the changed data
+	cycleFailed      uint64  // the number of the failed filters
+}
+
+func (m *activityMetrics) DownloadStarted(string) {
+	m.lock.Lock()
+	m.downloads++
+	m.lock.Unlock()
+}
+
+func (m *activityMetrics) DownloadFinished(url string, bytes int64, err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.downloadsDone++
+	if err != nil {
+		m.downloadFailures++
+	}
+	if bytes > 0 {
+		m.downloadBytes += uint64(bytes)
+	}
+}
+
+func (m *activityMetrics) UpdateCycleFinished(updated, failed int, dur time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.cycles++
+	m.cycleSeconds += dur.Seconds()
+	m.cycleUpdated += uint64(updated)
+	m.cycleFailed += uint64(failed)
+}
+
+// Write the counters in Prometheus text format
+func (m *activityMetrics) write(buf *bytes.Buffer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	metrics := []struct {
+		name  string
+		help  string
+		typ   string
+		value string
+	}{
+		{"filter_downloads_total", "The number of the started filter downloads since the start.", "counter",
+			fmt.Sprintf("%d", m.downloads)},
+		{"filter_downloads_in_progress", "The number of the filter downloads in progress.", "gauge",
+			fmt.Sprintf("%d", m.downloads-m.downloadsDone)},
+		{"filter_download_failures_total", "The number of the failed filter downloads since the start.", "counter",
+			fmt.Sprintf("%d", m.downloadFailures)},
+		{"filter_download_bytes_total", "The number of bytes received by the filter downloads since the start.", "counter",
+			fmt.Sprintf("%d", m.downloadBytes)},
+		{"filter_update_cycles_total", "The number of the filters update procedures since the start.", "counter",
+			fmt.Sprintf("%d", m.cycles)},
+		{"filter_update_cycle_seconds_total", "The total duration of the filters update procedures.", "counter",
+			fmt.Sprintf("%g", m.cycleSeconds)},
+		{"filter_update_cycle_updated_total", "The number of the filters with the changed data found by the update procedures.", "counter",
+			fmt.Sprintf("%d", m.cycleUpdated)},
+		{"filter_update_cycle_failed_total", "The number of the filters the update procedures couldn't download.", "counter",
+			fmt.Sprintf("%d", m.cycleFailed)},
+	}
+	for _, mt := range metrics {
+		fmt.Fprintf(buf, "# HELP %s %s\n", mt.name, mt.help)
+		fmt.Fprintf(buf, "# TYPE %s %s\n", mt.name, mt.typ)
+		fmt.Fprintf(buf, "%s %s\n", mt.name, mt.value)
+	}
+}
+
+// Serve the counters of the update activity in Prometheus text format
+func (f *Filtering) handleActivityMetrics(w http.ResponseWriter, r *http.Request) {
+	buf := bytes.Buffer{}
+	f.activity.write(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
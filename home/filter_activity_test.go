@@ -0,0 +1,107 @@
+package home
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testUpdateMetrics records the update events
+type testUpdateMetrics struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (m *testUpdateMetrics) add(e string) {
+	m.lock.Lock()
+	m.events = append(m.events, e)
+	m.lock.Unlock()
+}
+
+func (m *testUpdateMetrics) DownloadStarted(url string) {
+	m.add("started " + url)
+}
+
+func (m *testUpdateMetrics) DownloadFinished(url string, bytes int64, err error) {
+	m.add(fmt.Sprintf("finished %s %d %t", url, bytes, err != nil))
+}
+
+func (m *testUpdateMetrics) UpdateCycleFinished(updated, failed int, dur time.Duration) {
+	m.add(fmt.Sprintf("cycle %d %d", updated, failed))
+}
+
+func TestUpdateMetrics(t *testing.T) {
+	fs := newFixtureServer()
+	defer fs.Close()
+	h, cleanup := newFiltersHarness(t)
+	defer cleanup()
+
+	// the events are dropped by default
+	assert.Equal(t, noopUpdateMetrics{}, Context.filters.updateMetrics())
+
+	m := &testUpdateMetrics{}
+	Context.filters.SetUpdateMetrics(m)
+	url1 := fs.URL + "/1.txt"
+	url2 := fs.URL + "/2.txt"
+	data := fixtureRules("a", 2)
+	fs.set("/1.txt", fixtureResponse{body: data})
+	fs.set("/2.txt", fixtureResponse{body: data})
+	for _, u := range []string{url1, url2} {
+		code, body := h.post("/control/filtering/add_url", `{"name":"List","url":"`+u+`"}`)
+		assert.Equal(t, http.StatusOK, code, body)
+	}
+
+	fs.set("/1.txt", fixtureResponse{body: fixtureRules("b", 2)})
+	fs.set("/2.txt", fixtureResponse{status: http.StatusNotFound})
+	code, body := h.post("/control/filtering/refresh", `{"whitelist":false}`)
+	assert.Equal(t, http.StatusOK, code, body)
+
+	n := len(data)
+	assert.Equal(t, []string{
+		"started " + url1,
+		fmt.Sprintf("finished %s %d false", url1, n),
+		"started " + url2,
+		fmt.Sprintf("finished %s %d false", url2, n),
+		"started " + url1,
+		fmt.Sprintf("finished %s %d false", url1, n),
+		"started " + url2,
+		fmt.Sprintf("finished %s 0 true", url2),
+		"cycle 1 1",
+	}, m.events)
+}
+
+func TestActivityMetrics(t *testing.T) {
+	fs := newFixtureServer()
+	defer fs.Close()
+	h, cleanup := newFiltersHarness(t)
+	defer cleanup()
+	Context.filters.SetUpdateMetrics(&Context.filters.activity)
+
+	data := fixtureRules("a", 2)
+	fs.set("/1.txt", fixtureResponse{body: data})
+	code, body := h.post("/control/filtering/add_url", `{"name":"List","url":"`+fs.URL+`/1.txt"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	fs.set("/1.txt", fixtureResponse{status: http.StatusNotFound})
+	code, body = h.post("/control/filtering/refresh", `{"whitelist":false}`)
+	assert.Equal(t, http.StatusOK, code, body)
+
+	code, body = h.get("/control/filtering/metrics")
+	assert.Equal(t, http.StatusOK, code)
+	for _, line := range []string{
+		"# TYPE filter_downloads_total counter",
+		"filter_downloads_total 2",
+		"filter_downloads_in_progress 0",
+		"filter_download_failures_total 1",
+		fmt.Sprintf("filter_download_bytes_total %d", len(data)),
+		"filter_update_cycles_total 1",
+		"filter_update_cycle_updated_total 0",
+		"filter_update_cycle_failed_total 1",
+	} {
+		assert.True(t, strings.Contains(body, line+"\n"), line)
+	}
+}
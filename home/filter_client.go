@@ -0,0 +1,461 @@
+package home
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// The default HTTP client for the filters downloads is used if Context.client isn't set
+const (
+	filterHeaderTimeout = 30 * time.Second // waiting for the response headers
+	filterIdleTimeout   = 60 * time.Second // waiting for the next portion of data
+)
+
+var (
+	defaultFilterClient     *http.Client
+	defaultFilterClientOnce sync.Once
+)
+
+// idleConn is a connection which read fails if no data has been received for the timeout
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	if err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+// Get the HTTP client for the filters downloads: Context.client or the default client
+func filterClient() *http.Client {
+	if Context.client != nil {
+		return Context.client
+	}
+
+	defaultFilterClientOnce.Do(func() {
+		dialer := &net.Dialer{Timeout: filterHeaderTimeout}
+		defaultFilterClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					conn, err := dialer.DialContext(ctx, network, addr)
+					if err != nil {
+						return nil, err
+					}
+					return &idleConn{Conn: conn, timeout: filterIdleTimeout}, nil
+				},
+				TLSHandshakeTimeout:   filterHeaderTimeout,
+				ResponseHeaderTimeout: filterHeaderTimeout,
+			},
+		}
+	})
+	return defaultFilterClient
+}
+
+// The transport with the proxy for the filters downloads
+var (
+	filterProxyLock      sync.Mutex
+	filterProxyURL       string          // the proxy URL filterProxyTransport has been built for
+	filterProxyBase      *http.Transport // the transport filterProxyTransport has been copied from
+	filterProxyTransport *http.Transport
+)
+
+// Check the proxy URL for the filters downloads: "" (not set) or "scheme://[user:password@]host:port"
+func checkFiltersProxyURL(s string) error {
+	if len(s) == 0 {
+		return nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+		//
+	default:
+		return fmt.Errorf("unsupported proxy scheme: %q", u.Scheme)
+	}
+	if len(u.Hostname()) == 0 {
+		return fmt.Errorf("proxy host is required")
+	}
+	return nil
+}
+
+// Get the proxy URL with the password hidden
+func redactProxyURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
+
+// Get the transport for the filters downloads.
+// If the proxy for the filters is set, the transport of the HTTP client is copied with this proxy,
+//  otherwise the transport is used as is (with the global proxy setting).
+func filterTransport(c *http.Client, proxy string) http.RoundTripper {
+	if len(proxy) == 0 {
+		return c.Transport
+	}
+	base := http.DefaultTransport.(*http.Transport)
+	if c.Transport != nil {
+		t, ok := c.Transport.(*http.Transport)
+		if !ok {
+			return c.Transport
+		}
+		base = t
+	}
+
+	filterProxyLock.Lock()
+	defer filterProxyLock.Unlock()
+	if filterProxyTransport != nil && filterProxyURL == proxy && filterProxyBase == base {
+		return filterProxyTransport
+	}
+
+	u, err := url.Parse(proxy)
+	if err != nil {
+		log.Error("filter: proxy: %s", err)
+		return c.Transport
+	}
+	t := base.Clone()
+	t.Proxy = http.ProxyURL(u)
+	filterProxyURL = proxy
+	filterProxyBase = base
+	filterProxyTransport = t
+	return t
+}
+
+// Return TRUE if the error is the proxy authentication failure
+func isProxyAuthError(err error) bool {
+	s := err.Error()
+	return strings.Contains(s, "Proxy Authentication Required") ||
+		strings.Contains(s, "username/password authentication failed") // SOCKS5
+}
+
+// Resolve the host name of a filter URL (replaced in tests)
+var filterLookupIP = net.LookupIP
+
+// Private address ranges that aren't covered by net.IP methods: RFC1918 and ULA
+var privateNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, s := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"} {
+		_, n, _ := net.ParseCIDR(s)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// Return TRUE if the address is loopback, link-local, private (RFC1918, ULA) or unspecified
+func isPrivateIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check that the request isn't sent to a private address.
+// The host name is resolved and all its addresses are checked.
+// It's used only for the requests sent through a proxy: the proxy resolves the host name itself,
+//  so the address can't be checked when the connection is established.
+func checkFilterDestination(req *http.Request) error {
+	host := req.URL.Hostname()
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		var err error
+		ips, err = filterLookupIP(host)
+		if err != nil {
+			if dnsErr, ok := err.(*net.DNSError); ok {
+				// the error contains the host name, it may be retried
+				return dnsErr
+			}
+			return fmt.Errorf("couldn't resolve %s: %s", host, err)
+		}
+	}
+
+	for _, ip := range ips {
+		if isPrivateIP(ip) {
+			return fmt.Errorf("request to %s is blocked: %s is a private address", host, ip)
+		}
+	}
+	return nil
+}
+
+// Get the address of the proxy the transport connects to
+func proxyAddr(u *url.URL) string {
+	port := u.Port()
+	if len(port) == 0 {
+		switch u.Scheme {
+		case "https":
+			port = "443"
+		case "socks5":
+			port = "1080"
+		default:
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// Get the IP address the connection is made to
+func connIP(conn net.Conn) net.IP {
+	if a, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return a.IP
+	}
+	host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	return net.ParseIP(host)
+}
+
+// The transport that doesn't connect to the private addresses
+var (
+	filterGuardLock      sync.Mutex
+	filterGuardBase      http.RoundTripper // the transport filterGuardTransport has been copied from
+	filterGuardTransport *http.Transport
+)
+
+// Get the transport that doesn't connect to the private addresses.
+// The address is checked when the connection is established, so the host name isn't resolved twice
+//  (a DNS rebinding can't bypass the check) and the connections for the redirects are checked too.
+func guardedTransport(rt http.RoundTripper) (http.RoundTripper, error) {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	base, ok := rt.(*http.Transport)
+	if !ok || base.DialTLS != nil || base.DialTLSContext != nil {
+		return nil, fmt.Errorf("private destinations can't be blocked with this HTTP transport")
+	}
+
+	filterGuardLock.Lock()
+	defer filterGuardLock.Unlock()
+	if filterGuardTransport != nil && filterGuardBase == rt {
+		return filterGuardTransport, nil
+	}
+
+	t := base.Clone()
+	proxies := sync.Map{} // the addresses of the proxies, the connections to them aren't checked
+	if base.Proxy != nil {
+		t.Proxy = func(req *http.Request) (*url.URL, error) {
+			u, err := base.Proxy(req)
+			if err != nil || u == nil {
+				return u, err
+			}
+			err = checkFilterDestination(req)
+			if err != nil {
+				return nil, err
+			}
+			proxies.Store(proxyAddr(u), true)
+			return u, nil
+		}
+	}
+	dial := base.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := proxies.Load(addr); ok {
+			return conn, nil
+		}
+		host, _, _ := net.SplitHostPort(addr)
+		ip := connIP(conn)
+		if ip == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("request to %s is blocked: unknown address %s", host, conn.RemoteAddr())
+		}
+		if isPrivateIP(ip) {
+			_ = conn.Close()
+			return nil, fmt.Errorf("request to %s is blocked: %s is a private address", host, ip)
+		}
+		return conn, nil
+	}
+	filterGuardBase = rt
+	filterGuardTransport = t
+	return t, nil
+}
+
+// Send the request for the filter data.
+// If the private destinations are blocked, the address is checked for every connection, including the redirects.
+// trusted: the filter has been marked as trusted in the configuration file, its destination isn't checked
+func filterDo(req *http.Request, trusted bool) (*http.Response, error) {
+	config.RLock()
+	blockPrivate := config.DNS.FiltersBlockPrivate && !trusted
+	maxRedirects := int(config.DNS.FiltersMaxRedirects)
+	proxy := config.DNS.FiltersProxyURL
+	config.RUnlock()
+
+	c := filterClient()
+	transport := filterTransport(c, proxy)
+	if blockPrivate {
+		var err error
+		transport, err = guardedTransport(transport)
+		if err != nil {
+			return nil, err
+		}
+	}
+	client := &http.Client{
+		Timeout:   c.Timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if isProxyAuthError(err) {
+			return nil, fmt.Errorf("proxy authentication failed: %s", err)
+		}
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("proxy authentication failed: %s", resp.Status)
+	}
+	return resp, nil
+}
+
+// The maximum number of the download retries
+const maxFilterDownloadRetries = 10
+
+// The delay before the first retry (replaced in tests), it's doubled after every attempt
+var filterRetryDelay = time.Second
+
+// The maximum delay between the download attempts
+const maxFilterRetryDelay = 30 * time.Second
+
+// downloadRetries is the retry policy of a filter download
+type downloadRetries struct {
+	max   int // the number of retries
+	count int // the number of retries made
+}
+
+type downloadRetriesKey struct{}
+
+// Use the retry policy of the filter for the download instead of config.DNS.FiltersDownloadRetries.
+// The number of retries made is counted in r.
+func withDownloadRetries(ctx context.Context, r *downloadRetries) context.Context {
+	return context.WithValue(ctx, downloadRetriesKey{}, r)
+}
+
+// Get the number of the download retries of the filter
+func (filter *filter) maxRetries() int {
+	if filter.MaxRetries != nil {
+		return int(*filter.MaxRetries)
+	}
+	config.RLock()
+	defer config.RUnlock()
+	return int(config.DNS.FiltersDownloadRetries)
+}
+
+// Get the delay before the retry after 'attempt' attempts
+func filterGetRetryDelay(attempt int) time.Duration {
+	d := filterRetryDelay
+	for i := 1; i < attempt && d < maxFilterRetryDelay; i++ {
+		d *= 2
+	}
+	if d > maxFilterRetryDelay {
+		d = maxFilterRetryDelay
+	}
+	return d
+}
+
+// Return TRUE if the download may succeed when it's retried: a network error or a server error (5xx).
+// The errors of the destination checks and of the proxy authentication aren't retried.
+func isTransientFilterError(resp *http.Response, err error) bool {
+	if err == nil {
+		return resp.StatusCode >= 500
+	}
+	if _, ok := err.(*net.DNSError); ok {
+		// the filter host couldn't be resolved while its address was being checked
+		return true
+	}
+	ue, ok := err.(*url.Error)
+	if !ok {
+		return false
+	}
+	if ue.Timeout() {
+		return true
+	}
+	_, ok = ue.Err.(net.Error)
+	return ok
+}
+
+type noCacheKey struct{}
+
+// Request the filter data bypassing the HTTP caches (e.g. a CDN edge that serves the stale data)
+func withNoCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+// Send GET request for the filter data
+// A network error or a server error is retried config.DNS.FiltersDownloadRetries times (or as set by withDownloadRetries)
+//  with the exponentially growing delay, the persistent failures are handled by the update scheduler.
+// The request is aborted when the context is cancelled.
+func filterGet(ctx context.Context, u string, trusted bool) (*http.Response, error) {
+	config.RLock()
+	retries := int(config.DNS.FiltersDownloadRetries)
+	config.RUnlock()
+	r, _ := ctx.Value(downloadRetriesKey{}).(*downloadRetries)
+	if r != nil {
+		retries = r.max
+	}
+
+	for i := 0; ; i++ {
+		if tt := timingTraceFrom(ctx); tt != nil {
+			tt.reset()
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if ctx.Value(noCacheKey{}) != nil {
+			req.Header.Set("Cache-Control", "no-cache")
+			req.Header.Set("Pragma", "no-cache")
+		}
+		resp, err := filterDo(req, trusted)
+		if i == retries || !isTransientFilterError(resp, err) {
+			return resp, err
+		}
+
+		if err == nil {
+			log.Debug("filter: %s: attempt %d: %s, retrying", u, i+1, resp.Status)
+			_ = resp.Body.Close()
+		} else {
+			log.Debug("filter: %s: attempt %d: %s, retrying", u, i+1, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(filterGetRetryDelay(i + 1)):
+			//
+		}
+		if r != nil {
+			r.count++
+		}
+	}
+}
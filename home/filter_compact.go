@@ -0,0 +1,161 @@
+package home
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// Metadata headers that are kept in the compacted filter files
+var filterHeaderRegexp = regexp.MustCompile(`^[!#]\s*(Title|Expires|License|Homepage|Version|Description|Last modified)\s*:`)
+
+// Return TRUE if the line doesn't contain a rule (it's empty or a comment)
+func isNonRuleLine(line string) bool {
+	line = strings.TrimSpace(line)
+	return len(line) == 0 || line[0] == '!' || line[0] == '#'
+}
+
+// Remove comments and empty lines from the filter data.
+// The metadata headers (Title, Expires, etc.) before the first rule are kept.
+// Return the new file (or nil if nothing was removed) and the number of bytes saved.
+func compactFilterFile(file *os.File) (*os.File, int64, error) {
+	_, err := file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	out, err := ioutil.TempFile(filepath.Dir(file.Name()), "")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		if out != nil {
+			_ = out.Close()
+			_ = os.Remove(out.Name())
+		}
+	}()
+
+	var saved int64
+	seenRule := false
+	r := bufio.NewReader(file)
+	w := bufio.NewWriter(out)
+	for {
+		line, err := r.ReadString('\n')
+		if len(line) != 0 {
+			keep := true
+			if isNonRuleLine(line) {
+				keep = !seenRule && filterHeaderRegexp.MatchString(strings.TrimSpace(line))
+			} else {
+				seenRule = true
+			}
+
+			if keep {
+				if !strings.HasSuffix(line, "\n") {
+					line += "\n"
+					saved--
+				}
+				_, werr := w.WriteString(line)
+				if werr != nil {
+					return nil, 0, werr
+				}
+			} else {
+				saved += int64(len(line))
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	if saved <= 0 {
+		return nil, 0, nil
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return nil, 0, err
+	}
+	res := out
+	out = nil
+	return res, saved, nil
+}
+
+// Compact the stored filter file
+// Return the number of bytes saved
+func compactStoredFilter(filt *filter) (int64, error) {
+	file, err := os.Open(filt.Path())
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	newFile, saved, err := compactFilterFile(file)
+	_ = file.Close()
+	if err != nil || newFile == nil {
+		return 0, err
+	}
+
+	name := newFile.Name()
+	_ = newFile.Close()
+	err = os.Rename(name, filt.Path())
+	if err != nil {
+		_ = os.Remove(name)
+		return 0, err
+	}
+	return saved, nil
+}
+
+// Compact the stored filter files once after the compaction has been turned on in the configuration.
+// The existing files are compacted on startup, the downloaded files are compacted in updateIntl().
+// Must be called before the filters are loaded, because the checksums are changed.
+func (f *Filtering) compactFilesIfNeeded() {
+	f.stateLock.Lock()
+	defer f.stateLock.Unlock()
+
+	if !config.DNS.FiltersCompactFiles {
+		if f.state.Compacted {
+			// the option has been turned off: compact the files again when it's turned on
+			f.state.Compacted = false
+			f.saveStateNoLock()
+		}
+		return
+	}
+	if f.state.Compacted {
+		return
+	}
+
+	var saved int64
+	for _, filters := range [][]filter{config.Filters, config.WhitelistFilters} {
+		for i := range filters {
+			n, err := compactStoredFilter(&filters[i])
+			if err != nil {
+				log.Error("filter: %s: compaction: %s", filters[i].Path(), err)
+				continue
+			}
+			saved += n
+		}
+	}
+	log.Info("filter: compacted the stored filter files: %d bytes saved", saved)
+
+	f.state.Compacted = true
+	f.state.CompactionSaved = saved
+	f.saveStateNoLock()
+}
+
+// Get the number of bytes saved by the compaction of the stored filter files
+func (f *Filtering) compactionSaved() int64 {
+	f.stateLock.Lock()
+	defer f.stateLock.Unlock()
+	return f.state.CompactionSaved
+}
@@ -0,0 +1,101 @@
+package home
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+const compactTestData = `! Title: Test filter
+! Expires: 4 days
+! License: MIT
+
+! Comment about the rules below
+||example.org^
+# hosts-style comment
+0.0.0.0 example.com
+
+! Title: not a header anymore
+@@||example.net^`
+
+func TestCompactFilterFile(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.filters.Init()
+
+	fn := dir + "/filter.txt"
+	assert.Nil(t, ioutil.WriteFile(fn, []byte(compactTestData), 0644))
+	file, err := os.Open(fn)
+	assert.Nil(t, err)
+	defer file.Close()
+
+	newFile, saved, err := compactFilterFile(file)
+	assert.Nil(t, err)
+	assert.NotNil(t, newFile)
+	defer func() {
+		_ = newFile.Close()
+		_ = os.Remove(newFile.Name())
+	}()
+
+	data, err := ioutil.ReadFile(newFile.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, `! Title: Test filter
+! Expires: 4 days
+! License: MIT
+||example.org^
+0.0.0.0 example.com
+@@||example.net^
+`, string(data))
+	assert.Equal(t, int64(len(compactTestData)-len(data)), saved)
+
+	rulesBefore, _, nameBefore, _ := Context.filters.parseFilterContents(strings.NewReader(compactTestData))
+	rulesAfter, _, nameAfter, _ := Context.filters.parseFilterContents(strings.NewReader(string(data)))
+	assert.Equal(t, 3, rulesBefore)
+	assert.Equal(t, rulesBefore, rulesAfter)
+	assert.Equal(t, "Test filter", nameBefore)
+	assert.Equal(t, nameBefore, nameAfter)
+
+	// nothing to remove
+	_, _ = newFile.Seek(0, 0)
+	newFile2, saved, err := compactFilterFile(newFile)
+	assert.Nil(t, err)
+	assert.Nil(t, newFile2)
+	assert.Equal(t, int64(0), saved)
+}
+
+func TestCompactFilesIfNeeded(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	config.Filters = []filter{
+		{Enabled: true, URL: "https://example.org/1.txt", Filter: dnsfilter.Filter{ID: 1}},
+	}
+	defer func() { config.Filters = nil }()
+	Context.filters.Init()
+	assert.Nil(t, ioutil.WriteFile(config.Filters[0].Path(), []byte(compactTestData), 0644))
+
+	// the option is off: the file isn't changed
+	Context.filters.compactFilesIfNeeded()
+	data, _ := ioutil.ReadFile(config.Filters[0].Path())
+	assert.Equal(t, compactTestData, string(data))
+
+	config.DNS.FiltersCompactFiles = true
+	defer func() { config.DNS.FiltersCompactFiles = false }()
+	Context.filters.compactFilesIfNeeded()
+	data, _ = ioutil.ReadFile(config.Filters[0].Path())
+	assert.True(t, len(data) < len(compactTestData))
+	assert.Equal(t, int64(len(compactTestData)-len(data)), Context.filters.compactionSaved())
+
+	// the files are compacted only once
+	assert.Nil(t, ioutil.WriteFile(config.Filters[0].Path(), []byte(compactTestData), 0644))
+	Context.filters.compactFilesIfNeeded()
+	data, _ = ioutil.ReadFile(config.Filters[0].Path())
+	assert.Equal(t, compactTestData, string(data))
+}
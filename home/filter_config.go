@@ -0,0 +1,123 @@
+package home
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// YAML keys of the filter object
+var filterYAMLKeys = yamlKeys(reflect.TypeOf(filter{}))
+
+// Get the list of keys that yaml package uses for the struct fields
+func yamlKeys(t reflect.Type) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if len(field.PkgPath) != 0 && !field.Anonymous {
+			continue // unexported
+		}
+
+		tag := field.Tag.Get("yaml")
+		name := strings.Split(tag, ",")[0]
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(tag, ",inline") {
+			keys = append(keys, yamlKeys(field.Type)...)
+			continue
+		}
+		if len(name) == 0 {
+			name = strings.ToLower(field.Name)
+		}
+		keys = append(keys, name)
+	}
+	return keys
+}
+
+// Check filter objects in the configuration file for the unknown keys (e.g. misspelled "enabeld").
+// Anchors and aliases are expanded by yaml package before the check.
+// Return the list of warnings for the user.
+func checkFiltersYAML(data []byte) ([]string, error) {
+	type rawFilters struct {
+		Filters          []map[string]interface{} `yaml:"filters"`
+		WhitelistFilters []map[string]interface{} `yaml:"whitelist_filters"`
+	}
+	raw := rawFilters{}
+	err := yaml.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, err
+	}
+
+	known := map[string]bool{}
+	for _, k := range filterYAMLKeys {
+		known[k] = true
+	}
+
+	var warnings []string
+	check := func(section string, filters []map[string]interface{}) {
+		for i, f := range filters {
+			for k := range f {
+				if known[k] {
+					continue
+				}
+				w := fmt.Sprintf("%s[%d] (url: %v): unknown field %q", section, i, f["url"], k)
+				if s := nearestString(k, filterYAMLKeys); len(s) != 0 {
+					w += fmt.Sprintf(", did you mean %q?", s)
+				}
+				warnings = append(warnings, w)
+			}
+		}
+	}
+	check("filters", raw.Filters)
+	check("whitelist_filters", raw.WhitelistFilters)
+	return warnings, nil
+}
+
+// Get the string from the list that is the closest to s (by edit distance)
+// Return an empty string if no string is close enough
+func nearestString(s string, list []string) string {
+	best := ""
+	bestDist := len(s)/2 + 1 // don't suggest anything too different
+	for _, c := range list {
+		d := editDistance(s, c)
+		if d < bestDist {
+			best = c
+			bestDist = d
+		}
+	}
+	return best
+}
+
+// Get Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
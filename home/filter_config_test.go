@@ -0,0 +1,62 @@
+package home
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestCheckFiltersYAML(t *testing.T) {
+	// clean
+	data := `
+filters:
+- enabled: true
+  url: https://example.org/1.txt
+  name: "1"
+  id: 1
+`
+	w, err := checkFiltersYAML([]byte(data))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(w))
+
+	// misspelled key
+	data = `
+filters:
+- enabled: true
+  url: https://example.org/1.txt
+whitelist_filters:
+- enabeld: true
+  url: https://example.org/2.txt
+  xyz: 1
+`
+	w, err = checkFiltersYAML([]byte(data))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(w))
+	assert.Contains(t, w, `whitelist_filters[0] (url: https://example.org/2.txt): unknown field "enabeld", did you mean "enabled"?`)
+	assert.Contains(t, w, `whitelist_filters[0] (url: https://example.org/2.txt): unknown field "xyz"`)
+
+	// anchors
+	data = `
+filters:
+- &base
+  enabled: true
+  url: https://example.org/1.txt
+  name: "1"
+- <<: *base
+  url: https://example.org/2.txt
+- <<: *base
+  url: https://example.org/3.txt
+  enabeld: false
+`
+	w, err = checkFiltersYAML([]byte(data))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{`filters[2] (url: https://example.org/3.txt): unknown field "enabeld", did you mean "enabled"?`}, w)
+
+	conf := configuration{}
+	assert.Nil(t, yaml.Unmarshal([]byte(data), &conf))
+	assert.Equal(t, 3, len(conf.Filters))
+	assert.True(t, conf.Filters[1].Enabled)
+	assert.Equal(t, "1", conf.Filters[1].Name)
+	assert.Equal(t, "https://example.org/2.txt", conf.Filters[1].URL)
+}
@@ -0,0 +1,58 @@
+package home
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// The maximum size of the filter data in data: URL
+const maxDataURLSize = 256 * 1024
+
+// Return TRUE if the filter URL contains the filter data,
+// e.g. "data:text/plain;base64,<base64 data>" or "data:text/plain,<URL-encoded data>"
+func isDataURL(u string) bool {
+	return strings.HasPrefix(u, "data:")
+}
+
+// Get the filter data from data: URL
+func decodeDataURL(u string) ([]byte, error) {
+	s := strings.TrimPrefix(u, "data:")
+	i := strings.IndexByte(s, ',')
+	if i < 0 {
+		return nil, fmt.Errorf("invalid data URL: no data")
+	}
+	meta := s[:i]
+	s = s[i+1:]
+
+	b64 := strings.HasSuffix(meta, ";base64")
+	meta = strings.TrimSuffix(meta, ";base64")
+	mediaType := strings.TrimSpace(strings.Split(meta, ";")[0])
+	if len(mediaType) != 0 && mediaType != "text/plain" {
+		return nil, fmt.Errorf("invalid data URL: unsupported media type: %s", mediaType)
+	}
+
+	var data []byte
+	if b64 {
+		var err error
+		data, err = base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			data, err = base64.RawStdEncoding.DecodeString(s)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid data URL: %s", err)
+		}
+	} else {
+		str, err := url.PathUnescape(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data URL: %s", err)
+		}
+		data = []byte(str)
+	}
+
+	if len(data) > maxDataURLSize {
+		return nil, fmt.Errorf("invalid data URL: %w: %d bytes (the maximum is %d)", errKindTooLarge, len(data), maxDataURLSize)
+	}
+	return data, nil
+}
@@ -0,0 +1,72 @@
+package home
+
+import (
+	"encoding/base64"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeDataURL(t *testing.T) {
+	rules := "||example.org^\n||example.com^\n"
+	b64 := base64.StdEncoding.EncodeToString([]byte(rules))
+
+	for _, u := range []string{
+		"data:text/plain;base64," + b64,
+		"data:;base64," + b64,
+		"data:text/plain;base64," + strings.TrimRight(b64, "="),
+		"data:text/plain;charset=utf-8;base64," + b64,
+		"data:text/plain,%7C%7Cexample.org%5E%0A%7C%7Cexample.com%5E%0A",
+		"data:,||example.org^%0A||example.com^%0A",
+	} {
+		data, err := decodeDataURL(u)
+		assert.Nil(t, err, u)
+		assert.Equal(t, rules, string(data), u)
+	}
+
+	for _, u := range []string{
+		"data:text/plain;base64",
+		"data:text/html;base64," + b64,
+		"data:text/plain;base64,!!!",
+		"data:text/plain,%zz",
+		"data:text/plain;base64," + base64.StdEncoding.EncodeToString(make([]byte, maxDataURLSize+1)),
+	} {
+		_, err := decodeDataURL(u)
+		assert.NotNil(t, err, u)
+	}
+}
+
+func TestIsValidFilterURLData(t *testing.T) {
+	assert.True(t, isValidFilterURL("data:text/plain,||example.org^"))
+	assert.True(t, isValidFilterURL("data:text/plain;base64,fHxleGFtcGxlLm9yZ14K"))
+	assert.True(t, isValidFilterURL("https://example.org/filter.txt"))
+	assert.False(t, isValidFilterURL("example.org/filter.txt"))
+}
+
+func TestAddDataURLFilter(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.filters.Init()
+	defer func() { config.Filters = nil }()
+
+	u := "data:text/plain;base64," + base64.StdEncoding.EncodeToString([]byte("! Title: Inline rules\n||example.org^\n||example.com^\n"))
+	results, nAdded, _ := Context.filters.addFilters([]filterAddJSON{{URL: u}})
+	assert.Equal(t, 1, nAdded)
+	assert.Equal(t, "", results[0].Error)
+	assert.Equal(t, 2, results[0].RulesCount)
+	assert.Equal(t, "Inline rules", config.Filters[0].Name)
+
+	resp := Context.filters.checkFilterURL(u)
+	assert.Equal(t, "", resp.Error)
+	assert.True(t, resp.IsFilter)
+	assert.True(t, resp.Valid)
+	assert.Equal(t, 2, resp.RulesCount)
+
+	results, nAdded, _ = Context.filters.addFilters([]filterAddJSON{{URL: "data:text/plain;base64,!!!"}})
+	assert.Equal(t, 0, nAdded)
+	assert.NotEqual(t, "", results[0].Error)
+}
@@ -0,0 +1,106 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// The disk usage of the filters.
+// The size of the filter file is stored with the filter:
+//  it's set when the filters are loaded and when a filter is downloaded,
+//  so that the file isn't checked every time the filters are listed.
+
+// Set the size of the filter file, it's 0 if there's no file
+func (filter *filter) statFile() {
+	filter.fileSize = 0
+	st, err := os.Stat(filter.Path())
+	if err == nil {
+		filter.fileSize = st.Size()
+	}
+	filter.fileSizeKnown = true
+}
+
+// Get the size of the filter file, the file is checked only if the size hasn't been set yet
+func (filter *filter) sizeBytes() int64 {
+	if filter.fileSizeKnown {
+		return filter.fileSize
+	}
+	st, err := os.Stat(filter.Path())
+	if err != nil {
+		return 0
+	}
+	return st.Size()
+}
+
+// diskUsageListJSON is the disk usage of a filters list
+type diskUsageListJSON struct {
+	Filters   int   `json:"filters"`    // the number of filters in the list
+	SizeBytes int64 `json:"size_bytes"` // the total size of their files
+}
+
+// diskUsageDirJSON is the disk usage of the filters directory
+type diskUsageDirJSON struct {
+	Path      string `json:"path"`
+	Files     int    `json:"files"`      // all files including the trash, the update history and the temporary files
+	SizeBytes int64  `json:"size_bytes"` // the total size of the files
+}
+
+type diskUsageJSON struct {
+	Filters          diskUsageListJSON `json:"filters"`
+	WhitelistFilters diskUsageListJSON `json:"whitelist_filters"`
+	Dir              diskUsageDirJSON  `json:"filters_dir"`
+}
+
+// Get the disk usage of the filters list
+func listDiskUsage(filters []filter) diskUsageListJSON {
+	u := diskUsageListJSON{}
+	for i := range filters {
+		u.Filters++
+		u.SizeBytes += filters[i].sizeBytes()
+	}
+	return u
+}
+
+// Get the disk usage of the filters directory and its subdirectories
+func dirDiskUsage() (diskUsageDirJSON, error) {
+	u := diskUsageDirJSON{
+		Path: filepath.Join(Context.getDataDir(), filterDir),
+	}
+	err := filepath.Walk(u.Path, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			u.Files++
+			u.SizeBytes += fi.Size()
+		}
+		return nil
+	})
+	return u, err
+}
+
+// Get the disk usage of the filters
+func (f *Filtering) handleDiskUsage(w http.ResponseWriter, r *http.Request) {
+	resp := diskUsageJSON{}
+	config.RLock()
+	resp.Filters = listDiskUsage(config.Filters)
+	resp.WhitelistFilters = listDiskUsage(config.WhitelistFilters)
+	config.RUnlock()
+
+	var err error
+	resp.Dir, err = dirDiskUsage()
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgError, err)
+		return
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
@@ -0,0 +1,90 @@
+package home
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterDiskUsage(t *testing.T) {
+	data := []byte("||example.org^\n||example.com^\n")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(data)
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.configFilename = "AdGuardHome.yaml"
+	Context.client = &http.Client{}
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	defer Context.dnsFilter.Close()
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "data", filterDir), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "data", filterDir, "1.txt"), []byte("||a.example^\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "data", filterDir, "2.txt"), []byte("||b.example^\n||c.example^\n"), 0644))
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "data", filterDir, trashDir), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "data", filterDir, trashDir, "3.txt"), []byte("||d.example^\n"), 0644))
+	config.Filters = []filter{
+		{Enabled: true, URL: srv.URL + "/1.txt", Filter: dnsfilter.Filter{ID: 1}},
+		{Enabled: false, URL: srv.URL + "/2.txt", Filter: dnsfilter.Filter{ID: 2}},
+	}
+	config.WhitelistFilters = []filter{
+		{Enabled: true, URL: srv.URL + "/4.txt", Filter: dnsfilter.Filter{ID: 4}},
+	}
+	defer func() {
+		config.Filters = nil
+		config.WhitelistFilters = nil
+	}()
+	assert.Nil(t, Context.filters.Init())
+
+	// the sizes are set on start, the disabled filters included
+	assert.True(t, config.Filters[0].fileSizeKnown)
+	assert.Equal(t, int64(13), config.Filters[0].fileSize)
+	assert.True(t, config.Filters[1].fileSizeKnown)
+	assert.Equal(t, int64(26), config.Filters[1].fileSize)
+	assert.Equal(t, int64(0), config.WhitelistFilters[0].fileSize)
+
+	// the size is updated after the download
+	_, _ = Context.filters.refreshFilters(FilterRefreshAllowlists|FilterRefreshBlocklists|FilterRefreshForce, true, updateTriggerManual)
+	assert.Equal(t, int64(len(data)), config.Filters[0].fileSize)
+	assert.Equal(t, int64(len(data)), config.WhitelistFilters[0].fileSize)
+
+	// the stored size is returned without checking the file
+	config.Filters[1].fileSize = 100
+	w := httptest.NewRecorder()
+	Context.filters.handleFilteringStatus(w, httptest.NewRequest("GET", "/control/filtering/status", nil))
+	status := filteringConfig{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, int64(len(data)), status.Filters[0].SizeBytes)
+	assert.Equal(t, int64(100), status.Filters[1].SizeBytes)
+	config.Filters[1].fileSize = 26
+
+	w = httptest.NewRecorder()
+	Context.filters.handleDiskUsage(w, httptest.NewRequest("GET", "/control/filtering/disk_usage", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	resp := diskUsageJSON{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 2, resp.Filters.Filters)
+	assert.Equal(t, int64(len(data)+26), resp.Filters.SizeBytes)
+	assert.Equal(t, 1, resp.WhitelistFilters.Filters)
+	assert.Equal(t, int64(len(data)), resp.WhitelistFilters.SizeBytes)
+	// the trash and the update history are counted in the directory totals
+	files := 4
+	size := int64(2*len(data) + 26 + 13)
+	if st, err := os.Stat(filterHistoryPath()); err == nil {
+		files++
+		size += st.Size()
+	}
+	assert.Equal(t, filepath.Join(dir, "data", filterDir), resp.Dir.Path)
+	assert.Equal(t, files, resp.Dir.Files)
+	assert.Equal(t, size, resp.Dir.SizeBytes)
+}
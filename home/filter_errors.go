@@ -0,0 +1,87 @@
+package home
+
+import (
+	"errors"
+	"net/http"
+)
+
+// The kinds of the errors returned when the filters are added, changed or downloaded.
+// The errors are matched with errors.Is, e.g. errors.Is(err, errKindDuplicate).
+var (
+	errKindDuplicate  = errors.New("duplicate filter")
+	errKindNotFound   = errors.New("filter not found")
+	errKindDownload   = errors.New("filter download failed")
+	errKindInvalidURL = errors.New("invalid filter URL")
+	errKindTooLarge   = errors.New("the data is too large")
+)
+
+// The kinds of the user-facing messages
+var msgKinds = map[msgKey]error{
+	msgInvalidURL:        errKindInvalidURL,
+	msgInvalidURLValue:   errKindInvalidURL,
+	msgDuplicateURL:      errKindDuplicate,
+	msgFilterExists:      errKindDuplicate,
+	msgFilterURLExists:   errKindDuplicate,
+	msgFilterNotFound:    errKindNotFound,
+	msgFilterNotFoundURL: errKindNotFound,
+	msgFilterURLNotFound: errKindNotFound,
+	msgFilterFetchFailed: errKindDownload,
+	msgFilterInvalid:     errKindDownload,
+	msgFilterDownload:    errKindDownload,
+	msgEtcHostsTooLarge:  errKindTooLarge,
+}
+
+// Is returns TRUE if the error is of the specified kind.
+// The error arguments of the message are matched too,
+//  e.g. the failed download of a data URL that is too large is errKindTooLarge as well as errKindDownload.
+func (e *messageError) Is(target error) bool {
+	if kind, ok := msgKinds[e.key]; ok && kind == target {
+		return true
+	}
+	for _, a := range e.args {
+		if err, ok := a.(error); ok && errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Is returns TRUE for errKindDownload
+func (e *filterDownloadError) Is(target error) bool {
+	return target == errKindDownload
+}
+
+// The names and HTTP status codes of the error kinds.
+// The first matching kind is used: e.g. the too large data URL is a download error too.
+var errorKinds = []struct {
+	kind error
+	name string // passed to the client in the "kind" field of the error response
+	code int
+}{
+	{errKindTooLarge, "too_large", http.StatusRequestEntityTooLarge},
+	{errKindDuplicate, "duplicate", http.StatusConflict},
+	{errKindNotFound, "not_found", http.StatusNotFound},
+	{errKindDownload, "download", http.StatusBadGateway},
+	{errKindInvalidURL, "bad_url", http.StatusBadRequest},
+}
+
+// Get the name of the error kind
+// Return "" if the error isn't of any kind
+func filterErrorKind(err error) string {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.kind) {
+			return k.name
+		}
+	}
+	return ""
+}
+
+// Get HTTP status code for the error of the filter operation
+func filterErrorStatus(err error) int {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.kind) {
+			return k.code
+		}
+	}
+	return http.StatusBadRequest
+}
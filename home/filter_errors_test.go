@@ -0,0 +1,141 @@
+package home
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterErrorKinds(t *testing.T) {
+	fs := newFixtureServer()
+	defer fs.Close()
+	h, cleanup := newFiltersHarness(t)
+	defer cleanup()
+
+	fs.set("/1.txt", fixtureResponse{body: fixtureRules("a", 2)})
+	fs.set("/2.txt", fixtureResponse{body: fixtureRules("b", 2)})
+	code, body := h.post("/control/filtering/add_url", `{"name":"1","url":"`+fs.URL+`/1.txt"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+
+	bigURL := "data:text/plain;base64," + base64.StdEncoding.EncodeToString(make([]byte, maxDataURLSize+1))
+	testCases := []struct {
+		name string
+		url  string
+		kind error
+		code int
+	}{{
+		name: "invalid_url",
+		url:  "invalid",
+		kind: errKindInvalidURL,
+		code: http.StatusBadRequest,
+	}, {
+		name: "duplicate",
+		url:  fs.URL + "/1.txt",
+		kind: errKindDuplicate,
+		code: http.StatusConflict,
+	}, {
+		name: "download",
+		url:  fs.URL + "/404.txt",
+		kind: errKindDownload,
+		code: http.StatusBadGateway,
+	}, {
+		name: "too_large",
+		url:  bigURL,
+		kind: errKindTooLarge,
+		code: http.StatusRequestEntityTooLarge,
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Context.filters.downloadNewFilter(filterAddJSON{Name: "List", URL: tc.url})
+			assert.True(t, errors.Is(err, tc.kind), err)
+			for _, kind := range []error{errKindInvalidURL, errKindDuplicate, errKindNotFound} {
+				if kind != tc.kind {
+					assert.False(t, errors.Is(err, kind), err)
+				}
+			}
+
+			code, body := h.post("/control/filtering/add_url", `{"name":"List","url":"`+tc.url+`"}`)
+			assert.Equal(t, tc.code, code, body)
+		})
+	}
+
+	// the data URL is downloaded too
+	_, err := Context.filters.downloadNewFilter(filterAddJSON{URL: bigURL})
+	assert.True(t, errors.Is(err, errKindDownload), err)
+
+	// not found
+	assert.True(t, errors.Is(Context.filters.RefreshFilter(fs.URL+"/2.txt"), errKindNotFound))
+	err = Context.filters.filterChangeURL(Context.filters.context(), fs.URL+"/2.txt", filter{URL: fs.URL + "/3.txt"}, false)
+	assert.True(t, errors.Is(err, errKindNotFound), err)
+	code, body = h.post("/control/filtering/set_url",
+		`{"url":"`+fs.URL+`/2.txt","data":{"name":"2","url":"`+fs.URL+`/3.txt","enabled":true}}`)
+	assert.Equal(t, http.StatusNotFound, code, body)
+
+	// the new URL is used by another filter
+	code, body = h.post("/control/filtering/add_url", `{"name":"2","url":"`+fs.URL+`/2.txt"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	err = Context.filters.filterChangeURL(Context.filters.context(), fs.URL+"/2.txt", filter{URL: fs.URL + "/1.txt"}, false)
+	assert.True(t, errors.Is(err, errKindDuplicate), err)
+	code, body = h.post("/control/filtering/set_url",
+		`{"url":"`+fs.URL+`/2.txt","data":{"name":"2","url":"`+fs.URL+`/1.txt","enabled":true}}`)
+	assert.Equal(t, http.StatusConflict, code, body)
+
+	// the new URL can't be downloaded
+	err = Context.filters.filterChangeURL(Context.filters.context(), fs.URL+"/2.txt", filter{URL: fs.URL + "/404.txt"}, false)
+	assert.True(t, errors.Is(err, errKindDownload), err)
+	code, body = h.post("/control/filtering/set_url",
+		`{"url":"`+fs.URL+`/2.txt","data":{"name":"2","url":"`+fs.URL+`/404.txt","enabled":true}}`)
+	assert.Equal(t, http.StatusBadGateway, code, body)
+
+	assert.True(t, errors.Is(&filterDownloadError{msg: "404"}, errKindDownload))
+	assert.False(t, errors.Is(newMsgError(msgBadJSON, errors.New("EOF")), errKindDownload))
+}
+
+func TestFilterErrorResponseKind(t *testing.T) {
+	fs := newFixtureServer()
+	defer fs.Close()
+	h, cleanup := newFiltersHarness(t)
+	defer cleanup()
+
+	fs.set("/1.txt", fixtureResponse{body: fixtureRules("a", 2)})
+	code, body := h.post("/control/filtering/add_url", `{"name":"1","url":"`+fs.URL+`/1.txt"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+
+	errorKind := func(body string) string {
+		resp := filterErrorJSON{}
+		assert.Nil(t, json.Unmarshal([]byte(body), &resp), body)
+		return resp.Kind
+	}
+
+	code, body = h.post("/control/filtering/add_url", `{"name":"List","url":"invalid"}`)
+	assert.Equal(t, http.StatusBadRequest, code, body)
+	assert.Equal(t, "bad_url", errorKind(body))
+
+	code, body = h.post("/control/filtering/add_url", `{"name":"List","url":"`+fs.URL+`/1.txt"}`)
+	assert.Equal(t, http.StatusConflict, code, body)
+	assert.Equal(t, "duplicate", errorKind(body))
+
+	code, body = h.post("/control/filtering/add_url", `{"name":"List","url":"`+fs.URL+`/404.txt"}`)
+	assert.Equal(t, http.StatusBadGateway, code, body)
+	assert.Equal(t, "download", errorKind(body))
+
+	// the other errors don't have a kind
+	code, body = h.post("/control/filtering/add_url", `{`)
+	assert.Equal(t, http.StatusBadRequest, code, body)
+	assert.Equal(t, "", errorKind(body))
+
+	// remove
+	code, body = h.post("/control/filtering/remove_url", `{"url":"`+fs.URL+`/2.txt"}`)
+	assert.Equal(t, http.StatusNotFound, code, body)
+	assert.Equal(t, "not_found", errorKind(body))
+	code, body = h.post("/control/filtering/remove_url", `{"url":"`+fs.URL+`/1.txt","whitelist":true}`)
+	assert.Equal(t, http.StatusNotFound, code, body)
+	assert.Equal(t, 1, len(config.Filters))
+	code, body = h.post("/control/filtering/remove_url", `{"url":"`+fs.URL+`/1.txt"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, 0, len(config.Filters))
+}
@@ -0,0 +1,220 @@
+package home
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/AdguardTeam/golibs/utils"
+)
+
+// The import of the system hosts file.
+// Users often block the hosts by hand in the hosts file ("0.0.0.0 ads.example"):
+//  these entries are imported as a filter with the data in data: URL.
+// The real host mappings ("192.168.1.10 nas"), localhost and the machine's own names are never imported.
+
+// Get the path of the system hosts file (replaced in tests)
+var systemHostsPath = func() string {
+	if runtime.GOOS == "windows" {
+		return os.ExpandEnv("$SystemRoot\\system32\\drivers\\etc\\hosts")
+	}
+	return "/etc/hosts"
+}
+
+// Get the host name of the machine (replaced in tests)
+var systemHostname = os.Hostname
+
+// The names of the local host that are found in the hosts files
+var localHostNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+}
+
+// etcHostsEntries is the result of parsing the hosts file
+type etcHostsEntries struct {
+	hosts   []string // the blocked host names in the order of the file
+	skipped int      // the number of the host names that aren't imported
+}
+
+// Return TRUE if the address is used for blocking in the hosts files: 0.0.0.0, 127.0.0.1, :: or ::1.
+// The other loopback addresses (e.g. "127.0.1.1 myhost" on Debian) are the real mappings.
+func isBlockingHostsIP(ip net.IP) bool {
+	return ip.IsUnspecified() || ip.Equal(net.IPv4(127, 0, 0, 1)) || ip.Equal(net.IPv6loopback)
+}
+
+// Get the names of the machine: the host name and its first label
+func ownHostNames() []string {
+	name, err := systemHostname()
+	if err != nil || len(name) == 0 {
+		return nil
+	}
+	names := []string{name}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		names = append(names, name[:i])
+	}
+	return names
+}
+
+// Parse the hosts file and get the blocked host names.
+// A name is skipped if it's a local host name, a name of the machine (own),
+//  an invalid host name or if it's mapped to a real address anywhere in the file.
+// Every host name is counted once.
+func parseEtcHosts(r io.Reader, own []string) (etcHostsEntries, error) {
+	type entry struct {
+		host     string
+		blocking bool
+	}
+	var entries []entry
+	mapped := map[string]bool{} // the names that must not be blocked
+	for _, name := range own {
+		mapped[strings.ToLower(name)] = true
+	}
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		for _, host := range fields[1:] {
+			host = strings.ToLower(strings.TrimSuffix(host, "."))
+			blocking := ip != nil && isBlockingHostsIP(ip)
+			if ip != nil && !blocking {
+				mapped[host] = true
+			}
+			entries = append(entries, entry{host: host, blocking: blocking})
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return etcHostsEntries{}, err
+	}
+
+	res := etcHostsEntries{}
+	seen := map[string]bool{}
+	for _, e := range entries {
+		if seen[e.host] {
+			continue
+		}
+		seen[e.host] = true
+		if !e.blocking || mapped[e.host] || localHostNames[e.host] || utils.IsValidHostname(e.host) != nil {
+			res.skipped++
+			continue
+		}
+		res.hosts = append(res.hosts, e.host)
+	}
+	return res, nil
+}
+
+// Read the blocking entries of the system hosts file
+func readEtcHosts() (etcHostsEntries, error) {
+	file, err := os.Open(systemHostsPath())
+	if err != nil {
+		return etcHostsEntries{}, err
+	}
+	defer file.Close()
+	return parseEtcHosts(file, ownHostNames())
+}
+
+// Get the number of the blocking entries in the system hosts file: the setup wizard offers to import them.
+// Return 0 if the file can't be read.
+func etcHostsBlockingEntries() int {
+	res, err := readEtcHosts()
+	if err != nil {
+		log.Debug("filter: hosts file: %s", err)
+		return 0
+	}
+	return len(res.hosts)
+}
+
+// Get the data: URL of the filter with the blocked host names.
+// The URL is the same for the same entries: the repeated import of the same file is detected as a duplicate filter.
+func etcHostsFilterURL(path string, hosts []string) (string, error) {
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "! Imported from %s\n", path)
+	for _, host := range hosts {
+		fmt.Fprintf(&buf, "0.0.0.0 %s\n", host)
+	}
+	if buf.Len() > maxDataURLSize {
+		return "", newMsgError(msgEtcHostsTooLarge, buf.Len(), maxDataURLSize)
+	}
+	return "data:text/plain;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+type etcHostsImportJSON struct {
+	ID       int64  `json:"id,omitempty"`   // the ID of the created filter
+	Name     string `json:"name,omitempty"` // the name of the created filter
+	Imported int    `json:"imported"`       // the number of the imported host names
+	Skipped  int    `json:"skipped"`        // the number of the skipped host names
+}
+
+// Import the blocking entries of the system hosts file as a new blocklist.
+// No filter is created if there are no blocking entries.
+func (f *Filtering) handleImportEtcHosts(w http.ResponseWriter, r *http.Request) {
+	path := systemHostsPath()
+	entries, err := readEtcHosts()
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgEtcHostsRead, err)
+		return
+	}
+
+	resp := etcHostsImportJSON{
+		Imported: len(entries.hosts),
+		Skipped:  entries.skipped,
+	}
+	if len(entries.hosts) != 0 {
+		resp.Name = fmt.Sprintf("Hosts file (imported %s)", f.timeNow().Format("2006-01-02"))
+		u, err := etcHostsFilterURL(path, entries.hosts)
+		if err != nil {
+			f.httpErrorErr(w, r, http.StatusBadRequest, err)
+			return
+		}
+		if filterExists(u) {
+			// the URL is too long for the message
+			f.httpErrorMsg(w, r, http.StatusBadRequest, msgFilterExists, path)
+			return
+		}
+
+		filt, err := f.downloadNewFilter(filterAddJSON{Name: resp.Name, URL: u})
+		if err == nil && !filterAdd(filt) {
+			err = newMsgError(msgFilterExists, path)
+		}
+		if err != nil {
+			f.httpErrorErr(w, r, http.StatusBadRequest, err)
+			return
+		}
+		resp.ID = filt.ID
+		onConfigModified()
+		enableFilters(true)
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
@@ -0,0 +1,107 @@
+package home
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const etcHostsFixture = `# /etc/hosts: static table lookup for hostnames
+127.0.0.1	localhost localhost.localdomain
+::1		localhost ip6-localhost ip6-loopback
+ff02::1		ip6-allnodes
+ff02::2		ip6-allrouters
+127.0.1.1	router.lan router
+
+# the local network
+192.168.1.10	nas.lan nas
+192.168.1.20	printer.lan   # the office printer
+
+# blocked by hand
+0.0.0.0 ads.example.com tracker.example.com
+0.0.0.0 ADS.example.com
+127.0.0.1 metrics.example.org # telemetry
+::1 ipv6-ads.example.net
+:: ipv6-tracker.example.net
+0.0.0.0 nas.lan
+0.0.0.0 router
+0.0.0.0 bad_host..example
+   # 0.0.0.0 commented.example.com
+not-an-ip some.example.com
+`
+
+func TestParseEtcHosts(t *testing.T) {
+	res, err := parseEtcHosts(strings.NewReader(etcHostsFixture), []string{"Router.lan", "router"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"ads.example.com",
+		"tracker.example.com",
+		"metrics.example.org",
+		"ipv6-ads.example.net",
+		"ipv6-tracker.example.net",
+	}, res.hosts)
+	// localhost, localhost.localdomain, ip6-localhost, ip6-loopback, ip6-allnodes, ip6-allrouters,
+	// router.lan, router, nas.lan, nas, printer.lan, bad_host..example, some.example.com
+	assert.Equal(t, 13, res.skipped)
+
+	// the machine's own name isn't blocked even if it isn't mapped in the file
+	res, err = parseEtcHosts(strings.NewReader("0.0.0.0 myhost ads.example.com\n"), []string{"myhost"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ads.example.com"}, res.hosts)
+	assert.Equal(t, 1, res.skipped)
+}
+
+func TestImportEtcHosts(t *testing.T) {
+	h, cleanup := newFiltersHarness(t)
+	defer cleanup()
+
+	hostsPath := filepath.Join(Context.workDir, "hosts")
+	assert.Nil(t, ioutil.WriteFile(hostsPath, []byte(etcHostsFixture), 0644))
+	prevHostsPath := systemHostsPath
+	prevHostname := systemHostname
+	systemHostsPath = func() string { return hostsPath }
+	systemHostname = func() (string, error) { return "router.lan", nil }
+	defer func() {
+		systemHostsPath = prevHostsPath
+		systemHostname = prevHostname
+	}()
+
+	assert.Equal(t, 5, etcHostsBlockingEntries())
+
+	code, body := h.post("/control/filtering/import_etc_hosts", "")
+	assert.Equal(t, http.StatusOK, code, body)
+	resp := etcHostsImportJSON{}
+	assert.Nil(t, json.Unmarshal([]byte(body), &resp))
+	assert.Equal(t, 5, resp.Imported)
+	assert.Equal(t, 13, resp.Skipped)
+	assert.True(t, strings.HasPrefix(resp.Name, "Hosts file (imported "), resp.Name)
+	assert.Equal(t, []string{"config", "engine 1/0"}, h.takeEvents())
+
+	assert.Equal(t, 1, len(config.Filters))
+	assert.Equal(t, resp.ID, config.Filters[0].ID)
+	assert.Equal(t, 5, config.Filters[0].RulesCount)
+	assert.True(t, isDataURL(config.Filters[0].URL))
+	data, err := decodeDataURL(config.Filters[0].URL)
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(string(data), "0.0.0.0 ads.example.com\n"))
+	assert.False(t, strings.Contains(string(data), "nas"))
+	assert.False(t, strings.Contains(string(data), "router"))
+
+	// the same entries aren't imported twice
+	code, body = h.post("/control/filtering/import_etc_hosts", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.True(t, strings.Contains(body, string(msgFilterExists)), body)
+	assert.Equal(t, 1, len(config.Filters))
+
+	// no blocking entries: no filter is created
+	assert.Nil(t, ioutil.WriteFile(hostsPath, []byte("127.0.0.1 localhost\n192.168.1.10 nas\n"), 0644))
+	code, body = h.post("/control/filtering/import_etc_hosts", "")
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, `{"imported":0,"skipped":2}`, body)
+	assert.Equal(t, 1, len(config.Filters))
+}
@@ -0,0 +1,74 @@
+package home
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshFilterFull(t *testing.T) {
+	var lock sync.Mutex
+	var headers []http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		headers = append(headers, r.Header.Clone())
+		lock.Unlock()
+		_, _ = w.Write([]byte(fixtureRules("a", 2)))
+	}))
+	defer srv.Close()
+	lastHeader := func() http.Header {
+		lock.Lock()
+		defer lock.Unlock()
+		return headers[len(headers)-1]
+	}
+	h, cleanup := newFiltersHarness(t)
+	defer cleanup()
+
+	url := srv.URL + "/list.txt"
+	code, body := h.post("/control/filtering/add_url", `{"name":"List","url":"`+url+`"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, "", lastHeader().Get("Cache-Control"))
+	h.takeEvents()
+	filt, _ := filterFind(url, false)
+	st1, err := os.Stat(filt.Path())
+	assert.Nil(t, err)
+
+	// the same data: the file isn't rewritten
+	code, body = h.post("/control/filtering/refresh", `{"url":"`+url+`"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, `{"updated":0}`, body)
+	st2, err := os.Stat(filt.Path())
+	assert.Nil(t, err)
+	assert.True(t, os.SameFile(st1, st2))
+
+	// forced: the request is unconditional and the file is rewritten
+	code, body = h.post("/control/filtering/refresh", `{"url":"`+url+`","force_full":true}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, `{"updated":1}`, body)
+	hdr := lastHeader()
+	assert.Equal(t, "no-cache", hdr.Get("Cache-Control"))
+	assert.Equal(t, "no-cache", hdr.Get("Pragma"))
+	assert.Equal(t, "", hdr.Get("If-Modified-Since"))
+	assert.Equal(t, "", hdr.Get("If-None-Match"))
+	st3, err := os.Stat(filt.Path())
+	assert.Nil(t, err)
+	assert.False(t, os.SameFile(st2, st3))
+	assert.Equal(t, []string{"engine 1/0"}, h.takeEvents())
+
+	hist := Context.filters.filterHistory(url)
+	if assert.Equal(t, 2, len(hist)) {
+		assert.False(t, hist[0].Forced)
+		assert.Equal(t, historyResultNotModified, hist[0].Result)
+		assert.True(t, hist[1].Forced)
+		assert.Equal(t, historyResultUpdated, hist[1].Result)
+		assert.Equal(t, 2, hist[1].NewRules)
+	}
+
+	// only a single filter may be re-downloaded
+	code, _ = h.post("/control/filtering/refresh", `{"force_full":true}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
@@ -0,0 +1,42 @@
+package home
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+// The response status line and headers of the last download attempt are kept in memory for each filter
+//  so that the odd behaviour of a server (a wrong charset, caching, an intermediate proxy) can be debugged.
+
+// The maximum size of the captured response headers
+const maxCapturedHeaders = 4 * 1024
+
+// The headers whose values are replaced: they may contain credentials
+var redactedHeaders = []string{"Set-Cookie", "Set-Cookie2", "Cookie", "Authorization", "Proxy-Authorization"}
+
+// Get the status line and the headers of the response as they were received.
+// The values of the sensitive headers are redacted, the result is not longer than maxCapturedHeaders.
+func captureResponseHeaders(resp *http.Response) string {
+	h := resp.Header.Clone()
+	for _, name := range redactedHeaders {
+		if _, ok := h[name]; ok {
+			h[name] = []string{"[redacted]"}
+		}
+	}
+
+	buf := bytes.Buffer{}
+	buf.WriteString(resp.Proto + " " + resp.Status + "\r\n")
+	_ = h.Write(&buf)
+	s := buf.String()
+	if len(s) <= maxCapturedHeaders {
+		return s
+	}
+
+	const suffix = "[truncated]\r\n"
+	s = s[:maxCapturedHeaders-len(suffix)]
+	if i := strings.LastIndex(s, "\r\n"); i >= 0 {
+		s = s[:i+2] // don't cut a header line
+	}
+	return s + suffix
+}
@@ -0,0 +1,91 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterResponseHeaders(t *testing.T) {
+	var state int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("X-Filter-Version", "42")
+		switch atomic.LoadInt32(&state) {
+		case 0:
+			_, _ = w.Write([]byte("||example.org^\n"))
+		case 1:
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.Header().Set("X-Large", strings.Repeat("a", 2*maxCapturedHeaders))
+			_, _ = w.Write([]byte("||example.org^\n"))
+		}
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	defer Context.dnsFilter.Close()
+	url := srv.URL + "/1.txt"
+	config.Filters = []filter{
+		{Enabled: true, URL: url, Filter: dnsfilter.Filter{ID: 1}},
+	}
+	defer func() { config.Filters = nil }()
+	assert.Nil(t, Context.filters.Init())
+
+	details := func() filterDetailsJSON {
+		w := httptest.NewRecorder()
+		Context.filters.handleFilteringGetFilter(w, httptest.NewRequest("GET", "/control/filtering/filter?url="+url, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		resp := filterDetailsJSON{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	assert.Nil(t, Context.filters.RefreshFilter(url))
+	h := details().ResponseHeaders
+	assert.True(t, strings.HasPrefix(h, "HTTP/1.1 200 OK\r\n"), h)
+	assert.True(t, strings.Contains(h, "X-Filter-Version: 42\r\n"), h)
+	assert.True(t, strings.Contains(h, "Set-Cookie: [redacted]\r\n"), h)
+	assert.False(t, strings.Contains(h, "secret"), h)
+
+	// the headers are shown in the verbose status only
+	w := httptest.NewRecorder()
+	Context.filters.handleFilteringStatus(w, httptest.NewRequest("GET", "/control/filtering/status?verbose=true", nil))
+	st := filteringConfig{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &st))
+	assert.Equal(t, h, st.Filters[0].ResponseHeaders)
+	w = httptest.NewRecorder()
+	Context.filters.handleFilteringStatus(w, httptest.NewRequest("GET", "/control/filtering/status", nil))
+	st = filteringConfig{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &st))
+	assert.Equal(t, "", st.Filters[0].ResponseHeaders)
+
+	// the failed download
+	atomic.StoreInt32(&state, 1)
+	assert.NotNil(t, Context.filters.RefreshFilter(url))
+	h = details().ResponseHeaders
+	assert.True(t, strings.HasPrefix(h, "HTTP/1.1 304 Not Modified\r\n"), h)
+
+	// the size is bounded
+	atomic.StoreInt32(&state, 2)
+	assert.Nil(t, Context.filters.RefreshFilter(url))
+	h = details().ResponseHeaders
+	assert.True(t, len(h) <= maxCapturedHeaders, len(h))
+	assert.True(t, strings.HasSuffix(h, "[truncated]\r\n"))
+	assert.True(t, strings.HasPrefix(h, "HTTP/1.1 200 OK\r\n"), h)
+}
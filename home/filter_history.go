@@ -0,0 +1,145 @@
+package home
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/AdguardTeam/golibs/file"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// The history of the filter updates.
+// The latest update attempts of every filter are kept so that a sudden change of a filter
+//  (e.g. a list that has lost all its rules after a bad upstream deploy) can be noticed.
+
+// File (under the filters directory) where we keep the update history
+const filterHistoryFile = "history.json"
+
+// The number of the update attempts we keep for each filter
+const maxFilterHistory = 50
+
+// The result of a filter update attempt
+const (
+	historyResultUpdated     = "updated"      // the filter data has changed
+	historyResultNotModified = "not_modified" // the filter data is the same
+	historyResultFailed      = "failed"       // the filter couldn't be downloaded
+)
+
+// filterHistoryEntry is a single update attempt of a filter
+type filterHistoryEntry struct {
+	url string // the filter URL, it's the key of the history
+
+	Time     time.Time `json:"time"`
+	OldRules int       `json:"old_rules"` // the number of rules before the update
+	NewRules int       `json:"new_rules"` // the number of rules after the update
+	Bytes    int64     `json:"bytes"`     // the number of bytes received
+	Result   string    `json:"result"`    // historyResult*
+	Error    string    `json:"error,omitempty"`
+
+	Timing *downloadTiming `json:"timing,omitempty"` // the timing of the download, it's not set for the local files and data: URLs
+	Forced bool            `json:"forced,omitempty"` // the data has been downloaded bypassing the caches and the file has been rewritten
+
+	Retries int `json:"retries,omitempty"` // the number of retries made during the download
+}
+
+func filterHistoryPath() string {
+	return filepath.Join(Context.getDataDir(), filterDir, filterHistoryFile)
+}
+
+// Load the update history from file
+func (f *Filtering) loadHistory() {
+	f.historyLock.Lock()
+	defer f.historyLock.Unlock()
+	f.history = map[string][]filterHistoryEntry{}
+
+	data, err := ioutil.ReadFile(filterHistoryPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error("filter: %s", err)
+		}
+		return
+	}
+
+	err = json.Unmarshal(data, &f.history)
+	if err != nil {
+		log.Error("filter: %s: %s", filterHistoryPath(), err)
+		f.history = map[string][]filterHistoryEntry{}
+	}
+}
+
+// Add the update attempts to the history and store it on disk
+func (f *Filtering) addHistory(entries []filterHistoryEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	f.historyLock.Lock()
+	defer f.historyLock.Unlock()
+
+	if f.history == nil {
+		f.history = map[string][]filterHistoryEntry{}
+	}
+	for _, e := range entries {
+		h := append(f.history[e.url], e)
+		if n := len(h); n > maxFilterHistory {
+			h = append([]filterHistoryEntry{}, h[n-maxFilterHistory:]...)
+		}
+		f.history[e.url] = h
+	}
+
+	data, err := json.Marshal(f.history)
+	if err != nil {
+		log.Error("filter: json encode: %s", err)
+		return
+	}
+	err = file.SafeWrite(filterHistoryPath(), data)
+	if err != nil {
+		log.Error("filter: %s", err)
+	}
+}
+
+// Get the update history of the filter, the latest attempt is the last one
+func (f *Filtering) filterHistory(url string) []filterHistoryEntry {
+	f.historyLock.Lock()
+	defer f.historyLock.Unlock()
+
+	return append([]filterHistoryEntry{}, f.history[url]...)
+}
+
+// Get the update history of a filter
+func (f *Filtering) handleFilterHistory(w http.ResponseWriter, r *http.Request) {
+	url := r.URL.Query().Get("url")
+	if len(url) == 0 {
+		f.httpErrorMsg(w, r, http.StatusBadRequest, msgURLRequired)
+		return
+	}
+	_, found := filterFind(url, false)
+	if !found {
+		_, found = filterFind(url, true)
+	}
+	if !found {
+		f.httpErrorMsg(w, r, http.StatusNotFound, msgFilterNotFound)
+		return
+	}
+
+	type Resp struct {
+		URL     string               `json:"url"`
+		History []filterHistoryEntry `json:"history"`
+	}
+	resp := Resp{
+		URL:     url,
+		History: f.filterHistory(url),
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
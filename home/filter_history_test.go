@@ -0,0 +1,99 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterHistory(t *testing.T) {
+	var state int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.LoadInt32(&state) {
+		case 0:
+			_, _ = w.Write([]byte("||example.org^\n||example.com^\n"))
+		case 1:
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			_, _ = w.Write([]byte("||example.org^\n"))
+		}
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	defer Context.dnsFilter.Close()
+	url := srv.URL + "/1.txt"
+	config.Filters = []filter{
+		{Enabled: true, URL: url, Filter: dnsfilter.Filter{ID: 1}},
+	}
+	defer func() { config.Filters = nil }()
+	assert.Nil(t, Context.filters.Init())
+
+	refresh := func() {
+		_, _ = Context.filters.refreshFilters(FilterRefreshBlocklists|FilterRefreshForce, true, updateTriggerManual)
+	}
+	refresh()
+	refresh()
+	atomic.StoreInt32(&state, 1)
+	refresh()
+	atomic.StoreInt32(&state, 2)
+	refresh()
+
+	h := Context.filters.filterHistory(url)
+	assert.Equal(t, 4, len(h))
+	assert.Equal(t, historyResultUpdated, h[0].Result)
+	assert.Equal(t, 0, h[0].OldRules)
+	assert.Equal(t, 2, h[0].NewRules)
+	assert.True(t, h[0].Bytes > 0)
+	assert.Equal(t, historyResultNotModified, h[1].Result)
+	assert.Equal(t, 2, h[1].NewRules)
+	assert.Equal(t, historyResultFailed, h[2].Result)
+	assert.Equal(t, 2, h[2].NewRules)
+	assert.NotEqual(t, "", h[2].Error)
+	assert.Equal(t, historyResultUpdated, h[3].Result)
+	assert.Equal(t, 2, h[3].OldRules)
+	assert.Equal(t, 1, h[3].NewRules)
+
+	// the history is kept after a restart
+	Context.filters.Close()
+	Context.filters = Filtering{}
+	assert.Nil(t, Context.filters.Init())
+	w := httptest.NewRecorder()
+	Context.filters.handleFilterHistory(w, httptest.NewRequest("GET", "/control/filtering/history?url="+url, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	resp := struct {
+		URL     string               `json:"url"`
+		History []filterHistoryEntry `json:"history"`
+	}{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, url, resp.URL)
+	assert.Equal(t, 4, len(resp.History))
+	assert.Equal(t, 1, resp.History[3].NewRules)
+
+	w = httptest.NewRecorder()
+	Context.filters.handleFilterHistory(w, httptest.NewRequest("GET", "/control/filtering/history?url="+srv.URL+"/2.txt", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// the history is capped
+	for i := 0; i < maxFilterHistory; i++ {
+		Context.filters.addHistory([]filterHistoryEntry{{url: url, NewRules: i, Result: historyResultUpdated}})
+	}
+	h = Context.filters.filterHistory(url)
+	assert.Equal(t, maxFilterHistory, len(h))
+	assert.Equal(t, 0, h[0].NewRules)
+	assert.Equal(t, maxFilterHistory-1, h[maxFilterHistory-1].NewRules)
+}
@@ -0,0 +1,185 @@
+package home
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// The post-update hook is an executable set by the administrator in the configuration file (filters_post_update_hook).
+// It's run in background after the filters have been updated and the filtering engine has been rebuilt.
+// The hook path can't be changed via HTTP API.
+
+// The maximum length of the hook output written to the log
+const postUpdateHookMaxOutput = 1024
+
+// postUpdateSummary is the change set passed to the hook as JSON on stdin
+type postUpdateSummary struct {
+	ListType string             `json:"list_type"` // "blocklist", "allowlist" or "all"
+	Updated  int                `json:"updated"`   // the number of updated filters
+	Rules    int                `json:"rules"`     // the total number of rules in the enabled filters of the updated lists
+	Filters  []postUpdateFilter `json:"filters"`   // the updated filters
+}
+
+type postUpdateFilter struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	Whitelist bool   `json:"whitelist"`
+	Rules     int    `json:"rules"`
+}
+
+// hookStatus is the state of the post-update hook
+type hookStatus struct {
+	Running  bool   `json:"running"`
+	LastRun  string `json:"last_run,omitempty"` // the start time of the last run (RFC3339)
+	Duration int64  `json:"duration_ms"`        // the duration of the last run
+	Error    string `json:"error,omitempty"`    // the error of the last run
+	Skipped  int    `json:"skipped"`            // the number of runs skipped because the previous run hadn't finished yet
+}
+
+// Build the change set of the update
+// blocklists, allowlists: the updated filters
+// Note: config must be locked
+func newPostUpdateSummary(blocklists, allowlists []filter) postUpdateSummary {
+	s := postUpdateSummary{
+		Updated: len(blocklists) + len(allowlists),
+		Filters: []postUpdateFilter{},
+	}
+	switch {
+	case len(blocklists) != 0 && len(allowlists) != 0:
+		s.ListType = "all"
+	case len(allowlists) != 0:
+		s.ListType = "allowlist"
+	default:
+		s.ListType = "blocklist"
+	}
+
+	for i, list := range [][]filter{blocklists, allowlists} {
+		for _, filt := range list {
+			s.Filters = append(s.Filters, postUpdateFilter{
+				ID:        filt.ID,
+				Name:      filt.Name,
+				URL:       filt.URL,
+				Whitelist: i == 1,
+				Rules:     filt.RulesCount,
+			})
+		}
+		if len(list) == 0 {
+			continue
+		}
+		all := config.Filters
+		if i == 1 {
+			all = config.WhitelistFilters
+		}
+		for _, filt := range all {
+			if filt.Enabled {
+				s.Rules += filt.RulesCount
+			}
+		}
+	}
+	return s
+}
+
+// Get the state of the post-update hook
+// Return nil if the hook isn't configured
+func (f *Filtering) postUpdateHookStatus() *hookStatus {
+	config.RLock()
+	path := config.DNS.FiltersPostUpdateHook
+	config.RUnlock()
+	if len(path) == 0 {
+		return nil
+	}
+
+	f.hookLock.Lock()
+	defer f.hookLock.Unlock()
+	st := f.hook
+	return &st
+}
+
+// Start the post-update hook in background
+// The run is skipped if the previous one hasn't finished yet.
+func (f *Filtering) runPostUpdateHook(s postUpdateSummary) {
+	config.RLock()
+	path := config.DNS.FiltersPostUpdateHook
+	timeout := time.Duration(config.DNS.FiltersHookTimeout) * time.Second
+	config.RUnlock()
+	if len(path) == 0 {
+		return
+	}
+
+	f.hookLock.Lock()
+	if f.hook.Running {
+		f.hook.Skipped++
+		f.hookLock.Unlock()
+		log.Info("filter: post-update hook: the previous run hasn't finished yet, skipped")
+		return
+	}
+	f.hook.Running = true
+	f.hookLock.Unlock()
+
+	go f.execPostUpdateHook(path, timeout, s)
+}
+
+func (f *Filtering) execPostUpdateHook(path string, timeout time.Duration, s postUpdateSummary) {
+	start := time.Now()
+	err := execPostUpdateHook(f.context(), path, timeout, s)
+	if err != nil {
+		log.Error("filter: post-update hook: %s: %s", path, err)
+	} else {
+		log.Debug("filter: post-update hook: %s: finished in %s", path, time.Since(start))
+	}
+
+	f.hookLock.Lock()
+	f.hook.Running = false
+	f.hook.LastRun = start.Format(time.RFC3339)
+	f.hook.Duration = time.Since(start).Milliseconds()
+	f.hook.Error = ""
+	if err != nil {
+		f.hook.Error = err.Error()
+	}
+	st := f.hook
+	fn := f.hookDone
+	f.hookLock.Unlock()
+
+	if fn != nil {
+		fn(st)
+	}
+}
+
+// Run the hook and wait until it exits
+// The change set is passed in the environment variables and as JSON on stdin.
+func execPostUpdateHook(parent context.Context, path string, timeout time.Duration, s postUpdateSummary) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Env = append(os.Environ(),
+		"AGH_FILTERS_LIST_TYPE="+s.ListType,
+		"AGH_FILTERS_UPDATED="+strconv.Itoa(s.Updated),
+		"AGH_FILTERS_RULES="+strconv.Itoa(s.Rules),
+	)
+	cmd.Stdin = bytes.NewReader(data)
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		if len(out) > postUpdateHookMaxOutput {
+			out = out[:postUpdateHookMaxOutput]
+		}
+		return fmt.Errorf("%s: %q", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package home
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The hook script records its environment and the input
+const testHookScript = `#!/bin/sh
+echo "$AGH_FILTERS_LIST_TYPE $AGH_FILTERS_UPDATED $AGH_FILTERS_RULES" >> "$0.env"
+cat > "$0.json"
+`
+
+func writeTestHook(t *testing.T, dir, name, script string) string {
+	fn, err := filepath.Abs(filepath.Join(dir, name))
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(fn, []byte(script), 0755))
+	return fn
+}
+
+// Wait until the post-update hook run has finished
+func waitTestHook(t *testing.T, done chan hookStatus) hookStatus {
+	select {
+	case st := <-done:
+		return st
+	case <-time.After(5 * time.Second):
+		t.Fatal("the post-update hook hasn't finished")
+	}
+	return hookStatus{}
+}
+
+func TestPostUpdateHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook is a shell script")
+	}
+	fs := newFixtureServer()
+	defer fs.Close()
+	h, cleanup := newFiltersHarness(t)
+	defer cleanup()
+	defer func() { config.DNS.FiltersPostUpdateHook = "" }()
+
+	done := make(chan hookStatus, 1)
+	Context.filters.hookDone = func(st hookStatus) { done <- st }
+	hook := writeTestHook(t, Context.workDir, "hook.sh", testHookScript)
+
+	fs.set("/1.txt", fixtureResponse{body: fixtureRules("a", 2)})
+	fs.set("/2.txt", fixtureResponse{body: fixtureRules("b", 3)})
+	code, body := h.post("/control/filtering/add_url", `{"name":"1","url":"`+fs.URL+`/1.txt"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	code, body = h.post("/control/filtering/add_url", `{"name":"2","url":"`+fs.URL+`/2.txt"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+
+	// the hook isn't configured
+	_, body = h.get("/control/filtering/status")
+	assert.False(t, strings.Contains(body, "post_update_hook"))
+
+	// the hook path can't be set via HTTP API
+	code, body = h.post("/control/filtering/config", `{"enabled":true,"interval":24,"filters_post_update_hook":"`+hook+`"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, "", config.DNS.FiltersPostUpdateHook)
+
+	config.DNS.FiltersPostUpdateHook = hook
+	fs.set("/1.txt", fixtureResponse{body: fixtureRules("c", 4)})
+	code, body = h.post("/control/filtering/refresh", `{"whitelist":false}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	st := waitTestHook(t, done)
+	assert.Equal(t, "", st.Error)
+	assert.False(t, st.Running)
+
+	env, err := ioutil.ReadFile(hook + ".env")
+	assert.Nil(t, err)
+	assert.Equal(t, "blocklist 1 7\n", string(env))
+	data, err := ioutil.ReadFile(hook + ".json")
+	assert.Nil(t, err)
+	s := postUpdateSummary{}
+	assert.Nil(t, json.Unmarshal(data, &s))
+	assert.Equal(t, "blocklist", s.ListType)
+	assert.Equal(t, 1, s.Updated)
+	assert.Equal(t, 7, s.Rules)
+	assert.Equal(t, 1, len(s.Filters))
+	assert.Equal(t, "1", s.Filters[0].Name)
+	assert.Equal(t, 4, s.Filters[0].Rules)
+	assert.False(t, s.Filters[0].Whitelist)
+
+	// the hook isn't run if nothing has been updated
+	code, body = h.post("/control/filtering/refresh", `{"whitelist":false}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	select {
+	case <-done:
+		t.Fatal("the post-update hook has been run")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the failure is visible in status, the filters are still updated
+	config.DNS.FiltersPostUpdateHook = writeTestHook(t, Context.workDir, "fail.sh", "#!/bin/sh\necho failed\nexit 3\n")
+	fs.set("/2.txt", fixtureResponse{body: fixtureRules("d", 1)})
+	code, body = h.post("/control/filtering/refresh", `{"whitelist":false}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	st = waitTestHook(t, done)
+	assert.True(t, strings.Contains(st.Error, "exit status 3"), st.Error)
+	assert.True(t, strings.Contains(st.Error, "failed"), st.Error)
+	assert.Equal(t, 1, config.Filters[1].RulesCount)
+
+	_, body = h.get("/control/filtering/status")
+	resp := filteringConfig{}
+	assert.Nil(t, json.Unmarshal([]byte(body), &resp))
+	if assert.NotNil(t, resp.PostUpdateHook) {
+		assert.Equal(t, st.Error, resp.PostUpdateHook.Error)
+	}
+}
+
+func TestPostUpdateHookTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook is a shell script")
+	}
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	hook := writeTestHook(t, dir, "sleep.sh", "#!/bin/sh\nexec sleep 10\n")
+
+	start := time.Now()
+	err := execPostUpdateHook(Context.filters.context(), hook, 100*time.Millisecond, postUpdateSummary{})
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "timed out"), err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
@@ -0,0 +1,100 @@
+package home
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+const filterIDsTestConfig = `filters:
+- enabled: true
+  url: https://example.org/1.txt
+  name: "1"
+  id: 10
+- enabled: false
+  url: https://example.org/2.txt
+  name: "2"
+- enabled: true
+  url: https://example.org/3.txt
+  name: "3"
+  id: 10
+- enabled: true
+  url: https://example.org/1.txt
+  name: duplicate
+  id: 20
+whitelist_filters:
+- enabled: true
+  url: https://example.org/4.txt
+  name: "4"
+  id: 3
+`
+
+// Load the configuration file and initialize the filters
+func loadFilterIDsTestConfig(t *testing.T) {
+	config.Filters = nil
+	config.WhitelistFilters = nil
+	config.DeletedFilters = nil
+	assert.Nil(t, parseConfig())
+	Context.filters = Filtering{}
+	assert.Nil(t, Context.filters.Init())
+	Context.filters.Close()
+}
+
+func filterIDs() []int64 {
+	var ids []int64
+	for _, list := range [][]filter{config.Filters, config.WhitelistFilters} {
+		for _, filt := range list {
+			ids = append(ids, filt.ID)
+		}
+	}
+	return ids
+}
+
+func TestFilterIDsRoundTrip(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.configFilename = "AdGuardHome.yaml"
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	defer Context.dnsFilter.Close()
+	defer func() {
+		config.Filters = nil
+		config.WhitelistFilters = nil
+		config.DeletedFilters = nil
+	}()
+	confPath := config.getConfigFilename()
+	assert.Nil(t, ioutil.WriteFile(confPath, []byte(filterIDsTestConfig), 0644))
+
+	// the duplicate URL is removed, the missing and the duplicate IDs follow the largest one
+	loadFilterIDsTestConfig(t)
+	assert.Equal(t, []int64{10, 11, 12, 3}, filterIDs())
+
+	// the same IDs are assigned if the configuration hasn't been written
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "data", filterDir, "12.txt"), []byte("||example.org^\n"), 0644))
+	loadFilterIDsTestConfig(t)
+	assert.Equal(t, []int64{10, 11, 12, 3}, filterIDs())
+	assert.Equal(t, 1, config.Filters[2].RulesCount)
+
+	// write and reload: the configuration is the same byte for byte
+	assert.Nil(t, config.write())
+	data1, err := ioutil.ReadFile(confPath)
+	assert.Nil(t, err)
+	loadFilterIDsTestConfig(t)
+	assert.Equal(t, []int64{10, 11, 12, 3}, filterIDs())
+	assert.Equal(t, []string{"1", "2", "3", "4"}, []string{
+		config.Filters[0].Name, config.Filters[1].Name, config.Filters[2].Name, config.WhitelistFilters[0].Name,
+	})
+	assert.Nil(t, config.write())
+	data2, err := ioutil.ReadFile(confPath)
+	assert.Nil(t, err)
+	assert.Equal(t, string(data1), string(data2))
+
+	// the file of the filter is kept
+	_, err = os.Stat(filepath.Join(dir, "data", filterDir, "12.txt"))
+	assert.Nil(t, err)
+}
@@ -0,0 +1,224 @@
+package home
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+const (
+	includeDirective = "!#include"
+	maxIncludeDepth  = 3                // the maximum level of nested includes
+	maxIncludeSize   = 16 * 1024 * 1024 // the maximum total size of the included data (in bytes)
+)
+
+// Return TRUE if the filter data contains "!#include" directives
+func hasIncludes(r io.Reader) bool {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if strings.HasPrefix(strings.TrimSpace(line), includeDirective) {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// Get the URL the includes of the filter are resolved against:
+//  the filter URL, or file: URL of the local filter (the includes are relative to its directory)
+func includeBase(filterURL string) (*url.URL, string) {
+	if isLocalFilterURL(filterURL) {
+		path, err := localFilterPath(filterURL)
+		if err != nil {
+			return nil, ""
+		}
+		return &url.URL{Scheme: "file", Path: filepath.ToSlash(path)}, filepath.Dir(path)
+	}
+
+	base, err := url.Parse(filterURL)
+	if err != nil || len(base.Host) == 0 {
+		return nil, ""
+	}
+	return base, ""
+}
+
+// Replace "!#include URL" directives in the downloaded filter with the contents of the included files.
+// Only the files from the same host (or from the directory of a local filter) are included.
+// The directives that can't be processed are left as is (they're treated as comments),
+//  but an include cycle and too much included data are errors.
+// Return the new file (or nil if there are no directives) and the list of warnings.
+func inlineIncludes(ctx context.Context, file *os.File, filterURL string, trusted bool) (*os.File, []string, error) {
+	base, dir := includeBase(filterURL)
+	if base == nil {
+		return nil, nil, nil
+	}
+
+	_, err := file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !hasIncludes(file) {
+		return nil, nil, nil
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out, err := ioutil.TempFile(filepath.Dir(file.Name()), "")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if out != nil {
+			_ = out.Close()
+			_ = os.Remove(out.Name())
+		}
+	}()
+
+	inc := includer{
+		visited: map[string]bool{base.String(): true},
+		trusted: trusted,
+		dir:     dir,
+		ctx:     ctx,
+	}
+	w := bufio.NewWriter(out)
+	err = inc.process(w, file, base, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = w.Flush()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	log.Debug("filter: %s: resolved %d includes (%d bytes)", filterURL, inc.count, inc.size)
+	res := out
+	out = nil
+	return res, inc.warnings, nil
+}
+
+type includer struct {
+	visited  map[string]bool // URLs included in the current chain, used to detect cycles
+	warnings []string
+	trusted  bool            // the included files are downloaded for a trusted filter
+	dir      string          // the directory of a local filter: only the files inside it are included
+	ctx      context.Context // the downloads are cancelled with this context
+	count    int             // the number of the resolved includes
+	size     int             // the total size of the included data
+}
+
+// Copy the filter data to w, replacing the include directives with the included data
+func (inc *includer) process(w *bufio.Writer, r io.Reader, base *url.URL, depth int) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		s := strings.TrimSpace(line)
+		if strings.HasPrefix(s, includeDirective) {
+			included, ierr := inc.include(w, base, strings.TrimSpace(s[len(includeDirective):]), depth)
+			if ierr != nil {
+				return ierr
+			}
+			if included {
+				line = ""
+			}
+		}
+
+		if len(line) != 0 {
+			if !strings.HasSuffix(line, "\n") {
+				line += "\n"
+			}
+			if depth != 0 {
+				inc.size += len(line)
+				if inc.size > maxIncludeSize {
+					return fmt.Errorf("include: the included data exceeds %d bytes", maxIncludeSize)
+				}
+			}
+			_, werr := w.WriteString(line)
+			if werr != nil {
+				return werr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Open the included file: download it or open the local file
+func (inc *includer) open(u *url.URL) (io.ReadCloser, error) {
+	if u.Scheme == "file" {
+		f, err := os.Open(filepath.FromSlash(u.Path))
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+
+	resp, err := filterGet(inc.ctx, u.String(), inc.trusted)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("got status code %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// Download the included file and write its data to w
+// Return FALSE if the directive has been skipped
+func (inc *includer) include(w *bufio.Writer, base *url.URL, target string, depth int) (bool, error) {
+	ref, err := url.Parse(target)
+	if err != nil || len(target) == 0 {
+		inc.warnings = append(inc.warnings, fmt.Sprintf("include: invalid URL: %q", target))
+		return false, nil
+	}
+	u := base.ResolveReference(ref)
+	if u.Scheme != base.Scheme || u.Host != base.Host {
+		inc.warnings = append(inc.warnings, fmt.Sprintf("include: %s: only the files from the same host are allowed", u))
+		return false, nil
+	}
+	if u.Scheme == "file" && !isPathInDir(filepath.FromSlash(u.Path), inc.dir) {
+		inc.warnings = append(inc.warnings, fmt.Sprintf("include: %s: only the files from the directory of the filter are allowed", u.Path))
+		return false, nil
+	}
+	if depth+1 > maxIncludeDepth {
+		inc.warnings = append(inc.warnings, fmt.Sprintf("include: %s: too many nested includes", u))
+		return false, nil
+	}
+	if inc.visited[u.String()] {
+		return false, fmt.Errorf("include: %s: include cycle", u)
+	}
+
+	body, err := inc.open(u)
+	if err != nil {
+		inc.warnings = append(inc.warnings, fmt.Sprintf("include: %s: %s", u, err))
+		return false, nil
+	}
+	defer body.Close()
+
+	inc.visited[u.String()] = true
+	err = inc.process(w, body, u, depth+1)
+	delete(inc.visited, u.String())
+	if err != nil {
+		return false, err
+	}
+	inc.count++
+	return true, nil
+}
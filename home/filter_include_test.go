@@ -0,0 +1,94 @@
+package home
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterIncludes(t *testing.T) {
+	files := map[string]string{
+		"/main.txt":     "! Title: main\n||main.org^\n!#include sub/a.txt\n!#include https://other.example/x.txt\n!#include missing.txt\n",
+		"/sub/a.txt":    "||a.org^\n!#include b.txt",
+		"/sub/b.txt":    "||b.org^\n!#include c.txt\n",
+		"/sub/c.txt":    "||c.org^\n!#include d.txt\n",
+		"/sub/d.txt":    "||d.org^\n",
+		"/cycle.txt":    "||cycle.org^\n!#include sub/loop.txt\n",
+		"/sub/loop.txt": "!#include ../cycle.txt\n",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(data))
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	Context.filters.Init()
+
+	f := filter{
+		URL: srv.URL + "/main.txt",
+	}
+	ok, err := Context.filters.update(context.Background(), &f)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 4, f.RulesCount)
+	assert.Equal(t, "main", f.Name)
+	assert.Equal(t, 3, len(f.warnings))
+	assert.Contains(t, f.warnings[0], "too many nested includes")
+	assert.Contains(t, f.warnings[1], "only the files from the same host are allowed")
+	assert.Contains(t, f.warnings[2], "got status code 404")
+
+	data, err := ioutil.ReadFile(f.Path())
+	assert.Nil(t, err)
+	assert.Equal(t, "! Title: main\n||main.org^\n||a.org^\n||b.org^\n||c.org^\n!#include d.txt\n"+
+		"!#include https://other.example/x.txt\n!#include missing.txt\n", string(data))
+
+	// an include cycle is an error
+	f = filter{
+		URL: srv.URL + "/cycle.txt",
+	}
+	_, err = Context.filters.update(context.Background(), &f)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "include cycle")
+}
+
+func TestFilterIncludesLocal(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.filters.Init()
+
+	rulesDir := filepath.Join(Context.getDataDir(), "rules")
+	assert.Nil(t, os.MkdirAll(filepath.Join(rulesDir, "sub"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(rulesDir, "main.txt"), []byte("||main.org^\n!#include sub/part.txt\n!#include ../../outside.txt\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(rulesDir, "sub", "part.txt"), []byte("||part.org^\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "outside.txt"), []byte("||outside.org^\n"), 0644))
+
+	f := filter{
+		URL: "./rules/main.txt",
+	}
+	ok, err := Context.filters.update(context.Background(), &f)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 2, f.RulesCount)
+	assert.Equal(t, 1, len(f.warnings))
+	assert.Contains(t, f.warnings[0], "only the files from the directory of the filter are allowed")
+}
@@ -0,0 +1,410 @@
+package home
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// End-to-end tests of the filtering module: the HTTP handlers are registered in a test web server,
+// the filters are downloaded from a local fixture server,
+// the configuration file, the filter files and the filtering engine are checked after every step.
+
+// fixtureResponse is the response of the fixture server for a path
+type fixtureResponse struct {
+	status int
+	body   string
+	delay  time.Duration // the response is sent after this delay (or when the client goes away)
+}
+
+// fixtureServer serves filter lists with the controllable responses
+type fixtureServer struct {
+	*httptest.Server
+	lock      sync.Mutex
+	responses map[string]fixtureResponse
+	hits      map[string]int
+}
+
+func newFixtureServer() *fixtureServer {
+	fs := &fixtureServer{
+		responses: map[string]fixtureResponse{},
+		hits:      map[string]int{},
+	}
+	fs.Server = httptest.NewServer(http.HandlerFunc(fs.serve))
+	return fs
+}
+
+func (fs *fixtureServer) serve(w http.ResponseWriter, r *http.Request) {
+	fs.lock.Lock()
+	resp, ok := fs.responses[r.URL.Path]
+	fs.hits[r.URL.Path]++
+	fs.lock.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if resp.delay != 0 {
+		select {
+		case <-time.After(resp.delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if resp.status == http.StatusTooManyRequests {
+		w.Header().Set("Retry-After", "3600")
+	}
+	if resp.status != 0 {
+		w.WriteHeader(resp.status)
+	}
+	_, _ = w.Write([]byte(resp.body))
+}
+
+// Set the response for the path
+func (fs *fixtureServer) set(path string, resp fixtureResponse) {
+	fs.lock.Lock()
+	fs.responses[path] = resp
+	fs.lock.Unlock()
+}
+
+// Get the number of requests for the path
+func (fs *fixtureServer) hitCount(path string) int {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	return fs.hits[path]
+}
+
+// fakeClock is the time source of the filtering module in tests
+type fakeClock struct {
+	lock sync.Mutex
+	t    time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) add(d time.Duration) {
+	c.lock.Lock()
+	c.t = c.t.Add(d)
+	c.lock.Unlock()
+}
+
+// filtersHarness is the filtering module wired as in the application
+type filtersHarness struct {
+	t      *testing.T
+	web    *httptest.Server // the web server with the filtering handlers
+	clock  *fakeClock
+	lock   sync.Mutex
+	events []string // configuration writes and filtering engine rebuilds
+}
+
+// Set up the filtering module in a test directory.
+// Return the harness and the function that restores the global state.
+func newFiltersHarness(t *testing.T) (*filtersHarness, func()) {
+	h := &filtersHarness{
+		t:     t,
+		clock: &fakeClock{t: time.Now()},
+	}
+
+	dir := prepareTestDir()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.configFilename = "AdGuardHome.yaml"
+	Context.client = &http.Client{
+		Timeout: 1 * time.Second,
+	}
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	Context.dnsFilter.Start()
+	config.Filters = nil
+	config.WhitelistFilters = nil
+	config.UserRuleGroups = nil
+	config.DeletedFilters = nil
+	config.DNS.FilteringEnabled = true
+	config.DNS.FiltersUpdateIntervalHours = 24
+	Context.filters.Init()
+	Context.filters.now = h.clock.now
+
+	Context.configModifiedHook = func() { h.event("config") }
+	Context.filters.engineRebuilt = func(filters, whiteFilters []dnsfilter.Filter) {
+		h.event(fmt.Sprintf("engine %d/%d", len(filters), len(whiteFilters)))
+	}
+
+	mux := http.NewServeMux()
+	Context.filters.registerHandlers(func(method, url string, handler func(http.ResponseWriter, *http.Request)) {
+		mux.HandleFunc(url, ensure(method, handler))
+	})
+	h.web = httptest.NewServer(mux)
+
+	return h, func() {
+		h.web.Close()
+		Context.dnsFilter.Close()
+		config.Filters = nil
+		config.WhitelistFilters = nil
+		config.UserRuleGroups = nil
+		config.DeletedFilters = nil
+		config.DNS.FiltersUpdateIntervalHours = 24
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func (h *filtersHarness) event(e string) {
+	h.lock.Lock()
+	h.events = append(h.events, e)
+	h.lock.Unlock()
+}
+
+// Get the events recorded since the last call
+func (h *filtersHarness) takeEvents() []string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	events := h.events
+	h.events = nil
+	return events
+}
+
+func (h *filtersHarness) do(method, path, body string) (int, string) {
+	req, err := http.NewRequest(method, h.web.URL+path, strings.NewReader(body))
+	assert.Nil(h.t, err)
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.Nil(h.t, err) {
+		return 0, ""
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(data)
+}
+
+func (h *filtersHarness) post(path, body string) (int, string) {
+	return h.do("POST", path, body)
+}
+
+func (h *filtersHarness) get(path string) (int, string) {
+	return h.do("GET", path, "")
+}
+
+// Read the configuration file
+func (h *filtersHarness) conf() *configuration {
+	conf := &configuration{}
+	data, err := ioutil.ReadFile(config.getConfigFilename())
+	assert.Nil(h.t, err)
+	assert.Nil(h.t, yaml.Unmarshal(data, conf))
+	return conf
+}
+
+// Get the names of the filter files
+func (h *filtersHarness) files() []string {
+	names, _ := filepath.Glob(filepath.Join(Context.getDataDir(), filterDir, "*.txt*"))
+	for i := range names {
+		names[i] = filepath.Base(names[i])
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Wait until the filtering engine has the specified number of rules
+func (h *filtersHarness) assertEngineRules(n int) {
+	assert.Eventually(h.t, func() bool {
+		return Context.dnsFilter.GetEngineStatus().RulesCount == n
+	}, 5*time.Second, 10*time.Millisecond, "expected %d rules", n)
+}
+
+// Generate a filter list
+func fixtureRules(prefix string, n int) string {
+	sb := strings.Builder{}
+	sb.WriteString("! Title: " + prefix + "\n")
+	for i := 0; i < n; i++ {
+		sb.WriteString(fmt.Sprintf("||%s%d.example^\n", prefix, i))
+	}
+	return sb.String()
+}
+
+func TestFilteringIntegration(t *testing.T) {
+	fs := newFixtureServer()
+	defer fs.Close()
+	h, cleanup := newFiltersHarness(t)
+	defer cleanup()
+
+	type statusJSON struct {
+		Interval  uint32       `json:"interval"`
+		Filters   []filterJSON `json:"filters"`
+		UserRules []string     `json:"user_rules"`
+	}
+	status := func() statusJSON {
+		code, body := h.get("/control/filtering/status")
+		assert.Equal(t, http.StatusOK, code)
+		st := statusJSON{}
+		assert.Nil(t, json.Unmarshal([]byte(body), &st), body)
+		return st
+	}
+
+	// add
+	fs.set("/list.txt", fixtureResponse{body: fixtureRules("a", 2)})
+	code, body := h.post("/control/filtering/add_url", `{"name":"List","url":"`+fs.URL+`/list.txt"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, "OK 2 rules\n", body)
+	assert.Equal(t, []string{"config", "engine 1/0"}, h.takeEvents())
+	conf := h.conf()
+	assert.Equal(t, 1, len(conf.Filters))
+	assert.Equal(t, fs.URL+"/list.txt", conf.Filters[0].URL)
+	assert.True(t, conf.Filters[0].Enabled)
+	id := conf.Filters[0].ID
+	assert.Equal(t, []string{fmt.Sprintf("%d.txt", id)}, h.files())
+	h.assertEngineRules(2)
+
+	// status
+	st := status()
+	assert.Equal(t, 1, len(st.Filters))
+	assert.Equal(t, id, st.Filters[0].ID)
+	assert.Equal(t, "List", st.Filters[0].Name)
+	assert.Equal(t, uint32(2), st.Filters[0].RulesCount)
+	assert.Equal(t, 0, len(h.takeEvents()))
+
+	// modify URL: the filter gets a new ID and a new file
+	fs.set("/list2.txt", fixtureResponse{body: fixtureRules("b", 3)})
+	code, body = h.post("/control/filtering/set_url",
+		`{"url":"`+fs.URL+`/list.txt","data":{"name":"List 2","url":"`+fs.URL+`/list2.txt","enabled":true}}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, []string{"config", "engine 1/0"}, h.takeEvents())
+	conf = h.conf()
+	assert.Equal(t, 1, len(conf.Filters))
+	assert.Equal(t, fs.URL+"/list2.txt", conf.Filters[0].URL)
+	assert.Equal(t, "List 2", conf.Filters[0].Name)
+	assert.NotEqual(t, id, conf.Filters[0].ID)
+	id = conf.Filters[0].ID
+	assert.Equal(t, []string{fmt.Sprintf("%d.txt", id)}, h.files())
+	h.assertEngineRules(3)
+
+	// refresh: the new data is stored, the configuration isn't written
+	fs.set("/list2.txt", fixtureResponse{body: fixtureRules("b", 4)})
+	code, body = h.post("/control/filtering/refresh", `{"whitelist":false}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, `{"updated":1}`, body)
+	assert.Equal(t, []string{"engine 1/0"}, h.takeEvents())
+	data, err := ioutil.ReadFile(filepath.Join(Context.getDataDir(), filterDir, fmt.Sprintf("%d.txt", id)))
+	assert.Nil(t, err)
+	assert.Equal(t, fixtureRules("b", 4), string(data))
+	assert.Equal(t, uint32(4), status().Filters[0].RulesCount)
+	h.assertEngineRules(4)
+
+	// delete: the filter is moved to the trash
+	code, body = h.post("/control/filtering/remove_url", `{"url":"`+fs.URL+`/list2.txt"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, []string{"config", "engine 0/0"}, h.takeEvents())
+	conf = h.conf()
+	assert.Equal(t, 0, len(conf.Filters))
+	assert.Equal(t, 1, len(conf.DeletedFilters))
+	assert.Equal(t, id, conf.DeletedFilters[0].ID)
+	assert.Equal(t, 0, len(h.files()))
+	assert.True(t, util.FileExists(conf.DeletedFilters[0].trashPath()))
+	h.assertEngineRules(0)
+
+	// restore
+	code, body = h.post("/control/filtering/restore_url", `{"url":"`+fs.URL+`/list2.txt"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, "OK 4 rules\n", body)
+	assert.Equal(t, []string{"config", "engine 1/0"}, h.takeEvents())
+	conf = h.conf()
+	assert.Equal(t, 1, len(conf.Filters))
+	assert.Equal(t, id, conf.Filters[0].ID)
+	assert.Equal(t, "List 2", conf.Filters[0].Name)
+	assert.Equal(t, 0, len(conf.DeletedFilters))
+	assert.Equal(t, []string{fmt.Sprintf("%d.txt", id)}, h.files())
+	h.assertEngineRules(4)
+
+	// delete it again
+	code, body = h.post("/control/filtering/remove_url", `{"url":"`+fs.URL+`/list2.txt"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, []string{"config", "engine 0/0"}, h.takeEvents())
+	code, _ = h.post("/control/filtering/restore_url", `{"url":"`+fs.URL+`/list2.txt","whitelist":true}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, 0, len(h.takeEvents()))
+	h.assertEngineRules(0)
+
+	// bulk add: only the valid filter lists are added
+	fs.set("/ok.txt", fixtureResponse{body: fixtureRules("c", 1)})
+	fs.set("/huge.txt", fixtureResponse{body: fixtureRules("d", 20000)})
+	fs.set("/notmod.txt", fixtureResponse{status: http.StatusNotModified})
+	fs.set("/limited.txt", fixtureResponse{status: http.StatusTooManyRequests, body: "slow down"})
+	fs.set("/page.txt", fixtureResponse{body: "<!DOCTYPE html><html><body>Not a filter</body></html>"})
+	fs.set("/slow.txt", fixtureResponse{body: fixtureRules("e", 1), delay: 3 * time.Second})
+	var req []string
+	for _, p := range []string{"/ok.txt", "/huge.txt", "/notmod.txt", "/limited.txt", "/page.txt", "/slow.txt"} {
+		req = append(req, `{"name":"`+p+`","url":"`+fs.URL+p+`"}`)
+	}
+	code, body = h.post("/control/filtering/add_urls", "["+strings.Join(req, ",")+"]")
+	assert.Equal(t, http.StatusOK, code, body)
+	var results []filterAddResultJSON
+	assert.Nil(t, json.Unmarshal([]byte(body), &results), body)
+	assert.Equal(t, 6, len(results))
+	assert.Equal(t, "", results[0].Error)
+	assert.Equal(t, 1, results[0].RulesCount)
+	assert.Equal(t, "", results[1].Error)
+	assert.Equal(t, 20000, results[1].RulesCount)
+	assert.True(t, strings.Contains(results[2].Error, "304"), results[2].Error)
+	assert.True(t, strings.Contains(results[3].Error, "429"), results[3].Error)
+	assert.True(t, strings.Contains(results[4].Error, "HTML"), results[4].Error)
+	assert.NotEqual(t, "", results[5].Error)
+	assert.Equal(t, []string{"config", "engine 2/0"}, h.takeEvents())
+	conf = h.conf()
+	assert.Equal(t, 2, len(conf.Filters))
+	assert.Equal(t, fs.URL+"/ok.txt", conf.Filters[0].URL)
+	assert.Equal(t, fs.URL+"/huge.txt", conf.Filters[1].URL)
+	assert.Equal(t, sortedStrings([]string{
+		fmt.Sprintf("%d.txt", conf.Filters[0].ID),
+		fmt.Sprintf("%d.txt", conf.Filters[1].ID),
+	}), h.files())
+	h.assertEngineRules(20001)
+
+	// config interval change: the filters are downloaded again when the new interval has passed
+	code, body = h.post("/control/filtering/config", `{"enabled":true,"interval":1}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, []string{"config", "engine 2/0"}, h.takeEvents())
+	assert.Equal(t, uint32(1), h.conf().DNS.FiltersUpdateIntervalHours)
+	assert.Equal(t, uint32(1), status().Interval)
+
+	hits := fs.hitCount("/ok.txt")
+	h.clock.add(30 * time.Minute)
+	_, _ = Context.filters.refreshFiltersIfNecessary(context.Background(), FilterRefreshBlocklists, updateTriggerTimer, 0)
+	assert.Equal(t, hits, fs.hitCount("/ok.txt"))
+
+	h.clock.add(time.Hour)
+	fs.set("/ok.txt", fixtureResponse{body: fixtureRules("c", 2)})
+	n, _ := Context.filters.refreshFiltersIfNecessary(context.Background(), FilterRefreshBlocklists, updateTriggerTimer, 0)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, hits+1, fs.hitCount("/ok.txt"))
+	assert.Equal(t, []string{"engine 2/0"}, h.takeEvents())
+	h.assertEngineRules(20002)
+
+	// user rules
+	code, body = h.post("/control/filtering/set_rules", "||user1.example^\n||user2.example^")
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, []string{"config", "engine 3/0"}, h.takeEvents())
+	conf = h.conf()
+	assert.Equal(t, 1, len(conf.UserRuleGroups))
+	assert.Equal(t, []string{"||user1.example^", "||user2.example^"}, conf.UserRuleGroups[0].Rules)
+	assert.Equal(t, []string{"||user1.example^", "||user2.example^"}, status().UserRules)
+	h.assertEngineRules(20004)
+}
+
+func sortedStrings(a []string) []string {
+	sort.Strings(a)
+	return a
+}
@@ -0,0 +1,77 @@
+package home
+
+import (
+	"strings"
+)
+
+// The kind of a filter list: a blocklist or an allowlist.
+// A list may declare its intended usage in the header ("! Kind: allowlist"),
+//  otherwise the kind is detected from the rules: a hosts file is a blocklist,
+//  a list of the exception rules ("@@||example.org^") is an allowlist.
+// The lists of the plain rules ("||example.org^") are used both ways: their kind is unknown.
+// A list that is added as the other kind is most likely a mistake,
+//  e.g. a blocklist URL has been pasted into the allowlist box.
+
+// The kinds of filter lists
+const (
+	filterKindBlocklist = "blocklist"
+	filterKindAllowlist = "allowlist"
+)
+
+// The header directive that declares the kind of the list
+const filterKindDirective = "kind:"
+
+// Get the kind declared in the header line ("! Kind: allowlist").
+// Return "" if the line isn't a kind directive or the kind is unknown.
+func parseFilterKindDirective(line string) string {
+	line = strings.TrimSpace(strings.TrimPrefix(line, "!"))
+	if !strings.HasPrefix(strings.ToLower(line), filterKindDirective) {
+		return ""
+	}
+	switch strings.ToLower(strings.TrimSpace(line[len(filterKindDirective):])) {
+	case "blocklist", "blacklist", "block":
+		return filterKindBlocklist
+	case "allowlist", "whitelist", "allow":
+		return filterKindAllowlist
+	}
+	return ""
+}
+
+// Get the kind of the list by its rules, "" if it's unknown
+func (st ruleStats) detectedKind() string {
+	rules := st.Basic + st.Regexp + st.Complex
+	if st.Hosts > rules {
+		return filterKindBlocklist
+	}
+	if rules != 0 && st.Allow*2 > rules {
+		return filterKindAllowlist
+	}
+	return ""
+}
+
+// Get the kind of the filter list: the declared kind or the detected one.
+// Return "" if the kind is unknown or the filter isn't loaded.
+func (filter *filter) kind() string {
+	if len(filter.ruleStats.declaredKind) != 0 {
+		return filter.ruleStats.declaredKind
+	}
+	return filter.ruleStats.detectedKind()
+}
+
+// Get the kind of the list the filter is added to
+func listKind(whitelist bool) string {
+	if whitelist {
+		return filterKindAllowlist
+	}
+	return filterKindBlocklist
+}
+
+// Check the kind of the filter against the list it's added to.
+// Return the warning message and TRUE if they don't match.
+func (filter *filter) kindMismatch(whitelist bool) (string, bool) {
+	kind := filter.kind()
+	if len(kind) == 0 || kind == listKind(whitelist) {
+		return "", false
+	}
+	return msgFilterKindMismatch.format(filter.URL, kind, listKind(whitelist)), true
+}
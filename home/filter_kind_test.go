@@ -0,0 +1,69 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterKind(t *testing.T) {
+	kind := func(data string) string {
+		_, _, _, st := Context.filters.parseFilterContents(strings.NewReader(data))
+		return (&filter{ruleStats: st}).kind()
+	}
+	assert.Equal(t, filterKindBlocklist, kind("0.0.0.0 ads.example.org\n0.0.0.0 tracker.example.org\n||example.org^\n"))
+	assert.Equal(t, filterKindAllowlist, kind("@@||example.org^\n@@||example.com^\n||ads.example.org^\n"))
+	assert.Equal(t, "", kind("||example.org^\n||example.com^\n"))
+	assert.Equal(t, "", kind(""))
+
+	// the header takes precedence over the rules
+	assert.Equal(t, filterKindAllowlist, kind("! Title: Allowed\n! Kind: Whitelist\n0.0.0.0 example.org\n"))
+	assert.Equal(t, filterKindBlocklist, kind("!Kind: blocklist\n@@||example.org^\n"))
+	assert.Equal(t, "", kind("! Kind: something\n||example.org^\n"))
+
+	filt := filter{URL: "https://example.org/hosts.txt"}
+	filt.ruleStats.Hosts = 1
+	_, mismatch := filt.kindMismatch(false)
+	assert.False(t, mismatch)
+	warning, mismatch := filt.kindMismatch(true)
+	assert.True(t, mismatch)
+	assert.Equal(t, "the filter at https://example.org/hosts.txt looks like a blocklist, but it's added to the allowlists", warning)
+}
+
+func TestFilterAddKindMismatch(t *testing.T) {
+	fs := newFixtureServer()
+	defer fs.Close()
+	h, cleanup := newFiltersHarness(t)
+	defer cleanup()
+
+	fs.set("/hosts.txt", fixtureResponse{body: "0.0.0.0 ads.example.org\n0.0.0.0 tracker.example.org\n"})
+	fs.set("/allow.txt", fixtureResponse{body: "! Kind: allowlist\n||example.org^\n"})
+
+	// refused
+	code, body := h.post("/control/filtering/add_url",
+		`{"name":"Hosts","url":"`+fs.URL+`/hosts.txt","whitelist":true,"strict_kind":true}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.True(t, strings.Contains(body, string(msgFilterKindMismatch)), body)
+	assert.Equal(t, 0, len(config.WhitelistFilters))
+	assert.Equal(t, 0, len(h.takeEvents()))
+
+	// added with a warning
+	code, body = h.post("/control/filtering/add_url", `{"name":"Hosts","url":"`+fs.URL+`/hosts.txt","whitelist":true}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.True(t, strings.HasPrefix(body, "OK 2 rules\nWarning: "), body)
+	assert.Equal(t, 1, len(config.WhitelistFilters))
+	assert.Equal(t, filterKindBlocklist, config.WhitelistFilters[0].kind())
+	assert.Equal(t, 1, len(config.WhitelistFilters[0].warnings))
+
+	// the kinds match
+	code, body = h.post("/control/filtering/add_urls", `[{"name":"Allow","url":"`+fs.URL+`/allow.txt","whitelist":true}]`)
+	assert.Equal(t, http.StatusOK, code, body)
+	var results []filterAddResultJSON
+	assert.Nil(t, json.Unmarshal([]byte(body), &results))
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "", results[0].Warning)
+	assert.Equal(t, filterKindAllowlist, config.WhitelistFilters[1].kind())
+}
@@ -0,0 +1,99 @@
+package home
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// The lazy initial load of the filters (filters_lazy_load).
+// Parsing of the large filter files on a slow flash storage may take a long time:
+//  in this mode the startup isn't blocked, the enabled filters are loaded in background
+//  and the filtering engine is rebuilt when all of them have been loaded.
+// Until then the enabled filters have no rules and the status reports "loading": true.
+
+// InitialLoadDoneFn is called when the background initial load of the filters has been completed
+type InitialLoadDoneFn func()
+
+// SetInitialLoadDoneFn sets the function that is called when the background initial load has been completed
+func (f *Filtering) SetInitialLoadDoneFn(fn InitialLoadDoneFn) {
+	f.loadDoneLock.Lock()
+	f.loadDoneFn = fn
+	f.loadDoneLock.Unlock()
+}
+
+// Return TRUE if the filters are being loaded in background
+func (f *Filtering) isLoading() bool {
+	return atomic.LoadUint32(&f.loading) != 0
+}
+
+// Load the enabled filters in background.
+// The filter is loaded into a copy and the result is stored if the filter hasn't been changed meanwhile.
+func (f *Filtering) loadFiltersLazy() {
+	start := time.Now()
+	ctx := f.context()
+	for _, whitelist := range []bool{false, true} {
+		var todo []filter
+		config.RLock()
+		filters := config.Filters
+		if whitelist {
+			filters = config.WhitelistFilters
+		}
+		for _, filt := range filters {
+			if filt.Enabled {
+				todo = append(todo, filt)
+			}
+		}
+		config.RUnlock()
+
+		for i := range todo {
+			if ctx.Err() != nil {
+				// the module has been closed
+				return
+			}
+			uf := &todo[i]
+			err := f.load(uf)
+			if err != nil {
+				log.Error("Couldn't load filter %d contents due to %s", uf.ID, err)
+				continue
+			}
+			storeLoadedFilter(*uf, whitelist)
+		}
+	}
+
+	atomic.StoreUint32(&f.loading, 0)
+	log.Info("filter: the initial load has been completed in %s", time.Since(start))
+	enableFilters(true)
+
+	f.loadDoneLock.Lock()
+	fn := f.loadDoneFn
+	f.loadDoneLock.Unlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+// Store the data of the loaded filter.
+// Nothing is changed if the filter has been disabled, removed or updated while it was being loaded.
+func storeLoadedFilter(uf filter, whitelist bool) {
+	config.Lock()
+	defer config.Unlock()
+
+	filters := config.Filters
+	if whitelist {
+		filters = config.WhitelistFilters
+	}
+	for i := range filters {
+		filt := &filters[i]
+		if filt.ID != uf.ID || filt.URL != uf.URL || !filt.Enabled || filt.checksum != 0 {
+			continue
+		}
+		filt.RulesCount = uf.RulesCount
+		filt.ruleStats = uf.ruleStats
+		filt.checksum = uf.checksum
+		filt.fileSize = uf.fileSize
+		filt.fileSizeKnown = uf.fileSizeKnown
+		filt.LastUpdated = uf.LastUpdated
+	}
+}
@@ -0,0 +1,127 @@
+package home
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+// Prepare the filters with the files on disk
+func prepareLazyLoadTest(t *testing.T, lazy bool) func() {
+	dir := prepareTestDir()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.configFilename = "AdGuardHome.yaml"
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	config.DNS.FilteringEnabled = true
+	config.DNS.FiltersLazyLoad = lazy
+	config.Filters = []filter{
+		{Enabled: true, URL: "https://example.org/1.txt", Filter: dnsfilter.Filter{ID: 1}},
+		{Enabled: false, URL: "https://example.org/2.txt", Filter: dnsfilter.Filter{ID: 2}},
+	}
+	config.WhitelistFilters = []filter{
+		{Enabled: true, URL: "https://example.org/3.txt", Filter: dnsfilter.Filter{ID: 3}},
+	}
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "data", filterDir), 0755))
+	for _, id := range []string{"1", "2", "3"} {
+		data := []byte("||a.example^\n||b.example^\n")
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "data", filterDir, id+".txt"), data, 0644))
+	}
+
+	return func() {
+		Context.filters.Close()
+		Context.dnsFilter.Close()
+		config.DNS.FilteringEnabled = false
+		config.DNS.FiltersLazyLoad = false
+		config.Filters = nil
+		config.WhitelistFilters = nil
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func getStatusForTest(t *testing.T) filteringConfig {
+	w := httptest.NewRecorder()
+	Context.filters.handleFilteringStatus(w, httptest.NewRequest("GET", "/control/filtering/status", nil))
+	resp := filteringConfig{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp
+}
+
+func TestFiltersLazyLoad(t *testing.T) {
+	cleanup := prepareLazyLoadTest(t, true)
+	defer cleanup()
+
+	var rebuilt []int
+	var lock sync.Mutex
+	Context.filters.engineRebuilt = func(filters, whiteFilters []dnsfilter.Filter) {
+		lock.Lock()
+		rebuilt = append(rebuilt, len(filters), len(whiteFilters))
+		lock.Unlock()
+	}
+	done := make(chan struct{})
+	Context.filters.SetInitialLoadDoneFn(func() { close(done) })
+	assert.Nil(t, Context.filters.Init())
+
+	select {
+	case <-done:
+		//
+	case <-time.After(5 * time.Second):
+		t.Fatal("the initial load hasn't been completed")
+	}
+	assert.False(t, Context.filters.isLoading())
+	assert.False(t, getStatusForTest(t).Loading)
+
+	config.RLock()
+	assert.Equal(t, 2, config.Filters[0].RulesCount)
+	assert.Equal(t, 0, config.Filters[1].RulesCount)
+	assert.Equal(t, 2, config.WhitelistFilters[0].RulesCount)
+	config.RUnlock()
+
+	// the engine is rebuilt with the loaded filters
+	lock.Lock()
+	assert.Equal(t, []int{1, 1}, rebuilt)
+	lock.Unlock()
+
+	// the status reports the load in progress
+	atomic.StoreUint32(&Context.filters.loading, 1)
+	assert.True(t, getStatusForTest(t).Loading)
+	atomic.StoreUint32(&Context.filters.loading, 0)
+}
+
+// Request the status while the filters are being loaded (run with -race)
+func TestFiltersLoadConcurrentStatus(t *testing.T) {
+	cleanup := prepareLazyLoadTest(t, false)
+	defer cleanup()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = getStatusForTest(t)
+			}
+		}
+	}()
+	assert.Nil(t, Context.filters.Init())
+	close(stop)
+	wg.Wait()
+
+	// the filters are loaded before Init() returns
+	assert.False(t, getStatusForTest(t).Loading)
+	assert.Equal(t, 2, config.Filters[0].RulesCount)
+	assert.Equal(t, 2, config.WhitelistFilters[0].RulesCount)
+}
@@ -0,0 +1,176 @@
+package home
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/util"
+)
+
+// The local filter sources:
+//  an absolute file path: "/etc/agh/rules.txt"
+//  file: URL: "file:///etc/agh/rules.txt"
+//  a path relative to the data directory: "./rules.txt"
+// The file name may be a glob pattern, e.g. "file:///etc/agh/rules/*.txt":
+//  all matching files are concatenated in lexical order.
+// The relative paths can't point outside the data directory.
+
+// Return TRUE if the filter data is read from the local files
+func isLocalFilterURL(u string) bool {
+	return filepath.IsAbs(u) ||
+		strings.HasPrefix(u, "file://") ||
+		strings.HasPrefix(u, "./") || strings.HasPrefix(u, "../")
+}
+
+// Return TRUE if the path is a glob pattern
+func isGlobPattern(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// Get the absolute path (or glob pattern) of the local filter source
+func localFilterPath(u string) (string, error) {
+	if strings.HasPrefix(u, "file://") {
+		pu, err := url.Parse(u)
+		if err != nil {
+			return "", err
+		}
+		if len(pu.Host) != 0 || !filepath.IsAbs(pu.Path) {
+			return "", fmt.Errorf("file URL must have an absolute path: %s", u)
+		}
+		return filepath.Clean(pu.Path), nil
+	}
+
+	if filepath.IsAbs(u) {
+		return filepath.Clean(u), nil
+	}
+
+	dataDir, err := filepath.Abs(Context.getDataDir())
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dataDir, u)
+	if !isPathInDir(path, dataDir) {
+		return "", fmt.Errorf("path is outside of the data directory: %s", u)
+	}
+	return path, nil
+}
+
+// Return TRUE if the path is inside the directory
+func isPathInDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Get the files of the local filter source, sorted in lexical order
+func localFilterFiles(u string) ([]string, error) {
+	path, err := localFilterPath(u)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if isGlobPattern(path) {
+		files, err = filepath.Glob(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no files match %s", path)
+		}
+		sort.Strings(files)
+	} else if !util.FileExists(path) {
+		return nil, fmt.Errorf("file doesn't exist: %s", path)
+	}
+
+	if filepath.IsAbs(u) || strings.HasPrefix(u, "file://") {
+		return files, nil
+	}
+
+	// the symbolic links must not lead outside the data directory
+	dataDir, err := filepath.EvalSymlinks(Context.getDataDir())
+	if err != nil {
+		return nil, err
+	}
+	dataDir, err = filepath.Abs(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fn := range files {
+		p, err := filepath.EvalSymlinks(fn)
+		if err != nil {
+			return nil, err
+		}
+		if !isPathInDir(p, dataDir) {
+			return nil, fmt.Errorf("path is outside of the data directory: %s", fn)
+		}
+	}
+	return files, nil
+}
+
+// Read the data of the local filter source
+func readLocalFilter(u string) ([]byte, error) {
+	files, err := localFilterFiles(u)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.Buffer{}
+	for _, fn := range files {
+		st, err := os.Stat(fn)
+		if err != nil {
+			return nil, err
+		}
+		if st.IsDir() {
+			continue
+		}
+		data, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return nil, fmt.Errorf("open file: %s", err)
+		}
+		buf.Write(data)
+		if len(data) != 0 && data[len(data)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Get the latest modification time of the local filter source.
+// The directory of a glob pattern is checked too: its time is changed when a file is added or removed.
+func localFilterModTime(u string) time.Time {
+	var t time.Time
+	files, err := localFilterFiles(u)
+	if err != nil {
+		return t
+	}
+	if path, err := localFilterPath(u); err == nil && isGlobPattern(path) {
+		files = append(files, filepath.Dir(path))
+	}
+	for _, fn := range files {
+		st, err := os.Stat(fn)
+		if err == nil && st.ModTime().After(t) {
+			t = st.ModTime()
+		}
+	}
+	return t
+}
+
+// Return TRUE if the filter URL is valid: a local source must exist
+// The data of data: URL is checked when it's decoded.
+func isValidFilterURL(u string) bool {
+	if isDataURL(u) {
+		return true
+	}
+	if isLocalFilterURL(u) {
+		_, err := localFilterFiles(u)
+		return err == nil
+	}
+	return util.IsValidURL(u)
+}
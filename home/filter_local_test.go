@@ -0,0 +1,73 @@
+package home
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalFilter(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.configFilename = "AdGuardHome.yaml"
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	defer Context.dnsFilter.Close()
+
+	rulesDir := filepath.Join(Context.getDataDir(), "rules")
+	assert.Nil(t, os.MkdirAll(rulesDir, 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(rulesDir, "b.txt"), []byte("||b.org^\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(rulesDir, "a.txt"), []byte("||a.org^"), 0644))
+	outside := filepath.Join(dir, "outside.txt")
+	assert.Nil(t, ioutil.WriteFile(outside, []byte("||outside.org^\n"), 0644))
+
+	// the files are concatenated in lexical order
+	data, err := readLocalFilter("./rules/*.txt")
+	assert.Nil(t, err)
+	assert.Equal(t, "||a.org^\n||b.org^\n", string(data))
+	data, err = readLocalFilter("file://" + filepath.ToSlash(filepath.Join(rulesDir, "b.txt")))
+	assert.Nil(t, err)
+	assert.Equal(t, "||b.org^\n", string(data))
+	assert.True(t, isValidFilterURL("./rules/a.txt"))
+	assert.False(t, isValidFilterURL("./rules/c.txt"))
+	assert.False(t, isValidFilterURL("./rules/*.dat"))
+
+	// the relative paths can't point outside the data directory
+	assert.False(t, isValidFilterURL("../outside.txt"))
+	assert.False(t, isValidFilterURL("./rules/../../outside.txt"))
+	assert.Nil(t, os.Symlink(outside, filepath.Join(rulesDir, "link.txt")))
+	_, err = readLocalFilter("./rules/*.txt")
+	assert.NotNil(t, err)
+	assert.Nil(t, os.Remove(filepath.Join(rulesDir, "link.txt")))
+
+	config.DNS.FiltersUpdateIntervalHours = 24
+	config.Filters = []filter{
+		{Enabled: true, URL: "./rules/*.txt", Filter: dnsfilter.Filter{ID: 1}},
+	}
+	defer func() {
+		config.DNS.FiltersUpdateIntervalHours = 0
+		config.Filters = nil
+	}()
+	assert.Nil(t, Context.filters.Init())
+	assert.Nil(t, Context.filters.RefreshFilter("./rules/*.txt"))
+	assert.Equal(t, 2, config.Filters[0].RulesCount)
+
+	// the filter isn't expired and the files haven't been changed
+	n, _ := Context.filters.refreshFilters(FilterRefreshBlocklists, true, updateTriggerManual)
+	assert.Equal(t, 0, n)
+
+	// a new file matches the pattern
+	fn := filepath.Join(rulesDir, "c.txt")
+	assert.Nil(t, ioutil.WriteFile(fn, []byte("||c.org^\n"), 0644))
+	future := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(fn, future, future))
+	n, _ = Context.filters.refreshFilters(FilterRefreshBlocklists, true, updateTriggerManual)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 3, config.Filters[0].RulesCount)
+}
@@ -0,0 +1,151 @@
+package home
+
+import (
+	"net"
+	"os"
+	"strings"
+)
+
+// ruleStats is the number of rules of each type in a filter
+type ruleStats struct {
+	Basic   int `json:"basic"`   // "||example.org^", "example.org"
+	Hosts   int `json:"hosts"`   // host names in "0.0.0.0 example.org" rules
+	Regexp  int `json:"regexp"`  // "/regexp/"
+	Complex int `json:"complex"` // rules with modifiers or wildcards
+
+	Allow int `json:"allow"` // the exception rules "@@||example.org^", they are counted in the types above too
+
+	declaredKind string // the kind declared in the header ("! Kind: allowlist"), see filterKind*
+}
+
+// Approximate memory used by the filtering engine per rule of each type (in bytes).
+// The values have been measured on the popular filter lists.
+const (
+	memBasicRule   = 100  // a lookup table entry and the rule index
+	memHostsRule   = 120  // a host rule object
+	memRegexpRule  = 2000 // a compiled regular expression
+	memComplexRule = 400  // a network rule object with its modifiers
+)
+
+// Add a rule line (not empty and not a comment)
+func (st *ruleStats) add(line string) {
+	if strings.HasPrefix(line, "@@") {
+		st.Allow++
+	}
+	if len(line) > 1 && line[0] == '/' && strings.LastIndexByte(line, '/') > 0 {
+		st.Regexp++
+		return
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) >= 2 && net.ParseIP(fields[0]) != nil {
+		st.Hosts += len(fields) - 1
+		return
+	}
+
+	if strings.ContainsAny(line, "$*") {
+		st.Complex++
+		return
+	}
+	st.Basic++
+}
+
+// Get the estimated memory used by the rules (in bytes)
+func (st ruleStats) estimateMemory() int64 {
+	return int64(st.Basic)*memBasicRule +
+		int64(st.Hosts)*memHostsRule +
+		int64(st.Regexp)*memRegexpRule +
+		int64(st.Complex)*memComplexRule
+}
+
+// EstimateMemory returns the estimated memory the filter rules use in the filtering engine (in bytes).
+// It's 0 if the filter isn't loaded.
+func (filter *filter) EstimateMemory() int64 {
+	return filter.ruleStats.estimateMemory()
+}
+
+// Get the estimated memory the enabled filters of the list use (in bytes)
+// Note: config must be locked
+func estimateStorageMemory(filters []filter) int64 {
+	var n int64
+	for i := range filters {
+		if filters[i].Enabled {
+			n += filters[i].EstimateMemory()
+		}
+	}
+	return n
+}
+
+// Get the estimated memory the filter rules would use, the data is read from the stored file.
+// Return 0 if the file doesn't exist.
+func (f *Filtering) estimateFileMemory(filt filter) int64 {
+	file, err := os.Open(filt.Path())
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	_, _, _, st := f.parseFilterContents(file)
+	return st.estimateMemory()
+}
+
+// memoryWarning is returned when enabling filters pushes the memory estimate past the budget
+type memoryWarning struct {
+	Type     string `json:"type"` // "memory_budget"
+	Message  string `json:"message"`
+	Estimate int64  `json:"est_memory_bytes"` // the estimate for the list after the change
+	Budget   int64  `json:"budget_bytes"`
+}
+
+// Check whether enabling the filters pushes the memory estimate of the list past the budget.
+// Return nil if the budget isn't set or isn't exceeded, and TRUE if the budget is strict.
+func (f *Filtering) checkMemoryBudget(urls []string, whitelist bool) (*memoryWarning, bool) {
+	set := map[string]bool{}
+	for _, u := range urls {
+		set[u] = true
+	}
+
+	config.RLock()
+	budget := int64(config.DNS.FiltersMemoryBudget) * 1024 * 1024
+	strict := config.DNS.FiltersMemoryStrict
+	filters := config.Filters
+	if whitelist {
+		filters = config.WhitelistFilters
+	}
+	total := estimateStorageMemory(filters)
+	var enabling []filter
+	for _, filt := range filters {
+		if set[filt.URL] && !filt.Enabled {
+			enabling = append(enabling, filt)
+		}
+	}
+	config.RUnlock()
+
+	if budget == 0 || len(enabling) == 0 {
+		return nil, false
+	}
+
+	for _, filt := range enabling {
+		total += f.estimateFileMemory(filt)
+	}
+	if total <= budget {
+		return nil, false
+	}
+
+	w := &memoryWarning{
+		Type:     "memory_budget",
+		Estimate: total,
+		Budget:   budget,
+	}
+	w.Message = msgMemoryBudget.format(w.estimateMB(), w.budgetMB())
+	return w, strict
+}
+
+// Get the memory estimate in MB
+func (w *memoryWarning) estimateMB() float64 {
+	return float64(w.Estimate) / (1024 * 1024)
+}
+
+// Get the memory budget in MB
+func (w *memoryWarning) budgetMB() int64 {
+	return w.Budget / (1024 * 1024)
+}
@@ -0,0 +1,108 @@
+package home
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+const memoryTestData = `! Title: Memory test
+||example.org^
+example.net
+@@||example.com^
+0.0.0.0 ads.example.org tracker.example.org
+127.0.0.1 localhost.example
+/^ad[sv]?[0-9]*\./
+||example.org^$important
+||ads*.example.com^
+# comment
+`
+
+func TestRuleStats(t *testing.T) {
+	_, _, _, st := Context.filters.parseFilterContents(strings.NewReader(memoryTestData))
+	assert.Equal(t, ruleStats{Basic: 3, Hosts: 3, Regexp: 1, Complex: 2, Allow: 1}, st)
+	assert.Equal(t, int64(3*memBasicRule+3*memHostsRule+1*memRegexpRule+2*memComplexRule), st.estimateMemory())
+
+	filt := filter{Enabled: true, ruleStats: st}
+	assert.Equal(t, int64(3*100+3*120+2000+2*400), filt.EstimateMemory())
+	assert.Equal(t, 2*filt.EstimateMemory(), estimateStorageMemory([]filter{filt, filt, {ruleStats: st}}))
+
+	filt.unload()
+	assert.Equal(t, int64(0), filt.EstimateMemory())
+}
+
+func TestMemoryBudget(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	config.Filters = []filter{
+		{Enabled: true, URL: "https://example.org/1.txt", Filter: dnsfilter.Filter{ID: 1}},
+		{Enabled: false, URL: "https://example.org/2.txt", Filter: dnsfilter.Filter{ID: 2}},
+	}
+	defer func() {
+		config.Filters = nil
+		config.DNS.FiltersMemoryBudget = 0
+		config.DNS.FiltersMemoryStrict = false
+	}()
+	Context.filters.Init()
+
+	// 1: 6000 regexp rules (~11.4MB), 2: 1000 regexp rules (~1.9MB)
+	data := strings.Repeat("/^ads[0-9]+\\./\n", 6000)
+	assert.Nil(t, ioutil.WriteFile(config.Filters[0].Path(), []byte(data), 0644))
+	assert.Nil(t, Context.filters.load(&config.Filters[0]))
+	data = strings.Repeat("/^ads[0-9]+\\./\n", 1000)
+	assert.Nil(t, ioutil.WriteFile(config.Filters[1].Path(), []byte(data), 0644))
+	assert.Equal(t, int64(6000*memRegexpRule), config.Filters[0].EstimateMemory())
+	assert.Equal(t, int64(1000*memRegexpRule), Context.filters.estimateFileMemory(config.Filters[1]))
+
+	// no budget
+	w, _ := Context.filters.checkMemoryBudget([]string{"https://example.org/2.txt"}, false)
+	assert.Nil(t, w)
+
+	// the budget isn't exceeded
+	config.DNS.FiltersMemoryBudget = 14
+	w, _ = Context.filters.checkMemoryBudget([]string{"https://example.org/2.txt"}, false)
+	assert.Nil(t, w)
+
+	// the budget is exceeded: a warning
+	config.DNS.FiltersMemoryBudget = 13
+	w, strict := Context.filters.checkMemoryBudget([]string{"https://example.org/2.txt"}, false)
+	assert.NotNil(t, w)
+	assert.False(t, strict)
+	assert.Equal(t, "memory_budget", w.Type)
+	assert.Equal(t, int64(7000*memRegexpRule), w.Estimate)
+	assert.Equal(t, int64(13*1024*1024), w.Budget)
+
+	// the filter is enabled already
+	w, _ = Context.filters.checkMemoryBudget([]string{"https://example.org/1.txt"}, false)
+	assert.Nil(t, w)
+
+	// strict mode: the filter isn't enabled
+	config.DNS.FiltersMemoryStrict = true
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/control/filtering/set_enabled",
+		strings.NewReader(`{"type":"blocklist","urls":["https://example.org/2.txt"],"enabled":true}`))
+	Context.filters.handleFilteringSetEnabled(rec, r)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.True(t, strings.Contains(rec.Body.String(), "exceeds the budget"), rec.Body.String())
+	assert.False(t, config.Filters[1].Enabled)
+
+	// verbose status
+	rec = httptest.NewRecorder()
+	Context.filters.handleFilteringStatus(rec, httptest.NewRequest("GET", "/control/filtering/status?verbose=true", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	resp := filteringConfig{}
+	assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, int64(6000*memRegexpRule), resp.EstMemory["blocklist"])
+	assert.Equal(t, int64(6000*memRegexpRule), *resp.Filters[0].EstMemory)
+	assert.Equal(t, 6000, resp.Filters[0].RuleStats.Regexp)
+	assert.Equal(t, int64(0), *resp.Filters[1].EstMemory)
+}
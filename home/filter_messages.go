@@ -0,0 +1,257 @@
+package home
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// The user-facing messages of the filtering handlers.
+// Every message has a key that is passed to the client along with the text,
+//  the text is translated into the client's language (Accept-Language) if the translator is set.
+// The default (English) messages are the format strings for the message arguments,
+//  the translations must have the same arguments in the same order.
+
+// msgKey is the key of a user-facing message
+type msgKey string
+
+// The keys of the messages
+const (
+	msgError               msgKey = "error" // an error without its own message
+	msgBadJSON             msgKey = "bad_json"
+	msgJSONEncode          msgKey = "json_encode_failed"
+	msgReadFailed          msgKey = "read_failed"
+	msgWriteFailed         msgKey = "write_failed"
+	msgMultipartForm       msgKey = "multipart_form_failed"
+	msgImportFile          msgKey = "import_file_failed"
+	msgInvalidURL          msgKey = "invalid_url"
+	msgInvalidURLValue     msgKey = "invalid_url_value"
+	msgDuplicateURL        msgKey = "duplicate_url"
+	msgURLRequired         msgKey = "url_required"
+	msgIDOrURLRequired     msgKey = "id_or_url_required"
+	msgInvalidID           msgKey = "invalid_id"
+	msgUnknownType         msgKey = "unknown_type"
+	msgInvalidParameter    msgKey = "invalid_parameter"
+	msgInvalidApplyTo      msgKey = "invalid_apply_to"
+	msgFilterExists        msgKey = "filter_exists"
+	msgFilterURLNotFound   msgKey = "filter_url_not_found"
+	msgFilterURLExists     msgKey = "filter_url_exists"
+	msgFilterNotFound      msgKey = "filter_not_found"
+	msgFilterNotFoundURL   msgKey = "filter_not_found_url"
+	msgFilterOrderURL      msgKey = "filter_order_url"
+	msgFilterOrderCount    msgKey = "filter_order_count"
+	msgFilterInvalidIndex  msgKey = "filter_invalid_index"
+	msgFilterDisabled      msgKey = "filter_disabled"
+	msgFilterFetchFailed   msgKey = "filter_fetch_failed"
+	msgFilterInvalid       msgKey = "filter_invalid"
+	msgFilterModified      msgKey = "filter_modified"
+	msgFilterDownload      msgKey = "filter_download_failed"
+	msgUpdateRunning       msgKey = "update_running"
+	msgUnsupportedInterval msgKey = "unsupported_interval"
+	msgInvalidProxyURL     msgKey = "invalid_proxy_url"
+	msgMemoryBudget        msgKey = "memory_budget_exceeded"
+	msgRuleCountDrop       msgKey = "rule_count_drop"
+	msgGroupNameRequired   msgKey = "group_name_required"
+	msgGroupDuplicate      msgKey = "group_duplicate"
+	msgGroupInvalidRule    msgKey = "group_invalid_rule"
+	msgHostRequired        msgKey = "host_required"
+	msgClientNotFound      msgKey = "client_not_found"
+	msgUnknownQType        msgKey = "unknown_qtype"
+	msgTooManyNames        msgKey = "too_many_names"
+	msgInvalidRule         msgKey = "invalid_rule"
+	msgFilteringFailed     msgKey = "filtering_failed"
+	msgQueryRequired       msgKey = "query_required"
+	msgInvalidLimit        msgKey = "invalid_limit"
+	msgInvalidRegexp       msgKey = "invalid_regexp"
+	msgNotesTooLong        msgKey = "notes_too_long"
+	msgEtcHostsRead        msgKey = "etc_hosts_read_failed"
+	msgEtcHostsTooLarge    msgKey = "etc_hosts_too_large"
+	msgFilterKindMismatch  msgKey = "filter_kind_mismatch"
+	msgFilterDirReadOnly   msgKey = "filter_dir_read_only"
+)
+
+// The default messages
+var defaultMessages = map[msgKey]string{
+	msgError:               "%s",
+	msgBadJSON:             "Failed to parse request body json: %s",
+	msgJSONEncode:          "json encode: %s",
+	msgReadFailed:          "Failed to read request body: %s",
+	msgWriteFailed:         "Couldn't write body: %s",
+	msgMultipartForm:       "multipart form: %s",
+	msgImportFile:          "%s: %s",
+	msgInvalidURL:          "invalid URL or file path",
+	msgInvalidURLValue:     "invalid URL or file path: %s",
+	msgDuplicateURL:        "duplicate URL: %s",
+	msgURLRequired:         "url parameter is required",
+	msgIDOrURLRequired:     "id or url parameter is required",
+	msgInvalidID:           "invalid id: %s",
+	msgUnknownType:         "unknown type: %s",
+	msgInvalidParameter:    "invalid %s: %s",
+	msgInvalidApplyTo:      "invalid apply_to value: %s",
+	msgFilterExists:        "filter URL already added -- %s",
+	msgFilterURLNotFound:   "URL doesn't exist",
+	msgFilterURLExists:     "URL already exists",
+	msgFilterNotFound:      "filter not found",
+	msgFilterNotFoundURL:   "filter not found: %s",
+	msgFilterOrderURL:      "filter not found or duplicate: %s",
+	msgFilterOrderCount:    "the list must contain all %d filters, got %d",
+	msgFilterInvalidIndex:  "invalid index: %d",
+	msgFilterDisabled:      "filter is disabled",
+	msgFilterFetchFailed:   "couldn't fetch filter from url %s: %s",
+	msgFilterInvalid:       "filter at the url %s is invalid (maybe it points to blank page?)",
+	msgFilterModified:      "filter has been modified, try again",
+	msgFilterDownload:      "%s",
+	msgUpdateRunning:       "filters update procedure is already running",
+	msgUnsupportedInterval: "Unsupported interval",
+	msgInvalidProxyURL:     "invalid proxy URL: %s",
+	msgMemoryBudget:        "the estimated memory usage of the enabled filters (%.1f MB) exceeds the budget (%d MB)",
+	msgRuleCountDrop:       "suspicious rule count drop (%d -> %d), update skipped",
+	msgGroupNameRequired:   "group #%d: name is required",
+	msgGroupDuplicate:      "group %q: duplicate name",
+	msgGroupInvalidRule:    "group %q: line %d: %s: %s",
+	msgHostRequired:        "host is required",
+	msgClientNotFound:      "unknown client: %s",
+	msgUnknownQType:        "unknown query type: %s",
+	msgTooManyNames:        "too many names: %d (max %d)",
+	msgInvalidRule:         "invalid rule: %s",
+	msgFilteringFailed:     "couldn't apply filtering: %s: %s",
+	msgQueryRequired:       "query parameter is required",
+	msgInvalidLimit:        "invalid limit: %s",
+	msgInvalidRegexp:       "invalid regular expression: %s",
+	msgNotesTooLong:        "notes are too long: %d bytes (max %d)",
+	msgEtcHostsRead:        "couldn't read the hosts file: %s",
+	msgEtcHostsTooLarge:    "too many entries in the hosts file: %d bytes (max %d)",
+	msgFilterKindMismatch:  "the filter at %s looks like a %s, but it's added to the %ss",
+	msgFilterDirReadOnly:   "the filters directory %s is read-only: filters can't be added or updated",
+}
+
+// Get the default message
+func (key msgKey) format(args ...interface{}) string {
+	return fmt.Sprintf(defaultMessages[key], args...)
+}
+
+// messageError is an error with a user-facing message
+type messageError struct {
+	key  msgKey
+	args []interface{}
+}
+
+func (e *messageError) Error() string {
+	return e.key.format(e.args...)
+}
+
+// Create an error with a user-facing message
+func newMsgError(key msgKey, args ...interface{}) error {
+	return &messageError{key: key, args: args}
+}
+
+// TranslateFn returns the translation of the message into the language,
+//  or an empty string if there's no translation.
+// lang is a language tag from Accept-Language header, e.g. "de" or "pt-BR".
+type TranslateFn func(key, lang string) string
+
+// SetTranslateFn sets the function that translates the user-facing messages
+func (f *Filtering) SetTranslateFn(fn TranslateFn) {
+	f.translateLock.Lock()
+	f.translateFn = fn
+	f.translateLock.Unlock()
+}
+
+// Get the languages from Accept-Language header, the preferred language is the first one
+func acceptLanguages(header string) []string {
+	type langQ struct {
+		lang string
+		q    float64
+	}
+	var langs []langQ
+	for _, s := range strings.Split(header, ",") {
+		parts := strings.Split(s, ";")
+		lang := strings.TrimSpace(parts[0])
+		if len(lang) == 0 || lang == "*" {
+			continue
+		}
+		q := 1.0
+		for _, p := range parts[1:] {
+			p = strings.TrimSpace(p)
+			if !strings.HasPrefix(p, "q=") {
+				continue
+			}
+			v, err := strconv.ParseFloat(p[2:], 64)
+			if err == nil {
+				q = v
+			}
+		}
+		if q > 0 {
+			langs = append(langs, langQ{lang, q})
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].q > langs[j].q
+	})
+
+	var r []string
+	for _, l := range langs {
+		r = append(r, l.lang)
+	}
+	return r
+}
+
+// Get the message in the client's language
+func (f *Filtering) message(r *http.Request, key msgKey, args ...interface{}) string {
+	f.translateLock.Lock()
+	translate := f.translateFn
+	f.translateLock.Unlock()
+
+	if translate != nil && r != nil {
+		for _, lang := range acceptLanguages(r.Header.Get("Accept-Language")) {
+			s := translate(string(key), lang)
+			if len(s) != 0 {
+				return fmt.Sprintf(s, args...)
+			}
+		}
+	}
+	return key.format(args...)
+}
+
+// filterErrorJSON is the error response of the filtering handlers
+type filterErrorJSON struct {
+	Code    string `json:"code"`           // the message key
+	Message string `json:"message"`        // the message in the client's language
+	Kind    string `json:"kind,omitempty"` // the error kind: "bad_url", "duplicate", "not_found", "download" or "too_large"
+}
+
+// Respond with the error message in the client's language
+func (f *Filtering) httpErrorMsg(w http.ResponseWriter, r *http.Request, code int, key msgKey, args ...interface{}) {
+	log.Info("%s", key.format(args...))
+
+	js, err := json.Marshal(filterErrorJSON{
+		Code:    string(key),
+		Message: f.message(r, key, args...),
+		Kind:    filterErrorKind(&messageError{key: key, args: args}),
+	})
+	if err != nil {
+		httpError(w, code, "%s", key.format(args...))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	_, _ = w.Write(js)
+}
+
+// Respond with the error, the errors without their own message are passed as is
+func (f *Filtering) httpErrorErr(w http.ResponseWriter, r *http.Request, code int, err error) {
+	switch e := err.(type) {
+	case *messageError:
+		f.httpErrorMsg(w, r, code, e.key, e.args...)
+	case *filterDownloadError:
+		f.httpErrorMsg(w, r, code, msgFilterDownload, e.msg)
+	default:
+		f.httpErrorMsg(w, r, code, msgError, err)
+	}
+}
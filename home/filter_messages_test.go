@@ -0,0 +1,91 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcceptLanguages(t *testing.T) {
+	assert.Equal(t, []string{"de-DE", "de", "en"}, acceptLanguages("en;q=0.5, de-DE, de;q=0.9, *;q=0.1"))
+	assert.Equal(t, []string{"fr"}, acceptLanguages("fr, ru;q=0"))
+	assert.Nil(t, acceptLanguages(""))
+}
+
+func TestFilterMessages(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	config.Filters = []filter{
+		{Enabled: true, URL: "https://example.org/1.txt", Filter: dnsfilter.Filter{ID: 1}},
+	}
+	defer func() { config.Filters = nil }()
+	assert.Nil(t, Context.filters.Init())
+
+	// every message has the default text
+	for key, s := range defaultMessages {
+		assert.NotEqual(t, "", s, key)
+	}
+
+	Context.filters.SetTranslateFn(func(key, lang string) string {
+		if lang != "de" {
+			return ""
+		}
+		switch msgKey(key) {
+		case msgFilterNotFound:
+			return "Filter nicht gefunden"
+		case msgFilterExists:
+			return "Filter bereits hinzugefügt: %s"
+		}
+		return ""
+	})
+
+	request := func(h http.HandlerFunc, method, url, body, lang string) (int, filterErrorJSON) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		if len(lang) != 0 {
+			r.Header.Set("Accept-Language", lang)
+		}
+		h(w, r)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		resp := filterErrorJSON{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, resp
+	}
+
+	// the default message
+	code, resp := request(Context.filters.handleFilterHistory, "GET", "/control/filtering/history?url=https://example.org/2.txt", "", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "filter_not_found", resp.Code)
+	assert.Equal(t, "filter not found", resp.Message)
+
+	// the translated message
+	code, resp = request(Context.filters.handleFilterHistory, "GET", "/control/filtering/history?url=https://example.org/2.txt", "", "fr;q=0.5, de")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "filter_not_found", resp.Code)
+	assert.Equal(t, "Filter nicht gefunden", resp.Message)
+
+	// the translated message with arguments
+	code, resp = request(Context.filters.handleFilteringAddURL, "POST", "/control/filtering/add_url", `{"url":"https://example.org/1.txt"}`, "de")
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, "filter_exists", resp.Code)
+	assert.Equal(t, "Filter bereits hinzugefügt: https://example.org/1.txt", resp.Message)
+
+	// there's no translation
+	code, resp = request(Context.filters.handleFilteringAddURL, "POST", "/control/filtering/add_url", `{"url":"invalid"}`, "de")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "invalid_url", resp.Code)
+	assert.Equal(t, "invalid URL or file path", resp.Message)
+
+	// the argument of the message is the decoder error
+	code, resp = request(Context.filters.handleFilteringAddURL, "POST", "/control/filtering/add_url", `{`, "de")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "bad_json", resp.Code)
+}
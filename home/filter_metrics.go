@@ -0,0 +1,117 @@
+package home
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// The filters update metrics in Prometheus text format.
+// The counters are kept in memory since the start,
+//  the update time and the number of rules are taken from the configured filters.
+
+// filterMetrics is the number of the update attempts of a filter
+type filterMetrics struct {
+	updates  uint64 // all download attempts
+	failures uint64 // the failed download attempts
+	retries  uint64 // the retries made during the downloads
+}
+
+// Count the download attempt of the filter and the retries made during the download
+func (f *Filtering) countUpdate(id int64, failed bool, retries int) {
+	f.metricsLock.Lock()
+	defer f.metricsLock.Unlock()
+
+	if f.metrics == nil {
+		f.metrics = map[int64]*filterMetrics{}
+	}
+	m, ok := f.metrics[id]
+	if !ok {
+		m = &filterMetrics{}
+		f.metrics[id] = m
+	}
+	m.updates++
+	m.retries += uint64(retries)
+	if failed {
+		m.failures++
+	}
+}
+
+// Get the counters of the filter
+func (f *Filtering) updateCounters(id int64) filterMetrics {
+	f.metricsLock.Lock()
+	defer f.metricsLock.Unlock()
+
+	m, ok := f.metrics[id]
+	if !ok {
+		return filterMetrics{}
+	}
+	return *m
+}
+
+var metricsLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Get the labels of the filter metrics
+func metricsLabels(filt filter, list string) string {
+	return fmt.Sprintf(`id="%d",url="%s",list="%s"`, filt.ID, metricsLabelEscaper.Replace(filt.URL), list)
+}
+
+// Write the metrics of the configured filters
+func (f *Filtering) writeMetrics(buf *bytes.Buffer) {
+	type metric struct {
+		name  string
+		help  string
+		typ   string
+		value func(filt filter) string
+	}
+	metrics := []metric{
+		{
+			"filter_update_total", "The number of the filter download attempts since the start.", "counter",
+			func(filt filter) string { return fmt.Sprintf("%d", f.updateCounters(filt.ID).updates) },
+		},
+		{
+			"filter_update_failures_total", "The number of the failed filter download attempts since the start.", "counter",
+			func(filt filter) string { return fmt.Sprintf("%d", f.updateCounters(filt.ID).failures) },
+		},
+		{
+			"filter_download_retries_total", "The number of the filter download retries since the start.", "counter",
+			func(filt filter) string { return fmt.Sprintf("%d", f.updateCounters(filt.ID).retries) },
+		},
+		{
+			"filter_last_update_timestamp", "The time of the last successful filter update (in seconds since the epoch).", "gauge",
+			func(filt filter) string {
+				if filt.LastUpdated.IsZero() {
+					return "0"
+				}
+				return fmt.Sprintf("%d", filt.LastUpdated.Unix())
+			},
+		},
+		{
+			"filter_rule_count", "The number of rules in the filter.", "gauge",
+			func(filt filter) string { return fmt.Sprintf("%d", filt.RulesCount) },
+		},
+	}
+
+	config.RLock()
+	defer config.RUnlock()
+	for _, m := range metrics {
+		fmt.Fprintf(buf, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(buf, "# TYPE %s %s\n", m.name, m.typ)
+		for _, filt := range config.Filters {
+			fmt.Fprintf(buf, "%s{%s} %s\n", m.name, metricsLabels(filt, "blocklist"), m.value(filt))
+		}
+		for _, filt := range config.WhitelistFilters {
+			fmt.Fprintf(buf, "%s{%s} %s\n", m.name, metricsLabels(filt, "allowlist"), m.value(filt))
+		}
+	}
+}
+
+// HandleMetrics serves the filters update metrics in Prometheus text format.
+// The web server registers it as "/metrics".
+func (f *Filtering) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	buf := bytes.Buffer{}
+	f.writeMetrics(&buf)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
@@ -0,0 +1,73 @@
+package home
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bad.txt" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("||example.org^\n||example.com^\n"))
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	defer Context.dnsFilter.Close()
+	config.Filters = []filter{
+		{Enabled: true, URL: srv.URL + "/good.txt", Filter: dnsfilter.Filter{ID: 1}},
+		{Enabled: true, URL: srv.URL + "/bad.txt", Filter: dnsfilter.Filter{ID: 2}},
+	}
+	defer func() { config.Filters = nil }()
+	Context.filters.Init()
+
+	for i := 0; i < 2; i++ {
+		_, _ = Context.filters.refreshFilters(FilterRefreshBlocklists|FilterRefreshForce, true, updateTriggerManual)
+	}
+
+	w := httptest.NewRecorder()
+	Context.filters.HandleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain"))
+	body := w.Body.String()
+
+	good := fmt.Sprintf(`{id="1",url="%s/good.txt",list="blocklist"}`, srv.URL)
+	bad := fmt.Sprintf(`{id="2",url="%s/bad.txt",list="blocklist"}`, srv.URL)
+	for _, line := range []string{
+		"# TYPE filter_update_total counter",
+		"filter_update_total" + good + " 2",
+		"filter_update_total" + bad + " 2",
+		"# TYPE filter_update_failures_total counter",
+		"filter_update_failures_total" + good + " 0",
+		"filter_update_failures_total" + bad + " 2",
+		"# TYPE filter_last_update_timestamp gauge",
+		fmt.Sprintf("filter_last_update_timestamp%s %d", good, config.Filters[0].LastUpdated.Unix()),
+		"filter_last_update_timestamp" + bad + " 0",
+		"# TYPE filter_rule_count gauge",
+		"filter_rule_count" + good + " 2",
+		"filter_rule_count" + bad + " 0",
+	} {
+		assert.True(t, strings.Contains(body, line+"\n"), line)
+	}
+
+	assert.Equal(t, `id="3",url="a\"b\\c",list="allowlist"`,
+		metricsLabels(filter{URL: `a"b\c`, Filter: dnsfilter.Filter{ID: 3}}, "allowlist"))
+}
@@ -0,0 +1,44 @@
+package home
+
+import (
+	"strings"
+	"unicode"
+)
+
+// The notes of the filters: free-form text of the administrator,
+//  e.g. why the filter has been added ("ticket #1234, requested by marketing").
+
+// The maximum length of the filter notes (in bytes)
+const maxFilterNotes = 4 * 1024
+
+// Remove the control characters (except line feed and tab) from the notes and check their length
+func sanitizeFilterNotes(notes string) (string, error) {
+	notes = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' {
+			return r
+		}
+		if unicode.IsControl(r) || r == unicode.ReplacementChar {
+			return -1
+		}
+		return r
+	}, notes)
+
+	if len(notes) > maxFilterNotes {
+		return "", newMsgError(msgNotesTooLong, len(notes), maxFilterNotes)
+	}
+	return notes, nil
+}
+
+// Return TRUE if the name, the URL or the notes of the filter contain the search string (case-insensitive)
+// search must be in lower case
+func filterMatchesSearch(filt filter, search string) bool {
+	if len(search) == 0 {
+		return true
+	}
+	for _, s := range []string{filt.Name, filt.URL, filt.Notes} {
+		if strings.Contains(strings.ToLower(s), search) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterNotes(t *testing.T) {
+	h, cleanup := newFiltersHarness(t)
+	defer cleanup()
+
+	status := func(query string) filteringConfig {
+		code, body := h.get("/control/filtering/status" + query)
+		assert.Equal(t, http.StatusOK, code, body)
+		resp := filteringConfig{}
+		assert.Nil(t, json.Unmarshal([]byte(body), &resp))
+		return resp
+	}
+
+	// the control characters are removed
+	code, body := h.post("/control/filtering/add_url",
+		`{"name":"Ads","url":"https://example.org/ads.txt","enabled":false,"notes":"ticket #1234,\u0007 requested by marketing\nsee the wiki"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	code, body = h.post("/control/filtering/add_url",
+		`{"name":"Malware","url":"https://example.org/malware.txt","enabled":false}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	notes := "ticket #1234, requested by marketing\nsee the wiki"
+	assert.Equal(t, notes, config.Filters[0].Notes)
+
+	// the notes are stored in the configuration file
+	conf := h.conf()
+	assert.Equal(t, 2, len(conf.Filters))
+	assert.Equal(t, notes, conf.Filters[0].Notes)
+	assert.Equal(t, "", conf.Filters[1].Notes)
+	assert.Equal(t, notes, status("").Filters[0].Notes)
+
+	// the notes aren't changed if they aren't set
+	code, body = h.post("/control/filtering/set_url",
+		`{"url":"https://example.org/ads.txt","data":{"name":"Ads 2","url":"https://example.org/ads.txt","enabled":false}}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, notes, h.conf().Filters[0].Notes)
+
+	code, body = h.post("/control/filtering/set_url",
+		`{"url":"https://example.org/malware.txt","data":{"name":"Malware","url":"https://example.org/malware.txt","enabled":false,"notes":"security team"}}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, "security team", h.conf().Filters[1].Notes)
+
+	// the length is limited
+	long := strings.Repeat("a", maxFilterNotes+1)
+	code, body = h.post("/control/filtering/add_url",
+		`{"name":"Long","url":"https://example.org/long.txt","enabled":false,"notes":"`+long+`"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.True(t, strings.Contains(body, string(msgNotesTooLong)), body)
+	assert.Equal(t, 2, len(config.Filters))
+	code, body = h.post("/control/filtering/set_url",
+		`{"url":"https://example.org/malware.txt","data":{"name":"Malware","url":"https://example.org/malware.txt","enabled":false,"notes":"`+long+`"}}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.True(t, strings.Contains(body, string(msgNotesTooLong)), body)
+	assert.Equal(t, "security team", config.Filters[1].Notes)
+
+	// search by the name, the URL and the notes
+	for q, names := range map[string][]string{
+		"MARKETING": {"Ads 2"},
+		"malware":   {"Malware"},
+		"security":  {"Malware"},
+		"example":   {"Ads 2", "Malware"},
+		"nothing":   nil,
+	} {
+		resp := status("?q=" + q)
+		var found []string
+		for _, fj := range resp.Filters {
+			found = append(found, fj.Name)
+		}
+		assert.Equal(t, names, found, q)
+		assert.Equal(t, len(names), *resp.FiltersTotal, q)
+	}
+
+	// the notes are exported and imported
+	code, body = h.get("/control/filtering/export")
+	assert.Equal(t, http.StatusOK, code, body)
+	exp := filteringExportJSON{}
+	assert.Nil(t, json.Unmarshal([]byte(body), &exp))
+	assert.Equal(t, notes, exp.Filters[0].Notes)
+	assert.Equal(t, "security team", exp.Filters[1].Notes)
+
+	exp.Filters[1].Notes = "imported"
+	data, _ := json.Marshal(exp)
+	code, body = h.post("/control/filtering/import", string(data))
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, notes, h.conf().Filters[0].Notes)
+	assert.Equal(t, "imported", h.conf().Filters[1].Notes)
+}
+
+func TestSanitizeFilterNotes(t *testing.T) {
+	s, err := sanitizeFilterNotes("a\x00b\r\n\tc\x1b[31md")
+	assert.Nil(t, err)
+	assert.Equal(t, "ab\n\tc[31md", s)
+
+	_, err = sanitizeFilterNotes(strings.Repeat("ж", maxFilterNotes/2))
+	assert.Nil(t, err)
+	_, err = sanitizeFilterNotes(strings.Repeat("ж", maxFilterNotes/2+1))
+	assert.NotNil(t, err)
+	// the removed characters aren't counted
+	_, err = sanitizeFilterNotes(strings.Repeat("a", maxFilterNotes) + "\x00")
+	assert.Nil(t, err)
+}
@@ -0,0 +1,139 @@
+package home
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+)
+
+// Pi-hole configuration files that we can import
+const (
+	piholeAdlists = "adlists" // adlists.list: filter list URLs, one per line
+	piholeRegex   = "regex"   // regex.list: regular expressions for blocked domains
+	piholeCustom  = "custom"  // custom.list: local DNS records, "IP hostname" pairs
+)
+
+// piholeItem is the result of converting one entry from a Pi-hole configuration file
+type piholeItem struct {
+	File   string `json:"file"` // piholeAdlists, piholeRegex or piholeCustom
+	Line   int    `json:"line"`
+	Entry  string `json:"entry"`
+	Result string `json:"result,omitempty"` // filter URL or user rule
+	Status string `json:"status"`           // "converted" or "skipped"
+	Reason string `json:"reason,omitempty"` // why the entry has been skipped
+}
+
+func (it *piholeItem) convert(result string) {
+	it.Status = "converted"
+	it.Result = result
+}
+
+func (it *piholeItem) skip(format string, args ...interface{}) {
+	it.Status = "skipped"
+	it.Reason = fmt.Sprintf(format, args...)
+}
+
+// Call fn for each entry of a Pi-hole file.
+// Empty lines and comments are skipped, trailing comments are removed.
+func piholeForEachEntry(r io.Reader, fn func(lineNum int, entry string)) error {
+	sc := bufio.NewScanner(r)
+	for lineNum := 1; sc.Scan(); lineNum++ {
+		line := strings.TrimSpace(sc.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		fn(lineNum, line)
+	}
+	return sc.Err()
+}
+
+// Convert Pi-hole adlists.list.
+// Return the list of filter URLs to add.
+func convertPiholeAdlists(r io.Reader) ([]string, []piholeItem, error) {
+	var urls []string
+	var items []piholeItem
+	seen := map[string]bool{}
+	err := piholeForEachEntry(r, func(lineNum int, entry string) {
+		it := piholeItem{File: piholeAdlists, Line: lineNum, Entry: entry}
+		u := strings.Fields(entry)[0]
+		if !isValidFilterURL(u) {
+			it.skip("invalid URL")
+		} else if seen[u] {
+			it.skip("duplicate URL")
+		} else {
+			seen[u] = true
+			urls = append(urls, u)
+			it.convert(u)
+		}
+		items = append(items, it)
+	})
+	return urls, items, err
+}
+
+// Convert Pi-hole regex.list.
+// Pi-hole matches regular expressions against the domain name, so "pattern" becomes "/pattern/".
+// Pi-hole specific extensions (";querytype=", ";invert", etc.) aren't supported.
+// Return the list of user rules.
+func convertPiholeRegex(r io.Reader) ([]string, []piholeItem, error) {
+	var rules []string
+	var items []piholeItem
+	err := piholeForEachEntry(r, func(lineNum int, entry string) {
+		it := piholeItem{File: piholeRegex, Line: lineNum, Entry: entry}
+		if i := strings.IndexByte(entry, ';'); i >= 0 {
+			it.skip("unsupported Pi-hole extension: %s", entry[i:])
+			items = append(items, it)
+			return
+		}
+
+		rule := "/" + entry + "/"
+		err := dnsfilter.ValidateRule(rule)
+		if err != nil {
+			it.skip("%s", err)
+		} else {
+			rules = append(rules, rule)
+			it.convert(rule)
+		}
+		items = append(items, it)
+	})
+	return rules, items, err
+}
+
+// Convert Pi-hole custom.list.
+// "IP hostname [hostname...]" lines are the same in hosts syntax.
+// Return the list of user rules.
+func convertPiholeCustom(r io.Reader) ([]string, []piholeItem, error) {
+	var rules []string
+	var items []piholeItem
+	err := piholeForEachEntry(r, func(lineNum int, entry string) {
+		it := piholeItem{File: piholeCustom, Line: lineNum, Entry: entry}
+		if i := strings.IndexByte(entry, '#'); i >= 0 {
+			entry = entry[:i]
+		}
+		fields := strings.Fields(entry)
+		if net.ParseIP(fields[0]) == nil {
+			it.skip("invalid IP address: %s", fields[0])
+			items = append(items, it)
+			return
+		}
+		if len(fields) < 2 {
+			it.skip("no host name")
+			items = append(items, it)
+			return
+		}
+
+		rule := strings.Join(fields, " ")
+		err := dnsfilter.ValidateRule(rule)
+		if err != nil {
+			it.skip("%s", err)
+		} else {
+			rules = append(rules, rule)
+			it.convert(rule)
+		}
+		items = append(items, it)
+	})
+	return rules, items, err
+}
@@ -0,0 +1,102 @@
+package home
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertPiholeAdlists(t *testing.T) {
+	data := `# The below list amalgamates several lists we used previously.
+https://raw.githubusercontent.com/StevenBlack/hosts/master/hosts
+https://mirror1.malwaredomains.com/files/justdomains
+
+http://sysctl.org/cameleon/hosts
+https://s3.amazonaws.com/lists.disconnect.me/simple_tracking.txt # trackers
+https://mirror1.malwaredomains.com/files/justdomains
+raw.githubusercontent.com/list.txt
+`
+	urls, items, err := convertPiholeAdlists(strings.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"https://raw.githubusercontent.com/StevenBlack/hosts/master/hosts",
+		"https://mirror1.malwaredomains.com/files/justdomains",
+		"http://sysctl.org/cameleon/hosts",
+		"https://s3.amazonaws.com/lists.disconnect.me/simple_tracking.txt",
+	}, urls)
+
+	assert.Equal(t, 6, len(items))
+	assert.Equal(t, 2, items[0].Line)
+	assert.Equal(t, "converted", items[0].Status)
+	assert.Equal(t, "skipped", items[4].Status)
+	assert.Equal(t, "duplicate URL", items[4].Reason)
+	assert.Equal(t, 8, items[5].Line)
+	assert.Equal(t, "skipped", items[5].Status)
+	assert.Equal(t, "invalid URL", items[5].Reason)
+
+	// the local sources are validated as in the other add paths
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "data", "lists"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "data", "rules.txt"), []byte("||example.org^\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "data", "lists", "1.txt"), []byte("||example.org^\n"), 0644))
+	data = "./rules.txt\n./lists/*.txt\n./missing.txt\n"
+	urls, items, err = convertPiholeAdlists(strings.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"./rules.txt", "./lists/*.txt"}, urls)
+	assert.Equal(t, "invalid URL", items[2].Reason)
+}
+
+func TestConvertPiholeRegex(t *testing.T) {
+	data := `# regex.list
+^(.+[-_.])??adse?rv(er?|ice)?s?[0-9]*[-.]
+^ad([sxv]?[0-9]*|system)[_.-]([^.[:space:]]+\.){1,}|[_.-]ad([sxv]?[0-9]*|system)[_.-]
+(^|\.)doubleclick\.net$
+^analytics?[_.-]
+^telemetry\.;querytype=AAAA
+^(ads|tracker[
+`
+	rules, items, err := convertPiholeRegex(strings.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		`/^(.+[-_.])??adse?rv(er?|ice)?s?[0-9]*[-.]/`,
+		`/^ad([sxv]?[0-9]*|system)[_.-]([^.[:space:]]+\.){1,}|[_.-]ad([sxv]?[0-9]*|system)[_.-]/`,
+		`/(^|\.)doubleclick\.net$/`,
+		`/^analytics?[_.-]/`,
+	}, rules)
+
+	assert.Equal(t, 6, len(items))
+	assert.Equal(t, "skipped", items[4].Status)
+	assert.True(t, strings.Contains(items[4].Reason, ";querytype=AAAA"))
+	assert.Equal(t, 7, items[5].Line)
+	assert.Equal(t, "skipped", items[5].Status)
+	assert.True(t, len(items[5].Reason) != 0)
+}
+
+func TestConvertPiholeCustom(t *testing.T) {
+	data := `192.168.1.10 nas.lan
+192.168.1.11   printer.lan printer # office
+fd00::10 nas6.lan
+nas.lan 192.168.1.10
+10.0.0.1
+`
+	rules, items, err := convertPiholeCustom(strings.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"192.168.1.10 nas.lan",
+		"192.168.1.11 printer.lan printer",
+		"fd00::10 nas6.lan",
+	}, rules)
+
+	assert.Equal(t, 5, len(items))
+	assert.Equal(t, "skipped", items[3].Status)
+	assert.Equal(t, "invalid IP address: nas.lan", items[3].Reason)
+	assert.Equal(t, "skipped", items[4].Status)
+	assert.Equal(t, "no host name", items[4].Reason)
+}
@@ -0,0 +1,150 @@
+package home
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// Convert the internationalized domain names in the rule to punycode.
+// Supported rules: "||domain^..." (with optional "@@" prefix) and hosts-style "IP domain...".
+// Return the new line and TRUE if the line has been changed.
+func punycodeRule(line string) (string, bool, error) {
+	if isASCII(line) {
+		return line, false, nil
+	}
+
+	s := strings.TrimSpace(line)
+	if len(s) == 0 || s[0] == '!' || s[0] == '#' {
+		return line, false, nil
+	}
+
+	prefix := ""
+	if strings.HasPrefix(s, "@@||") {
+		prefix = "@@||"
+	} else if strings.HasPrefix(s, "||") {
+		prefix = "||"
+	}
+	if len(prefix) != 0 {
+		rest := s[len(prefix):]
+		end := strings.IndexAny(rest, "^$/|:*")
+		if end < 0 {
+			end = len(rest)
+		}
+		host := rest[:end]
+		if isASCII(host) {
+			return line, false, nil
+		}
+		ahost, err := idna.Lookup.ToASCII(host)
+		if err != nil {
+			return line, false, fmt.Errorf("%s: %s", host, err)
+		}
+		return prefix + ahost + rest[end:], true, nil
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+		return line, false, nil
+	}
+	changed := false
+	for i := 1; i < len(fields); i++ {
+		if fields[i][0] == '#' {
+			break // the rest is a comment
+		}
+		if isASCII(fields[i]) {
+			continue
+		}
+		ahost, err := idna.Lookup.ToASCII(fields[i])
+		if err != nil {
+			return line, false, fmt.Errorf("%s: %s", fields[i], err)
+		}
+		fields[i] = ahost
+		changed = true
+	}
+	if !changed {
+		return line, false, nil
+	}
+	return strings.Join(fields, " "), true, nil
+}
+
+// Convert the internationalized domain names in the rules from the file to punycode.
+// The lines that can't be converted are left as is.
+// Return the new file (or nil if nothing was changed), the number of converted rules and the list of warnings.
+func convertFileToPunycode(file *os.File) (*os.File, int, []string, error) {
+	_, err := file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	out, err := ioutil.TempFile(filepath.Dir(file.Name()), "")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	defer func() {
+		if out != nil {
+			_ = out.Close()
+			_ = os.Remove(out.Name())
+		}
+	}()
+
+	converted := 0
+	var warnings []string
+	r := bufio.NewReader(file)
+	w := bufio.NewWriter(out)
+	for lineNum := 1; ; lineNum++ {
+		line, err := r.ReadString('\n')
+		if len(line) != 0 {
+			eol := ""
+			if strings.HasSuffix(line, "\n") {
+				eol = "\n"
+			}
+			newLine, changed, perr := punycodeRule(strings.TrimRight(line, "\r\n"))
+			if perr != nil {
+				warnings = append(warnings, fmt.Sprintf("line %d: %s", lineNum, perr))
+			} else if changed {
+				line = newLine + eol
+				converted++
+			}
+
+			_, werr := w.WriteString(line)
+			if werr != nil {
+				return nil, 0, nil, werr
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, nil, err
+		}
+	}
+
+	if converted == 0 {
+		return nil, 0, warnings, nil
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	res := out
+	out = nil
+	return res, converted, warnings, nil
+}
@@ -0,0 +1,68 @@
+package home
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPunycodeRule(t *testing.T) {
+	check := func(in, out string, changed bool) {
+		s, ch, err := punycodeRule(in)
+		assert.Nil(t, err, in)
+		assert.Equal(t, out, s)
+		assert.Equal(t, changed, ch, in)
+	}
+
+	check("||пример.рф^", "||xn--e1afmkfd.xn--p1ai^", true)
+	check("@@||пример.рф^$important", "@@||xn--e1afmkfd.xn--p1ai^$important", true)
+	check("||💩.la^", "||xn--ls8h.la^", true)
+	check("0.0.0.0 пример.рф i❤.ws # comment", "0.0.0.0 xn--e1afmkfd.xn--p1ai xn--i-7iq.ws # comment", true)
+	check("::1 💩.la", "::1 xn--ls8h.la", true)
+	check("||example.org^", "||example.org^", false)
+	check("0.0.0.0 example.org # пример", "0.0.0.0 example.org # пример", false)
+	check("! Title: пример", "! Title: пример", false)
+	check("# пример.рф", "# пример.рф", false)
+	check("/пример/", "/пример/", false)
+
+	_, _, err := punycodeRule("||test_a.рф^")
+	assert.NotNil(t, err)
+	_, _, err = punycodeRule("0.0.0.0 test_a.рф")
+	assert.NotNil(t, err)
+}
+
+func TestConvertFileToPunycode(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	file, err := ioutil.TempFile(dir, "")
+	assert.Nil(t, err)
+	defer file.Close()
+	_, err = file.WriteString("! Title: test\n||пример.рф^\n||test_a.рф^\n0.0.0.0 💩.la\n||example.org^")
+	assert.Nil(t, err)
+
+	out, n, warnings, err := convertFileToPunycode(file)
+	assert.Nil(t, err)
+	assert.NotNil(t, out)
+	defer out.Close()
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 1, len(warnings))
+	assert.Contains(t, warnings[0], "line 3: test_a.рф")
+
+	data, err := ioutil.ReadFile(out.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, "! Title: test\n||xn--e1afmkfd.xn--p1ai^\n||test_a.рф^\n0.0.0.0 xn--ls8h.la\n||example.org^", string(data))
+
+	// nothing to convert
+	file2, err := ioutil.TempFile(dir, "")
+	assert.Nil(t, err)
+	defer file2.Close()
+	_, err = file2.WriteString("||example.org^\n")
+	assert.Nil(t, err)
+	out, n, _, err = convertFileToPunycode(file2)
+	assert.Nil(t, err)
+	assert.Nil(t, out)
+	assert.Equal(t, 0, n)
+}
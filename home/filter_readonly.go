@@ -0,0 +1,49 @@
+package home
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// The read-only filters directory.
+// On some router firmwares the data directory ends up on a read-only overlay after an upgrade:
+//  the existing filter files are loaded, but the filters can't be updated or added.
+// Instead of failing every download, the updates are skipped with a single error message
+//  until the directory becomes writable again.
+
+// Check that the directory is writable: create and remove a probe file (overridden in tests)
+var checkDirWritable = func(dir string) error {
+	file, err := ioutil.TempFile(dir, ".probe")
+	if err != nil {
+		return err
+	}
+	_ = file.Close()
+	return os.Remove(file.Name())
+}
+
+// Check that the filters directory is writable.
+// Return FALSE if it isn't: the filters can't be updated.
+func (f *Filtering) checkFilterDirWritable() bool {
+	dir := filepath.Join(Context.getDataDir(), filterDir)
+	err := checkDirWritable(dir)
+	if err != nil {
+		if atomic.SwapUint32(&f.readOnly, 1) == 0 {
+			log.Error("filter: the filters directory isn't writable: %s.  Filters won't be updated", err)
+		}
+		return false
+	}
+
+	if atomic.SwapUint32(&f.readOnly, 0) == 1 {
+		log.Info("filter: the filters directory %s is writable.  Filters update is resumed", dir)
+	}
+	return true
+}
+
+// Return TRUE if the filters directory isn't writable
+func (f *Filtering) isReadOnly() bool {
+	return atomic.LoadUint32(&f.readOnly) == 1
+}
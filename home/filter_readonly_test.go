@@ -0,0 +1,73 @@
+package home
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+var errTestReadOnly = errors.New("read-only file system")
+
+func TestFilterDirReadOnlyInit(t *testing.T) {
+	cleanup := prepareLazyLoadTest(t, false)
+	defer cleanup()
+	prev := checkDirWritable
+	checkDirWritable = func(string) error { return errTestReadOnly }
+	defer func() { checkDirWritable = prev }()
+
+	// the orphaned file isn't removed
+	orphan := filepath.Join(Context.getDataDir(), filterDir, "100.txt")
+	assert.Nil(t, ioutil.WriteFile(orphan, []byte("||example.org^\n"), 0644))
+
+	assert.Nil(t, Context.filters.Init())
+	assert.True(t, Context.filters.isReadOnly())
+	assert.Equal(t, 2, config.Filters[0].RulesCount)
+	assert.Equal(t, 2, config.WhitelistFilters[0].RulesCount)
+	_, err := os.Stat(orphan)
+	assert.Nil(t, err)
+}
+
+func TestFilterDirReadOnly(t *testing.T) {
+	fs := newFixtureServer()
+	defer fs.Close()
+	h, cleanup := newFiltersHarness(t)
+	defer cleanup()
+	assert.False(t, Context.filters.isReadOnly())
+
+	prev := checkDirWritable
+	checkDirWritable = func(string) error { return errTestReadOnly }
+	defer func() { checkDirWritable = prev }()
+	assert.False(t, Context.filters.checkFilterDirWritable())
+
+	// a filter can't be added
+	fs.set("/list.txt", fixtureResponse{body: fixtureRules("a", 2)})
+	code, body := h.post("/control/filtering/add_url", `{"name":"List","url":"`+fs.URL+`/list.txt"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.True(t, strings.Contains(body, string(msgFilterDirReadOnly)), body)
+	assert.Equal(t, 0, fs.hitCount("/list.txt"))
+
+	// the filters aren't updated
+	config.Filters = []filter{
+		{Enabled: true, URL: fs.URL + "/list.txt", Filter: dnsfilter.Filter{ID: 1}},
+	}
+	code, body = h.post("/control/filtering/refresh", `{"whitelist":false}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, `{"updated":0}`, body)
+	assert.Equal(t, 0, fs.hitCount("/list.txt"))
+	assert.True(t, getStatusForTest(t).ReadOnly)
+
+	// the directory is writable again: the update is resumed
+	checkDirWritable = prev
+	code, body = h.post("/control/filtering/refresh", `{"whitelist":false}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, `{"updated":1}`, body)
+	assert.Equal(t, 1, fs.hitCount("/list.txt"))
+	assert.False(t, getStatusForTest(t).ReadOnly)
+}
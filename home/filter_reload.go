@@ -0,0 +1,94 @@
+package home
+
+import (
+	"time"
+)
+
+// ReloadGate returns the time by which the filtering engine reload should be deferred,
+// e.g. because the DNS server is busy at the moment.
+type ReloadGate func() time.Duration
+
+// SetReloadGate sets the function that is consulted before the filtering engine is reloaded after filters update
+func (f *Filtering) SetReloadGate(gate ReloadGate) {
+	f.reloadLock.Lock()
+	f.reloadGate = gate
+	f.reloadLock.Unlock()
+}
+
+// Get the maximum time a reload may be deferred by
+func reloadMaxDelay() time.Duration {
+	return time.Duration(config.DNS.FiltersReloadMaxDelay) * time.Second
+}
+
+// Reload the filtering engine after filters update.
+// If the reload gate asks to wait, the reload is deferred (but not longer than reloadMaxDelay()).
+// Multiple pending reloads are coalesced into one.
+func (f *Filtering) reloadFilters() {
+	f.reloadLock.Lock()
+	defer f.reloadLock.Unlock()
+
+	if !f.reloadPending {
+		f.reloadFirst = time.Now()
+	}
+	f.scheduleReloadNoLock()
+}
+
+// Note: reloadLock must be held
+func (f *Filtering) scheduleReloadNoLock() {
+	var d time.Duration
+	if f.reloadGate != nil {
+		d = f.reloadGate()
+	}
+
+	now := time.Now()
+	eta := now.Add(d)
+	deadline := f.reloadFirst.Add(reloadMaxDelay())
+	if eta.After(deadline) {
+		eta = deadline
+	}
+
+	if !eta.After(now) {
+		if f.reloadTimer != nil {
+			f.reloadTimer.Stop()
+		}
+		f.reloadPending = false
+		f.reloadETA = time.Time{}
+		f.reload()
+		return
+	}
+
+	f.reloadPending = true
+	f.reloadETA = eta
+	if f.reloadTimer == nil {
+		f.reloadTimer = time.AfterFunc(eta.Sub(now), f.onReloadTimer)
+	} else {
+		f.reloadTimer.Reset(eta.Sub(now))
+	}
+}
+
+func (f *Filtering) onReloadTimer() {
+	f.reloadLock.Lock()
+	defer f.reloadLock.Unlock()
+
+	if !f.reloadPending {
+		return
+	}
+	// ask the gate again: the load may still be high
+	f.scheduleReloadNoLock()
+}
+
+func (f *Filtering) reload() {
+	if f.reloadFunc != nil { // for tests
+		f.reloadFunc()
+		return
+	}
+	enableFilters(false)
+}
+
+// Get the state of the deferred reload
+// Return TRUE and the time of the reload if there's a pending reload
+func (f *Filtering) reloadStatus() (bool, time.Time) {
+	f.reloadLock.Lock()
+	defer f.reloadLock.Unlock()
+	return f.reloadPending, f.reloadETA
+}
@@ -0,0 +1,53 @@
+package home
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadGate(t *testing.T) {
+	maxDelay := config.DNS.FiltersReloadMaxDelay
+	defer func() { config.DNS.FiltersReloadMaxDelay = maxDelay }()
+	config.DNS.FiltersReloadMaxDelay = 1
+
+	f := Filtering{}
+	var reloads int32
+	f.reloadFunc = func() { atomic.AddInt32(&reloads, 1) }
+
+	// no gate: reload immediately
+	f.reloadFilters()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&reloads))
+	pending, _ := f.reloadStatus()
+	assert.False(t, pending)
+
+	// the gate defers the reload
+	var delay int64 = int64(100 * time.Millisecond)
+	f.SetReloadGate(func() time.Duration { return time.Duration(atomic.LoadInt64(&delay)) })
+	f.reloadFilters()
+	f.reloadFilters() // coalesced with the previous one
+	pending, eta := f.reloadStatus()
+	assert.True(t, pending)
+	assert.True(t, eta.After(time.Now()))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&reloads))
+
+	atomic.StoreInt64(&delay, 0)
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&reloads))
+	pending, _ = f.reloadStatus()
+	assert.False(t, pending)
+
+	// the gate always asks to wait: the reload happens after the maximum delay
+	atomic.StoreInt64(&delay, int64(time.Hour))
+	start := time.Now()
+	f.reloadFilters()
+	pending, eta = f.reloadStatus()
+	assert.True(t, pending)
+	assert.True(t, eta.Sub(start) <= time.Second)
+	time.Sleep(1500 * time.Millisecond)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&reloads))
+	pending, _ = f.reloadStatus()
+	assert.False(t, pending)
+}
@@ -0,0 +1,59 @@
+package home
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilteringRemoveAll(t *testing.T) {
+	fs := newFixtureServer()
+	defer fs.Close()
+	h, cleanup := newFiltersHarness(t)
+	defer cleanup()
+
+	fs.set("/1.txt", fixtureResponse{body: fixtureRules("a", 2)})
+	fs.set("/2.txt", fixtureResponse{body: fixtureRules("b", 2)})
+	fs.set("/3.txt", fixtureResponse{body: fixtureRules("c", 2)})
+	for _, req := range []string{
+		`{"name":"1","url":"` + fs.URL + `/1.txt"}`,
+		`{"name":"2","url":"` + fs.URL + `/2.txt"}`,
+		`{"name":"3","url":"` + fs.URL + `/3.txt","whitelist":true}`,
+	} {
+		code, body := h.post("/control/filtering/add_url", req)
+		assert.Equal(t, http.StatusOK, code, body)
+	}
+	h.takeEvents()
+
+	// the configuration is written and the engine is rebuilt once
+	code, body := h.post("/control/filtering/remove_all", `{"type":"blocklist"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, `{"removed":2}`, body)
+	assert.Equal(t, []string{"config", "engine 0/1"}, h.takeEvents())
+	conf := h.conf()
+	assert.Equal(t, 0, len(conf.Filters))
+	assert.Equal(t, 1, len(conf.WhitelistFilters))
+
+	// the removed filters can be restored from the trash
+	assert.Equal(t, 2, len(config.DeletedFilters))
+	code, body = h.post("/control/filtering/restore_url", `{"url":"`+fs.URL+`/1.txt"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, 1, len(config.Filters))
+	h.takeEvents()
+
+	code, body = h.post("/control/filtering/remove_all", `{"type":"allowlist"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, `{"removed":1}`, body)
+
+	// nothing to remove: the configuration isn't written
+	code, body = h.post("/control/filtering/remove_all", `{"type":"allowlist"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, `{"removed":0}`, body)
+	assert.Equal(t, []string{"config", "engine 1/0"}, h.takeEvents())
+
+	// the unknown type is rejected
+	code, body = h.post("/control/filtering/remove_all", `{"type":"whitelist"}`)
+	assert.Equal(t, http.StatusBadRequest, code, body)
+	assert.Equal(t, 1, len(config.Filters))
+}
@@ -0,0 +1,154 @@
+package home
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterMaxRetries(t *testing.T) {
+	// the server fails the first N requests of every path
+	var lock sync.Mutex
+	hits := map[string]int{}
+	failures := map[string]int{"/flaky.txt": 2, "/down.txt": 100, "/default.txt": 100}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		hits[r.URL.Path]++
+		n := hits[r.URL.Path]
+		lock.Unlock()
+		if n <= failures[r.URL.Path] {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("||example.org^\n||example.com^\n"))
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	defer Context.dnsFilter.Close()
+	prevDelay := filterRetryDelay
+	filterRetryDelay = 10 * time.Millisecond
+	prevRetries := config.DNS.FiltersDownloadRetries
+	config.DNS.FiltersDownloadRetries = 0
+	defer func() {
+		filterRetryDelay = prevDelay
+		config.DNS.FiltersDownloadRetries = prevRetries
+		config.Filters = nil
+	}()
+
+	three := uint32(3)
+	one := uint32(1)
+	config.Filters = []filter{
+		{Enabled: true, URL: srv.URL + "/flaky.txt", MaxRetries: &three, Filter: dnsfilter.Filter{ID: 1}},
+		{Enabled: true, URL: srv.URL + "/down.txt", MaxRetries: &one, Filter: dnsfilter.Filter{ID: 2}},
+		{Enabled: true, URL: srv.URL + "/default.txt", Filter: dnsfilter.Filter{ID: 3}},
+	}
+	Context.filters.Init()
+	_, _ = Context.filters.refreshFilters(FilterRefreshBlocklists|FilterRefreshForce, true, updateTriggerManual)
+
+	// success after the retries
+	assert.Equal(t, 3, hits["/flaky.txt"])
+	assert.Equal(t, 2, config.Filters[0].RulesCount)
+	h := Context.filters.filterHistory(srv.URL + "/flaky.txt")
+	if assert.Equal(t, 1, len(h)) {
+		assert.Equal(t, historyResultUpdated, h[0].Result)
+		assert.Equal(t, 2, h[0].Retries)
+	}
+
+	// failure beyond the cap
+	assert.Equal(t, 2, hits["/down.txt"])
+	h = Context.filters.filterHistory(srv.URL + "/down.txt")
+	if assert.Equal(t, 1, len(h)) {
+		assert.Equal(t, historyResultFailed, h[0].Result)
+		assert.Equal(t, 1, h[0].Retries)
+	}
+
+	// the global number of retries is used by default
+	assert.Equal(t, 1, hits["/default.txt"])
+
+	cycles := Context.filters.updateCycles()
+	if assert.Equal(t, 1, len(cycles)) {
+		assert.Equal(t, 3, cycles[0].Retries)
+	}
+	assert.Equal(t, uint64(2), Context.filters.updateCounters(1).retries)
+	assert.Equal(t, uint64(1), Context.filters.updateCounters(2).retries)
+	assert.Equal(t, uint64(0), Context.filters.updateCounters(3).retries)
+
+	w := httptest.NewRecorder()
+	Context.filters.HandleMetrics(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.True(t, strings.Contains(w.Body.String(), `filter_download_retries_total{id="1",url="`+srv.URL+`/flaky.txt",list="blocklist"} 2`+"\n"))
+
+	// the retry delay is cancelled with the context
+	filterRetryDelay = 10 * time.Second
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := filterGet(withDownloadRetries(ctx, &downloadRetries{max: 5}), srv.URL+"/down.txt", true)
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestFilterGetRetryDelay(t *testing.T) {
+	prevDelay := filterRetryDelay
+	filterRetryDelay = time.Second
+	defer func() { filterRetryDelay = prevDelay }()
+
+	assert.Equal(t, 1*time.Second, filterGetRetryDelay(1))
+	assert.Equal(t, 2*time.Second, filterGetRetryDelay(2))
+	assert.Equal(t, 4*time.Second, filterGetRetryDelay(3))
+	assert.Equal(t, 16*time.Second, filterGetRetryDelay(5))
+	assert.Equal(t, maxFilterRetryDelay, filterGetRetryDelay(6))
+	assert.Equal(t, maxFilterRetryDelay, filterGetRetryDelay(maxFilterDownloadRetries))
+}
+
+func TestFilterChangeURLMaxRetries(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("||example.org^\n"))
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	five := uint32(5)
+	config.Filters = []filter{
+		{Enabled: true, URL: srv.URL + "/1.txt", Name: "1", MaxRetries: &five, Filter: dnsfilter.Filter{ID: 1}},
+	}
+	defer func() { config.Filters = nil }()
+	Context.filters.Init()
+
+	// the per-filter number of retries is kept
+	err := Context.filters.filterChangeURL(context.Background(), srv.URL+"/1.txt", filter{URL: srv.URL + "/2.txt", Name: "2"}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, srv.URL+"/2.txt", config.Filters[0].URL)
+	if assert.NotNil(t, config.Filters[0].MaxRetries) {
+		assert.Equal(t, uint32(5), *config.Filters[0].MaxRetries)
+	}
+
+	// it's replaced if it's set
+	zero := uint32(0)
+	err = Context.filters.filterChangeURL(context.Background(), srv.URL+"/2.txt", filter{URL: srv.URL + "/3.txt", Name: "3", MaxRetries: &zero}, false)
+	assert.Nil(t, err)
+	if assert.NotNil(t, config.Filters[0].MaxRetries) {
+		assert.Equal(t, uint32(0), *config.Filters[0].MaxRetries)
+	}
+}
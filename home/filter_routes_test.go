@@ -0,0 +1,116 @@
+package home
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// The routes of the filtering handlers: a new handler must be added here and to openapi.yaml
+var filteringRoutes = []string{
+	"GET /control/filtering/check_host",
+	"GET /control/filtering/disk_usage",
+	"GET /control/filtering/export",
+	"GET /control/filtering/filter",
+	"GET /control/filtering/history",
+	"GET /control/filtering/metrics",
+	"GET /control/filtering/search_rules",
+	"GET /control/filtering/selftest",
+	"GET /control/filtering/serve/allowlist",
+	"GET /control/filtering/serve/allowlist.sha256",
+	"GET /control/filtering/serve/blocklist",
+	"GET /control/filtering/serve/blocklist.sha256",
+	"GET /control/filtering/status",
+	"GET /control/filtering/update_cycles",
+	"GET /control/filtering/update_status",
+	"GET /control/filtering/user_groups",
+	"POST /control/filtering/add_url",
+	"POST /control/filtering/add_urls",
+	"POST /control/filtering/check_hosts",
+	"POST /control/filtering/check_url",
+	"POST /control/filtering/config",
+	"POST /control/filtering/import",
+	"POST /control/filtering/import_etc_hosts",
+	"POST /control/filtering/import_pihole",
+	"POST /control/filtering/refresh",
+	"POST /control/filtering/remove_all",
+	"POST /control/filtering/remove_url",
+	"POST /control/filtering/restore_url",
+	"POST /control/filtering/set_enabled",
+	"POST /control/filtering/set_order",
+	"POST /control/filtering/set_rules",
+	"POST /control/filtering/test_rule",
+	"POST /control/filtering/updates_pause",
+	"POST /control/filtering/user_groups",
+}
+
+// Get the differences between the lists: "-" the expected items that are missing, "+" the unexpected items
+func routesDiff(expected, actual []string) string {
+	exp := map[string]bool{}
+	for _, s := range expected {
+		exp[s] = true
+	}
+	act := map[string]bool{}
+	for _, s := range actual {
+		act[s] = true
+	}
+
+	var diff []string
+	for _, s := range expected {
+		if !act[s] {
+			diff = append(diff, "- "+s)
+		}
+	}
+	for _, s := range actual {
+		if !exp[s] {
+			diff = append(diff, "+ "+s)
+		}
+	}
+	sort.Strings(diff)
+	return strings.Join(diff, "\n")
+}
+
+func TestFilteringRoutes(t *testing.T) {
+	h, cleanup := newFiltersHarness(t)
+	defer cleanup()
+
+	var routes []string
+	seen := map[string]bool{}
+	Context.filters.registerHandlers(func(method, url string, handler func(http.ResponseWriter, *http.Request)) {
+		r := method + " " + url
+		assert.False(t, seen[r], "duplicate route: %s", r)
+		seen[r] = true
+		routes = append(routes, r)
+	})
+	assert.Equal(t, "", routesDiff(filteringRoutes, routes), "the registered routes differ from the expected ones")
+
+	// the system hosts file isn't imported
+	hostsPath := filepath.Join(Context.workDir, "hosts")
+	assert.Nil(t, ioutil.WriteFile(hostsPath, nil, 0644))
+	prevHostsPath := systemHostsPath
+	systemHostsPath = func() string { return hostsPath }
+	defer func() { systemHostsPath = prevHostsPath }()
+
+	// the network isn't used
+	prevSelfTestURL := selfTestURL
+	selfTestURL = h.web.URL + "/control/filtering/status"
+	defer func() { selfTestURL = prevSelfTestURL }()
+
+	// every route responds to a request with an empty body:
+	//  the request is either served or rejected with 400 (e.g. a required field is missing)
+	for _, r := range routes {
+		parts := strings.SplitN(r, " ", 2)
+		body := ""
+		if parts[0] == "POST" {
+			body = "{}"
+		}
+		code, resp := h.do(parts[0], parts[1], body)
+		assert.True(t, code == http.StatusOK || code == http.StatusBadRequest,
+			"%s: %d: %s", r, code, resp)
+	}
+}
@@ -0,0 +1,67 @@
+package home
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+)
+
+// A filter may be applied only to the clients that have received their addresses from our DHCP server
+//  (e.g. LAN devices) or only to the other clients (e.g. VPN clients).
+// The filtering engine has a separate set of engines for each group of clients,
+//  so the rules of the filters that aren't applied to the client are never matched.
+
+// The clients the filter rules are applied to ("apply_to")
+const (
+	applyToAll            = "all" // stored as ""
+	applyToDHCPClients    = "dhcp_clients"
+	applyToNonDHCPClients = "non_dhcp_clients"
+)
+
+// Check the scope of a filter
+func checkApplyTo(s string) bool {
+	switch s {
+	case "", applyToAll, applyToDHCPClients, applyToNonDHCPClients:
+		return true
+	}
+	return false
+}
+
+// Get the scope of a filter as it's stored in the configuration
+func normalizeApplyTo(s string) string {
+	if s == applyToAll {
+		return ""
+	}
+	return s
+}
+
+// Get the group of the client for the filtering engine (see dnsfilter.Config.ClientGroups).
+// "" is returned if there are no scoped filters: all filters are applied to the client.
+func (f *Filtering) clientGroup(ip net.IP) string {
+	if atomic.LoadUint32(&f.scoped) == 0 {
+		return "" // don't search for the client in the DHCP leases
+	}
+	if isDHCPClient(ip) {
+		return applyToDHCPClients
+	}
+	return applyToNonDHCPClients
+}
+
+// Remember whether the filters passed to the filtering engine are scoped
+func (f *Filtering) setScoped(filters, whiteFilters []dnsfilter.Filter) {
+	scoped := uint32(0)
+	for _, list := range [][]dnsfilter.Filter{filters, whiteFilters} {
+		for _, filt := range list {
+			if len(filt.ClientGroup) != 0 {
+				scoped = 1
+			}
+		}
+	}
+	atomic.StoreUint32(&f.scoped, scoped)
+}
+
+// Return TRUE if the client has received its address from our DHCP server
+func isDHCPClient(ip net.IP) bool {
+	return Context.dhcpServer != nil && ip != nil && Context.dhcpServer.FindMACbyIP(ip) != nil
+}
@@ -0,0 +1,122 @@
+package home
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterScope(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.configFilename = "AdGuardHome.yaml"
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{
+		ClientGroups: []string{applyToDHCPClients, applyToNonDHCPClients},
+	}, nil)
+	Context.dnsFilter.Start()
+	defer Context.dnsFilter.Close()
+	config.DNS.FilteringEnabled = true
+	config.Filters = []filter{
+		{Enabled: true, URL: "https://example.org/1.txt", Filter: dnsfilter.Filter{ID: 1}},
+		{Enabled: true, URL: "https://example.org/2.txt", ApplyTo: applyToDHCPClients, Filter: dnsfilter.Filter{ID: 2}},
+		{Enabled: true, URL: "https://example.org/3.txt", ApplyTo: applyToNonDHCPClients, Filter: dnsfilter.Filter{ID: 3}},
+		{Enabled: false, URL: "https://example.org/4.txt", ApplyTo: applyToDHCPClients, Filter: dnsfilter.Filter{ID: 4}},
+	}
+	config.WhitelistFilters = []filter{
+		{Enabled: true, URL: "https://example.org/10.txt", ApplyTo: applyToNonDHCPClients, Filter: dnsfilter.Filter{ID: 10}},
+	}
+	defer func() {
+		config.DNS.FilteringEnabled = false
+		config.Filters = nil
+		config.WhitelistFilters = nil
+	}()
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "data", filterDir), 0755))
+	files := map[string]string{
+		"1":  "||example.org^\n",
+		"2":  "@@||example.org^\n||example.com^\n",
+		"3":  "||example.net^\n",
+		"4":  "||example.info^\n",
+		"10": "||example.net^\n",
+	}
+	for id, data := range files {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "data", filterDir, id+".txt"), []byte(data), 0644))
+	}
+	assert.Nil(t, Context.filters.Init())
+	enableFilters(false)
+
+	check := func(host, client string) dnsfilter.Result {
+		setts := Context.dnsFilter.GetConfig()
+		setts.FilteringEnabled = true
+		applyAdditionalFiltering(client, &setts)
+		res, err := Context.dnsFilter.CheckHost(host, dns.TypeA, &setts)
+		assert.Nil(t, err)
+		return res
+	}
+
+	// there's no DHCP server: the client gets the filters for non-DHCP clients
+	ip := net.ParseIP("192.168.1.2")
+	assert.Equal(t, applyToNonDHCPClients, Context.filters.clientGroup(ip))
+
+	// the exception rule from the filter for DHCP clients doesn't hide the blocking rule for the same host
+	res := check("example.org", "192.168.1.2")
+	assert.True(t, res.IsFiltered)
+	assert.Equal(t, int64(1), res.FilterID)
+	assert.False(t, check("example.com", "192.168.1.2").IsFiltered)
+	assert.Equal(t, dnsfilter.NotFilteredWhiteList, check("example.net", "192.168.1.2").Reason)
+
+	// all filters are applied if the client isn't specified
+	assert.Equal(t, dnsfilter.NotFilteredWhiteList, check("example.org", "").Reason)
+	assert.True(t, check("example.com", "").IsFiltered)
+	assert.False(t, check("example.info", "").IsFiltered)
+
+	// the scope is changed with set_url: the cached sets are dropped
+	w := httptest.NewRecorder()
+	body := `{"url":"https://example.org/3.txt","whitelist":false,
+		"data":{"url":"https://example.org/3.txt","name":"3","enabled":true,"apply_to":"all"}}`
+	Context.filters.handleFilteringSetURL(w, httptest.NewRequest("POST", "/control/filtering/set_url", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "", config.Filters[2].ApplyTo)
+	assert.Equal(t, "3", config.Filters[2].Name)
+
+	// the scope isn't changed if it isn't set
+	w = httptest.NewRecorder()
+	body = `{"url":"https://example.org/2.txt","whitelist":false,
+		"data":{"url":"https://example.org/2.txt","name":"2","enabled":true}}`
+	Context.filters.handleFilteringSetURL(w, httptest.NewRequest("POST", "/control/filtering/set_url", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, applyToDHCPClients, config.Filters[1].ApplyTo)
+
+	w = httptest.NewRecorder()
+	body = `{"url":"https://example.org/2.txt","whitelist":false,
+		"data":{"url":"https://example.org/2.txt","name":"2","enabled":true,"apply_to":"vpn_clients"}}`
+	Context.filters.handleFilteringSetURL(w, httptest.NewRequest("POST", "/control/filtering/set_url", strings.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// the scope is shown in the status
+	w = httptest.NewRecorder()
+	Context.filters.handleFilteringStatus(w, httptest.NewRequest("GET", "/control/filtering/status", nil))
+	resp := filteringConfig{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, applyToAll, resp.Filters[0].ApplyTo)
+	assert.Equal(t, applyToDHCPClients, resp.Filters[1].ApplyTo)
+	assert.Equal(t, applyToNonDHCPClients, resp.WhitelistFilters[0].ApplyTo)
+
+	// there are no scoped filters: the client isn't searched among the DHCP clients
+	config.Filters[1].ApplyTo = ""
+	config.WhitelistFilters[0].ApplyTo = ""
+	enableFilters(false)
+	assert.Equal(t, "", Context.filters.clientGroup(ip))
+	assert.Equal(t, dnsfilter.NotFilteredWhiteList, check("example.org", "192.168.1.2").Reason)
+}
@@ -0,0 +1,108 @@
+package home
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+// The self-test of the filters subsystem.
+// The proxy, DNS and permission problems otherwise show up only as the failed updates:
+//  the self-test checks that the filter lists can be downloaded and stored.
+
+// The URL requested by the network check (overridden in tests)
+var selfTestURL = defaultFilters()[0].URL
+
+// How long the network check may take
+const selfTestTimeout = 10 * time.Second
+
+// The names of the self-test checks
+const (
+	selfTestNetwork  = "network"   // a filter list can be requested with the configured HTTP client and proxy
+	selfTestWritable = "dir_write" // the filters directory is writable
+)
+
+// selfTestCheck is the result of a self-test check
+type selfTestCheck struct {
+	Name     string `json:"name"` // selfTest*
+	OK       bool   `json:"ok"`
+	Detail   string `json:"detail"`
+	Duration int64  `json:"duration_ms"`
+}
+
+type selfTestJSON struct {
+	OK     bool            `json:"ok"` // TRUE if all checks have passed
+	Checks []selfTestCheck `json:"checks"`
+}
+
+// Send HEAD request for the known-good filter list
+func selfTestNetworkCheck(ctx context.Context) (c selfTestCheck) {
+	c.Name = selfTestNetwork
+	start := time.Now()
+	defer func() { c.Duration = time.Since(start).Milliseconds() }()
+
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "HEAD", selfTestURL, nil)
+	if err != nil {
+		c.Detail = err.Error()
+		return c
+	}
+	resp, err := filterDo(req, true)
+	if err != nil {
+		c.Detail = fmt.Sprintf("HEAD %s: %s", selfTestURL, err)
+		return c
+	}
+	_ = resp.Body.Close()
+	c.OK = resp.StatusCode < 400
+	c.Detail = fmt.Sprintf("HEAD %s: %s", selfTestURL, resp.Status)
+	return c
+}
+
+// Create and remove a probe file in the filters directory
+func selfTestWritableCheck() selfTestCheck {
+	c := selfTestCheck{Name: selfTestWritable}
+	start := time.Now()
+	dir := filepath.Join(Context.getDataDir(), filterDir)
+	err := checkDirWritable(dir)
+	c.Duration = time.Since(start).Milliseconds()
+	if err != nil {
+		c.Detail = err.Error()
+		return c
+	}
+	c.OK = true
+	c.Detail = dir
+	return c
+}
+
+// Run the self-test of the filters subsystem.
+// The response is 200 OK even if some checks have failed.
+func (f *Filtering) handleSelfTest(w http.ResponseWriter, r *http.Request) {
+	// the other requests aren't blocked by the network check
+	ctx, cancel := f.withContext(r.Context())
+	Context.controlLock.Unlock()
+	resp := selfTestJSON{
+		Checks: []selfTestCheck{
+			selfTestNetworkCheck(ctx),
+			selfTestWritableCheck(),
+		},
+	}
+	Context.controlLock.Lock()
+	cancel()
+
+	resp.OK = true
+	for _, c := range resp.Checks {
+		resp.OK = resp.OK && c.OK
+	}
+
+	js, err := json.Marshal(resp)
+	if err != nil {
+		f.httpErrorMsg(w, r, http.StatusInternalServerError, msgJSONEncode, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(js)
+}
@@ -0,0 +1,55 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilteringSelfTest(t *testing.T) {
+	fs := newFixtureServer()
+	defer fs.Close()
+	h, cleanup := newFiltersHarness(t)
+	defer cleanup()
+
+	prevURL := selfTestURL
+	selfTestURL = fs.URL + "/list.txt"
+	defer func() { selfTestURL = prevURL }()
+
+	selfTest := func() selfTestJSON {
+		code, body := h.get("/control/filtering/selftest")
+		assert.Equal(t, http.StatusOK, code, body)
+		resp := selfTestJSON{}
+		assert.Nil(t, json.Unmarshal([]byte(body), &resp), body)
+		assert.Equal(t, 2, len(resp.Checks))
+		return resp
+	}
+
+	fs.set("/list.txt", fixtureResponse{body: fixtureRules("a", 2)})
+	resp := selfTest()
+	assert.True(t, resp.OK)
+	assert.Equal(t, selfTestNetwork, resp.Checks[0].Name)
+	assert.True(t, resp.Checks[0].OK)
+	assert.True(t, strings.HasSuffix(resp.Checks[0].Detail, "200 OK"), resp.Checks[0].Detail)
+	assert.Equal(t, selfTestWritable, resp.Checks[1].Name)
+	assert.True(t, resp.Checks[1].OK)
+	assert.Equal(t, 1, fs.hitCount("/list.txt"))
+
+	// the server error and the read-only directory
+	fs.set("/list.txt", fixtureResponse{status: http.StatusServiceUnavailable})
+	prev := checkDirWritable
+	checkDirWritable = func(string) error { return errTestReadOnly }
+	defer func() { checkDirWritable = prev }()
+	resp = selfTest()
+	assert.False(t, resp.OK)
+	assert.False(t, resp.Checks[0].OK)
+	assert.True(t, strings.HasSuffix(resp.Checks[0].Detail, "503 Service Unavailable"), resp.Checks[0].Detail)
+	assert.False(t, resp.Checks[1].OK)
+	assert.Equal(t, errTestReadOnly.Error(), resp.Checks[1].Detail)
+
+	// the self-test doesn't change the state of the module
+	assert.False(t, Context.filters.isReadOnly())
+}
@@ -0,0 +1,231 @@
+package home
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// The merged filter lists are served over HTTP so that the other instances ("satellites")
+//  don't have to download every filter themselves.
+// The merge order is fixed, it doesn't depend on the order of the filters in the list:
+//  the rules of the enabled user rule groups (blocklist only),
+//  then the enabled filters sorted by ID.
+// Every part starts with a header line:
+//  "! User rules: <group name>" or "! Filter <id>: <URL>"
+// The merged list is cached until its parts are changed,
+//  so the content, its ETag and its SHA-256 digest always come from the same snapshot.
+
+// The names of the served lists
+const (
+	serveBlocklist = "blocklist"
+	serveAllowlist = "allowlist"
+)
+
+// servedETag is the last served ETag of a list, it's stored in the state file
+//  so that the satellites' caches stay valid after a restart
+type servedETag struct {
+	ETag     string    `json:"etag"`
+	Modified time.Time `json:"modified"` // when the content with this ETag was served for the first time
+}
+
+// A part of the merged list
+type servePart struct {
+	header string
+	path   string // the filter file; empty for user rules
+	data   []byte
+}
+
+// serveSnapshot is a merged list
+type serveSnapshot struct {
+	key    string // identifies the parts the list has been merged from
+	data   []byte
+	etag   string
+	digest string // SHA-256 of data (hex)
+}
+
+// Get the parts of the merged list
+func serveParts(whitelist bool) []servePart {
+	var parts []servePart
+
+	config.RLock()
+	if !whitelist {
+		for _, g := range config.UserRuleGroups {
+			if !g.Enabled {
+				continue
+			}
+			parts = append(parts, servePart{
+				header: "! User rules: " + g.Name,
+				data:   []byte(strings.Join(g.Rules, "\n")),
+			})
+		}
+	}
+	filters := config.Filters
+	if whitelist {
+		filters = config.WhitelistFilters
+	}
+	var enabled []filter
+	for _, filt := range filters {
+		if filt.Enabled {
+			enabled = append(enabled, filt)
+		}
+	}
+	config.RUnlock()
+
+	sort.Slice(enabled, func(i, j int) bool { return enabled[i].ID < enabled[j].ID })
+	for _, filt := range enabled {
+		parts = append(parts, servePart{
+			header: fmt.Sprintf("! Filter %d: %s", filt.ID, filt.URL),
+			path:   filt.Path(),
+		})
+	}
+	return parts
+}
+
+// Get the key of the parts: the snapshot is valid as long as the key is the same.
+// The filter files are identified by their size and modification time.
+func servePartsKey(parts []servePart) string {
+	h := sha256.New()
+	for _, p := range parts {
+		_, _ = fmt.Fprintf(h, "%s\n", p.header)
+		if len(p.path) == 0 {
+			_, _ = fmt.Fprintf(h, "%d\n", len(p.data))
+			_, _ = h.Write(p.data)
+			continue
+		}
+		st, err := os.Stat(p.path)
+		if err != nil {
+			_, _ = fmt.Fprintf(h, "-\n")
+			continue
+		}
+		_, _ = fmt.Fprintf(h, "%d %d\n", st.Size(), st.ModTime().UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get the merged list and its ETag.
+// ETag is computed from the sorted hashes of the parts
+//  so it stays the same as long as the content is the same.
+func mergeParts(parts []servePart) ([]byte, string) {
+	buf := bytes.Buffer{}
+	var hashes []string
+	for _, p := range parts {
+		if len(p.path) != 0 {
+			data, err := ioutil.ReadFile(p.path)
+			if err != nil {
+				log.Debug("filter: serve: %s", err)
+				// the filter hasn't been downloaded yet: serve only its header
+			}
+			p.data = data
+		}
+
+		start := buf.Len()
+		buf.WriteString(p.header)
+		buf.WriteByte('\n')
+		buf.Write(p.data)
+		if len(p.data) != 0 && p.data[len(p.data)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+		sum := sha256.Sum256(buf.Bytes()[start:])
+		hashes = append(hashes, hex.EncodeToString(sum[:]))
+	}
+
+	sort.Strings(hashes)
+	sum := sha256.Sum256([]byte(strings.Join(hashes, "\n")))
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	return buf.Bytes(), etag
+}
+
+// Get the merged list: the cached snapshot is used if the parts haven't been changed
+func (f *Filtering) serveSnapshot(name string) *serveSnapshot {
+	parts := serveParts(name == serveAllowlist)
+	key := servePartsKey(parts)
+
+	f.serveLock.Lock()
+	defer f.serveLock.Unlock()
+	s, ok := f.serveSnapshots[name]
+	if ok && s.key == key {
+		return s
+	}
+
+	s = &serveSnapshot{key: key}
+	s.data, s.etag = mergeParts(parts)
+	sum := sha256.Sum256(s.data)
+	s.digest = hex.EncodeToString(sum[:])
+	if f.serveSnapshots == nil {
+		f.serveSnapshots = map[string]*serveSnapshot{}
+	}
+	f.serveSnapshots[name] = s
+	return s
+}
+
+// Get the time when the content with this ETag was served for the first time.
+// The new ETag is stored in the state file.
+func (f *Filtering) servedModified(name, etag string) time.Time {
+	f.stateLock.Lock()
+	defer f.stateLock.Unlock()
+
+	s, ok := f.state.Served[name]
+	if ok && s.ETag == etag {
+		return s.Modified
+	}
+
+	s = servedETag{
+		ETag:     etag,
+		Modified: f.timeNow().UTC().Truncate(time.Second),
+	}
+	if f.state.Served == nil {
+		f.state.Served = map[string]servedETag{}
+	}
+	f.state.Served[name] = s
+	f.saveStateNoLock()
+	return s.Modified
+}
+
+// Serve the merged list.
+// The conditional requests (If-None-Match, If-Modified-Since) are supported.
+// X-Content-SHA256 header is the SHA-256 digest of the whole content.
+func (f *Filtering) handleServe(w http.ResponseWriter, r *http.Request, name string) {
+	s := f.serveSnapshot(name)
+	modified := f.servedModified(name, s.etag)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("ETag", s.etag)
+	w.Header().Set("X-Content-SHA256", s.digest)
+	http.ServeContent(w, r, "", modified, bytes.NewReader(s.data))
+}
+
+// Serve the SHA-256 digest (hex) of the merged list.
+// ETag header is the ETag of the list the digest has been computed for.
+func (f *Filtering) handleServeDigest(w http.ResponseWriter, r *http.Request, name string) {
+	s := f.serveSnapshot(name)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("ETag", s.etag)
+	_, _ = w.Write([]byte(s.digest + "\n"))
+}
+
+func (f *Filtering) handleServeBlocklist(w http.ResponseWriter, r *http.Request) {
+	f.handleServe(w, r, serveBlocklist)
+}
+
+func (f *Filtering) handleServeAllowlist(w http.ResponseWriter, r *http.Request) {
+	f.handleServe(w, r, serveAllowlist)
+}
+
+func (f *Filtering) handleServeBlocklistDigest(w http.ResponseWriter, r *http.Request) {
+	f.handleServeDigest(w, r, serveBlocklist)
+}
+
+func (f *Filtering) handleServeAllowlistDigest(w http.ResponseWriter, r *http.Request) {
+	f.handleServeDigest(w, r, serveAllowlist)
+}
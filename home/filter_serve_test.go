@@ -0,0 +1,159 @@
+package home
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+func getServedBlocklist(etag string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/control/filtering/serve/blocklist", nil)
+	if len(etag) != 0 {
+		r.Header.Set("If-None-Match", etag)
+	}
+	Context.filters.handleServeBlocklist(w, r)
+	return w
+}
+
+func TestServeMerged(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	config.Filters = []filter{
+		{Enabled: true, URL: "https://example.org/2.txt", Filter: dnsfilter.Filter{ID: 2}},
+		{Enabled: false, URL: "https://example.org/3.txt", Filter: dnsfilter.Filter{ID: 3}},
+		{Enabled: true, URL: "https://example.org/1.txt", Filter: dnsfilter.Filter{ID: 1}},
+	}
+	config.UserRuleGroups = []userRuleGroup{
+		{Name: "default", Enabled: true, Rules: []string{"||user.org^"}},
+		{Name: "disabled", Enabled: false, Rules: []string{"||disabled.org^"}},
+	}
+	defer func() {
+		config.Filters = nil
+		config.UserRuleGroups = nil
+	}()
+	Context.filters.Init()
+	assert.Nil(t, ioutil.WriteFile(config.Filters[0].Path(), []byte("||two.org^\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(config.Filters[1].Path(), []byte("||three.org^\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(config.Filters[2].Path(), []byte("||one.org^"), 0644))
+
+	w := getServedBlocklist("")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `! User rules: default
+||user.org^
+! Filter 1: https://example.org/1.txt
+||one.org^
+! Filter 2: https://example.org/2.txt
+||two.org^
+`, w.Body.String())
+	etag := w.Header().Get("ETag")
+	modified := w.Header().Get("Last-Modified")
+	assert.NotEqual(t, "", etag)
+	assert.NotEqual(t, "", modified)
+
+	// the order of the filters in the list doesn't matter
+	config.Filters[0], config.Filters[2] = config.Filters[2], config.Filters[0]
+	w = getServedBlocklist("")
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+
+	// the client has the same content
+	w = getServedBlocklist(etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// ETag and Last-Modified are the same after a restart
+	Context.filters.Close()
+	Context.filters = Filtering{}
+	Context.filters.Init()
+	w = getServedBlocklist("")
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+	assert.Equal(t, modified, w.Header().Get("Last-Modified"))
+	w = getServedBlocklist(etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// the content has been changed
+	assert.Nil(t, ioutil.WriteFile(config.Filters[0].Path(), []byte("||one.org^\n||one.net^\n"), 0644))
+	w = getServedBlocklist(etag)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+
+	// the allowlist is empty
+	w = httptest.NewRecorder()
+	Context.filters.handleServeAllowlist(w, httptest.NewRequest("GET", "/control/filtering/serve/allowlist", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "", w.Body.String())
+}
+
+func TestServeDigest(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	config.Filters = []filter{
+		{Enabled: true, URL: "https://example.org/1.txt", Filter: dnsfilter.Filter{ID: 1}},
+	}
+	defer func() { config.Filters = nil }()
+	Context.filters.Init()
+	path := config.Filters[0].Path()
+	assert.Nil(t, ioutil.WriteFile(path, []byte("||one.org^\n"), 0644))
+
+	// the filter is updated (the file is replaced) while the satellites fetch the list and its digest
+	stop := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			data := []byte(strings.Repeat("||one.org^\n", i%7+1))
+			_ = ioutil.WriteFile(path+".tmp", data, 0644)
+			_ = os.Rename(path+".tmp", path)
+		}
+	}()
+
+	digests := map[string]string{} // ETag -> digest
+	for i := 0; i < 200; i++ {
+		w := getServedBlocklist("")
+		assert.Equal(t, http.StatusOK, w.Code)
+		sum := sha256.Sum256(w.Body.Bytes())
+		digest := hex.EncodeToString(sum[:])
+		assert.Equal(t, digest, w.Header().Get("X-Content-SHA256"))
+		etag := w.Header().Get("ETag")
+		if d, ok := digests[etag]; ok {
+			assert.Equal(t, d, digest)
+		}
+		digests[etag] = digest
+
+		w = httptest.NewRecorder()
+		Context.filters.handleServeBlocklistDigest(w, httptest.NewRequest("GET", "/control/filtering/serve/blocklist.sha256", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		digest = strings.TrimSpace(w.Body.String())
+		assert.Equal(t, 64, len(digest))
+		etag = w.Header().Get("ETag")
+		if d, ok := digests[etag]; ok {
+			assert.Equal(t, d, digest)
+		}
+		digests[etag] = digest
+	}
+	close(stop)
+	<-done
+
+	// the digest corresponds to the content when nothing is changed
+	w := getServedBlocklist("")
+	d := httptest.NewRecorder()
+	Context.filters.handleServeBlocklistDigest(d, httptest.NewRequest("GET", "/control/filtering/serve/blocklist.sha256", nil))
+	assert.Equal(t, w.Header().Get("X-Content-SHA256")+"\n", d.Body.String())
+	assert.Equal(t, w.Header().Get("ETag"), d.Header().Get("ETag"))
+}
@@ -0,0 +1,76 @@
+package home
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The shared downloads (filters_shared_downloads).
+// The same list may be used by several filters, e.g. as a blocklist and as an allowlist
+//  (the URLs of the filters may differ in the host name case, the default port or the fragment).
+// During an update the list is downloaded only once: the other filters get the data from the file of the first one.
+// Every filter still has its own file, so removing one of the filters doesn't affect the others.
+
+// sharedDownloads is the set of the lists downloaded during an update
+type sharedDownloads struct {
+	files map[string]string // the key of the list -> the file with its data
+}
+
+func newSharedDownloads() *sharedDownloads {
+	return &sharedDownloads{files: map[string]string{}}
+}
+
+// Get the normalized form of the filter URL:
+//  the scheme and the host name are lower-cased, the default port and the fragment are removed
+func normalizeFilterURL(rawurl string) string {
+	if isLocalFilterURL(rawurl) || isDataURL(rawurl) {
+		return rawurl
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if strings.IndexByte(host, ':') >= 0 {
+		host = "[" + host + "]"
+	}
+	u.Host = host
+	if len(port) != 0 {
+		u.Host += ":" + port
+	}
+	u.Fragment = ""
+	return u.String()
+}
+
+// Get the key of the filter's list.
+// The data of a trusted filter isn't shared with an untrusted one: its destination hasn't been checked.
+func sharedDownloadKey(filt *filter) string {
+	return strconv.FormatBool(filt.Trusted) + " " + normalizeFilterURL(filt.URL)
+}
+
+// Get the file with the data of the filter's list if it has been downloaded during this update.
+// Only the remote lists are shared.
+func (sd *sharedDownloads) get(filt *filter) (string, bool) {
+	if sd == nil || isLocalFilterURL(filt.URL) || isDataURL(filt.URL) {
+		return "", false
+	}
+	path, ok := sd.files[sharedDownloadKey(filt)]
+	return path, ok
+}
+
+// Remember the file with the data of the filter's list after it has been successfully downloaded
+func (sd *sharedDownloads) add(filt *filter) {
+	if sd == nil || isLocalFilterURL(filt.URL) || isDataURL(filt.URL) {
+		return
+	}
+	key := sharedDownloadKey(filt)
+	if _, ok := sd.files[key]; !ok {
+		sd.files[key] = filt.Path()
+	}
+}
@@ -0,0 +1,68 @@
+package home
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeFilterURL(t *testing.T) {
+	assert.Equal(t, "https://example.org/list.txt", normalizeFilterURL("HTTPS://Example.ORG:443/list.txt#top"))
+	assert.Equal(t, "http://example.org/list.txt", normalizeFilterURL("http://example.org:80/list.txt"))
+	assert.Equal(t, "http://example.org:8080/List.txt", normalizeFilterURL("http://example.org:8080/List.txt"))
+	assert.Equal(t, "http://[::1]:8080/list.txt", normalizeFilterURL("http://[::1]:8080/list.txt"))
+	assert.Equal(t, "file:///etc/rules.txt", normalizeFilterURL("file:///etc/rules.txt"))
+}
+
+func TestSharedDownloads(t *testing.T) {
+	fs := newFixtureServer()
+	defer fs.Close()
+	_, cleanup := newFiltersHarness(t)
+	defer cleanup()
+	defer func() { config.DNS.FiltersSharedDownloads = false }()
+
+	fs.set("/list.txt", fixtureResponse{body: fixtureRules("a", 2)})
+	// the same list is used as a blocklist and as an allowlist
+	url := fs.URL + "/list.txt"
+	config.Filters = []filter{
+		{Enabled: true, URL: url, Filter: dnsfilter.Filter{ID: 1}},
+	}
+	config.WhitelistFilters = []filter{
+		{Enabled: true, URL: strings.Replace(url, "http://", "HTTP://", 1) + "#allow", Filter: dnsfilter.Filter{ID: 2}},
+	}
+	refresh := func() {
+		_, _ = Context.filters.refreshFilters(FilterRefreshBlocklists|FilterRefreshAllowlists|FilterRefreshForce, true, updateTriggerManual)
+	}
+
+	// the list is downloaded for every filter
+	refresh()
+	assert.Equal(t, 2, fs.hitCount("/list.txt"))
+
+	// the list is downloaded once
+	config.DNS.FiltersSharedDownloads = true
+	fs.set("/list.txt", fixtureResponse{body: fixtureRules("b", 3)})
+	refresh()
+	assert.Equal(t, 3, fs.hitCount("/list.txt"))
+	for _, filt := range []filter{config.Filters[0], config.WhitelistFilters[0]} {
+		assert.Equal(t, 3, filt.RulesCount)
+		data, err := ioutil.ReadFile(filt.Path())
+		assert.Nil(t, err)
+		assert.Equal(t, fixtureRules("b", 3), string(data))
+	}
+	cycles := Context.filters.updateCycles()
+	assert.True(t, len(cycles) >= 2)
+	assert.True(t, cycles[len(cycles)-2].Bytes > 0)
+	assert.Equal(t, int64(0), cycles[len(cycles)-1].Bytes)
+
+	// the blocklist is removed: the allowlist has its own data and is still updated
+	assert.Nil(t, os.Remove(config.Filters[0].Path()))
+	config.Filters = nil
+	fs.set("/list.txt", fixtureResponse{body: fixtureRules("c", 4)})
+	refresh()
+	assert.Equal(t, 4, fs.hitCount("/list.txt"))
+	assert.Equal(t, 4, config.WhitelistFilters[0].RulesCount)
+}
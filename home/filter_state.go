@@ -0,0 +1,114 @@
+package home
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/AdguardTeam/golibs/file"
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// File (under DataDir) where we keep the runtime state of the filtering module between restarts
+const filtersStateFile = "filters_state.json"
+
+// The number of update cycles we keep in the history
+const maxUpdateCycles = 10
+
+// What started an update cycle
+const (
+	updateTriggerTimer     = "timer"      // periodic update
+	updateTriggerManual    = "manual"     // user requested a refresh
+	updateTriggerURLChange = "url_change" // filter URL has been changed
+	updateTriggerFile      = "file"       // the file of a local filter has been changed
+)
+
+// updateCycle is the outcome of a single update cycle for one filter list
+type updateCycle struct {
+	Storage     string    `json:"storage"` // "blocklist" or "allowlist"
+	Trigger     string    `json:"trigger"` // updateTrigger*
+	Started     time.Time `json:"started"`
+	Finished    time.Time `json:"finished"`
+	Checked     int       `json:"checked"`      // the number of filters we tried to download
+	Updated     int       `json:"updated"`      // the number of filters with the changed data
+	NotModified int       `json:"not_modified"` // the number of filters with the same data
+	Failed      int       `json:"failed"`       // the number of filters that couldn't be downloaded
+	Bytes       int64     `json:"bytes"`        // total number of bytes received
+	Retries     int       `json:"retries"`      // total number of download retries
+
+	shared *sharedDownloads // the lists downloaded during the update, nil if the downloads aren't shared
+}
+
+// filtersState is the data stored in filtersStateFile
+type filtersState struct {
+	UpdateCycles []updateCycle `json:"update_cycles"`
+
+	Compacted       bool  `json:"compacted"`        // TRUE if the stored filter files have been compacted
+	CompactionSaved int64 `json:"compaction_saved"` // the number of bytes saved by the compaction
+
+	Served map[string]servedETag `json:"served,omitempty"` // the last served ETag of each merged list
+}
+
+func filtersStatePath() string {
+	return filepath.Join(Context.getDataDir(), filtersStateFile)
+}
+
+// Load the state from file
+func (f *Filtering) loadState() {
+	data, err := ioutil.ReadFile(filtersStatePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error("filter: %s", err)
+		}
+		return
+	}
+
+	st := filtersState{}
+	err = json.Unmarshal(data, &st)
+	if err != nil {
+		log.Error("filter: %s: %s", filtersStatePath(), err)
+		return
+	}
+
+	f.stateLock.Lock()
+	f.state = st
+	f.stateLock.Unlock()
+}
+
+// Store the state to file
+// Note: must be called with stateLock held
+func (f *Filtering) saveStateNoLock() {
+	data, err := json.Marshal(f.state)
+	if err != nil {
+		log.Error("filter: json encode: %s", err)
+		return
+	}
+
+	err = file.SafeWrite(filtersStatePath(), data)
+	if err != nil {
+		log.Error("filter: %s", err)
+	}
+}
+
+// Add the update cycle to the history and store it on disk
+func (f *Filtering) addUpdateCycle(c updateCycle) {
+	f.stateLock.Lock()
+	defer f.stateLock.Unlock()
+
+	c.shared = nil // the update is over
+	f.state.UpdateCycles = append(f.state.UpdateCycles, c)
+	if n := len(f.state.UpdateCycles); n > maxUpdateCycles {
+		f.state.UpdateCycles = f.state.UpdateCycles[n-maxUpdateCycles:]
+	}
+	f.saveStateNoLock()
+}
+
+// Get the update cycles history, the latest cycle is the last one
+func (f *Filtering) updateCycles() []updateCycle {
+	f.stateLock.Lock()
+	defer f.stateLock.Unlock()
+
+	return append([]updateCycle{}, f.state.UpdateCycles...)
+}
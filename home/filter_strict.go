@@ -0,0 +1,32 @@
+package home
+
+// The strict blocklists: the allowlists and the exception rules of the other filters
+//  can't override their rules, e.g. for the malware domains lists.
+// The filtering engine matches the strict blocklists before the allowlists are considered.
+
+// Return TRUE if the allowlists and the exception rules may override the rules of the filter (the default)
+func (filter *filter) allowExceptions() bool {
+	return filter.AllowExceptions == nil || *filter.AllowExceptions
+}
+
+// Set the flag as it's stored in the configuration: the default value isn't stored
+func (filter *filter) setAllowExceptions(allow bool) {
+	if allow {
+		filter.AllowExceptions = nil
+		return
+	}
+	filter.AllowExceptions = &allow
+}
+
+// Return TRUE if the blocklist with this ID is strict
+func isStrictFilter(id int64) bool {
+	config.RLock()
+	defer config.RUnlock()
+
+	for _, filt := range config.Filters {
+		if filt.ID == id {
+			return !filt.allowExceptions()
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package home
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+func checkHostForTest(t *testing.T, host string) checkHostResp {
+	w := httptest.NewRecorder()
+	Context.filters.handleCheckHost(w, httptest.NewRequest("GET", "/control/filtering/check_host?name="+host, nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	resp := checkHostResp{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return resp
+}
+
+func TestStrictFilter(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.configFilename = "AdGuardHome.yaml"
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	defer Context.dnsFilter.Close()
+	strict := false
+	config.DNS.FilteringEnabled = true
+	config.Filters = []filter{
+		{Enabled: true, URL: "https://example.org/malware.txt", AllowExceptions: &strict, Filter: dnsfilter.Filter{ID: 1}},
+		{Enabled: true, URL: "https://example.org/ads.txt", Filter: dnsfilter.Filter{ID: 2}},
+	}
+	config.UserRuleGroups = []userRuleGroup{
+		{Name: "default", Enabled: true, Rules: []string{"@@||malware.example^", "@@||ads.example^"}},
+	}
+	defer func() {
+		config.DNS.FilteringEnabled = false
+		config.Filters = nil
+		config.UserRuleGroups = nil
+	}()
+	assert.Nil(t, Context.filters.Init())
+	assert.Nil(t, ioutil.WriteFile(config.Filters[0].Path(), []byte("||malware.example^\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(config.Filters[1].Path(), []byte("||ads.example^\n"), 0644))
+	enableFilters(false)
+
+	// the user allowlist doesn't override the strict filter
+	resp := checkHostForTest(t, "malware.example")
+	assert.Equal(t, "FilteredBlackList", resp.Reason)
+	assert.Equal(t, int64(1), resp.FilterID)
+	assert.True(t, resp.Strict)
+
+	resp = checkHostForTest(t, "ads.example")
+	assert.Equal(t, "NotFilteredWhiteList", resp.Reason)
+	assert.False(t, resp.Strict)
+
+	// the flag is shown in the status
+	w := httptest.NewRecorder()
+	Context.filters.handleFilteringStatus(w, httptest.NewRequest("GET", "/control/filtering/status", nil))
+	status := filteringConfig{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.False(t, status.Filters[0].AllowExceptions)
+	assert.True(t, status.Filters[1].AllowExceptions)
+
+	// the flag isn't changed if it isn't set
+	w = httptest.NewRecorder()
+	body := `{"url":"https://example.org/malware.txt","whitelist":false,
+		"data":{"url":"https://example.org/malware.txt","name":"malware","enabled":true}}`
+	Context.filters.handleFilteringSetURL(w, httptest.NewRequest("POST", "/control/filtering/set_url", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, config.Filters[0].allowExceptions())
+
+	// the exceptions are allowed: the default value isn't stored
+	w = httptest.NewRecorder()
+	body = `{"url":"https://example.org/malware.txt","whitelist":false,
+		"data":{"url":"https://example.org/malware.txt","name":"malware","enabled":true,"allow_exceptions":true}}`
+	Context.filters.handleFilteringSetURL(w, httptest.NewRequest("POST", "/control/filtering/set_url", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, config.Filters[0].AllowExceptions)
+	enableFilters(false)
+
+	resp = checkHostForTest(t, "malware.example")
+	assert.Equal(t, "NotFilteredWhiteList", resp.Reason)
+	assert.False(t, resp.Strict)
+}
@@ -1,16 +1,32 @@
 package home
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/miekg/dns"
 	"github.com/stretchr/testify/assert"
 )
 
+func TestMain(m *testing.M) {
+	// the test HTTP servers listen on the loopback interface
+	config.DNS.FiltersBlockPrivate = false
+	os.Exit(m.Run())
+}
+
 func testStartFilterListener() net.Listener {
 	http.HandleFunc("/filters/1.txt", func(w http.ResponseWriter, r *http.Request) {
 		content := `||example.org^$third-party
@@ -48,13 +64,13 @@ func TestFilters(t *testing.T) {
 	}
 
 	// download
-	ok, err := Context.filters.update(&f)
+	ok, err := Context.filters.update(context.Background(), &f)
 	assert.Equal(t, nil, err)
 	assert.True(t, ok)
 	assert.Equal(t, 3, f.RulesCount)
 
 	// refresh
-	ok, err = Context.filters.update(&f)
+	ok, err = Context.filters.update(context.Background(), &f)
 	assert.True(t, !ok && err == nil)
 
 	err = Context.filters.load(&f)
@@ -63,3 +79,1624 @@ func TestFilters(t *testing.T) {
 	f.unload()
 	_ = os.Remove(f.Path())
 }
+
+func TestSearchRules(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.filters.Init()
+
+	f := filter{Name: "test"}
+	f.ID = 1
+	data := "! Title: test\n||example.org^\n||example.com^\n\n@@||sub.example.org^\n"
+	assert.Nil(t, ioutil.WriteFile(f.Path(), []byte(data), 0644))
+	config.Filters = []filter{f}
+	defer func() { config.Filters = nil }()
+
+	m, truncated := Context.filters.searchRules(func(line string) bool {
+		return strings.Contains(line, "example.org")
+	}, false, 10)
+	assert.False(t, truncated)
+	assert.Equal(t, 2, len(m))
+	assert.Equal(t, int64(1), m[0].FilterID)
+	assert.Equal(t, "test", m[0].FilterName)
+	assert.Equal(t, 2, m[0].Line)
+	assert.Equal(t, "||example.org^", m[0].Rule)
+	assert.Equal(t, 5, m[1].Line)
+
+	m, truncated = Context.filters.searchRules(func(line string) bool {
+		return strings.Contains(line, "example")
+	}, false, 2)
+	assert.True(t, truncated)
+	assert.Equal(t, 2, len(m))
+
+	m, _ = Context.filters.searchRules(func(line string) bool {
+		return true
+	}, true, 10)
+	assert.Equal(t, 0, len(m))
+}
+
+func TestFilterAddMultiple(t *testing.T) {
+	defer func() {
+		config.Filters = nil
+		config.WhitelistFilters = nil
+	}()
+
+	added := filterAddMultiple([]filter{
+		{URL: "https://example.org/1.txt"},
+		{URL: "https://example.org/2.txt", white: true},
+		{URL: "https://example.org/1.txt", white: true},
+	})
+	assert.Equal(t, []bool{true, true, false}, added)
+	assert.Equal(t, 1, len(config.Filters))
+	assert.Equal(t, 1, len(config.WhitelistFilters))
+}
+
+func TestFiltersOrder(t *testing.T) {
+	reset := func() {
+		config.Filters = []filter{
+			{URL: "1", Filter: dnsfilter.Filter{ID: 1}},
+			{URL: "2", Filter: dnsfilter.Filter{ID: 2}},
+			{URL: "3", Filter: dnsfilter.Filter{ID: 3}},
+		}
+	}
+	urls := func() []string {
+		var list []string
+		for _, filt := range config.Filters {
+			list = append(list, filt.URL)
+		}
+		return list
+	}
+	defer func() { config.Filters = nil }()
+
+	reset()
+	changed, err := filterMove("1", 2, false)
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []string{"2", "3", "1"}, urls())
+	changed, err = filterMove("1", 0, false)
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []string{"1", "2", "3"}, urls())
+	changed, err = filterMove("2", 1, false)
+	assert.Nil(t, err)
+	assert.False(t, changed)
+
+	_, err = filterMove("2", 3, false)
+	assert.NotNil(t, err)
+	_, err = filterMove("4", 0, false)
+	assert.NotNil(t, err)
+	_, err = filterMove("1", 0, true)
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"1", "2", "3"}, urls())
+
+	changed, err = filtersSetOrder([]string{"3", "1", "2"}, false)
+	assert.Nil(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, []string{"3", "1", "2"}, urls())
+	assert.Equal(t, int64(3), config.Filters[0].ID)
+
+	// invalid lists: nothing is changed
+	for _, list := range [][]string{{"1", "2"}, {"1", "2", "2"}, {"1", "2", "4"}, {"1", "2", "3", "4"}} {
+		_, err = filtersSetOrder(list, false)
+		assert.NotNil(t, err)
+		assert.Equal(t, []string{"3", "1", "2"}, urls())
+	}
+}
+
+func TestUpdateCycles(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("||example.org^\n"))
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	defer Context.dnsFilter.Close()
+	config.Filters = []filter{
+		{Enabled: true, URL: srv.URL + "/1.txt"},
+		{Enabled: true, URL: srv.URL + "/2.txt"},
+		{Enabled: false, URL: srv.URL + "/3.txt"},
+	}
+	defer func() { config.Filters = nil }()
+	Context.filters.Init()
+
+	n, err := Context.filters.refreshFilters(FilterRefreshBlocklists|FilterRefreshForce, true, updateTriggerManual)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	cycles := Context.filters.updateCycles()
+	assert.Equal(t, 1, len(cycles))
+	c := cycles[0]
+	assert.Equal(t, "blocklist", c.Storage)
+	assert.Equal(t, updateTriggerManual, c.Trigger)
+	assert.Equal(t, 2, c.Checked)
+	assert.Equal(t, 2, c.Updated)
+	assert.Equal(t, 0, c.NotModified)
+	assert.Equal(t, 0, c.Failed)
+	assert.Equal(t, int64(2*len("||example.org^\n")), c.Bytes)
+	assert.False(t, c.Finished.Before(c.Started))
+
+	// the data hasn't changed
+	_, _ = Context.filters.refreshFilters(FilterRefreshBlocklists|FilterRefreshForce, true, updateTriggerTimer)
+	cycles = Context.filters.updateCycles()
+	assert.Equal(t, 2, len(cycles))
+	assert.Equal(t, updateTriggerTimer, cycles[1].Trigger)
+	assert.Equal(t, 0, cycles[1].Updated)
+	assert.Equal(t, 2, cycles[1].NotModified)
+
+	// the history is restored after restart
+	Context.filters = Filtering{}
+	Context.filters.Init()
+	assert.Equal(t, 2, len(Context.filters.updateCycles()))
+}
+
+func TestRefreshHTMLPage(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/preamble.txt":
+			// a text preamble pushes the error page past the first chunk
+			_, _ = w.Write([]byte(strings.Repeat("! Service is temporarily unavailable\n", 200)))
+			_, _ = w.Write([]byte("<!DOCTYPE html>\n<html><body>Error</body></html>\n"))
+		case "/tags.txt":
+			_, _ = w.Write([]byte(strings.Repeat(" \n", 3000)))
+			_, _ = w.Write([]byte(strings.Repeat("<div>Error</div>\n", 20)))
+			_, _ = w.Write([]byte("||example.org^\n"))
+		}
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	defer Context.dnsFilter.Close()
+	config.Filters = []filter{
+		{Enabled: true, URL: srv.URL + "/preamble.txt", Filter: dnsfilter.Filter{ID: 1}},
+		{Enabled: true, URL: srv.URL + "/tags.txt", Filter: dnsfilter.Filter{ID: 2}},
+	}
+	defer func() { config.Filters = nil }()
+	const data = "||example.org^\n||example.com^\n"
+	assert.Nil(t, ioutil.WriteFile(config.Filters[0].Path(), []byte(data), 0644))
+	assert.Nil(t, ioutil.WriteFile(config.Filters[1].Path(), []byte(data), 0644))
+	Context.filters.Init()
+	assert.Equal(t, 2, config.Filters[0].RulesCount)
+
+	// the working files aren't replaced
+	for i := range config.Filters {
+		n, err := Context.filters.refreshSingleFilter(context.Background(), config.Filters[i].ID, false, 0)
+		_, ok := err.(*filterDownloadError)
+		assert.True(t, ok, err)
+		assert.Equal(t, 0, n)
+		assert.True(t, strings.Contains(config.Filters[i].lastError, "data is HTML"), config.Filters[i].lastError)
+		assert.Equal(t, 2, config.Filters[i].RulesCount)
+		d, _ := ioutil.ReadFile(config.Filters[i].Path())
+		assert.Equal(t, data, string(d))
+	}
+}
+
+func TestCheckHTMLSample(t *testing.T) {
+	assert.Nil(t, checkHTMLSample([]byte("||example.org^\n0.0.0.0 example.com\n")))
+	assert.Nil(t, checkHTMLSample([]byte(strings.Repeat("||example.org^\n", 9)+"<div>\n")))
+	assert.NotNil(t, checkHTMLSample([]byte("\uFEFF<!DOCTYPE html>\n")))
+	assert.NotNil(t, checkHTMLSample([]byte("Error 503\n<!DOCTYPE html>\n")))
+	assert.NotNil(t, checkHTMLSample([]byte("\n<HTML>\n")))
+	assert.NotNil(t, checkHTMLSample([]byte(strings.Repeat("||example.org^\n", 5)+strings.Repeat("<p>Error</p>\n", 5))))
+}
+
+func TestUpdateStatus(t *testing.T) {
+	var inProgress updateProgress
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inProgress = Context.filters.updateStatus()
+		_, _ = w.Write([]byte("||example.org^\n"))
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	defer Context.dnsFilter.Close()
+	config.Filters = []filter{
+		{Enabled: true, URL: srv.URL + "/1.txt"},
+	}
+	defer func() { config.Filters = nil }()
+	Context.filters.Init()
+
+	assert.False(t, Context.filters.updateStatus().Running)
+	_, err := Context.filters.refreshFilters(FilterRefreshBlocklists|FilterRefreshForce, true, updateTriggerManual)
+	assert.Nil(t, err)
+	assert.True(t, inProgress.Running)
+	assert.Equal(t, "blocklist", inProgress.Storage)
+	assert.Equal(t, 0, inProgress.Done)
+	assert.Equal(t, 1, inProgress.Total)
+	assert.Equal(t, srv.URL+"/1.txt", inProgress.URL)
+	assert.False(t, Context.filters.updateStatus().Running)
+}
+
+func TestRetryDelay(t *testing.T) {
+	interval := config.DNS.FiltersUpdateIntervalHours
+	defer func() {
+		config.DNS.FiltersUpdateIntervalHours = interval
+		config.DNS.FiltersUpdateMinutes = 0
+	}()
+	config.DNS.FiltersUpdateIntervalHours = 1
+
+	check := func(retries int, min time.Duration) {
+		d := retryDelay(retries)
+		assert.True(t, d >= min && d <= min+min/10, "retries: %d, delay: %s", retries, d)
+	}
+	check(1, 10*time.Second)
+	check(2, 20*time.Second)
+	check(3, 40*time.Second)
+	check(100, time.Hour)
+}
+
+func TestFiltersUpdateInterval(t *testing.T) {
+	for _, i := range []uint32{0, 1, 6, 48, 720} {
+		assert.True(t, checkFiltersUpdateIntervalHours(i), i)
+	}
+	assert.False(t, checkFiltersUpdateIntervalHours(721))
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.configFilename = "AdGuardHome.yaml"
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	Context.dnsFilter.Start()
+	defer Context.dnsFilter.Close()
+	interval := config.DNS.FiltersUpdateIntervalHours
+	defer func() { config.DNS.FiltersUpdateIntervalHours = interval }()
+
+	set := func(body string) int {
+		w := httptest.NewRecorder()
+		Context.filters.handleFilteringConfig(w, httptest.NewRequest("POST", "/control/filtering/config", strings.NewReader(body)))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, set(`{"enabled":true,"interval":6}`))
+	assert.Equal(t, uint32(6), config.DNS.FiltersUpdateIntervalHours)
+	assert.Equal(t, http.StatusBadRequest, set(`{"enabled":true,"interval":721}`))
+	assert.Equal(t, uint32(6), config.DNS.FiltersUpdateIntervalHours)
+
+	// a filter is updated when the interval has passed
+	now := time.Now()
+	filt := filter{Enabled: true, URL: "https://example.org/1.txt", LastUpdated: now.Add(-5 * time.Hour)}
+	assert.True(t, now.Add(time.Hour).Equal(filt.nextUpdateTime()))
+
+	// the interval in minutes takes precedence
+	assert.Equal(t, http.StatusOK, set(`{"enabled":true,"interval":6,"interval_minutes":15}`))
+	assert.Equal(t, uint32(15), config.DNS.FiltersUpdateMinutes)
+	assert.True(t, filt.LastUpdated.Add(15*time.Minute).Equal(filt.nextUpdateTime()))
+	assert.Equal(t, http.StatusBadRequest, set(`{"enabled":true,"interval":6,"interval_minutes":43201}`))
+	assert.Equal(t, uint32(15), config.DNS.FiltersUpdateMinutes)
+
+	// the interval in minutes isn't changed if it isn't set
+	assert.Equal(t, http.StatusOK, set(`{"enabled":true,"interval":12}`))
+	assert.Equal(t, uint32(12), config.DNS.FiltersUpdateIntervalHours)
+	assert.Equal(t, uint32(15), config.DNS.FiltersUpdateMinutes)
+
+	// a filter updated 20 minutes ago is updated again
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("||example.org^\n"))
+	}))
+	defer srv.Close()
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	config.Filters = []filter{
+		{Enabled: true, URL: srv.URL + "/1.txt", LastUpdated: now.Add(-20 * time.Minute), Filter: dnsfilter.Filter{ID: 1}},
+		{Enabled: true, URL: srv.URL + "/2.txt", LastUpdated: now.Add(-10 * time.Minute), Filter: dnsfilter.Filter{ID: 2}},
+	}
+	defer func() { config.Filters = nil }()
+	Context.filters.Init()
+	n, netErr := Context.filters.refreshFiltersIfNecessary(context.Background(), FilterRefreshBlocklists, updateTriggerTimer, 0)
+	assert.False(t, netErr)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 1, config.Filters[0].RulesCount)
+	assert.Equal(t, 0, config.Filters[1].RulesCount)
+
+	// 0: the interval in hours is used
+	assert.Equal(t, http.StatusOK, set(`{"enabled":true,"interval":6,"interval_minutes":0}`))
+	assert.Equal(t, uint32(0), config.DNS.FiltersUpdateMinutes)
+	assert.True(t, now.Add(time.Hour).Equal(filt.nextUpdateTime()))
+}
+
+func TestFiltersSetEnabled(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.filters.Init()
+
+	config.Filters = []filter{
+		{URL: "https://example.org/1.txt"},
+		{URL: "https://example.org/2.txt"},
+		{URL: "https://example.org/3.txt", Enabled: true},
+	}
+	defer func() { config.Filters = nil }()
+	config.Filters[0].ID = 1
+	config.Filters[1].ID = 2
+	config.Filters[2].ID = 3
+	assert.Nil(t, ioutil.WriteFile(config.Filters[0].Path(), []byte("||example.org^\n"), 0644))
+
+	// the file of the first filter is loaded from disk
+	changed, updateRequired := Context.filters.filtersSetEnabled([]string{"https://example.org/1.txt"}, true, false)
+	assert.Equal(t, 1, changed)
+	assert.False(t, updateRequired)
+	assert.True(t, config.Filters[0].Enabled)
+	assert.Equal(t, 1, config.Filters[0].RulesCount)
+
+	// the second filter has no file
+	changed, updateRequired = Context.filters.filtersSetEnabled([]string{"https://example.org/1.txt", "https://example.org/2.txt"}, true, false)
+	assert.Equal(t, 1, changed)
+	assert.True(t, updateRequired)
+
+	// allowlists aren't affected
+	changed, _ = Context.filters.filtersSetEnabled([]string{"https://example.org/3.txt"}, false, true)
+	assert.Equal(t, 0, changed)
+
+	changed, _ = Context.filters.filtersSetEnabled([]string{"https://example.org/1.txt", "https://example.org/3.txt"}, false, false)
+	assert.Equal(t, 2, changed)
+	assert.False(t, config.Filters[0].Enabled)
+	assert.Equal(t, 0, config.Filters[0].RulesCount)
+	assert.False(t, config.Filters[2].Enabled)
+}
+
+func TestImportFilters(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("||example.org^\n"))
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	Context.filters.Init()
+	defer func() { config.Filters = nil }()
+
+	items := []filterExportJSON{
+		{Name: "1", URL: srv.URL + "/1.txt", Enabled: true},
+		{Name: "2", URL: srv.URL + "/2.txt", Enabled: false},
+	}
+	var results []importResultJSON
+	modified, _ := Context.filters.importFilters(items, false, false, &results)
+	assert.True(t, modified)
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, "added", results[0].Status)
+	assert.Equal(t, "added", results[1].Status)
+	assert.Equal(t, 2, len(config.Filters))
+	assert.Equal(t, 1, config.Filters[0].RulesCount)
+	assert.False(t, config.Filters[1].Enabled)
+
+	// the same data
+	results = nil
+	modified, _ = Context.filters.importFilters(items, false, true, &results)
+	assert.False(t, modified)
+	assert.Equal(t, "unchanged", results[0].Status)
+	assert.Equal(t, "unchanged", results[1].Status)
+
+	// replace
+	results = nil
+	modified, _ = Context.filters.importFilters(items[:1], false, true, &results)
+	assert.True(t, modified)
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, "removed", results[1].Status)
+	assert.Equal(t, srv.URL+"/2.txt", results[1].URL)
+	assert.Equal(t, 1, len(config.Filters))
+
+	// the filter is in the other list
+	results = nil
+	modified, _ = Context.filters.importFilters(items[:1], true, false, &results)
+	assert.False(t, modified)
+	assert.Equal(t, "conflict", results[0].Status)
+	assert.Equal(t, "the filter is in the blocklists", results[0].Error)
+	assert.Equal(t, 0, len(config.WhitelistFilters))
+}
+
+func TestAddFilterDisabled(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		_, _ = w.Write([]byte("||example.org^\n"))
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	Context.filters.Init()
+	defer func() { config.Filters = nil }()
+
+	disabled := false
+	results, nAdded, enabled := Context.filters.addFilters([]filterAddJSON{
+		{Name: "1", URL: srv.URL + "/1.txt", Enabled: &disabled},
+	})
+	assert.Equal(t, 1, nAdded)
+	assert.False(t, enabled)
+	assert.Equal(t, "", results[0].Error)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&requests))
+	assert.Equal(t, 1, len(config.Filters))
+	assert.False(t, config.Filters[0].Enabled)
+	assert.False(t, util.FileExists(config.Filters[0].Path()))
+
+	// the URL is still checked for duplicates
+	_, nAdded, _ = Context.filters.addFilters([]filterAddJSON{
+		{Name: "1", URL: srv.URL + "/1.txt", Enabled: &disabled},
+	})
+	assert.Equal(t, 0, nAdded)
+
+	// "enabled" isn't set: the filter is downloaded and enabled
+	results, nAdded, enabled = Context.filters.addFilters([]filterAddJSON{
+		{Name: "2", URL: srv.URL + "/2.txt"},
+	})
+	assert.Equal(t, 1, nAdded)
+	assert.True(t, enabled)
+	assert.Equal(t, 1, results[0].RulesCount)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+	assert.True(t, config.Filters[1].Enabled)
+}
+
+func TestFilterChangeURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bad.txt" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte("||example.org^\n||example.com^\n"))
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	config.Filters = []filter{
+		{Enabled: true, URL: srv.URL + "/1.txt", Name: "1", Filter: dnsfilter.Filter{ID: 1}},
+	}
+	defer func() { config.Filters = nil }()
+	Context.filters.Init()
+	oldData := []byte("||example.net^\n")
+	assert.Nil(t, ioutil.WriteFile(config.Filters[0].Path(), oldData, 0644))
+	assert.Nil(t, Context.filters.load(&config.Filters[0]))
+	assert.Equal(t, 1, config.Filters[0].RulesCount)
+
+	listFiles := func() []string {
+		var names []string
+		files, _ := ioutil.ReadDir(filepath.Join(Context.getDataDir(), filterDir))
+		for _, fi := range files {
+			names = append(names, fi.Name())
+		}
+		return names
+	}
+
+	// the download fails: nothing is changed
+	newf := filter{Enabled: true, URL: srv.URL + "/bad.txt", Name: "2"}
+	err := Context.filters.filterChangeURL(context.Background(), srv.URL+"/1.txt", newf, false)
+	assert.NotNil(t, err)
+	assert.Equal(t, int64(1), config.Filters[0].ID)
+	assert.Equal(t, srv.URL+"/1.txt", config.Filters[0].URL)
+	assert.Equal(t, "1", config.Filters[0].Name)
+	assert.Equal(t, 1, config.Filters[0].RulesCount)
+	assert.Equal(t, []string{"1.txt"}, listFiles())
+	data, _ := ioutil.ReadFile(config.Filters[0].Path())
+	assert.Equal(t, oldData, data)
+
+	// the same via HTTP handler
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/control/filtering/set_url", strings.NewReader(
+		`{"url":"`+srv.URL+`/1.txt","data":{"name":"2","url":"`+srv.URL+`/bad.txt","enabled":true}}`))
+	Context.filters.handleFilteringSetURL(w, r)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), "404"), w.Body.String())
+	assert.Equal(t, srv.URL+"/1.txt", config.Filters[0].URL)
+	assert.Equal(t, 1, config.Filters[0].RulesCount)
+	assert.Equal(t, []string{"1.txt"}, listFiles())
+	data, _ = ioutil.ReadFile(config.Filters[0].Path())
+	assert.Equal(t, oldData, data)
+
+	// success: the filter gets a new ID, the old file is deleted
+	newf.URL = srv.URL + "/2.txt"
+	err = Context.filters.filterChangeURL(context.Background(), srv.URL+"/1.txt", newf, false)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(config.Filters))
+	assert.NotEqual(t, int64(1), config.Filters[0].ID)
+	assert.Equal(t, srv.URL+"/2.txt", config.Filters[0].URL)
+	assert.Equal(t, "2", config.Filters[0].Name)
+	assert.Equal(t, 2, config.Filters[0].RulesCount)
+	assert.Equal(t, []string{filepath.Base(config.Filters[0].Path())}, listFiles())
+
+	// unknown URL
+	err = Context.filters.filterChangeURL(context.Background(), srv.URL+"/1.txt", newf, false)
+	assert.NotNil(t, err)
+}
+
+func TestRemoveOrphanedFiles(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	config.Filters = []filter{
+		{URL: "https://example.org/1.txt", Filter: dnsfilter.Filter{ID: 1}},
+	}
+	config.WhitelistFilters = []filter{
+		{URL: "https://example.org/2.txt", Filter: dnsfilter.Filter{ID: 2}},
+	}
+	defer func() {
+		config.Filters = nil
+		config.WhitelistFilters = nil
+	}()
+
+	fdir := filepath.Join(Context.getDataDir(), filterDir)
+	assert.Nil(t, os.MkdirAll(fdir, 0755))
+	for _, name := range []string{"1.txt", "2.txt", "3.txt", "3.txt.old", "123456789", "readme"} {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(fdir, name), []byte("||example.org^\n"), 0644))
+	}
+
+	Context.filters.Init()
+
+	var names []string
+	files, _ := ioutil.ReadDir(fdir)
+	for _, fi := range files {
+		names = append(names, fi.Name())
+	}
+	assert.Equal(t, []string{"1.txt", "2.txt", "3.txt.old", "readme"}, names)
+}
+
+func TestCheckFilterURL(t *testing.T) {
+	const filterData = "! Title: Example filter\n||example.org^\n||example.com^\n@@||example.net^\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/filter.txt":
+			w.Header().Set("Content-Type", "text/plain")
+			http.ServeContent(w, r, "filter.txt", time.Time{}, strings.NewReader(filterData))
+		case "/hosts.txt":
+			_, _ = w.Write([]byte("# comment\n0.0.0.0 example.org\n0.0.0.0 example.com\nexample.net\n"))
+		case "/empty.txt":
+			_, _ = w.Write([]byte("! Title: Empty\n"))
+		case "/large.txt":
+			for i := 0; i < checkURLMaxSize/20+1000; i++ {
+				_, _ = fmt.Fprintf(w, "||host%08d.org^\n", i)
+			}
+		case "/page.html":
+			_, _ = w.Write([]byte("<!DOCTYPE html><html></html>"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	assert.Nil(t, Context.filters.Init())
+
+	resp := Context.filters.checkFilterURL(srv.URL + "/filter.txt")
+	assert.Equal(t, "", resp.Error)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/plain", resp.ContentType)
+	assert.Equal(t, int64(len(filterData)), resp.Size)
+	assert.True(t, resp.IsFilter)
+	assert.True(t, resp.Valid)
+	assert.Equal(t, 3, resp.RulesCount)
+	assert.Equal(t, "Example filter", resp.Title)
+	assert.Equal(t, "adblock", resp.Format)
+	assert.False(t, resp.Truncated)
+
+	resp = Context.filters.checkFilterURL(srv.URL + "/hosts.txt")
+	assert.True(t, resp.Valid)
+	assert.Equal(t, 3, resp.RulesCount)
+	assert.Equal(t, "hosts", resp.Format)
+
+	resp = Context.filters.checkFilterURL(srv.URL + "/empty.txt")
+	assert.True(t, resp.IsFilter)
+	assert.False(t, resp.Valid)
+	assert.Equal(t, 0, resp.RulesCount)
+	assert.Equal(t, "the filter contains no rules", resp.Error)
+
+	resp = Context.filters.checkFilterURL(srv.URL + "/large.txt")
+	assert.True(t, resp.Valid)
+	assert.True(t, resp.Truncated)
+	assert.Equal(t, checkURLMaxSize/20, resp.RulesCount)
+
+	resp = Context.filters.checkFilterURL(srv.URL + "/page.html")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.False(t, resp.IsFilter)
+	assert.False(t, resp.Valid)
+	assert.Equal(t, "data is HTML, not plain text", resp.Error)
+
+	resp = Context.filters.checkFilterURL(srv.URL + "/404.txt")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.False(t, resp.IsFilter)
+
+	assert.Equal(t, "domains", filterFormat([]byte("example.org\nexample.com\n||example.net^\n")))
+	assert.Equal(t, "", filterFormat([]byte("! comment\n")))
+}
+
+func TestValidateUserRules(t *testing.T) {
+	errs := validateUserRules([]string{"! comment", "", "||example.org^", "/example.org"})
+	assert.Equal(t, 1, len(errs))
+	assert.Equal(t, 4, errs[0].Line)
+	assert.Equal(t, "/example.org", errs[0].Rule)
+}
+
+func TestLowDisk(t *testing.T) {
+	nRequests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nRequests++
+		_, _ = w.Write([]byte("||example.org^\n"))
+	}))
+	defer srv.Close()
+
+	var free uint64 = 10 * 1024 * 1024
+	freeDiskSpace = func(path string) (uint64, error) {
+		return free, nil
+	}
+	defer func() { freeDiskSpace = util.FreeDiskSpace }()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	defer Context.dnsFilter.Close()
+	config.Filters = []filter{
+		{Enabled: true, URL: srv.URL + "/1.txt"},
+	}
+	defer func() { config.Filters = nil }()
+	Context.filters.Init()
+
+	n, err := Context.filters.refreshFilters(FilterRefreshBlocklists|FilterRefreshForce, true, updateTriggerManual)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 0, nRequests)
+	assert.True(t, Context.filters.isLowDisk())
+
+	free = 100 * 1024 * 1024
+	n, err = Context.filters.refreshFilters(FilterRefreshBlocklists|FilterRefreshForce, true, updateTriggerManual)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 1, nRequests)
+	assert.False(t, Context.filters.isLowDisk())
+}
+
+func TestUserRuleGroups(t *testing.T) {
+	config.UserRuleGroups = nil
+	defer func() { config.UserRuleGroups = nil }()
+
+	setUserRules([]string{"||example.org^"})
+	assert.Equal(t, 1, len(config.UserRuleGroups))
+	assert.Equal(t, defaultUserRuleGroup, config.UserRuleGroups[0].Name)
+	assert.True(t, config.UserRuleGroups[0].Enabled)
+	assert.Equal(t, []string{"||example.org^"}, userRules())
+
+	config.UserRuleGroups = append(config.UserRuleGroups,
+		userRuleGroup{Name: "kids", Enabled: false, Rules: []string{"||kids.example.org^"}},
+		userRuleGroup{Name: "iot", Enabled: true, Rules: []string{"||iot.example.org^", "||iot.example.com^"}},
+	)
+	filters := userFilters()
+	assert.Equal(t, 2, len(filters))
+	assert.Equal(t, int64(0), filters[0].ID)
+	assert.Equal(t, "||example.org^", string(filters[0].Data))
+	assert.Equal(t, int64(-2), filters[1].ID)
+	assert.Equal(t, "||iot.example.org^\n||iot.example.com^", string(filters[1].Data))
+
+	assert.Nil(t, validateUserRuleGroups(config.UserRuleGroups))
+	assert.NotNil(t, validateUserRuleGroups([]userRuleGroup{{Name: "a"}, {Name: "a"}}))
+	assert.NotNil(t, validateUserRuleGroups([]userRuleGroup{{Name: ""}}))
+	assert.NotNil(t, validateUserRuleGroups([]userRuleGroup{{Name: "a", Rules: []string{"/example.org"}}}))
+}
+
+func TestParseQType(t *testing.T) {
+	qtype, err := parseQType("")
+	assert.Nil(t, err)
+	assert.Equal(t, dns.TypeA, qtype)
+
+	qtype, err = parseQType("aaaa")
+	assert.Nil(t, err)
+	assert.Equal(t, dns.TypeAAAA, qtype)
+
+	qtype, err = parseQType("HTTPS")
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(65), qtype)
+	assert.Equal(t, "HTTPS", qtypeToString(qtype))
+
+	qtype, err = parseQType("16")
+	assert.Nil(t, err)
+	assert.Equal(t, dns.TypeTXT, qtype)
+	assert.Equal(t, "TXT", qtypeToString(qtype))
+
+	_, err = parseQType("NOTATYPE")
+	assert.NotNil(t, err)
+
+	qtypes, err := parseQTypes("")
+	assert.Nil(t, err)
+	assert.Equal(t, []uint16{dns.TypeA}, qtypes)
+
+	qtypes, err = parseQTypes("A, AAAA,cname,https")
+	assert.Nil(t, err)
+	assert.Equal(t, []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeCNAME, 65}, qtypes)
+
+	_, err = parseQTypes("A,NOTATYPE")
+	assert.NotNil(t, err)
+}
+
+func TestInactiveFilters(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	defer Context.dnsFilter.Close()
+	config.DNS.FilteringEnabled = true
+	defer func() { config.DNS.FilteringEnabled = false }()
+	config.Filters = []filter{
+		{Enabled: true, URL: "https://example.org/good.txt", Name: "good", RulesCount: 1, Filter: dnsfilter.Filter{ID: 1}},
+		{Enabled: true, URL: "https://example.org/empty.txt", Name: "empty", Filter: dnsfilter.Filter{ID: 2}},
+		{Enabled: true, URL: "https://example.org/new.txt", Name: "new", Filter: dnsfilter.Filter{ID: 3}},
+		{Enabled: false, URL: "https://example.org/disabled.txt", Name: "disabled", Filter: dnsfilter.Filter{ID: 4}},
+	}
+	defer func() { config.Filters = nil }()
+	Context.filters.Init()
+	assert.Nil(t, ioutil.WriteFile(config.Filters[0].Path(), []byte("||example.org^\n"), 0644))
+	assert.Nil(t, ioutil.WriteFile(config.Filters[1].Path(), []byte("! no rules\n"), 0644))
+	config.Filters[0].RulesCount = 1
+	config.Filters[1].RulesCount = 0
+
+	enableFilters(false)
+	inactive := Context.filters.inactiveFilters()
+	assert.Equal(t, 2, len(inactive))
+	assert.Equal(t, int64(2), inactive[0].ID)
+	assert.Equal(t, inactiveNoRules, inactive[0].Reason)
+	assert.Equal(t, int64(3), inactive[1].ID)
+	assert.Equal(t, inactiveNoFile, inactive[1].Reason)
+
+	// the entry is cleared once the filter is loaded
+	config.Filters[1].RulesCount = 1
+	config.Filters = config.Filters[:2]
+	enableFilters(false)
+	assert.Equal(t, 0, len(Context.filters.inactiveFilters()))
+}
+
+func TestFilterNameByID(t *testing.T) {
+	config.Filters = []filter{
+		{URL: "https://example.org/1.txt", Name: "blocklist", Filter: dnsfilter.Filter{ID: 1}},
+	}
+	config.WhitelistFilters = []filter{
+		{URL: "https://example.org/2.txt", Name: "allowlist", Filter: dnsfilter.Filter{ID: 2}},
+	}
+	defer func() {
+		config.Filters = nil
+		config.WhitelistFilters = nil
+		config.UserRuleGroups = nil
+	}()
+
+	name, url := filterNameByID(1)
+	assert.Equal(t, "blocklist", name)
+	assert.Equal(t, "https://example.org/1.txt", url)
+	name, url = filterNameByID(2)
+	assert.Equal(t, "allowlist", name)
+	assert.Equal(t, "https://example.org/2.txt", url)
+	name, url = filterNameByID(3)
+	assert.Equal(t, "(removed)", name)
+	assert.Equal(t, "", url)
+
+	name, _ = filterNameByID(0)
+	assert.Equal(t, "Custom rules", name)
+	config.UserRuleGroups = []userRuleGroup{{Name: "Custom rules"}, {Name: "iot"}}
+	name, _ = filterNameByID(-1)
+	assert.Equal(t, "iot", name)
+	name, _ = filterNameByID(-2)
+	assert.Equal(t, "(removed)", name)
+}
+
+func TestGetFilter(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	interval := config.DNS.FiltersUpdateIntervalHours
+	config.DNS.FiltersUpdateIntervalHours = 24
+	defer func() { config.DNS.FiltersUpdateIntervalHours = interval }()
+	config.Filters = []filter{
+		{Enabled: true, URL: "https://example.org/1.txt", Name: "one", RulesCount: 1, Filter: dnsfilter.Filter{ID: 1}},
+	}
+	config.WhitelistFilters = []filter{
+		{Enabled: true, URL: "https://example.org/2.txt", Name: "two", Filter: dnsfilter.Filter{ID: 2}},
+	}
+	defer func() {
+		config.Filters = nil
+		config.WhitelistFilters = nil
+	}()
+	Context.filters.Init()
+	assert.Nil(t, ioutil.WriteFile(config.Filters[0].Path(), []byte("||example.org^\n"), 0644))
+	config.Filters[0].LastUpdated = time.Now()
+	config.Filters[0].lastError = "got status code != 200: 404"
+
+	get := func(query string) (int, filterDetailsJSON) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/control/filtering/filter?"+query, nil)
+		Context.filters.handleFilteringGetFilter(w, r)
+		resp := filterDetailsJSON{}
+		if w.Code == http.StatusOK {
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		}
+		return w.Code, resp
+	}
+
+	code, resp := get("id=1")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "one", resp.Name)
+	assert.Equal(t, int64(len("||example.org^\n")), resp.FileSize)
+	assert.Equal(t, "https://example.org/1.txt", resp.EffectiveURL)
+	assert.Equal(t, "got status code != 200: 404", resp.LastError)
+	assert.NotEqual(t, "", resp.NextUpdate)
+
+	code, resp = get("type=allowlist&url=https://example.org/2.txt")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "two", resp.Name)
+	assert.Equal(t, int64(0), resp.FileSize)
+
+	code, _ = get("id=2")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = get("id=abc")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = get("type=other&id=1")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestRefreshFilter(t *testing.T) {
+	requests := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		_, _ = w.Write([]byte("||example.org^\n"))
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	defer Context.dnsFilter.Close()
+	config.Filters = []filter{
+		{Enabled: true, URL: srv.URL + "/1.txt", Filter: dnsfilter.Filter{ID: 1}},
+		{Enabled: true, URL: srv.URL + "/2.txt", Filter: dnsfilter.Filter{ID: 2}},
+	}
+	defer func() { config.Filters = nil }()
+	Context.filters.Init()
+	nextUpdate := time.Now().Add(time.Hour)
+	config.Filters[1].retries = 1
+	config.Filters[1].nextUpdate = nextUpdate
+
+	refresh := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		Context.controlLock.Lock()
+		Context.filters.handleFilteringRefresh(w, httptest.NewRequest("POST", "/control/filtering/refresh", strings.NewReader(body)))
+		Context.controlLock.Unlock()
+		return w
+	}
+
+	w := refresh(`{"id":1}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, `{"updated":1}`, w.Body.String())
+	assert.Equal(t, 1, requests["/1.txt"])
+	assert.Equal(t, 0, requests["/2.txt"])
+	assert.Equal(t, 1, config.Filters[0].RulesCount)
+
+	// the other filter isn't touched: its next update time and its retries are kept
+	assert.Equal(t, 0, config.Filters[1].RulesCount)
+	assert.Equal(t, 1, config.Filters[1].retries)
+	assert.True(t, nextUpdate.Equal(config.Filters[1].nextUpdate))
+
+	// the data hasn't changed
+	w = refresh(`{"url":"` + srv.URL + `/1.txt"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, `{"updated":0}`, w.Body.String())
+	assert.Equal(t, 2, requests["/1.txt"])
+	assert.True(t, nextUpdate.Equal(config.Filters[1].nextUpdate))
+}
+
+func TestFilterDownloadStats(t *testing.T) {
+	const data = "||example.org^\n||example.com^\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = w.Write([]byte(data))
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	config.Filters = []filter{
+		{Enabled: true, URL: srv.URL + "/1.txt", Filter: dnsfilter.Filter{ID: 1}},
+	}
+	defer func() { config.Filters = nil }()
+	Context.filters.Init()
+	Context.filters.reloadFunc = func() {}
+
+	// not downloaded yet
+	fj := filterToJSON(config.Filters[0])
+	assert.Equal(t, int64(0), fj.DownloadSize)
+	assert.Equal(t, int64(0), fj.DownloadDuration)
+
+	n, err := Context.filters.refreshSingleFilter(context.Background(), 1, false, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, int64(len(data)), config.Filters[0].downloadSize)
+	assert.True(t, config.Filters[0].downloadDuration >= 50*time.Millisecond)
+
+	fj = filterToJSON(config.Filters[0])
+	assert.Equal(t, int64(len(data)), fj.DownloadSize)
+	assert.True(t, fj.DownloadDuration >= 50, fj.DownloadDuration)
+
+	// the data hasn't changed: the values are updated anyway
+	config.Filters[0].downloadSize = 0
+	n, err = Context.filters.refreshSingleFilter(context.Background(), 1, false, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, int64(len(data)), config.Filters[0].downloadSize)
+}
+
+func TestFilteringStatusPaging(t *testing.T) {
+	Context = homeContext{}
+	config.Filters = []filter{
+		{Enabled: true, URL: "https://example.org/1.txt", Filter: dnsfilter.Filter{ID: 1}},
+		{Enabled: false, URL: "https://example.org/2.txt", Filter: dnsfilter.Filter{ID: 2}},
+		{Enabled: true, URL: "https://example.org/3.txt", Filter: dnsfilter.Filter{ID: 3}},
+		{Enabled: true, URL: "https://example.org/4.txt", Filter: dnsfilter.Filter{ID: 4}},
+	}
+	config.WhitelistFilters = []filter{
+		{Enabled: true, URL: "https://example.org/5.txt", Filter: dnsfilter.Filter{ID: 5}},
+	}
+	defer func() {
+		config.Filters = nil
+		config.WhitelistFilters = nil
+	}()
+
+	get := func(query string) (int, filteringConfig) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/control/filtering/status?"+query, nil)
+		Context.filters.handleFilteringStatus(w, r)
+		resp := filteringConfig{}
+		if w.Code == http.StatusOK {
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		}
+		return w.Code, resp
+	}
+
+	// no parameters: the full response
+	code, resp := get("")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 4, len(resp.Filters))
+	assert.Equal(t, 1, len(resp.WhitelistFilters))
+	assert.Nil(t, resp.FiltersTotal)
+	assert.Nil(t, resp.WhitelistFiltersTotal)
+
+	code, resp = get("type=blocklist&offset=1&limit=2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, len(resp.Filters))
+	assert.Equal(t, int64(2), resp.Filters[0].ID)
+	assert.Equal(t, int64(3), resp.Filters[1].ID)
+	assert.Equal(t, 4, *resp.FiltersTotal)
+	assert.Equal(t, 0, len(resp.WhitelistFilters))
+	assert.Nil(t, resp.WhitelistFiltersTotal)
+
+	code, resp = get("enabled_only=true&offset=1")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, len(resp.Filters))
+	assert.Equal(t, int64(3), resp.Filters[0].ID)
+	assert.Equal(t, 3, *resp.FiltersTotal)
+	assert.Equal(t, 0, len(resp.WhitelistFilters))
+	assert.Equal(t, 1, *resp.WhitelistFiltersTotal)
+
+	code, _ = get("type=proxylist")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = get("limit=-1")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestFilteringStatusTotals(t *testing.T) {
+	Context = homeContext{}
+	config.Filters = []filter{
+		{Enabled: true, URL: "https://example.org/1.txt", RulesCount: 10, Filter: dnsfilter.Filter{ID: 1}},
+		{Enabled: false, URL: "https://example.org/2.txt", RulesCount: 20, Filter: dnsfilter.Filter{ID: 2}},
+	}
+	config.WhitelistFilters = []filter{
+		{Enabled: true, URL: "https://example.org/3.txt", RulesCount: 3, Filter: dnsfilter.Filter{ID: 3}},
+	}
+	defer func() {
+		config.Filters = nil
+		config.WhitelistFilters = nil
+	}()
+
+	get := func() filteringConfig {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/control/filtering/status", nil)
+		Context.filters.handleFilteringStatus(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+		resp := filteringConfig{}
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp
+	}
+
+	// the engine isn't running
+	resp := get()
+	assert.Equal(t, 2, resp.TotalFiltersEnabled)
+	assert.Equal(t, 13, resp.TotalRulesEnabled)
+	assert.Nil(t, resp.EngineRulesCount)
+	assert.Nil(t, resp.EngineMemoryEstimate)
+
+	filters := []dnsfilter.Filter{{ID: 0, Data: []byte("||example.org^\n||example.com^\n")}}
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, filters)
+	defer Context.dnsFilter.Close()
+	Context.filters.SetEngineStatusFn(Context.dnsFilter.GetEngineStatus)
+
+	resp = get()
+	assert.Equal(t, 2, *resp.EngineRulesCount)
+	assert.True(t, *resp.EngineMemoryEstimate > 0)
+}
+
+// Remove and modify filters while they're being updated (run with -race)
+func TestRefreshFiltersConcurrentModify(t *testing.T) {
+	var counter int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&counter, 1)
+		_, _ = w.Write([]byte(fmt.Sprintf("||example%d.org^\n", n)))
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	config.Filters = nil
+	for i := 0; i < 10; i++ {
+		config.Filters = append(config.Filters, filter{Enabled: true, URL: fmt.Sprintf("%s/%d.txt", srv.URL, i)})
+	}
+	defer func() {
+		config.Filters = nil
+		config.DeletedFilters = nil
+	}()
+	Context.filters.Init()
+	Context.filters.reloadFunc = func() {}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			u := fmt.Sprintf("%s/%d.txt", srv.URL, i)
+			if i%2 == 0 {
+				filterRemove(u, false)
+			} else {
+				Context.filters.filterSetProperties(u, filter{Enabled: true, URL: u + "?changed"}, false)
+			}
+			nf := filter{Enabled: true, URL: fmt.Sprintf("%s/new%d.txt", srv.URL, i)}
+			nf.ID = assignUniqueFilterID()
+			filterAdd(nf)
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		_, err := Context.filters.refreshFilters(FilterRefreshBlocklists|FilterRefreshForce, true, updateTriggerManual)
+		assert.Nil(t, err)
+	}
+	<-done
+
+	// the data downloaded for a removed or changed filter isn't stored
+	config.RLock()
+	defer config.RUnlock()
+	for _, f := range config.Filters {
+		if f.RulesCount != 0 {
+			assert.True(t, util.FileExists(f.Path()), f.URL)
+		}
+	}
+	assert.Equal(t, 15, len(config.Filters))
+}
+
+// The filter is removed while its data is being downloaded
+func TestRefreshRemoveInFlight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = w.Write([]byte("||example.org^\n||example.com^\n"))
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	u := srv.URL + "/1.txt"
+	config.Filters = []filter{
+		{Enabled: true, URL: u, Filter: dnsfilter.Filter{ID: 1}},
+	}
+	defer func() {
+		config.Filters = nil
+		config.DeletedFilters = nil
+	}()
+	Context.filters.Init()
+	Context.filters.reloadFunc = func() {}
+	assert.Nil(t, ioutil.WriteFile(config.Filters[0].Path(), []byte("||example.net^\n"), 0644))
+
+	done := make(chan int)
+	go func() {
+		n, _ := Context.filters.refreshFilters(FilterRefreshBlocklists|FilterRefreshForce, true, updateTriggerManual)
+		done <- n
+	}()
+	<-started
+	assert.True(t, filterRemove(u, false))
+	close(release)
+	assert.Equal(t, 0, <-done)
+
+	// the downloaded data isn't stored and the temporary file is removed
+	files, err := ioutil.ReadDir(filepath.Join(Context.getDataDir(), filterDir))
+	assert.Nil(t, err)
+	var names []string
+	for _, fi := range files {
+		names = append(names, fi.Name())
+	}
+	assert.Equal(t, []string{trashDir}, names)
+	assert.Equal(t, 1, len(config.DeletedFilters))
+	assert.True(t, util.FileExists(config.DeletedFilters[0].trashPath()))
+
+	// the removed filter isn't reported as failed
+	cycles := Context.filters.updateCycles()
+	assert.Equal(t, 0, len(cycles))
+}
+
+func TestRefreshCancel(t *testing.T) {
+	started := make(chan struct{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+			_, _ = w.Write([]byte("||example.org^\n"))
+		}
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.client = &http.Client{
+		Timeout: 30 * time.Second,
+	}
+	config.Filters = []filter{
+		{Enabled: true, URL: srv.URL + "/1.txt", Filter: dnsfilter.Filter{ID: 1}},
+		{Enabled: true, URL: srv.URL + "/2.txt", Filter: dnsfilter.Filter{ID: 2}},
+	}
+	defer func() { config.Filters = nil }()
+	Context.filters.Init()
+	Context.filters.reloadFunc = func() {}
+
+	// the request context is cancelled
+	ctx, cancel := Context.filters.withContext(context.Background())
+	done := make(chan int)
+	go func() {
+		n, _ := Context.filters.refreshSingleFilter(ctx, 1, false, 0)
+		done <- n
+	}()
+	<-started
+	cancel()
+	select {
+	case n := <-done:
+		assert.Equal(t, 0, n)
+	case <-time.After(3 * time.Second):
+		t.Fatal("the download isn't cancelled")
+	}
+
+	// the module is closed: the rest of the filters aren't downloaded
+	go func() {
+		n, _ := Context.filters.refreshFilters(FilterRefreshBlocklists|FilterRefreshForce, true, updateTriggerManual)
+		done <- n
+	}()
+	<-started
+	Context.filters.Close()
+	select {
+	case n := <-done:
+		assert.Equal(t, 0, n)
+	case <-time.After(3 * time.Second):
+		t.Fatal("the update isn't cancelled")
+	}
+	assert.Equal(t, 0, len(started))
+
+	// the cancelled downloads aren't failures
+	for _, f := range config.Filters {
+		assert.Equal(t, 0, f.retries)
+		assert.Equal(t, "", f.lastError)
+	}
+	assert.Equal(t, 0, len(Context.filters.updateCycles()))
+}
+
+func TestCheckHosts(t *testing.T) {
+	Context = homeContext{}
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, []dnsfilter.Filter{
+		{ID: 0, Data: []byte("||example.org^\n")},
+	})
+	defer Context.dnsFilter.Close()
+
+	post := func(body string) (int, []checkHostResp) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/control/filtering/check_hosts", strings.NewReader(body))
+		Context.filters.handleCheckHosts(w, r)
+		var resp []checkHostResp
+		if w.Code == http.StatusOK {
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		}
+		return w.Code, resp
+	}
+
+	code, resp := post(`{"names":["example.org","example.com","sub.example.org"]}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 3, len(resp))
+	assert.Equal(t, "FilteredBlackList", resp[0].Reason)
+	assert.Equal(t, "NotFilteredNotFound", resp[1].Reason)
+	assert.Equal(t, "FilteredBlackList", resp[2].Reason)
+	assert.Equal(t, "A", resp[0].QType)
+
+	names := make([]string, checkHostsMax+1)
+	for i := range names {
+		names[i] = fmt.Sprintf("%d.example.org", i)
+	}
+	js, _ := json.Marshal(map[string]interface{}{"names": names})
+	code, _ = post(string(js))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = post(`{"names":["example.org"],"qtype":"NOTATYPE"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestCheckHostClientName(t *testing.T) {
+	Context = homeContext{}
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, []dnsfilter.Filter{
+		{ID: 0, Data: []byte("||example.org^\n")},
+	})
+	defer Context.dnsFilter.Close()
+	Context.clients.testing = true
+	Context.clients.Init(nil, nil, nil)
+	ok, err := Context.clients.Add(Client{
+		IDs:            []string{"1.2.3.4"},
+		Name:           "client1",
+		UseOwnSettings: true,
+	})
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	get := func(client string) (int, checkHostResp) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/control/filtering/check_host?name=example.org&client="+client, nil)
+		Context.filters.handleCheckHost(w, r)
+		resp := checkHostResp{}
+		if w.Code == http.StatusOK {
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		}
+		return w.Code, resp
+	}
+
+	code, resp := get("")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "FilteredBlackList", resp.Reason)
+
+	// filtering is disabled for the client: it is found by its name and by its IP address
+	for _, client := range []string{"client1", "1.2.3.4"} {
+		code, resp = get(client)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "NotFilteredNotFound", resp.Reason)
+		assert.Equal(t, client, resp.Client)
+	}
+
+	code, _ = get("client2")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestUpdatesPause(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		_, _ = w.Write([]byte("||example.org^\n"))
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.configFilename = "AdGuardHome.yaml"
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	Context.dnsFilter.Start()
+	defer Context.dnsFilter.Close()
+	config.Filters = []filter{
+		{Enabled: true, URL: srv.URL + "/1.txt", Filter: dnsfilter.Filter{ID: 1}},
+	}
+	defer func() {
+		config.Filters = nil
+		config.DNS.FiltersUpdatesPaused = false
+	}()
+	Context.filters.Init()
+
+	w := httptest.NewRecorder()
+	Context.filters.handleUpdatesPause(w, httptest.NewRequest("POST", "/control/filtering/updates_pause", strings.NewReader(`{"paused":true}`)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, config.DNS.FiltersUpdatesPaused)
+
+	w = httptest.NewRecorder()
+	Context.filters.handleFilteringStatus(w, httptest.NewRequest("GET", "/control/filtering/status", nil))
+	resp := filteringConfig{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.UpdatesPaused)
+
+	// the timer doesn't update the filters
+	Context.filters.refreshByTimer()
+	assert.Equal(t, int32(0), atomic.LoadInt32(&hits))
+
+	// the manual update still works
+	n, err := Context.filters.refreshFilters(FilterRefreshBlocklists|FilterRefreshForce, true, updateTriggerManual)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+
+	Context.filters.ResumeUpdates()
+	assert.False(t, config.DNS.FiltersUpdatesPaused)
+	config.Filters[0].LastUpdated = time.Time{}
+	Context.filters.refreshByTimer()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hits))
+}
+
+func TestTestRule(t *testing.T) {
+	Context = homeContext{}
+	// the configured filters aren't used
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, []dnsfilter.Filter{
+		{ID: 0, Data: []byte("||example.com^\n")},
+	})
+	defer Context.dnsFilter.Close()
+
+	post := func(body string) (int, testRuleResp) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/control/filtering/test_rule", strings.NewReader(body))
+		Context.filters.handleTestRule(w, r)
+		resp := testRuleResp{}
+		if w.Code == http.StatusOK {
+			assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		}
+		return w.Code, resp
+	}
+
+	code, resp := post(`{"rule":"||example.org^","host":"sub.example.org"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, resp.Matched)
+	assert.Equal(t, "FilteredBlackList", resp.Reason)
+	assert.Equal(t, "A", resp.QType)
+
+	code, resp = post(`{"rule":"||example.org^","host":"example.com"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, resp.Matched)
+	assert.Equal(t, "NotFilteredNotFound", resp.Reason)
+
+	code, resp = post(`{"rule":"@@||example.org^","host":"example.org"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, resp.Matched)
+	assert.Equal(t, "NotFilteredWhiteList", resp.Reason)
+
+	code, resp = post(`{"rule":"1.2.3.4 example.org","host":"example.org","qtype":"A"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, resp.Matched)
+	assert.Equal(t, "1.2.3.4", resp.IP)
+
+	code, _ = post(`{"rule":"! comment","host":"example.org"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = post(`{"rule":"||example.org^$unknown_modifier","host":"example.org"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = post(`{"rule":"||example.org^"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = post(`{"rule":"||example.org^","host":"example.org","qtype":"BAD"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestRefreshFilterByURL(t *testing.T) {
+	var fail int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) != 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("||example.org^\n"))
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.configFilename = "AdGuardHome.yaml"
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	defer Context.dnsFilter.Close()
+	config.Filters = []filter{
+		{Enabled: true, URL: srv.URL + "/1.txt", Filter: dnsfilter.Filter{ID: 1}},
+	}
+	config.WhitelistFilters = []filter{
+		{Enabled: true, URL: srv.URL + "/2.txt", Filter: dnsfilter.Filter{ID: 2}},
+	}
+	defer func() {
+		config.Filters = nil
+		config.WhitelistFilters = nil
+	}()
+	assert.Nil(t, Context.filters.Init())
+
+	assert.Equal(t, errFilterNotFound, Context.filters.RefreshFilter(srv.URL+"/3.txt"))
+	assert.Nil(t, Context.filters.RefreshFilter(srv.URL+"/2.txt"))
+	assert.Equal(t, 1, config.WhitelistFilters[0].RulesCount)
+	assert.Equal(t, 0, config.Filters[0].RulesCount)
+
+	// the filter isn't expired but it's downloaded again
+	assert.Nil(t, Context.filters.RefreshFilter(srv.URL+"/1.txt"))
+	assert.Equal(t, 1, config.Filters[0].RulesCount)
+	assert.Nil(t, Context.filters.RefreshFilter(srv.URL+"/1.txt"))
+
+	refresh := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		Context.controlLock.Lock()
+		Context.filters.handleFilteringRefresh(w, httptest.NewRequest("POST", "/control/filtering/refresh", strings.NewReader(body)))
+		Context.controlLock.Unlock()
+		return w
+	}
+	w := refresh(`{"url":"` + srv.URL + `/1.txt"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"updated":0}`, w.Body.String())
+
+	w = refresh(`{"url":"` + srv.URL + `/3.txt"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	atomic.StoreInt32(&fail, 1)
+	w = refresh(`{"url":"` + srv.URL + `/1.txt"}`)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.True(t, strings.Contains(w.Body.String(), "500"), w.Body.String())
+	_, ok := Context.filters.RefreshFilter(srv.URL + "/1.txt").(*filterDownloadError)
+	assert.True(t, ok)
+	assert.Equal(t, 1, config.Filters[0].RulesCount)
+}
+
+func TestRuleCountDrop(t *testing.T) {
+	var rules int32 = 20
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < int(atomic.LoadInt32(&rules)); i++ {
+			_, _ = fmt.Fprintf(w, "||host%d.example.org^\n", i)
+		}
+	}))
+	defer srv.Close()
+
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.configFilename = "AdGuardHome.yaml"
+	Context.client = &http.Client{
+		Timeout: 5 * time.Second,
+	}
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	defer Context.dnsFilter.Close()
+	config.Filters = []filter{
+		{Enabled: true, URL: srv.URL + "/1.txt", Filter: dnsfilter.Filter{ID: 1}},
+	}
+	defer func() { config.Filters = nil }()
+	assert.Nil(t, Context.filters.Init())
+
+	assert.Nil(t, Context.filters.RefreshFilter(srv.URL+"/1.txt"))
+	assert.Equal(t, 20, config.Filters[0].RulesCount)
+
+	// 2 rules of 20 are enough
+	assert.True(t, isRuleCountDrop(20, 1))
+	assert.False(t, isRuleCountDrop(20, 2))
+	assert.False(t, isRuleCountDrop(0, 0))
+
+	// the old data is kept
+	atomic.StoreInt32(&rules, 1)
+	err := Context.filters.RefreshFilter(srv.URL + "/1.txt")
+	assert.NotNil(t, err)
+	assert.Equal(t, "suspicious rule count drop (20 -> 1), update skipped", config.Filters[0].lastError)
+	assert.Equal(t, 20, config.Filters[0].RulesCount)
+	data, err := ioutil.ReadFile(config.Filters[0].Path())
+	assert.Nil(t, err)
+	assert.Equal(t, 20, strings.Count(string(data), "\n"))
+
+	// the error is shown in the status
+	w := httptest.NewRecorder()
+	Context.filters.handleFilteringStatus(w, httptest.NewRequest("GET", "/control/filtering/status", nil))
+	resp := filteringConfig{}
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, config.Filters[0].lastError, resp.Filters[0].LastError)
+
+	// the new data is applied on demand
+	w = httptest.NewRecorder()
+	Context.controlLock.Lock()
+	Context.filters.handleFilteringRefresh(w, httptest.NewRequest("POST", "/control/filtering/refresh",
+		strings.NewReader(`{"url":"`+srv.URL+`/1.txt","force":true}`)))
+	Context.controlLock.Unlock()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"updated":1}`, w.Body.String())
+	assert.Equal(t, 1, config.Filters[0].RulesCount)
+	assert.Equal(t, "", config.Filters[0].lastError)
+
+	// the check is disabled
+	config.DNS.FiltersMinRuleRatio = 0
+	defer func() { config.DNS.FiltersMinRuleRatio = 0.1 }()
+	assert.False(t, isRuleCountDrop(20, 0))
+}
+
+func TestFilteringImportInvalidRules(t *testing.T) {
+	h, cleanup := newFiltersHarness(t)
+	defer cleanup()
+
+	code, body := h.post("/control/filtering/import",
+		`{"interval":24,"filters":[],"whitelist_filters":[],"user_rules":["||example.org^","||example.com^$unknown_modifier"]}`)
+	assert.Equal(t, http.StatusBadRequest, code, body)
+	resp := struct {
+		Errors []ruleErrorJSON `json:"errors"`
+	}{}
+	assert.Nil(t, json.Unmarshal([]byte(body), &resp), body)
+	if assert.Equal(t, 1, len(resp.Errors)) {
+		assert.Equal(t, 2, resp.Errors[0].Line)
+		assert.Equal(t, "||example.com^$unknown_modifier", resp.Errors[0].Rule)
+	}
+	assert.Equal(t, 0, len(userRules()))
+
+	code, body = h.post("/control/filtering/import",
+		`{"interval":24,"filters":[],"whitelist_filters":[],"user_rules":["||example.org^"]}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, []string{"||example.org^"}, userRules())
+}
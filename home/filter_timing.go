@@ -0,0 +1,113 @@
+package home
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// The timing of the filter downloads.
+// The download of a filter update is traced with httptrace so that a slow list can be diagnosed:
+//  the DNS lookup, the connection, the TLS handshake, the first response byte and the whole transfer.
+// Only the updates that are recorded in the update history are traced.
+
+// downloadTiming is the timing breakdown of a download attempt (in microseconds).
+// The durations are summed up if there are several connections (e.g. redirects).
+type downloadTiming struct {
+	DNS     int64 `json:"dns_us"`     // the DNS lookups
+	Connect int64 `json:"connect_us"` // the TCP connections
+	TLS     int64 `json:"tls_us"`     // the TLS handshakes
+	TTFB    int64 `json:"ttfb_us"`    // from the start of the attempt to the first byte of the last response
+	Total   int64 `json:"total_us"`   // from the start of the attempt to the end of the transfer
+}
+
+// timingTrace collects the timing of a download attempt
+type timingTrace struct {
+	lock         sync.Mutex // the trace functions may be called from the other goroutines
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	timing       downloadTiming
+}
+
+type timingTraceKey struct{}
+
+// Attach a new timing trace to the context
+func withTimingTrace(ctx context.Context) (context.Context, *timingTrace) {
+	tt := &timingTrace{start: time.Now()}
+	ctx = context.WithValue(ctx, timingTraceKey{}, tt)
+	return httptrace.WithClientTrace(ctx, tt.clientTrace()), tt
+}
+
+// Get the timing trace attached to the context, nil if there's none
+func timingTraceFrom(ctx context.Context) *timingTrace {
+	tt, _ := ctx.Value(timingTraceKey{}).(*timingTrace)
+	return tt
+}
+
+func sinceMicro(t time.Time) int64 {
+	return time.Since(t).Microseconds()
+}
+
+// Start a new attempt: the timing of the previous attempt is discarded
+func (tt *timingTrace) reset() {
+	tt.lock.Lock()
+	tt.start = time.Now()
+	tt.timing = downloadTiming{}
+	tt.lock.Unlock()
+}
+
+// Set the total duration of the attempt and get the timing
+func (tt *timingTrace) finish() *downloadTiming {
+	tt.lock.Lock()
+	defer tt.lock.Unlock()
+	t := tt.timing
+	t.Total = sinceMicro(tt.start)
+	if t.TTFB > t.Total {
+		t.TTFB = t.Total
+	}
+	return &t
+}
+
+func (tt *timingTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			tt.lock.Lock()
+			tt.dnsStart = time.Now()
+			tt.lock.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			tt.lock.Lock()
+			tt.timing.DNS += sinceMicro(tt.dnsStart)
+			tt.lock.Unlock()
+		},
+		ConnectStart: func(network, addr string) {
+			tt.lock.Lock()
+			tt.connectStart = time.Now()
+			tt.lock.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			tt.lock.Lock()
+			tt.timing.Connect += sinceMicro(tt.connectStart)
+			tt.lock.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			tt.lock.Lock()
+			tt.tlsStart = time.Now()
+			tt.lock.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			tt.lock.Lock()
+			tt.timing.TLS += sinceMicro(tt.tlsStart)
+			tt.lock.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			tt.lock.Lock()
+			tt.timing.TTFB = sinceMicro(tt.start)
+			tt.lock.Unlock()
+		},
+	}
+}
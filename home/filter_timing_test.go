@@ -0,0 +1,59 @@
+package home
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterDownloadTiming(t *testing.T) {
+	fs := newFixtureServer()
+	defer fs.Close()
+	h, cleanup := newFiltersHarness(t)
+	defer cleanup()
+
+	// the download of a new filter isn't recorded in the history and isn't traced
+	url := fs.URL + "/list.txt"
+	fs.set("/list.txt", fixtureResponse{body: fixtureRules("a", 2)})
+	code, body := h.post("/control/filtering/add_url", `{"name":"List","url":"`+url+`"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	filt, ok := filterFind(url, false)
+	assert.True(t, ok)
+	assert.Nil(t, filt.timing)
+
+	delay := 20 * time.Millisecond
+	fs.set("/list.txt", fixtureResponse{body: fixtureRules("a", 3), delay: delay})
+	code, body = h.post("/control/filtering/refresh", `{"whitelist":false}`)
+	assert.Equal(t, http.StatusOK, code, body)
+
+	hist := Context.filters.filterHistory(url)
+	if !assert.Equal(t, 1, len(hist)) || !assert.NotNil(t, hist[0].Timing) {
+		return
+	}
+	tm := hist[0].Timing
+	assert.Equal(t, int64(0), tm.DNS) // the URL has an IP address
+	assert.Equal(t, int64(0), tm.TLS) // plain HTTP
+	assert.True(t, tm.Connect > 0, "%+v", tm)
+	assert.True(t, tm.TTFB >= delay.Microseconds(), "%+v", tm)
+	assert.True(t, tm.TTFB <= tm.Total, "%+v", tm)
+	assert.True(t, tm.Connect <= tm.TTFB, "%+v", tm)
+
+	// the latest breakdown is in the filter details
+	code, body = h.get("/control/filtering/filter?url=" + url)
+	assert.Equal(t, http.StatusOK, code, body)
+	details := filterDetailsJSON{}
+	assert.Nil(t, json.Unmarshal([]byte(body), &details), body)
+	assert.Equal(t, tm, details.Timing)
+
+	// a failed attempt is traced too
+	fs.set("/list.txt", fixtureResponse{status: http.StatusNotFound})
+	_, _ = h.post("/control/filtering/refresh", `{"whitelist":false}`)
+	hist = Context.filters.filterHistory(url)
+	if assert.Equal(t, 2, len(hist)) && assert.NotNil(t, hist[1].Timing) {
+		assert.Equal(t, historyResultFailed, hist[1].Result)
+		assert.True(t, hist[1].Timing.TTFB <= hist[1].Timing.Total, "%+v", hist[1].Timing)
+	}
+}
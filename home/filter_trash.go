@@ -0,0 +1,132 @@
+package home
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+)
+
+// The removed filters are kept in the trash for a while so that the removal can be undone.
+// The filter properties are stored in the configuration file ("deleted_filters")
+// and the filter file is moved to the "trash" subdirectory of the filters directory.
+
+const (
+	trashDir      = "trash"        // the subdirectory of filterDir with the files of the removed filters
+	trashMaxAge   = 24 * time.Hour // the removed filters are purged after this time
+	trashMaxItems = 20             // the maximum number of the removed filters in the trash
+)
+
+// deletedFilter is a removed filter that can be restored
+type deletedFilter struct {
+	filter    `yaml:",inline"`
+	Whitelist bool      `yaml:"whitelist"`
+	DeletedAt time.Time `yaml:"deleted_at"`
+}
+
+// Get the path of the filter file in the trash
+func (d *deletedFilter) trashPath() string {
+	return filepath.Join(Context.getDataDir(), filterDir, trashDir, strconv.FormatInt(d.ID, 10)+".txt")
+}
+
+// Move the removed filter to the trash
+// Note: config must be locked
+func trashFilterNoLock(filt filter, whitelist bool, now time.Time) {
+	d := deletedFilter{
+		filter: filter{
+			Enabled: filt.Enabled,
+			URL:     filt.URL,
+			Name:    filt.Name,
+			Trusted: filt.Trusted,
+			ApplyTo: filt.ApplyTo,
+		},
+		Whitelist: whitelist,
+		DeletedAt: now,
+	}
+	d.ID = filt.ID
+
+	err := os.MkdirAll(filepath.Dir(d.trashPath()), 0755)
+	if err == nil {
+		err = os.Rename(filt.Path(), d.trashPath())
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Error("filter: %s: moving to trash: %s", filt.URL, err)
+	}
+
+	config.DeletedFilters = append(config.DeletedFilters, d)
+	purgeTrashNoLock(now)
+}
+
+// Remove the filters that have been in the trash for too long
+//  and the oldest filters if there are too many of them.
+// Return TRUE if some filters have been removed.
+// Note: config must be locked
+func purgeTrashNoLock(now time.Time) bool {
+	var keep []deletedFilter
+	n := len(config.DeletedFilters)
+	for i, d := range config.DeletedFilters {
+		if now.Sub(d.DeletedAt) > trashMaxAge || n-i > trashMaxItems {
+			log.Debug("filter: %s: purged from trash", d.URL)
+			removeFilterFile(d.trashPath())
+			continue
+		}
+		keep = append(keep, d)
+	}
+	config.DeletedFilters = keep
+	return len(keep) != n
+}
+
+// Purge the expired filters from the trash
+func (f *Filtering) purgeTrash() {
+	config.Lock()
+	purged := purgeTrashNoLock(f.timeNow())
+	config.Unlock()
+	if purged {
+		onConfigModified()
+	}
+}
+
+// Restore the removed filter: its file is moved back from the trash.
+// If there are several removed filters with this URL, the latest one is restored.
+func (f *Filtering) filterRestore(url string, whitelist bool) (filter, error) {
+	config.Lock()
+	defer config.Unlock()
+
+	i := len(config.DeletedFilters) - 1
+	for ; i >= 0; i-- {
+		d := config.DeletedFilters[i]
+		if d.URL == url && d.Whitelist == whitelist {
+			break
+		}
+	}
+	if i < 0 {
+		return filter{}, fmt.Errorf("filter isn't in the trash -- %s", url)
+	}
+	if filterExistsNoLock(url) {
+		return filter{}, fmt.Errorf("filter URL already added -- %s", url)
+	}
+
+	d := config.DeletedFilters[i]
+	filt := d.filter
+	filt.white = whitelist
+	err := os.Rename(d.trashPath(), filt.Path())
+	if err == nil {
+		if filt.Enabled {
+			err = f.load(&filt)
+			if err != nil {
+				log.Error("filter: %s: %s", filt.URL, err)
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return filter{}, err
+	}
+	// if there's no file, an enabled filter is downloaded on the next update
+	filt.statFile()
+
+	filterAddNoLock(filt)
+	config.DeletedFilters = append(config.DeletedFilters[:i], config.DeletedFilters[i+1:]...)
+	return filt, nil
+}
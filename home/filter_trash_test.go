@@ -0,0 +1,101 @@
+package home
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/AdguardTeam/AdGuardHome/util"
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestFilterTrash(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.configFilename = "AdGuardHome.yaml"
+	config.Filters = []filter{
+		{Enabled: true, URL: "https://example.org/1.txt", Name: "1", Filter: dnsfilter.Filter{ID: 1}},
+		{Enabled: false, URL: "https://example.org/2.txt", Name: "2", Filter: dnsfilter.Filter{ID: 2}},
+	}
+	defer func() {
+		config.Filters = nil
+		config.DeletedFilters = nil
+	}()
+	Context.filters.Init()
+	assert.Nil(t, ioutil.WriteFile(config.Filters[0].Path(), []byte("||example.org^\n"), 0644))
+	now := time.Now()
+	Context.filters.now = func() time.Time { return now }
+
+	assert.True(t, filterRemove("https://example.org/1.txt", false))
+	assert.True(t, filterRemove("https://example.org/2.txt", false))
+	assert.Equal(t, 0, len(config.Filters))
+	assert.Equal(t, 2, len(config.DeletedFilters))
+	assert.False(t, util.FileExists(filepath.Join(Context.getDataDir(), filterDir, "1.txt")))
+	assert.True(t, util.FileExists(config.DeletedFilters[0].trashPath()))
+
+	// the trash is stored in the configuration file
+	data, err := yaml.Marshal(struct {
+		DeletedFilters []deletedFilter `yaml:"deleted_filters"`
+	}{config.DeletedFilters})
+	assert.Nil(t, err)
+	conf := configuration{}
+	assert.Nil(t, yaml.Unmarshal(data, &conf))
+	assert.Equal(t, 2, len(conf.DeletedFilters))
+	assert.Equal(t, int64(1), conf.DeletedFilters[0].ID)
+	assert.Equal(t, "https://example.org/1.txt", conf.DeletedFilters[0].URL)
+	assert.Equal(t, "1", conf.DeletedFilters[0].Name)
+	assert.True(t, conf.DeletedFilters[0].Enabled)
+	assert.False(t, conf.DeletedFilters[1].Enabled)
+	assert.True(t, now.Equal(conf.DeletedFilters[0].DeletedAt))
+
+	// not in the trash
+	_, err = Context.filters.filterRestore("https://example.org/1.txt", true)
+	assert.NotNil(t, err)
+	_, err = Context.filters.filterRestore("https://example.org/3.txt", false)
+	assert.NotNil(t, err)
+
+	// the filter is restored with its data
+	filt, err := Context.filters.filterRestore("https://example.org/1.txt", false)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, filt.RulesCount)
+	assert.Equal(t, 1, len(config.Filters))
+	assert.Equal(t, int64(1), config.Filters[0].ID)
+	assert.Equal(t, "1", config.Filters[0].Name)
+	assert.True(t, util.FileExists(config.Filters[0].Path()))
+	assert.Equal(t, 1, len(config.DeletedFilters))
+
+	// a filter with this URL already exists
+	config.DeletedFilters = append(config.DeletedFilters, deletedFilter{
+		filter:    filter{URL: "https://example.org/1.txt", Filter: dnsfilter.Filter{ID: 5}},
+		DeletedAt: now,
+	})
+	_, err = Context.filters.filterRestore("https://example.org/1.txt", false)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, len(config.Filters))
+	config.DeletedFilters = config.DeletedFilters[:1]
+
+	// the expired filters are purged
+	now = now.Add(trashMaxAge + time.Minute)
+	Context.filters.purgeTrash()
+	assert.Equal(t, 0, len(config.DeletedFilters))
+
+	// only the latest filters are kept
+	for i := 0; i < trashMaxItems+2; i++ {
+		u := fmt.Sprintf("https://example.org/n%d.txt", i)
+		filterAdd(filter{Enabled: true, URL: u, Filter: dnsfilter.Filter{ID: int64(100 + i)}})
+		assert.Nil(t, ioutil.WriteFile(config.Filters[len(config.Filters)-1].Path(), []byte("||example.org^\n"), 0644))
+		assert.True(t, filterRemove(u, false))
+	}
+	assert.Equal(t, trashMaxItems, len(config.DeletedFilters))
+	assert.Equal(t, "https://example.org/n2.txt", config.DeletedFilters[0].URL)
+	d := deletedFilter{}
+	d.ID = 100
+	assert.False(t, util.FileExists(d.trashPath()))
+}
@@ -0,0 +1,204 @@
+package home
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/AdguardTeam/golibs/log"
+	"github.com/fsnotify/fsnotify"
+)
+
+// The files of the local filters are watched (if filters_watch_files is set)
+//  so that the filters are refreshed as soon as the files are changed, not on the next timer tick.
+// The directories of the files are watched: the editors often replace the file instead of writing to it,
+//  and a new file may match the glob pattern of a filter.
+
+// The changes are applied when there are no more events for this time (changed in tests)
+var filterWatchDelay = time.Second
+
+// filterWatcher watches the directories of the local filter files
+type filterWatcher struct {
+	lock    sync.Mutex
+	watcher *fsnotify.Watcher
+	dirs    map[string]bool            // the watched directories
+	timers  map[int64]*time.Timer      // the pending refreshes (by filter ID)
+	refresh func(id int64, white bool) // refreshes the filter
+	closed  bool
+}
+
+// A local filter source
+type watchedFilter struct {
+	id    int64
+	white bool
+	path  string // the file path or glob pattern
+}
+
+// Get the enabled local filters
+func watchedFilters() []watchedFilter {
+	var r []watchedFilter
+	config.RLock()
+	defer config.RUnlock()
+	for i, arr := range [][]filter{config.Filters, config.WhitelistFilters} {
+		for _, filt := range arr {
+			if !filt.Enabled || !isLocalFilterURL(filt.URL) {
+				continue
+			}
+			path, err := localFilterPath(filt.URL)
+			if err != nil {
+				continue
+			}
+			r = append(r, watchedFilter{id: filt.ID, white: i == 1, path: path})
+		}
+	}
+	return r
+}
+
+// Start watching the local filter files if it's enabled in the configuration
+func (f *Filtering) startWatcher() {
+	config.RLock()
+	enabled := config.DNS.FiltersWatchFiles
+	config.RUnlock()
+	if !enabled {
+		return
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Error("filter: watcher: %s", err)
+		return
+	}
+	w := &filterWatcher{
+		watcher: watcher,
+		dirs:    map[string]bool{},
+		timers:  map[int64]*time.Timer{},
+		refresh: func(id int64, white bool) {
+			flags := FilterRefreshBlocklists | FilterRefreshForce
+			if white {
+				flags = FilterRefreshAllowlists | FilterRefreshForce
+			}
+			// wait for the update that is in progress: the change must not be missed
+			_, err := f.refreshFiltersOnly(f.context(), flags, true, updateTriggerFile, id)
+			if err != nil {
+				log.Error("filter: watcher: refresh: %s", err)
+			}
+		},
+	}
+	go w.loop()
+
+	f.watchLock.Lock()
+	f.watch = w
+	f.watchLock.Unlock()
+	f.updateWatchList()
+}
+
+// Stop watching the local filter files
+func (f *Filtering) stopWatcher() {
+	f.watchLock.Lock()
+	w := f.watch
+	f.watch = nil
+	f.watchLock.Unlock()
+	if w == nil {
+		return
+	}
+
+	w.lock.Lock()
+	w.closed = true
+	for _, t := range w.timers {
+		t.Stop()
+	}
+	w.lock.Unlock()
+	// 'loop' goroutine exits when the channels are closed
+	_ = w.watcher.Close()
+}
+
+// Watch the directories of the current local filters.
+// It's called whenever the filters are changed.
+func (f *Filtering) updateWatchList() {
+	f.watchLock.Lock()
+	w := f.watch
+	f.watchLock.Unlock()
+	if w == nil {
+		return
+	}
+
+	dirs := map[string]bool{}
+	for _, wf := range watchedFilters() {
+		dirs[filepath.Dir(wf.path)] = true
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return
+	}
+	for dir := range w.dirs {
+		if !dirs[dir] {
+			_ = w.watcher.Remove(dir)
+			delete(w.dirs, dir)
+		}
+	}
+	for dir := range dirs {
+		if w.dirs[dir] {
+			continue
+		}
+		err := w.watcher.Add(dir)
+		if err != nil {
+			log.Error("filter: watcher: %s: %s", dir, err)
+			continue
+		}
+		w.dirs[dir] = true
+	}
+}
+
+// Receive the notifications from fsnotify
+func (w *filterWatcher) loop() {
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			w.changed(event.Name)
+
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Error("filter: watcher: %s", err)
+		}
+	}
+}
+
+// Schedule the refresh of the filters the changed file belongs to.
+// The timer is restarted on every change so that the rapid writes cause a single refresh.
+func (w *filterWatcher) changed(name string) {
+	for _, wf := range watchedFilters() {
+		match := wf.path == name
+		if !match && isGlobPattern(wf.path) {
+			match, _ = filepath.Match(wf.path, name)
+		}
+		if !match {
+			continue
+		}
+
+		log.Debug("filter: watcher: %s: modified: %s", wf.path, name)
+		wf := wf
+		w.lock.Lock()
+		if !w.closed {
+			if t, ok := w.timers[wf.id]; ok {
+				t.Stop()
+			}
+			w.timers[wf.id] = time.AfterFunc(filterWatchDelay, func() {
+				w.lock.Lock()
+				delete(w.timers, wf.id)
+				closed := w.closed
+				w.lock.Unlock()
+				if !closed {
+					w.refresh(wf.id, wf.white)
+				}
+			})
+		}
+		w.lock.Unlock()
+	}
+}
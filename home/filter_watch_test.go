@@ -0,0 +1,71 @@
+package home
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AdguardTeam/AdGuardHome/dnsfilter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchLocalFilter(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+	Context = homeContext{}
+	Context.workDir = dir
+	Context.configFilename = "AdGuardHome.yaml"
+	Context.dnsFilter = dnsfilter.New(&dnsfilter.Config{}, nil)
+	defer Context.dnsFilter.Close()
+
+	assert.Nil(t, os.MkdirAll(Context.getDataDir(), 0755))
+	fn := filepath.Join(Context.getDataDir(), "rules.txt")
+	assert.Nil(t, ioutil.WriteFile(fn, []byte("||a.org^\n"), 0644))
+
+	config.DNS.FiltersWatchFiles = true
+	config.Filters = []filter{
+		{Enabled: true, URL: "./rules.txt", Filter: dnsfilter.Filter{ID: 1}},
+	}
+	oldDelay := filterWatchDelay
+	filterWatchDelay = 50 * time.Millisecond
+	defer func() {
+		config.DNS.FiltersWatchFiles = false
+		config.Filters = nil
+		filterWatchDelay = oldDelay
+	}()
+	assert.Nil(t, Context.filters.Init())
+	assert.Nil(t, Context.filters.RefreshFilter("./rules.txt"))
+	Context.filters.startWatcher()
+	assert.NotNil(t, Context.filters.watch)
+	dataDir, _ := filepath.Abs(Context.getDataDir())
+	assert.True(t, Context.filters.watch.dirs[dataDir])
+
+	rulesCount := func() int {
+		config.RLock()
+		defer config.RUnlock()
+		return config.Filters[0].RulesCount
+	}
+	assert.Equal(t, 1, rulesCount())
+
+	// several writes in a row: the filter is refreshed
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, ioutil.WriteFile(fn, []byte("||a.org^\n||b.org^\n||c.org^\n"), 0644))
+	}
+	for i := 0; i < 100 && rulesCount() != 3; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Equal(t, 3, rulesCount())
+
+	// the removed filter isn't watched anymore
+	config.Lock()
+	config.Filters[0].Enabled = false
+	config.Unlock()
+	Context.filters.updateWatchList()
+	assert.Equal(t, 0, len(Context.filters.watch.dirs))
+
+	Context.filters.Close()
+	assert.Nil(t, Context.filters.watch)
+	Context.filters.Close()
+}
@@ -84,6 +84,8 @@ type homeContext struct {
 	appSignalChannel chan os.Signal // Channel for receiving OS signals by the console app
 	// runningAsService flag is set to true when options are passed from the service runner
 	runningAsService bool
+
+	configModifiedHook func() // called after the configuration has been written (for tests)
 }
 
 // getDataDir returns path to the directory where we store databases and filters
@@ -13,7 +13,7 @@ import (
 	yaml "gopkg.in/yaml.v2"
 )
 
-const currentSchemaVersion = 7 // used for upgrading from old configs to new config
+const currentSchemaVersion = 8 // used for upgrading from old configs to new config
 
 // Performs necessary upgrade operations if needed
 func upgradeConfig() error {
@@ -96,6 +96,12 @@ func upgradeConfigSchema(oldVersion int, diskConfig *map[string]interface{}) err
 		if err != nil {
 			return err
 		}
+		fallthrough
+	case 7:
+		err := upgradeSchema7to8(diskConfig)
+		if err != nil {
+			return err
+		}
 	default:
 		err := fmt.Errorf("configuration file contains unknown schema_version, abort")
 		log.Println(err)
@@ -435,3 +441,33 @@ func upgradeSchema6to7(diskConfig *map[string]interface{}) error {
 
 	return nil
 }
+
+// Move the user rules into the default user rule group
+// Replace
+//   user_rules:
+//   - rule
+// with
+//   user_rule_groups:
+//   - name: Custom rules
+//     enabled: true
+//     rules:
+//     - rule
+func upgradeSchema7to8(diskConfig *map[string]interface{}) error {
+	log.Printf("Upgrade yaml: 7 to 8")
+
+	(*diskConfig)["schema_version"] = 8
+
+	rules, ok := (*diskConfig)["user_rules"]
+	if !ok {
+		return nil
+	}
+	delete(*diskConfig, "user_rules")
+
+	group := map[string]interface{}{
+		"name":    defaultUserRuleGroup,
+		"enabled": true,
+		"rules":   rules,
+	}
+	(*diskConfig)["user_rule_groups"] = []interface{}{group}
+	return nil
+}
@@ -164,6 +164,36 @@ func compareConfigs(t *testing.T, oldConfig, newConfig *map[string]interface{})
 	}
 }
 
+func TestUpgrade7to8(t *testing.T) {
+	diskConfig := createTestDiskConfig(7)
+	diskConfig["user_rules"] = []string{"||example.org^", "@@||example.com^"}
+
+	err := upgradeSchema7to8(&diskConfig)
+	if err != nil {
+		t.Fatalf("Can't update schema version from 7 to 8: %s", err)
+	}
+
+	compareSchemaVersion(t, diskConfig["schema_version"], 8)
+
+	_, ok := diskConfig["user_rules"]
+	if ok {
+		t.Fatalf("user_rules was not removed after upgrade schema version from 7 to 8")
+	}
+
+	groups, ok := diskConfig["user_rule_groups"].([]interface{})
+	if !ok || len(groups) != 1 {
+		t.Fatalf("user_rule_groups is invalid: %v", diskConfig["user_rule_groups"])
+	}
+	group := groups[0].(map[string]interface{})
+	if group["name"] != defaultUserRuleGroup || group["enabled"] != true {
+		t.Fatalf("wrong default group: %v", group)
+	}
+	rules := group["rules"].([]string)
+	if len(rules) != 2 || rules[0] != "||example.org^" || rules[1] != "@@||example.com^" {
+		t.Fatalf("wrong rules after upgrade: %v", rules)
+	}
+}
+
 // compareSchemaVersion check if newSchemaVersion equals schemaVersion
 func compareSchemaVersion(t *testing.T, newSchemaVersion interface{}, schemaVersion int) {
 	switch v := newSchemaVersion.(type) {
@@ -28,6 +28,8 @@ type AutoHosts struct {
 	hostsDirs  []string          // paths to OS-specific directories with hosts-files
 	watcher    *fsnotify.Watcher // file and directory watcher object
 	updateChan chan bool         // signal for 'updateLoop' goroutine
+	chanLock   sync.Mutex        // serialize the sending to updateChan with its closing
+	closed     bool              // TRUE if updateChan is closed
 
 	onChanged onChangedT // notification to other modules
 }
@@ -49,7 +51,10 @@ func (a *AutoHosts) notify() {
 // hostsFn: Override default name for the hosts-file (optional)
 func (a *AutoHosts) Init(hostsFn string) {
 	a.table = make(map[string][]net.IP)
+	a.chanLock.Lock()
 	a.updateChan = make(chan bool, 2)
+	a.closed = false
+	a.chanLock.Unlock()
 
 	a.hostsFn = "/etc/hosts"
 	if runtime.GOOS == "windows" {
@@ -78,7 +83,7 @@ func (a *AutoHosts) Start() {
 	log.Debug("Start AutoHosts module")
 
 	go a.updateLoop()
-	a.updateChan <- true
+	a.requestUpdate()
 
 	if a.watcher != nil {
 		go a.watcherLoop()
@@ -98,14 +103,52 @@ func (a *AutoHosts) Start() {
 }
 
 // Close - close module
+// It's safe to call it more than once.
 func (a *AutoHosts) Close() {
-	a.updateChan <- false
-	close(a.updateChan)
+	a.chanLock.Lock()
+	if a.closed {
+		a.chanLock.Unlock()
+		return
+	}
+	a.closed = true
+	if a.updateChan != nil {
+		// 'updateLoop' goroutine exits when it reads from the closed channel
+		close(a.updateChan)
+	}
+	a.chanLock.Unlock()
+
 	if a.watcher != nil {
 		_ = a.watcher.Close()
 	}
 }
 
+// Refresh - reload the hosts-files
+// It's a no-op if the module is closed.
+func (a *AutoHosts) Refresh() {
+	if !a.requestUpdate() {
+		log.Debug("AutoHosts: refresh: the module is closed")
+	}
+}
+
+// Send a signal to 'updateLoop' goroutine.
+// The signal is dropped if the queue is full: the queued signal will do the job.
+// Return FALSE if the module is closed.
+func (a *AutoHosts) requestUpdate() bool {
+	a.chanLock.Lock()
+	defer a.chanLock.Unlock()
+	if a.closed {
+		return false
+	}
+
+	select {
+	case a.updateChan <- true:
+		// sent a signal to 'updateLoop' goroutine
+	default:
+		// queue is full
+	}
+	return true
+}
+
 // Process - get the list of IP addresses for the hostname
 // Return nil if not found
 func (a *AutoHosts) Process(host string, qtype uint16) []net.IP {
@@ -275,12 +318,7 @@ func (a *AutoHosts) watcherLoop() {
 
 			if event.Op&fsnotify.Write == fsnotify.Write {
 				log.Debug("AutoHosts: modified: %s", event.Name)
-				select {
-				case a.updateChan <- true:
-					// sent a signal to 'updateLoop' goroutine
-				default:
-					// queue is full
-				}
+				a.requestUpdate()
 			}
 
 		case err, ok := <-a.watcher.Errors:
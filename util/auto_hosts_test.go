@@ -5,6 +5,7 @@ import (
 	"net"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -99,6 +100,44 @@ func TestAutoHostsFSNotify(t *testing.T) {
 	assert.Equal(t, "127.0.0.2", ips[0].String())
 }
 
+func TestAutoHostsRefreshClose(t *testing.T) {
+	dir := prepareTestDir()
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	f, _ := ioutil.TempFile(dir, "")
+	defer func() { _ = os.Remove(f.Name()) }()
+	defer f.Close()
+	_, _ = f.WriteString("127.0.0.1   host\n")
+
+	// Refresh and Close are called concurrently: there must be no "send on closed channel" panic
+	for i := 0; i != 50; i++ {
+		ah := AutoHosts{}
+		ah.Init(f.Name())
+		ah.Start()
+
+		wg := sync.WaitGroup{}
+		for k := 0; k != 4; k++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				for n := 0; n != 10; n++ {
+					ah.Refresh()
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				ah.Close()
+			}()
+		}
+		wg.Wait()
+
+		// Refresh after Close is a no-op
+		assert.False(t, ah.requestUpdate())
+		ah.Refresh()
+		ah.Close()
+	}
+}
+
 func TestIP(t *testing.T) {
 	assert.Equal(t, "127.0.0.1", DNSUnreverseAddr("1.0.0.127.in-addr.arpa").String())
 	assert.Equal(t, "::abcd:1234", DNSUnreverseAddr("4.3.2.1.d.c.b.a.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.ip6.arpa").String())
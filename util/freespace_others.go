@@ -0,0 +1,12 @@
+// +build !darwin,!freebsd,!linux,!windows
+
+package util
+
+import (
+	"fmt"
+)
+
+// FreeDiskSpace isn't supported on this OS
+func FreeDiskSpace(path string) (uint64, error) {
+	return 0, fmt.Errorf("not supported")
+}
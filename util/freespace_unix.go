@@ -0,0 +1,17 @@
+// +build darwin freebsd linux
+
+package util
+
+import (
+	"syscall"
+)
+
+// FreeDiskSpace returns the number of bytes available to the current user on the filesystem containing path
+func FreeDiskSpace(path string) (uint64, error) {
+	st := syscall.Statfs_t{}
+	err := syscall.Statfs(path, &st)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -3,9 +3,11 @@ package util
 import (
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"runtime"
 	"strings"
 )
@@ -29,6 +31,24 @@ func FileExists(fn string) bool {
 	return true
 }
 
+// IsValidURL - return TRUE if URL or file path is valid
+// An absolute file path is valid if the file exists
+func IsValidURL(rawurl string) bool {
+	if filepath.IsAbs(rawurl) {
+		// this is a file path
+		return FileExists(rawurl)
+	}
+
+	url, err := url.ParseRequestURI(rawurl)
+	if err != nil {
+		return false //Couldn't even parse the rawurl
+	}
+	if len(url.Scheme) == 0 {
+		return false //No Scheme found
+	}
+	return true
+}
+
 // runCommand runs shell command
 func RunCommand(command string, arguments ...string) (int, string, error) {
 	cmd := exec.Command(command, arguments...)
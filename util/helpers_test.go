@@ -1,6 +1,9 @@
 package util
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -12,3 +15,23 @@ func TestSplitNext(t *testing.T) {
 	assert.True(t, SplitNext(&s, ',') == "b")
 	assert.True(t, SplitNext(&s, ',') == "c" && len(s) == 0)
 }
+
+func TestIsValidURL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agh-test")
+	assert.Nil(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	fn := filepath.Join(dir, "filter.txt")
+	assert.Nil(t, ioutil.WriteFile(fn, []byte("||example.org^\n"), 0644))
+
+	// file paths
+	assert.True(t, IsValidURL(fn))
+	assert.False(t, IsValidURL(filepath.Join(dir, "nonexistent.txt")))
+	assert.False(t, IsValidURL("filter.txt"))
+
+	// URLs
+	assert.True(t, IsValidURL("https://example.org/filter.txt"))
+	assert.True(t, IsValidURL("http://127.0.0.1:8080/filter.txt"))
+	assert.False(t, IsValidURL("example.org/filter.txt"))
+	assert.False(t, IsValidURL("//example.org/filter.txt"))
+	assert.False(t, IsValidURL(""))
+}